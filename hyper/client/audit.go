@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/pkg/timeutils"
+	gflag "github.com/jessevdk/go-flags"
+)
+
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user,omitempty"`
+	Uid      *uint32   `json:"uid,omitempty"`
+	Remote   string    `json:"remote,omitempty"`
+	Method   string    `json:"method"`
+	URI      string    `json:"uri"`
+	Pod      string    `json:"pod,omitempty"`
+	Vm       string    `json:"vm,omitempty"`
+	Code     int       `json:"code"`
+	Error    string    `json:"error,omitempty"`
+	Duration int64     `json:"durationMs"`
+}
+
+func (e *auditEntry) client() string {
+	switch {
+	case e.User != "":
+		return e.User
+	case e.Uid != nil:
+		return fmt.Sprintf("uid:%d", *e.Uid)
+	}
+	return e.Remote
+}
+
+func (cli *HyperClient) HyperCmdAudit(args ...string) error {
+	var opts struct {
+		Since  string `long:"since" value-name:"\"\"" description:"Show the entries since timestamp"`
+		Until  string `long:"until" value-name:"\"\"" description:"Show the entries until timestamp"`
+		User   string `short:"u" long:"user" value-name:"\"\"" description:"Only show the calls of the user (certificate CN or unix uid)"`
+		Pod    string `short:"p" long:"pod" value-name:"\"\"" description:"Only show the calls on the pod"`
+		Route  string `short:"r" long:"route" value-name:"\"\"" description:"Only show the calls of the route, e.g. '/pod/*'"`
+		Failed bool   `long:"failed" default:"false" value-name:"false" description:"Only show the failed calls"`
+		Limit  int    `short:"n" long:"limit" default:"0" value-name:"0" description:"Show the latest n entries"`
+		Json   bool   `long:"json" default:"false" value-name:"false" description:"Print the raw entries in json"`
+	}
+
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "audit [OPTIONS]\n\nSearch the audit log of the daemon"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	v := url.Values{}
+	now := time.Now()
+	if opts.Since != "" {
+		v.Set("since", timeutils.GetTimestamp(opts.Since, now))
+	}
+	if opts.Until != "" {
+		v.Set("until", timeutils.GetTimestamp(opts.Until, now))
+	}
+	if opts.User != "" {
+		v.Set("user", opts.User)
+	}
+	if opts.Pod != "" {
+		v.Set("pod", opts.Pod)
+	}
+	if opts.Route != "" {
+		v.Set("route", opts.Route)
+	}
+	if opts.Failed {
+		v.Set("failed", "true")
+	}
+	if opts.Limit > 0 {
+		v.Set("limit", fmt.Sprintf("%d", opts.Limit))
+	}
+
+	body, _, err := readBody(cli.call("GET", "/audit?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	if opts.Json {
+		fmt.Fprintf(cli.out, "%s\n", body)
+		return nil
+	}
+
+	var entries []auditEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return fmt.Errorf("Error reading audit log: %s", err)
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "TIME\tCLIENT\tCALL\tPOD\tCODE\tERROR")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s %s\t%s\t%d\t%s\n", e.Time.Local().Format(time.RFC3339), e.client(), e.Method, e.URI, e.Pod, e.Code, e.Error)
+	}
+	w.Flush()
+	return nil
+}
@@ -1,19 +1,24 @@
 package client
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/docker/docker/api"
 	"github.com/docker/docker/graph/tags"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/fileutils"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/docker/docker/pkg/parsers"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
@@ -31,8 +36,14 @@ func (cli *HyperClient) HyperCmdBuild(args ...string) error {
 		return fmt.Errorf("%s ERROR: Can not accept the 'run' command without argument!\n", os.Args[0])
 	}
 	var opts struct {
-		ImageName      string `long:"tag" short:"t" default:"" value-name:"\"\"" default-mask:"-" description:"Repository name (and optionally a tag) to be applied to the resulting image in case of success"`
-		DockerfileName string `long:"file" short:"f" default:"" value-name:"\"\"" default-mask:"-" description:"Customized docker file"`
+		ImageName      string   `long:"tag" short:"t" default:"" value-name:"\"\"" default-mask:"-" description:"Repository name (and optionally a tag) to be applied to the resulting image in case of success"`
+		DockerfileName string   `long:"file" short:"f" default:"" value-name:"\"\"" default-mask:"-" description:"Customized docker file"`
+		Cpu            int      `long:"cpu" default:"0" value-name:"0" default-mask:"-" description:"CPU number of the builder VM"`
+		Memory         int      `long:"memory" default:"0" value-name:"0" default-mask:"-" description:"Memory size (MB) of the builder VM"`
+		CacheFrom      []string `long:"cache-from" value-name:"[]" default-mask:"-" description:"Import the build cache from a tarball written by --cache-to, a missing tarball is skipped"`
+		CacheTo        string   `long:"cache-to" default:"" value-name:"\"\"" default-mask:"-" description:"Export the build cache to a tarball"`
+		Secrets        []string `long:"secret" value-name:"[]" default-mask:"-" description:"Secret file for RUN --mount=type=secret, format: id=mysecret,src=/local/secret"`
+		SSH            []string `long:"ssh" value-name:"[]" default-mask:"-" description:"SSH agent socket for RUN --mount=type=ssh, format: default|<id>[=<socket>], $SSH_AUTH_SOCK if the socket is not given"`
 	}
 
 	var parser = gflag.NewParser(&opts, gflag.Default)
@@ -183,13 +194,198 @@ func (cli *HyperClient) HyperCmdBuild(args ...string) error {
 	}
 	v := url.Values{}
 	v.Set("name", name)
+	if opts.Cpu > 0 {
+		v.Set("cpu", strconv.Itoa(opts.Cpu))
+	}
+	if opts.Memory > 0 {
+		v.Set("memory", strconv.Itoa(opts.Memory))
+	}
 	headers := http.Header(make(map[string][]string))
 	if context != nil {
 		headers.Set("Content-Type", "application/tar")
 	}
-	err = cli.stream("POST", "/image/build?"+v.Encode(), body, cli.out, headers)
+	if len(opts.Secrets) > 0 {
+		secrets, err := readBuildSecrets(opts.Secrets)
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(secrets)
+		if err != nil {
+			return err
+		}
+		headers.Set("X-Build-Secrets", base64.URLEncoding.EncodeToString(buf))
+	}
+	for _, ssh := range opts.SSH {
+		agent, err := parseBuildSSH(ssh)
+		if err != nil {
+			return err
+		}
+		v.Add("ssh", agent)
+	}
+
+	for _, file := range opts.CacheFrom {
+		if err := cli.importBuildCache(file); err != nil {
+			return err
+		}
+	}
+	if opts.CacheTo == "" {
+		return cli.stream("POST", "/image/build?"+v.Encode(), body, cli.out, headers)
+	}
+
+	v.Set("exportcache", "yes")
+	resp, _, err := cli.clientResponse("POST", "/image/build?"+v.Encode(), body, headers)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	cacheImages, err := cli.readBuildStream(resp.Body)
+	if err != nil {
+		return err
+	}
+	return cli.exportBuildCache(cacheImages, opts.CacheTo)
+}
+
+// readBuildSecrets reads the files of the `--secret id=<id>,src=<file>`
+func readBuildSecrets(values []string) (map[string][]byte, error) {
+	secrets := map[string][]byte{}
+	for _, value := range values {
+		var id, src string
+		for _, field := range strings.Split(value, ",") {
+			i := strings.Index(field, "=")
+			if i < 0 {
+				return nil, fmt.Errorf("invalid secret %q, the format is id=mysecret,src=/local/secret", value)
+			}
+			switch field[:i] {
+			case "id":
+				id = field[i+1:]
+			case "src", "source":
+				src = field[i+1:]
+			default:
+				return nil, fmt.Errorf("invalid secret %q, unknown field %s", value, field[:i])
+			}
+		}
+		if src == "" {
+			return nil, fmt.Errorf("invalid secret %q, the src is required", value)
+		}
+		if id == "" {
+			id = filepath.Base(src)
+		}
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		secrets[id] = data
+	}
+	return secrets, nil
+}
+
+// parseBuildSSH returns the `--ssh <id>[=<socket>]` as id=socket, with the
+// socket given by $SSH_AUTH_SOCK by default. The daemon dials the socket, it
+// has to be on the same host.
+func parseBuildSSH(value string) (string, error) {
+	id, socket := value, ""
+	if i := strings.Index(value, "="); i >= 0 {
+		id, socket = value[:i], value[i+1:]
+	}
+	if id == "" {
+		return "", fmt.Errorf("invalid ssh %q, the format is default|<id>[=<socket>]", value)
+	}
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return "", fmt.Errorf("invalid ssh %q, no socket given and SSH_AUTH_SOCK is not set", value)
+		}
+	}
+	socket, err := filepath.Abs(socket)
+	if err != nil {
+		return "", err
+	}
+	return id + "=" + socket, nil
+}
+
+// readBuildStream displays the messages of the build, and returns the images
+// of the build cache sent at the end of the stream
+func (cli *HyperClient) readBuildStream(in io.Reader) ([]string, error) {
+	var cacheImages []string
+	dec := json.NewDecoder(in)
+	for {
+		var jm struct {
+			jsonmessage.JSONMessage
+			CacheImages []string `json:"cacheImages,omitempty"`
+		}
+		if err := dec.Decode(&jm); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if jm.Error != nil {
+			return nil, jm.Error
+		}
+		if jm.CacheImages != nil {
+			cacheImages = jm.CacheImages
+			continue
+		}
+		if err := jm.Display(cli.out, cli.isTerminalOut); err != nil {
+			return nil, err
+		}
+	}
+	return cacheImages, nil
+}
+
+// importBuildCache loads the images of the build cache exported by a previous
+// build, the build goes without the cache if the tarball does not exist
+func (cli *HyperClient) importBuildCache(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(cli.err, "Build cache %s does not exist, skipped\n", file)
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(cli.out, "Importing build cache from %s\n", file)
+	headers := http.Header(make(map[string][]string))
+	headers.Set("Content-Type", "application/x-tar")
+	return cli.stream("POST", "/image/load", f, cli.out, headers)
+}
+
+// exportBuildCache saves the images of the build cache, with their parents,
+// to the tarball. The tarball is replaced only after it is fully written.
+func (cli *HyperClient) exportBuildCache(cacheImages []string, file string) error {
+	if len(cacheImages) == 0 {
+		return fmt.Errorf("No build cache to export")
+	}
+
+	v := url.Values{}
+	for _, id := range cacheImages {
+		v.Add("names", id)
+	}
+	resp, _, err := cli.clientResponse("GET", "/image/save?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file))
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Exported build cache to %s\n", file)
 	return nil
 }
@@ -43,12 +43,18 @@ var funcMap = template.FuncMap{
 }
 
 func (cli *HyperClient) getMethod(args ...string) (func(...string) error, bool) {
-	camelArgs := make([]string, len(args))
-	for i, s := range args {
+	camelArgs := []string{}
+	for _, s := range args {
 		if len(s) == 0 {
 			return nil, false
 		}
-		camelArgs[i] = strings.ToUpper(s[:1]) + strings.ToLower(s[1:])
+		// the dashed commands, e.g. attach-console, are in camel case
+		for _, w := range strings.Split(s, "-") {
+			if len(w) == 0 {
+				return nil, false
+			}
+			camelArgs = append(camelArgs, strings.ToUpper(w[:1])+strings.ToLower(w[1:]))
+		}
 	}
 	methodName := "HyperCmd" + strings.Join(camelArgs, "")
 	method := reflect.ValueOf(cli).MethodByName(methodName)
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/system"
+	"github.com/hyperhq/runv/hypervisor/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+// HyperCmdCp copies files or folders between a container and the local
+// filesystem, in the same way of `docker cp`.
+//
+// When copying from a container, if the local path is '-' the data is
+// written as a tar archive to STDOUT. When copying to a container, if the
+// local path is '-' the data is read as a tar archive from STDIN, and the
+// destination in the container must be a directory.
+func (cli *HyperClient) HyperCmdCp(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "cp CONTAINER:SRC_PATH DEST_PATH|-\n  cp SRC_PATH|- CONTAINER:DEST_PATH\n\nCopy files or folders between a container and the local filesystem, keeping the mode and ownership.\nUse '-' as the source to read a tar archive from stdin and extract it to a directory in the container.\nUse '-' as the destination to stream a tar archive of the path in the container to stdout."
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("\"cp\" requires exactly 2 arguments, See 'hyperctl cp --help'.")
+	}
+	if args[1] == "" {
+		return fmt.Errorf("source can not be empty")
+	}
+	if args[2] == "" {
+		return fmt.Errorf("destination can not be empty")
+	}
+
+	srcContainer, srcPath := splitCpArg(args[1])
+	dstContainer, dstPath := splitCpArg(args[2])
+
+	switch {
+	case srcContainer != "" && dstContainer != "":
+		return fmt.Errorf("copying between containers is not supported")
+	case srcContainer != "":
+		return cli.copyFromContainer(srcContainer, srcPath, dstPath)
+	case dstContainer != "":
+		return cli.copyToContainer(srcPath, dstContainer, dstPath)
+	default:
+		return fmt.Errorf("must specify at least one container source")
+	}
+}
+
+// splitCpArg splits the CONTAINER:PATH argument. A local path with `:` must
+// be explicit with a relative or absolute path, like `./file:name.txt`.
+func splitCpArg(arg string) (container, path string) {
+	if system.IsAbs(arg) {
+		return "", arg
+	}
+
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) == 1 || strings.HasPrefix(parts[0], ".") {
+		return "", arg
+	}
+	return parts[0], parts[1]
+}
+
+func resolveLocalPath(localPath string) (string, error) {
+	absPath, err := filepath.Abs(localPath)
+	if err != nil {
+		return "", err
+	}
+	return archive.PreserveTrailingDotOrSeparator(absPath, localPath), nil
+}
+
+func archiveQuery(container, path string) url.Values {
+	v := url.Values{}
+	v.Set("container", container)
+	v.Set("path", filepath.ToSlash(path))
+	return v
+}
+
+func getContainerPathStat(header http.Header) (*types.PathStat, error) {
+	stat := &types.PathStat{}
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(header.Get("X-Docker-Container-Path-Stat")))
+	if err := json.NewDecoder(decoder).Decode(stat); err != nil {
+		return nil, fmt.Errorf("unable to decode container path stat header: %v", err)
+	}
+	return stat, nil
+}
+
+func (cli *HyperClient) statContainerPath(container, path string) (*types.PathStat, error) {
+	resp, _, err := cli.clientResponse("HEAD", "/container/archive?"+archiveQuery(container, path).Encode(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return getContainerPathStat(resp.Header)
+}
+
+func (cli *HyperClient) copyFromContainer(srcContainer, srcPath, dstPath string) (err error) {
+	if dstPath != "-" {
+		dstPath, err = resolveLocalPath(dstPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, _, err := cli.clientResponse("GET", "/container/archive?"+archiveQuery(srcContainer, srcPath).Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if dstPath == "-" {
+		_, err = io.Copy(cli.out, resp.Body)
+		return err
+	}
+
+	// the stat of the source decides how to copy it to the local
+	// destination, see archive.CopyTo
+	stat, err := getContainerPathStat(resp.Header)
+	if err != nil {
+		return err
+	}
+	srcInfo := archive.CopyInfo{
+		Path:   srcPath,
+		Exists: true,
+		IsDir:  stat.Mode.IsDir(),
+	}
+	return archive.CopyTo(resp.Body, srcInfo, dstPath)
+}
+
+func (cli *HyperClient) copyToContainer(srcPath, dstContainer, dstPath string) (err error) {
+	if srcPath != "-" {
+		srcPath, err = resolveLocalPath(srcPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	dstInfo := archive.CopyInfo{Path: dstPath}
+	dstStat, err := cli.statContainerPath(dstContainer, dstPath)
+
+	// copy into the target of the symbolic link
+	if err == nil && dstStat.Mode&os.ModeSymlink != 0 {
+		linkTarget := dstStat.LinkTarget
+		if !system.IsAbs(linkTarget) {
+			dstParent, _ := archive.SplitPathDirEntry(dstPath)
+			linkTarget = filepath.Join(dstParent, linkTarget)
+		}
+		dstInfo.Path = linkTarget
+		dstStat, err = cli.statContainerPath(dstContainer, linkTarget)
+	}
+
+	// If the destination does not exist, assume its parent directory does,
+	// the extraction fails otherwise.
+	if err == nil {
+		dstInfo.Exists, dstInfo.IsDir = true, dstStat.Mode.IsDir()
+	}
+
+	var (
+		content         io.Reader
+		resolvedDstPath string
+	)
+	if srcPath == "-" {
+		if !dstInfo.IsDir {
+			return fmt.Errorf("destination %s:%s must be a directory", dstContainer, dstPath)
+		}
+		content = cli.in
+		resolvedDstPath = dstInfo.Path
+	} else {
+		srcInfo, err := archive.CopyInfoSourcePath(srcPath)
+		if err != nil {
+			return err
+		}
+
+		srcArchive, err := archive.TarResource(srcInfo)
+		if err != nil {
+			return err
+		}
+		defer srcArchive.Close()
+
+		// the archive is rebased so that extracting it to dstDir gets the
+		// same result of `cp`, see archive.PrepareArchiveCopy
+		dstDir, preparedArchive, err := archive.PrepareArchiveCopy(srcArchive, srcInfo, dstInfo)
+		if err != nil {
+			return err
+		}
+		defer preparedArchive.Close()
+
+		content = preparedArchive
+		resolvedDstPath = dstDir
+	}
+
+	v := archiveQuery(dstContainer, resolvedDstPath)
+	// do not overwrite an existing directory with a non-directory, and vice versa
+	v.Set("noOverwriteDirNonDir", "true")
+	headers := http.Header(make(map[string][]string))
+	headers.Set("Content-Type", "application/x-tar")
+	body, _, _, err := cli.clientRequest("PUT", "/container/archive?"+v.Encode(), content, headers)
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/timeutils"
+	gflag "github.com/jessevdk/go-flags"
+)
+
+type eventMessage struct {
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	TimeNano   int64             `json:"timeNano"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (cli *HyperClient) HyperCmdEvents(args ...string) error {
+	var opts struct {
+		Since   string   `long:"since" value-name:"\"\"" description:"Show all events created since timestamp"`
+		Until   string   `long:"until" value-name:"\"\"" description:"Stream events until this timestamp"`
+		Filters []string `short:"f" long:"filter" value-name:"[]" description:"Filter output based on conditions provided (type, event, id, label)"`
+	}
+
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "events [OPTIONS]\n\nGet real time events from the daemon"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	v := url.Values{}
+	now := time.Now()
+	if opts.Since != "" {
+		v.Set("since", timeutils.GetTimestamp(opts.Since, now))
+	}
+	if opts.Until != "" {
+		v.Set("until", timeutils.GetTimestamp(opts.Until, now))
+	}
+	for _, f := range opts.Filters {
+		v.Add("filter", f)
+	}
+
+	body, _, _, err := cli.clientRequest("GET", "/events?"+v.Encode(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var e eventMessage
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		attrs := []string{}
+		for k, v := range e.Attributes {
+			attrs = append(attrs, fmt.Sprintf("%s=%s", k, v))
+		}
+		sort.Strings(attrs)
+		fmt.Fprintf(cli.out, "%s %s %s %s", time.Unix(0, e.TimeNano).Format(time.RFC3339Nano), e.Type, e.Action, e.ID)
+		if len(attrs) > 0 {
+			fmt.Fprintf(cli.out, " (%s)", strings.Join(attrs, ", "))
+		}
+		fmt.Fprintln(cli.out)
+	}
+}
@@ -49,8 +49,12 @@ func GetExitCode(cli *HyperClient, container, tag string) error {
 
 func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	var opts struct {
-		Attach bool `short:"a" long:"attach" default:"true" value-name:"false" description:"attach current terminal to the stdio of command"`
-		Vm     bool `long:"vm" default:"false" value-name:"false" description:"attach to vm"`
+		Attach  bool     `short:"a" long:"attach" default:"true" value-name:"false" description:"attach current terminal to the stdio of command"`
+		Vm      bool     `long:"vm" default:"false" value-name:"false" description:"attach to vm"`
+		Env     []string `short:"e" long:"env" value-name:"[]" description:"Set environment variables, KEY=VALUE"`
+		User    string   `short:"u" long:"user" value-name:"\"\"" description:"Username or UID (format: <name|uid>[:<group|gid>])"`
+		Workdir string   `short:"w" long:"workdir" value-name:"\"\"" description:"Working directory of the command"`
+		Detach  bool     `short:"d" long:"detach" default:"false" default-mask:"-" description:"Run the command in the background and print the exec ID"`
 	}
 	var parser = gflag.NewParser(&opts, gflag.Default|gflag.IgnoreUnknown)
 	parser.Usage = "exec [OPTIONS] POD|CONTAINER COMMAND [ARGS...]\n\nRun a command in a container of a running pod"
@@ -102,6 +106,29 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 	}
 	v.Set("command", string(command))
 	v.Set("tag", tag)
+	if len(opts.Env) > 0 {
+		env, err := json.Marshal(opts.Env)
+		if err != nil {
+			return err
+		}
+		v.Set("env", string(env))
+	}
+	v.Set("user", opts.User)
+	v.Set("workdir", opts.Workdir)
+
+	if opts.Detach {
+		v.Set("detach", "yes")
+		body, _, err := readBody(cli.call("POST", "/exec?"+v.Encode(), nil, nil))
+		if err != nil {
+			return err
+		}
+		var dat map[string]string
+		if err := json.Unmarshal(body, &dat); err != nil {
+			return err
+		}
+		fmt.Fprintf(cli.out, "%s\n", dat["ID"])
+		return nil
+	}
 
 	var (
 		hijacked = make(chan io.Closer)
@@ -119,7 +146,8 @@ func (cli *HyperClient) HyperCmdExec(args ...string) error {
 		return cli.hijack("POST", "/exec?"+v.Encode(), true, cli.in, cli.out, cli.out, hijacked, nil, "")
 	})
 
-	if err := cli.monitorTtySize(podName, tag); err != nil {
+	// the tty of the exec is resized by the exec ID
+	if err := cli.monitorTtySize(tag, tag); err != nil {
 		fmt.Printf("Monitor tty size fail for %s!\n", podName)
 	}
 
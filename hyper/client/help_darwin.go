@@ -11,10 +11,13 @@ func (cli *HyperClient) HyperCmdHelp(args ...string) error {
 
 Command:
   attach                 Attach to the tty of a specified container in a pod
+  audit                  Search the audit log of the daemon
   build                  Build an image from a Dockerfile
   commit                 Create a new image from a container's changes
+  cp                     Copy files or folders between a container and the local filesystem
   create                 Create a pod into 'pending' status, but without running it
   exec                   Run a command in a container of a running pod
+  events                 Get real time events from the daemon
   images                 List images
   info                   Display system-wide information
   list                   List all pods or containers
@@ -25,8 +28,12 @@ Command:
   rm                     Remove one or more pods
   rmi                    Remove one or more images
   run                    Create a pod, and launch a new pod
+  secret                 Manage secrets stored in the daemon
   start                  Launch a 'pending' pod
   stop                   Stop a running pod, it will become 'pending'
+  system                 Show the disk usage of the daemon, or remove the unused data
+  template               Manage pod templates stored in the daemon
+  vm                     Run a VM without any pod, show the info of a VM, or read and attach its console
 
 Help Options:
   -h, --help             Show this help message
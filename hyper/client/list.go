@@ -1,22 +1,26 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/url"
 	"os"
 	"strings"
 	"text/tabwriter"
+	"text/template"
 
-	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/types"
 
 	gflag "github.com/jessevdk/go-flags"
 )
 
 func (cli *HyperClient) HyperCmdList(args ...string) error {
 	var opts struct {
-		Aux bool   `short:"x" long:"aux" default:"false" value-name:"false" description:"show the auxiliary containers"`
-		Pod string `short:"p" long:"pod" value-name:"\"\"" description:"only list the specified pod"`
-		VM  string `short:"m" long:"vm" value-name:"\"\"" description:"only list  resources on the specified vm"`
+		Aux     bool     `short:"x" long:"aux" default:"false" value-name:"false" description:"show the auxiliary containers"`
+		Pod     string   `short:"p" long:"pod" value-name:"\"\"" description:"only list the specified pod"`
+		VM      string   `short:"m" long:"vm" value-name:"\"\"" description:"only list  resources on the specified vm"`
+		Format  string   `long:"format" value-name:"\"\"" description:"table, json, or a Go template applied to each item, e.g. '{{.ID}} {{.Status}}'"`
+		Filters []string `short:"f" long:"filter" value-name:"[]" description:"Filter output based on conditions provided (label, status, name)"`
 	}
 
 	var parser = gflag.NewParser(&opts, gflag.Default|gflag.IgnoreUnknown)
@@ -41,7 +45,6 @@ func (cli *HyperClient) HyperCmdList(args ...string) error {
 	}
 
 	v := url.Values{}
-	v.Set("item", item)
 	if opts.Aux {
 		v.Set("auxiliary", "yes")
 	}
@@ -51,75 +54,96 @@ func (cli *HyperClient) HyperCmdList(args ...string) error {
 	if opts.VM != "" {
 		v.Set("vm", opts.VM)
 	}
-	body, _, err := readBody(cli.call("GET", "/list?"+v.Encode(), nil, nil))
-	if err != nil {
-		return err
+	for _, f := range opts.Filters {
+		v.Add("filter", f)
 	}
-	out := engine.NewOutput()
-	remoteInfo, err := out.AddEnv()
+	list, err := cli.list(item, v)
 	if err != nil {
 		return err
 	}
 
-	if _, err := out.Write(body); err != nil {
-		fmt.Printf("Error reading remote info: %s", err)
-		return err
-	}
-	out.Close()
-
-	var (
-		vmResponse        = []string{}
-		podResponse       = []string{}
-		containerResponse = []string{}
-	)
-	if remoteInfo.Exists("item") {
-		item = remoteInfo.Get("item")
-	}
-	if remoteInfo.Exists("Error") {
-		return fmt.Errorf("Found an error while getting %s list: %s", item, remoteInfo.Get("Error"))
+	switch opts.Format {
+	case "", "table":
+	case "json":
+		var data interface{}
+		switch item {
+		case "vm":
+			data = list.Vms
+		case "pod":
+			data = list.Pods
+		case "container":
+			data = list.Containers
+		}
+		return json.NewEncoder(cli.out).Encode(data)
+	default:
+		return cli.formatList(opts.Format, list)
 	}
 
 	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
-	if item == "vm" {
-		vmResponse = remoteInfo.GetList("vmData")
-	}
-	if item == "pod" {
-		podResponse = remoteInfo.GetList("podData")
-	}
-	if item == "container" {
-		containerResponse = remoteInfo.GetList("cData")
-	}
-
-	//fmt.Printf("Item is %s\n", item)
 	if item == "vm" {
 		fmt.Fprintln(w, "VM name\tStatus")
-		for _, vm := range vmResponse {
-			fields := strings.Split(vm, ":")
-			fmt.Fprintf(w, "%s\t%s\n", fields[0], fields[2])
+		for _, vm := range list.Vms {
+			fmt.Fprintf(w, "%s\t%s\n", vm.ID, vm.Status)
 		}
 	}
 
 	if item == "pod" {
 		fmt.Fprintln(w, "POD ID\tPOD Name\tVM name\tStatus")
-		for _, p := range podResponse {
-			fields := strings.Split(p, ":")
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", fields[0], fields[1], fields[2], fields[3])
+		for _, p := range list.Pods {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.ID, p.Name, p.Vm, p.Status)
 		}
 	}
 
 	if item == "container" {
 		fmt.Fprintln(w, "Container ID\tName\tPOD ID\tStatus")
-		for _, c := range containerResponse {
-			fields := strings.Split(c, ":")
-			name := fields[1]
-			if len(name) > 0 {
-				if name[0] == '/' {
-					name = name[1:]
-				}
-			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", fields[0], name, fields[2], fields[3])
+		for _, c := range list.Containers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.ID, c.Name, c.PodID, c.Status)
 		}
 	}
 	w.Flush()
 	return nil
 }
+
+func (cli *HyperClient) formatList(format string, list *types.ListResponse) error {
+	tmpl, err := template.New("list").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(format)
+	if err != nil {
+		return fmt.Errorf("Template parsing error: %v", err)
+	}
+
+	var items []interface{}
+	for _, vm := range list.Vms {
+		items = append(items, vm)
+	}
+	for _, p := range list.Pods {
+		items = append(items, p)
+	}
+	for _, c := range list.Containers {
+		items = append(items, c)
+	}
+	for _, i := range items {
+		if err := tmpl.Execute(cli.out, i); err != nil {
+			return err
+		}
+		fmt.Fprintln(cli.out)
+	}
+	return nil
+}
+
+func (cli *HyperClient) list(item string, v url.Values) (*types.ListResponse, error) {
+	v.Set("item", item)
+	body, _, err := readBody(cli.call("GET", "/list?"+v.Encode(), nil, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	var list types.ListResponse
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, fmt.Errorf("Error reading remote info: %s", err)
+	}
+	return &list, nil
+}
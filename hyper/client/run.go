@@ -12,7 +12,6 @@ import (
 	"time"
 
 	"github.com/docker/docker/pkg/namesgenerator"
-	"github.com/hyperhq/hyper/engine"
 	"github.com/hyperhq/hyper/utils"
 	"github.com/hyperhq/runv/hypervisor/pod"
 
@@ -27,9 +26,11 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 	var opts struct {
 		PodFile       string   `short:"p" long:"podfile" value-name:"\"\"" description:"Create and Run a pod based on the pod file"`
 		K8s           string   `short:"k" long:"kubernetes" value-name:"\"\"" description:"Create and Run a pod based on the kubernetes pod file"`
+		Template      string   `long:"template" value-name:"\"\"" description:"Create and Run a pod based on the pod template"`
+		Set           []string `long:"set" value-name:"[]" default-mask:"-" description:"(from template) Set a template parameter, format: --set key=value"`
 		Yaml          bool     `short:"y" long:"yaml" default:"false" default-mask:"-" description:"Create a pod based on Yaml file"`
 		Name          string   `long:"name" value-name:"\"\"" description:"Assign a name to the container"`
-		Attach        bool     `short:"a" long:"attach" default:"false" default-mask:"-" description:"(from podfile) Attach the stdin, stdout and stderr to the container"`
+		Attach        bool     `short:"a" long:"attach" default:"false" default-mask:"-" description:"(from podfile or template) Attach the stdin, stdout and stderr to the container"`
 		Detach        bool     `short:"d" long:"detach" default:"false" default-mask:"-" description:"(from cmdline) Not Attach the stdin, stdout and stderr to the container"`
 		Workdir       string   `long:"workdir" default:"/" value-name:"\"\"" default-mask:"-" description:"Working directory inside the container"`
 		Tty           bool     `short:"t" long:"tty" default:"false" default-mask:"-" description:"the run command in tty, such as bash shell"`
@@ -67,6 +68,9 @@ func (cli *HyperClient) HyperCmdRun(args ...string) error {
 	} else if opts.K8s != "" {
 		attach = opts.Attach
 		podJson, err = cli.JsonFromFile(opts.K8s, opts.Yaml, true)
+	} else if opts.Template != "" {
+		attach = opts.Attach
+		podJson, err = cli.RenderTemplate(opts.Template, opts.Set)
 	} else {
 		if len(args) == 0 {
 			return fmt.Errorf("%s: \"run\" requires a minimum of 1 argument, please provide the image.", os.Args[0])
@@ -239,7 +243,8 @@ func (cli *HyperClient) JsonFromCmdline(cmdArgs, cmdEnvs, cmdPortmaps []string,
 			Type:   cmdLogDriver,
 			Config: logOpts,
 		},
-		Tty: tty,
+		Tty:           tty,
+		RestartPolicy: cmdRestartPolicy,
 	}
 
 	jsonString, _ := json.Marshal(userPod)
@@ -308,30 +313,14 @@ func imageToName(image string) string {
 
 func (cli *HyperClient) GetContainerByPod(podId string) (string, error) {
 	v := url.Values{}
-	v.Set("item", "container")
 	v.Set("pod", podId)
-	body, _, err := readBody(cli.call("GET", "/list?"+v.Encode(), nil, nil))
-	if err != nil {
-		return "", err
-	}
-	out := engine.NewOutput()
-	remoteInfo, err := out.AddEnv()
+	list, err := cli.list("container", v)
 	if err != nil {
 		return "", err
 	}
-
-	if _, err := out.Write(body); err != nil {
-		fmt.Printf("Error reading remote info: %s", err)
-		return "", err
-	}
-	out.Close()
-	var containerResponse = []string{}
-	containerResponse = remoteInfo.GetList("cData")
-	for _, c := range containerResponse {
-		fields := strings.Split(c, ":")
-		containerId := fields[0]
-		if podId == fields[2] {
-			return containerId, nil
+	for _, c := range list.Containers {
+		if podId == c.PodID {
+			return c.ID, nil
 		}
 	}
 
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hyperhq/hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdSecret(args ...string) error {
+	fmt.Fprintf(cli.out, `Usage: %s secret COMMAND
+
+Manage the secrets stored in the daemon
+
+Commands:
+  create NAME FILE|-     Create a secret from a file or the standard input
+  ls                     List secrets
+  rm NAME [NAME...]      Remove one or more secrets
+`, os.Args[0])
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdSecretCreate(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "secret create NAME FILE|-\n\nCreate a secret, the content is read from FILE or the standard input"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("\"secret create\" requires 2 arguments, please provide the secret NAME and FILE.\n")
+	}
+	name, file := args[2], args[3]
+
+	var data []byte
+	if file == "-" {
+		data, err = ioutil.ReadAll(cli.in)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	v := url.Values{}
+	v.Set("name", name)
+	body, _, _, err := cli.clientRequest("POST", "/secret/create?"+v.Encode(), bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+	body.Close()
+
+	fmt.Fprintf(cli.out, "%s\n", name)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdSecretLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "secret ls\n\nList secrets"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	body, _, err := readBody(cli.call("GET", "/secret/list", nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tCREATED")
+	for _, item := range remoteInfo.GetList("secretList") {
+		fields := strings.Split(item, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		date, _ := strconv.ParseInt(fields[2], 0, 64)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", fields[0], fields[1], time.Unix(date, 0).Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdSecretRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "secret rm NAME [NAME...]\n\nRemove one or more secrets"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"secret rm\" requires a minimum of 1 argument, please provide secret NAME.\n")
+	}
+
+	for _, name := range args[2:] {
+		v := url.Values{}
+		v.Set("name", name)
+		if _, _, err := readBody(cli.call("DELETE", "/secret?"+v.Encode(), nil, nil)); err != nil {
+			fmt.Fprintf(cli.out, "Error to remove secret(%s), %s\n", name, err.Error())
+			continue
+		}
+		fmt.Fprintf(cli.out, "Secret(%s) is successful to be deleted!\n", name)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/hyperhq/hyper/engine"
@@ -14,7 +15,9 @@ import (
 func (cli *HyperClient) HyperCmdStop(args ...string) error {
 
 	var opts struct {
-		Novm bool `long:"onlypod" default:"false" value-name:"false" description:"Stop a Pod, but left the VM running"`
+		Novm   bool   `long:"onlypod" default:"false" value-name:"false" description:"Stop a Pod, but left the VM running"`
+		Time   int    `short:"t" long:"time" default:"-1" value-name:"-1" default-mask:"-" description:"Seconds to wait for the containers to stop before killing them, the grace periods of the containers by default"`
+		Signal string `short:"s" long:"signal" value-name:"\"\"" default-mask:"-" description:"Signal to stop the containers, the stop signals of the containers by default"`
 	}
 	var parser = gflag.NewParser(&opts, gflag.Default)
 	parser.Usage = "stop POD_ID\n\nStop a running pod"
@@ -35,7 +38,7 @@ func (cli *HyperClient) HyperCmdStop(args ...string) error {
 	if opts.Novm {
 		stopVm = "no"
 	}
-	code, cause, err := cli.StopPod(podID, stopVm)
+	code, cause, err := cli.StopPodWithTimeout(podID, stopVm, opts.Time, opts.Signal)
 	if err != nil {
 		return err
 	}
@@ -47,9 +50,21 @@ func (cli *HyperClient) HyperCmdStop(args ...string) error {
 }
 
 func (cli *HyperClient) StopPod(podId, stopVm string) (int, string, error) {
+	return cli.StopPodWithTimeout(podId, stopVm, -1, "")
+}
+
+// StopPodWithTimeout stops the pod, a negative timeout and empty signal
+// mean the settings of the containers
+func (cli *HyperClient) StopPodWithTimeout(podId, stopVm string, timeout int, signal string) (int, string, error) {
 	v := url.Values{}
 	v.Set("podId", podId)
 	v.Set("stopVm", stopVm)
+	if timeout >= 0 {
+		v.Set("time", strconv.Itoa(timeout))
+	}
+	if signal != "" {
+		v.Set("signal", signal)
+	}
 	body, _, err := readBody(cli.call("POST", "/pod/stop?"+v.Encode(), nil, nil))
 	if err != nil {
 		if strings.Contains(err.Error(), "leveldb: not found") {
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/docker/docker/pkg/units"
+	hypertypes "github.com/hyperhq/hyper/types"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdSystem(args ...string) error {
+	fmt.Fprintf(cli.out, `Usage: %s system COMMAND
+
+Manage the disk space used by the daemon
+
+Commands:
+  df                     Show the disk usage of the images, containers, volumes and VMs
+  prune                  Remove the unused data
+`, os.Args[0])
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdSystemDf(args ...string) error {
+	var opts struct {
+		Size bool `short:"s" long:"size" default:"false" default-mask:"-" description:"Measure the size of the containers, which mounts each of them"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "system df [OPTIONS]\n\nShow the disk usage of the images, the containers, the volumes and the run dirs of the VMs"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	v := url.Values{}
+	if opts.Size {
+		v.Set("size", "yes")
+	}
+	body, _, err := readBody(cli.call("GET", "/system/df?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	var usage []hypertypes.DiskUsage
+	if err := json.Unmarshal(body, &usage); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE")
+	for _, du := range usage {
+		if du.Unmeasured {
+			fmt.Fprintf(w, "%s\t%d\t%d\t-\t-\n", du.Type, du.Total, du.Active)
+			continue
+		}
+		reclaimable := units.HumanSize(float64(du.Reclaimable))
+		if du.Size > 0 {
+			reclaimable = fmt.Sprintf("%s (%d%%)", reclaimable, du.Reclaimable*100/du.Size)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", du.Type, du.Total, du.Active, units.HumanSize(float64(du.Size)), reclaimable)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdSystemPrune(args ...string) error {
+	var opts struct {
+		DryRun bool `long:"dry-run" default:"false" default-mask:"-" description:"Only show what would be removed"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "system prune [OPTIONS]\n\nRemove the dangling images, the stopped pods with their containers, the volumes of the removed pods, and the run dirs left by the VMs"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	v := url.Values{}
+	if opts.DryRun {
+		v.Set("dryrun", "yes")
+	}
+	body, _, err := readBody(cli.call("POST", "/system/prune?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	var reports []hypertypes.PruneReport
+	if err := json.Unmarshal(body, &reports); err != nil {
+		return err
+	}
+
+	action := "Removed"
+	if opts.DryRun {
+		action = "Would remove"
+	}
+	var total int64
+	for _, r := range reports {
+		if len(r.Removed) == 0 {
+			continue
+		}
+		fmt.Fprintf(cli.out, "%s %s:\n", action, r.Type)
+		for _, id := range r.Removed {
+			fmt.Fprintf(cli.out, "  %s\n", id)
+		}
+		total += r.Reclaimed
+	}
+	if opts.DryRun {
+		fmt.Fprintf(cli.out, "Total reclaimable space: %s\n", units.HumanSize(float64(total)))
+	} else {
+		fmt.Fprintf(cli.out, "Total reclaimed space: %s\n", units.HumanSize(float64(total)))
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hyperhq/hyper/engine"
+
+	gflag "github.com/jessevdk/go-flags"
+)
+
+func (cli *HyperClient) HyperCmdTemplate(args ...string) error {
+	fmt.Fprintf(cli.out, `Usage: %s template COMMAND
+
+Manage the pod templates stored in the daemon
+
+Commands:
+  create NAME FILE|-     Create a template from a file or the standard input
+  ls                     List templates
+  render NAME            Print the pod spec rendered from a template
+  rm NAME [NAME...]      Remove one or more templates
+
+The template is a pod json with ${param} or ${param:-default} placeholders,
+the parameters are given with --set param=value to "render" and "run --template".
+`, os.Args[0])
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdTemplateCreate(args ...string) error {
+	var opts struct {
+		Yaml bool `short:"y" long:"yaml" default:"false" default-mask:"-" description:"The template is in yaml format"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "template create [OPTIONS] NAME FILE|-\n\nCreate a pod template, the content is read from FILE or the standard input"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 4 {
+		return fmt.Errorf("\"template create\" requires 2 arguments, please provide the template NAME and FILE.\n")
+	}
+	name, file := args[2], args[3]
+
+	var data []byte
+	if file == "-" {
+		data, err = ioutil.ReadAll(cli.in)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	if opts.Yaml {
+		data, err = cli.ConvertYamlToJson(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	v := url.Values{}
+	v.Set("name", name)
+	body, _, _, err := cli.clientRequest("POST", "/template/create?"+v.Encode(), bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+	body.Close()
+
+	fmt.Fprintf(cli.out, "%s\n", name)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdTemplateLs(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "template ls\n\nList pod templates, the optional parameters are marked with '?'"
+	if _, err := parser.Parse(); err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+
+	body, _, err := readBody(cli.call("GET", "/template/list", nil, nil))
+	if err != nil {
+		return err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tPARAMS\tCREATED")
+	for _, item := range remoteInfo.GetList("templateList") {
+		fields := strings.SplitN(item, ":", 3)
+		if len(fields) < 3 {
+			continue
+		}
+		date, _ := strconv.ParseInt(fields[1], 0, 64)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", fields[0], fields[2], time.Unix(date, 0).Format("2006-01-02 15:04:05"))
+	}
+	w.Flush()
+
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdTemplateRender(args ...string) error {
+	var opts struct {
+		Set []string `long:"set" value-name:"[]" default-mask:"-" description:"Set a template parameter, format: --set key=value"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "template render [OPTIONS] NAME\n\nPrint the pod spec rendered from a template"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"template render\" requires a minimum of 1 argument, please provide template NAME.\n")
+	}
+
+	spec, err := cli.RenderTemplate(args[2], opts.Set)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s\n", spec)
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdTemplateRm(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "template rm NAME [NAME...]\n\nRemove one or more pod templates"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"template rm\" requires a minimum of 1 argument, please provide template NAME.\n")
+	}
+
+	for _, name := range args[2:] {
+		v := url.Values{}
+		v.Set("name", name)
+		if _, _, err := readBody(cli.call("DELETE", "/template?"+v.Encode(), nil, nil)); err != nil {
+			fmt.Fprintf(cli.out, "Error to remove template(%s), %s\n", name, err.Error())
+			continue
+		}
+		fmt.Fprintf(cli.out, "Template(%s) is successful to be deleted!\n", name)
+	}
+	return nil
+}
+
+// RenderTemplate returns the pod json instantiated from the template by the daemon
+func (cli *HyperClient) RenderTemplate(name string, sets []string) (string, error) {
+	v := url.Values{}
+	v.Set("name", name)
+	for _, s := range sets {
+		v.Add("set", s)
+	}
+	body, _, err := readBody(cli.call("GET", "/template/render?"+v.Encode(), nil, nil))
+	if err != nil {
+		return "", err
+	}
+	out := engine.NewOutput()
+	remoteInfo, err := out.AddEnv()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := out.Write(body); err != nil {
+		return "", fmt.Errorf("Error reading remote info: %s", err)
+	}
+	out.Close()
+
+	return remoteInfo.Get("Spec"), nil
+}
@@ -50,13 +50,23 @@ func (cli *HyperClient) encodeData(data interface{}) (*bytes.Buffer, error) {
 }
 
 func (cli *HyperClient) clientRequest(method, path string, in io.Reader, headers map[string][]string) (io.ReadCloser, string, int, error) {
+	resp, statusCode, err := cli.clientResponse(method, path, in, headers)
+	if err != nil {
+		return nil, "", statusCode, err
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), statusCode, nil
+}
+
+// clientResponse is the same as clientRequest, but returns the whole
+// response for the callers need the headers
+func (cli *HyperClient) clientResponse(method, path string, in io.Reader, headers map[string][]string) (*http.Response, int, error) {
 	expectedPayload := (method == "POST" || method == "PUT" || method == "DELETE")
 	if expectedPayload && in == nil {
 		in = bytes.NewReader([]byte{})
 	}
-	req, err := http.NewRequest(method, fmt.Sprintf("/v%s%s", utils.VERSION, path), in)
+	req, err := http.NewRequest(method, fmt.Sprintf("/v%s%s", utils.APIVERSION, path), in)
 	if err != nil {
-		return nil, "", -1, err
+		return nil, -1, err
 	}
 	req.Header.Set("User-Agent", "Hyper-Client/"+utils.VERSION)
 	req.URL.Host = cli.addr
@@ -79,24 +89,24 @@ func (cli *HyperClient) clientRequest(method, path string, in io.Reader, headers
 	}
 	if err != nil {
 		if strings.Contains(err.Error(), "connection refused") {
-			return nil, "", statusCode, ErrConnectionRefused
+			return nil, statusCode, ErrConnectionRefused
 		}
 
-		return nil, "", statusCode, fmt.Errorf("An error occurred trying to connect: %v", err)
+		return nil, statusCode, fmt.Errorf("An error occurred trying to connect: %v", err)
 	}
 
 	if statusCode < 200 || statusCode >= 400 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return nil, "", statusCode, err
+			return nil, statusCode, err
 		}
 		if len(body) == 0 {
-			return nil, "", statusCode, fmt.Errorf("Error: request returned %s for API route and version %s, check if the server supports the requested API version", http.StatusText(statusCode), req.URL)
+			return nil, statusCode, fmt.Errorf("Error: request returned %s for API route and version %s, check if the server supports the requested API version", http.StatusText(statusCode), req.URL)
 		}
-		return nil, "", statusCode, fmt.Errorf("Error from daemon's response: %s", bytes.TrimSpace(body))
+		return nil, statusCode, fmt.Errorf("Error from daemon's response: %s", bytes.TrimSpace(body))
 	}
 
-	return resp.Body, resp.Header.Get("Content-Type"), statusCode, nil
+	return resp, statusCode, nil
 }
 
 func (cli *HyperClient) clientRequestAttemptLogin(method, path string, in io.Reader, out io.Writer, index *registry.IndexInfo, cmdName string) (io.ReadCloser, int, error) {
@@ -1,12 +1,19 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/docker/docker/pkg/promise"
+	"github.com/docker/docker/pkg/units"
 	"github.com/hyperhq/hyper/engine"
+	hypertypes "github.com/hyperhq/hyper/types"
 	"github.com/hyperhq/runv/hypervisor/types"
 
 	gflag "github.com/jessevdk/go-flags"
@@ -81,3 +88,127 @@ func (cli *HyperClient) CreateVm(cpu, mem int, async bool) (id string, err error
 
 	return id, err
 }
+
+func (cli *HyperClient) HyperCmdVmInfo(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "vm info VM_ID|POD_ID\n\nDisplay the information of a VM, and the health reported by its guest"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"vm info\" requires a minimum of 1 argument, please provide VM ID or POD ID.\n")
+	}
+
+	v := url.Values{}
+	v.Set("id", args[2])
+	body, _, err := readBody(cli.call("GET", "/vm/info?"+v.Encode(), nil, nil))
+	if err != nil {
+		return err
+	}
+	var info hypertypes.VmInfo
+	if err := json.Unmarshal(body, &info); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cli.out, "ID: %s\n", info.Id)
+	fmt.Fprintf(cli.out, "Status: %s\n", info.Status)
+	if info.Pod != "" {
+		fmt.Fprintf(cli.out, "Pod: %s\n", info.Pod)
+	}
+	fmt.Fprintf(cli.out, "Vcpu: %d\n", info.Vcpu)
+	fmt.Fprintf(cli.out, "Memory: %d MB\n", info.Memory)
+
+	h := info.Health
+	if h == nil {
+		fmt.Fprintf(cli.out, "Guest Health: unknown, the guest does not report heartbeats\n")
+		return nil
+	}
+	fmt.Fprintf(cli.out, "Guest Health: %s\n", h.Status)
+	if h.Reason != "" {
+		fmt.Fprintf(cli.out, "  Reason: %s\n", h.Reason)
+	}
+	fmt.Fprintf(cli.out, "  Last Heartbeat: %s\n", h.LastHeartbeat)
+	fmt.Fprintf(cli.out, "  Uptime: %s\n", units.HumanDuration(time.Duration(h.Uptime)*time.Second))
+	fmt.Fprintf(cli.out, "  Load Average: %.2f %.2f %.2f\n", h.Load[0], h.Load[1], h.Load[2])
+	fmt.Fprintf(cli.out, "  Memory: %s available of %s\n", units.BytesSize(float64(h.MemAvailable)), units.BytesSize(float64(h.MemTotal)))
+	for _, fs := range h.Filesystems {
+		fmt.Fprintf(cli.out, "  Filesystem %s: %s free of %s\n", fs.Path, units.BytesSize(float64(fs.Free)), units.BytesSize(float64(fs.Total)))
+	}
+
+	return nil
+}
+
+func (cli *HyperClient) HyperCmdVmConsole(args ...string) error {
+	var opts struct {
+		Follow bool `short:"f" long:"follow" default:"false" default-mask:"-" description:"Follow the console output"`
+	}
+	var parser = gflag.NewParser(&opts, gflag.Default)
+	parser.Usage = "vm console [OPTIONS] VM_ID|POD_ID\n\nPrint the console output of a VM, or the last VM of a pod"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"vm console\" requires a minimum of 1 argument, please provide VM ID or POD ID.\n")
+	}
+
+	v := url.Values{}
+	v.Set("id", args[2])
+	if opts.Follow {
+		v.Set("follow", "yes")
+	}
+	headers := http.Header(make(map[string][]string))
+	return cli.stream("GET", "/vm/console?"+v.Encode(), nil, cli.out, headers)
+}
+
+func (cli *HyperClient) HyperCmdVmAttachConsole(args ...string) error {
+	var parser = gflag.NewParser(nil, gflag.Default)
+	parser.Usage = "vm attach-console VM_ID|POD_ID\n\nAttach to the serial console of a VM for debugging, detach with the DetachKeys of hyperd (ctrl-p,ctrl-q by default)"
+	args, err := parser.Parse()
+	if err != nil {
+		if !strings.Contains(err.Error(), "Usage") {
+			return err
+		} else {
+			return nil
+		}
+	}
+	if len(args) < 3 {
+		return fmt.Errorf("\"vm attach-console\" requires a minimum of 1 argument, please provide VM ID or POD ID.\n")
+	}
+
+	v := url.Values{}
+	v.Set("id", args[2])
+
+	hijacked := make(chan io.Closer)
+	// Block the return until the chan gets closed
+	defer func() {
+		if _, ok := <-hijacked; ok {
+			fmt.Printf("Hijack did not finish (chan still open)\n")
+		}
+	}()
+	errCh := promise.Go(func() error {
+		return cli.hijack("POST", "/vm/console/attach?"+v.Encode(), true, cli.in, cli.out, cli.out, hijacked, nil, "")
+	})
+
+	// Acknowledge the hijack before starting
+	select {
+	case closer := <-hijacked:
+		if closer != nil {
+			defer closer.Close()
+		}
+	case err := <-errCh:
+		if err != nil {
+			return err
+		}
+	}
+	return <-errCh
+}
@@ -0,0 +1,84 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+// archiveContainer returns the VM of the running container, and the id of
+// the container
+func (daemon *Daemon) archiveContainer(name, path string) (*hypervisor.Vm, string, error) {
+	if name == "" {
+		return nil, "", fmt.Errorf("Null container name")
+	}
+	if path == "" {
+		return nil, "", fmt.Errorf("Can not copy files without the path in the container")
+	}
+	p, idx, err := daemon.GetPodByContainerIdOrName(name)
+	if err != nil {
+		return nil, "", err
+	}
+	status, vm := p.snapshot()
+	if vm == nil || status.Status != types.S_POD_RUNNING {
+		return nil, "", fmt.Errorf("Container %s is not running", name)
+	}
+	return vm, status.Containers[idx].Id, nil
+}
+
+func (daemon *Daemon) CmdContainerStatPath(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not stat without container and path")
+	}
+	vm, container, err := daemon.archiveContainer(job.Args[0], job.Args[1])
+	if err != nil {
+		return err
+	}
+
+	stat, err := vm.StatPath(container, job.Args[1])
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.SetJson("data", stat)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CmdContainerGetArchive writes the tar stream of the path in the container
+func (daemon *Daemon) CmdContainerGetArchive(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not copy files without container and path")
+	}
+	vm, container, err := daemon.archiveContainer(job.Args[0], job.Args[1])
+	if err != nil {
+		return err
+	}
+
+	glog.V(1).Infof("archive path %s of container %s", job.Args[1], container)
+	daemon.LogEvent(EVENT_TYPE_CONTAINER, "archive-path", container, map[string]string{"path": job.Args[1]})
+	return vm.GetArchive(container, job.Args[1], job.Stdout)
+}
+
+// CmdContainerPutArchive extracts the tar stream of the stdin to the
+// directory in the container
+func (daemon *Daemon) CmdContainerPutArchive(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not copy files without container and path")
+	}
+	vm, container, err := daemon.archiveContainer(job.Args[0], job.Args[1])
+	if err != nil {
+		return err
+	}
+	noOverwriteDirNonDir := len(job.Args) > 2 && job.Args[2] == "yes"
+
+	glog.V(1).Infof("extract to dir %s of container %s", job.Args[1], container)
+	daemon.LogEvent(EVENT_TYPE_CONTAINER, "extract-to-dir", container, map[string]string{"path": job.Args[1]})
+	return vm.PutArchive(container, job.Args[1], noOverwriteDirNonDir, job.Stdin)
+}
@@ -47,7 +47,7 @@ func (daemon *Daemon) CmdAttach(job *engine.Job) (err error) {
 		}
 	}
 
-	vm, ok := daemon.VmList[vmId]
+	vm, ok := daemon.GetVm(vmId)
 	if !ok {
 		return fmt.Errorf("Can find VM whose Id is %s!", vmId)
 	}
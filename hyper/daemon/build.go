@@ -5,15 +5,20 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/types"
 )
 
 func (daemon *Daemon) CmdBuild(job *engine.Job) error {
 	imgName := job.Args[0]
 	size, _ := strconv.Atoi(job.Args[1])
 	content := job.Stdin
+	config := &types.ImageBuildConfig{}
+	if err := job.GetenvJson("ImageBuildConfig", config); err != nil {
+		return err
+	}
 
 	cli := daemon.DockerCli
-	_, _, err := cli.SendImageBuild(imgName, size, content)
+	cache, _, err := cli.SendImageBuild(imgName, size, content, config)
 	if err != nil {
 		glog.Error(err.Error())
 		return err
@@ -23,6 +28,7 @@ func (daemon *Daemon) CmdBuild(job *engine.Job) error {
 	v.SetJson("ID", daemon.ID)
 	v.SetInt("Code", 0)
 	v.Set("Cause", "")
+	v.SetList("CacheImages", cache)
 	if _, err := v.WriteTo(job.Stdout); err != nil {
 		return err
 	}
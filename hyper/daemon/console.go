@@ -0,0 +1,222 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/runv/hypervisor"
+)
+
+const (
+	// the interval to check the new output of the console log
+	CONSOLE_POLL_INTERVAL = 200 * time.Millisecond
+
+	// the keys to detach from the attached console, the same as docker
+	DEFAULT_DETACH_KEYS = "ctrl-p,ctrl-q"
+)
+
+func (daemon *Daemon) CmdVmConsole(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not read the console without vm id")
+	}
+	vmId := daemon.consoleVmId(job.Args[0])
+	follow := len(job.Args) > 1 && job.Args[1] == "yes"
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, job.Stdin)
+		close(closed)
+	}()
+
+	running := func() bool {
+		if !follow {
+			return false
+		}
+		_, ok := daemon.GetVm(vmId)
+		return ok
+	}
+	return copyConsoleLog(hypervisor.ConsoleLogPath(vmId), job.Stdout, running, closed)
+}
+
+func (daemon *Daemon) CmdVmAttachConsole(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not attach the console without vm id")
+	}
+	if !daemon.ConsoleAttach {
+		return fmt.Errorf("Attaching the console of VMs is disabled, set ConsoleAttach in the config of hyperd to enable it")
+	}
+	vmId := daemon.consoleVmId(job.Args[0])
+	vm, ok := daemon.GetVm(vmId)
+	if !ok {
+		return fmt.Errorf("Can not find the VM(%s)", vmId)
+	}
+
+	glog.Infof("attach the console of vm %s", vmId)
+	daemon.LogEvent(EVENT_TYPE_VM, "attach_console", vmId, nil)
+	return vm.AttachConsole(newDetachReader(job.Stdin, daemon.DetachKeys), job.Stdout)
+}
+
+// consoleVmId returns the vm of the console log, the id may be a VM or a
+// pod, whose last VM is used after it exits
+func (daemon *Daemon) consoleVmId(id string) string {
+	if _, ok := daemon.GetVm(id); ok {
+		return id
+	}
+	if p, ok := daemon.PodList.Get(id); ok {
+		if vm := p.getVm(); vm != nil {
+			return vm.Id
+		}
+		if rs := daemon.getPodRestart(id); rs.Vm != "" {
+			return rs.Vm
+		}
+	}
+	return id
+}
+
+// copyConsoleLog writes the console log to out. It keeps waiting for the
+// new output while following returns true, until done is closed.
+func copyConsoleLog(path string, out io.Writer, following func() bool, done <-chan struct{}) error {
+	if f, err := os.Open(path + ".1"); err == nil {
+		_, err = io.Copy(out, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no console log found")
+		}
+		return err
+	}
+	defer func() { f.Close() }()
+
+	for {
+		if _, err := io.Copy(out, f); err != nil {
+			glog.V(1).Infof("console: client is gone, %v", err)
+			return nil
+		}
+
+		// the log is rotated, the rest of it has been read from the old file
+		if cur, err := f.Stat(); err == nil {
+			if fi, err := os.Stat(path); err == nil && !os.SameFile(cur, fi) {
+				nf, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				f.Close()
+				f = nf
+				continue
+			}
+		}
+
+		if !following() {
+			return nil
+		}
+		select {
+		case <-done:
+			return nil
+		case <-time.After(CONSOLE_POLL_INTERVAL):
+		}
+	}
+}
+
+// parseDetachKeys parses the comma separated keys, a key is a single
+// character or ctrl-<char>, e.g. "ctrl-p,ctrl-q"
+func parseDetachKeys(keys string) ([]byte, error) {
+	var seq []byte
+	for _, key := range strings.Split(keys, ",") {
+		key = strings.TrimSpace(key)
+		switch {
+		case len(key) == 1:
+			seq = append(seq, key[0])
+		case strings.HasPrefix(key, "ctrl-") && len(key) == 6:
+			c := key[5]
+			if c >= 'A' && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			switch {
+			case c >= 'a' && c <= 'z':
+				seq = append(seq, c-'a'+1)
+			case c == '@':
+				seq = append(seq, 0)
+			case c >= '[' && c <= '_':
+				seq = append(seq, c-'['+27)
+			default:
+				return nil, fmt.Errorf("invalid detach key %s", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %s", key)
+		}
+	}
+	return seq, nil
+}
+
+// detachReader reads from the client until the detach keys, which are not
+// passed to the console. The keys partially matched are passed once they
+// turn out not to be the detach keys.
+type detachReader struct {
+	r        io.Reader
+	keys     []byte
+	matched  int
+	pending  []byte
+	detached bool
+	err      error
+}
+
+func newDetachReader(r io.Reader, keys []byte) io.Reader {
+	if len(keys) == 0 {
+		return r
+	}
+	return &detachReader{r: r, keys: keys}
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for len(d.pending) == 0 {
+		if d.detached {
+			return 0, io.EOF
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		n, err := d.r.Read(buf)
+		for _, b := range buf[:n] {
+			if b == d.keys[d.matched] {
+				d.matched++
+				if d.matched == len(d.keys) {
+					glog.V(1).Info("detached from the console")
+					d.detached = true
+					break
+				}
+				continue
+			}
+			d.pending = append(d.pending, d.keys[:d.matched]...)
+			d.matched = 0
+			if b == d.keys[0] {
+				d.matched = 1
+				continue
+			}
+			d.pending = append(d.pending, b)
+		}
+		if err != nil && !d.detached {
+			// the client is gone in the middle of the keys
+			d.pending = append(d.pending, d.keys[:d.matched]...)
+			d.matched = 0
+		}
+		d.err = err
+	}
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
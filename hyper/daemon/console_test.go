@@ -0,0 +1,93 @@
+package daemon
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyConsoleLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "console.log")
+
+	out := &bytes.Buffer{}
+	if err := copyConsoleLog(path, out, func() bool { return false }, nil); err == nil {
+		t.Fatal("reading a missing console log should fail")
+	}
+
+	ioutil.WriteFile(path+".1", []byte("booting\n"), 0640)
+	ioutil.WriteFile(path, []byte("panic\n"), 0640)
+
+	// the log is rotated while following it
+	polls := 0
+	following := func() bool {
+		polls++
+		switch polls {
+		case 1:
+			f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0640)
+			f.WriteString("rotated\n")
+			f.Close()
+			os.Rename(path, path+".1")
+			ioutil.WriteFile(path, []byte("reboot\n"), 0640)
+			return true
+		}
+		return false
+	}
+	if err := copyConsoleLog(path, out, following, make(chan struct{})); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "booting\npanic\nrotated\nreboot\n" {
+		t.Fatalf("unexpected console output %q", out.String())
+	}
+}
+
+func TestParseDetachKeys(t *testing.T) {
+	cases := map[string][]byte{
+		"ctrl-p,ctrl-q": {16, 17},
+		"ctrl-@,ctrl-[": {0, 27},
+		"a, ctrl-C":     {'a', 3},
+	}
+	for keys, expected := range cases {
+		seq, err := parseDetachKeys(keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(seq, expected) {
+			t.Fatalf("%s: expected %v, got %v", keys, expected, seq)
+		}
+	}
+	for _, keys := range []string{"ctrl-", "ctrl-1", "ab", ""} {
+		if _, err := parseDetachKeys(keys); err == nil {
+			t.Fatalf("%q should be invalid", keys)
+		}
+	}
+}
+
+func TestDetachReader(t *testing.T) {
+	keys := []byte{16, 17}
+	cases := []struct {
+		input  string
+		output string
+	}{
+		{"ls\n\x10\x11echo", "ls\n"},
+		// a partial match is passed to the console
+		{"a\x10b\x10\x10\x11c", "a\x10b\x10"},
+		{"no detach\x10", "no detach\x10"},
+	}
+	for _, c := range cases {
+		out, err := ioutil.ReadAll(newDetachReader(bytes.NewBufferString(c.input), keys))
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if string(out) != c.output {
+			t.Fatalf("%q: expected %q, got %q", c.input, c.output, out)
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Unknwon/goconfig"
 	dockertypes "github.com/docker/docker/api/types"
@@ -16,6 +18,7 @@ import (
 	"github.com/hyperhq/hyper/engine"
 	"github.com/hyperhq/hyper/lib/portallocator"
 	apiserver "github.com/hyperhq/hyper/server"
+	hypertypes "github.com/hyperhq/hyper/types"
 	"github.com/hyperhq/hyper/utils"
 	"github.com/hyperhq/runv/hypervisor"
 	"github.com/hyperhq/runv/hypervisor/pod"
@@ -39,9 +42,12 @@ type DockerInterface interface {
 	SendCmdAuth(body io.ReadCloser) (string, error)
 	SendCmdPush(remote string, ipconfig *graph.ImagePushConfig) error
 	SendImageDelete(args ...string) ([]dockertypes.ImageDelete, error)
-	SendImageBuild(image string, size int, context io.ReadCloser) ([]byte, int, error)
+	SendImageBuild(image string, size int, context io.ReadCloser, config *hypertypes.ImageBuildConfig) ([]string, int, error)
+	SendImageSave(names []string, out io.Writer) error
+	SendImageLoad(in io.ReadCloser, out io.Writer) error
 	SendContainerCommit(args ...string) ([]byte, int, error)
 	SendContainerRename(oName, nName string) error
+	SendContainerCopy(id, resource string) (io.ReadCloser, error)
 	Shutdown() error
 	Setup() error
 }
@@ -64,6 +70,26 @@ type Daemon struct {
 	Storage     Storage
 	Hypervisor  string
 	DefaultLog  *pod.PodLogConfig
+	// how long the logs of a removed pod are kept
+	LogRetention time.Duration
+	// whether the serial console of the VMs can be attached, and the keys
+	// to detach from it
+	ConsoleAttach bool
+	DetachKeys    []byte
+	// the percent of the disk used to prune automatically, 0 to disable it
+	GCThreshold int
+	GCInterval  time.Duration
+	// whether the automatic prune removes the stopped pods
+	GCPrunePods bool
+	secretKey   []byte
+	events      *Events
+	execs       *ExecList
+	// guards VmList, use GetVm and ListVms to read it
+	vmLock sync.RWMutex
+	// serializes the prunes
+	gcLock sync.Mutex
+	// serializes the changes of the secret store
+	secretLock sync.Mutex
 }
 
 // Install installs daemon capabilities to eng.
@@ -90,10 +116,21 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"podStop":           daemon.CmdPodStop,
 		"podMigrate":		 daemon.CmdPodMigrate,
 		"podListen":		 daemon.CmdPodListen,
+		"podVolumeAttach":   daemon.CmdPodVolumeAttach,
+		"podVolumeDetach":   daemon.CmdPodVolumeDetach,
 		"vmCreate":          daemon.CmdVmCreate,
 		"vmKill":            daemon.CmdVmKill,
+		"vmConsole":         daemon.CmdVmConsole,
+		"vmAttachConsole":   daemon.CmdVmAttachConsole,
+		"vmInfo":            daemon.CmdVmInfo,
+		"systemDf":          daemon.CmdSystemDf,
+		"systemPrune":       daemon.CmdSystemPrune,
+		"containerStatPath": daemon.CmdContainerStatPath,
+		"containerGetArchive": daemon.CmdContainerGetArchive,
+		"containerPutArchive": daemon.CmdContainerPutArchive,
 		"list":              daemon.CmdList,
 		"exec":              daemon.CmdExec,
+		"execInspect":       daemon.CmdExecInspect,
 		"exitcode":          daemon.CmdExitCode,
 		"attach":            daemon.CmdAttach,
 		"tty":               daemon.CmdTty,
@@ -101,11 +138,21 @@ func (daemon *Daemon) Install(eng *engine.Engine) error {
 		"serviceList":       daemon.GetServices,
 		"serviceUpdate":     daemon.UpdateService,
 		"serviceDelete":     daemon.DeleteService,
+		"secretCreate":      daemon.CmdSecretCreate,
+		"secretList":        daemon.CmdSecretList,
+		"secretRemove":      daemon.CmdSecretRemove,
+		"templateCreate":    daemon.CmdTemplateCreate,
+		"templateList":      daemon.CmdTemplateList,
+		"templateRemove":    daemon.CmdTemplateRemove,
+		"templateRender":    daemon.CmdTemplateRender,
+		"events":            daemon.CmdEvents,
 		"serveapi":          apiserver.ServeApi,
 		"acceptconnections": apiserver.AcceptConnections,
 
 		"images":       daemon.CmdImages,
 		"imagesremove": daemon.CmdImagesRemove,
+		"imagesave":    daemon.CmdImageSave,
+		"imageload":    daemon.CmdImageLoad,
 	} {
 		glog.V(3).Infof("Engine Register: name= %s", name)
 		if err := eng.Register(name, method); err != nil {
@@ -143,11 +190,6 @@ func (daemon *Daemon) Restore() error {
 		return err
 	}
 
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-	defer glog.V(2).Infof("unlock PodList")
-	defer daemon.PodList.Unlock()
-
 	for k, v := range podList {
 		err = daemon.CreatePod(k, v, false)
 		if err != nil {
@@ -162,9 +204,16 @@ func (daemon *Daemon) Restore() error {
 		p, _ := daemon.PodList.Get(k)
 		if err := p.AssociateVm(daemon, string(vmId)); err != nil {
 			glog.V(1).Info("Some problem during associate vm %s to pod %s, %v", string(vmId), k, err)
+			// the vm is gone while hyperd was down
+			daemon.DeleteVmByPod(k)
+			p.status.Lock()
+			p.status.Status = types.S_POD_FAILED
+			p.status.Unlock()
+			daemon.podExited(p.status, string(vmId), false, true)
 			// continue to next
 		}
 	}
+	daemon.resumePodRestarts()
 
 	return nil
 }
@@ -208,6 +257,50 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 	cbfs, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Cbfs")
 	glog.V(0).Infof("The config: bios=%s, cbfs=%s", bios, cbfs)
 	host, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "Host")
+	var logRetention time.Duration
+	if retention, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "LogRetention"); retention != "" {
+		if logRetention, err = time.ParseDuration(retention); err != nil {
+			glog.Errorf("Invalid LogRetention %s, %s", retention, err.Error())
+			return nil, err
+		}
+	}
+	consoleAttach := false
+	if attach, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "ConsoleAttach"); attach != "" {
+		if consoleAttach, err = strconv.ParseBool(attach); err != nil {
+			glog.Errorf("Invalid ConsoleAttach %s, %s", attach, err.Error())
+			return nil, err
+		}
+	}
+	keys, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "DetachKeys")
+	if keys == "" {
+		keys = DEFAULT_DETACH_KEYS
+	}
+	detachKeys, err := parseDetachKeys(keys)
+	if err != nil {
+		glog.Errorf("Invalid DetachKeys %s, %s", keys, err.Error())
+		return nil, err
+	}
+	gcThreshold := 0
+	if threshold, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "GCThreshold"); threshold != "" {
+		if gcThreshold, err = parseGCThreshold(threshold); err != nil {
+			glog.Errorf("Invalid GCThreshold %s, %s", threshold, err.Error())
+			return nil, err
+		}
+	}
+	gcInterval := DEFAULT_GC_INTERVAL
+	if interval, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "GCInterval"); interval != "" {
+		if gcInterval, err = time.ParseDuration(interval); err != nil || gcInterval <= 0 {
+			glog.Errorf("Invalid GCInterval %s", interval)
+			return nil, fmt.Errorf("Invalid GCInterval %s", interval)
+		}
+	}
+	gcPrunePods := false
+	if prunePods, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "GCPrunePods"); prunePods != "" {
+		if gcPrunePods, err = strconv.ParseBool(prunePods); err != nil {
+			glog.Errorf("Invalid GCPrunePods %s, %s", prunePods, err.Error())
+			return nil, err
+		}
+	}
 
 	var tempdir = path.Join(utils.HYPER_ROOT, "run")
 	os.Setenv("TMPDIR", tempdir)
@@ -229,6 +322,11 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 		glog.Errorf("open leveldb file failed, %s", err.Error())
 		return nil, err
 	}
+	secretKey, err := loadSecretKey(path.Join(realRoot, SECRET_KEY_FILE))
+	if err != nil {
+		glog.Errorf("load secret key failed, %s", err.Error())
+		return nil, err
+	}
 	dockerCli, err1 := NewDocker()
 	if err1 != nil {
 		glog.Errorf(err1.Error())
@@ -250,6 +348,19 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 		Host:        host,
 		BridgeIP:    bridgeip,
 		BridgeIface: biface,
+		secretKey:   secretKey,
+
+		LogRetention:  logRetention,
+		ConsoleAttach: consoleAttach,
+		DetachKeys:    detachKeys,
+		GCThreshold:   gcThreshold,
+		GCInterval:    gcInterval,
+		GCPrunePods:   gcPrunePods,
+		events:        NewEvents(),
+		execs:         NewExecList(),
+	}
+	if logRetention > 0 {
+		go daemon.sweepPodLogsLoop()
 	}
 
 	// Get the docker daemon info
@@ -265,6 +376,9 @@ func NewDaemonFromDirectory(eng *engine.Engine) (*Daemon, error) {
 	}
 	daemon.Storage = stor
 	daemon.Storage.Init()
+	if gcThreshold > 0 {
+		go daemon.gcLoop(gcThreshold, gcInterval, gcPrunePods)
+	}
 	eng.OnShutdown(func() {
 		if err := daemon.shutdown(); err != nil {
 			glog.Errorf("Error during daemon.shutdown(): %v", err)
@@ -350,6 +464,7 @@ func (daemon *Daemon) GetPod(podId, podArgs string, autoremove bool) (*Pod, erro
 	if err = daemon.AddPod(pod, podArgs); err != nil {
 		return nil, err
 	}
+	daemon.LogPodEvent("create", podId, nil)
 
 	return pod, nil
 }
@@ -369,7 +484,7 @@ func (daemon *Daemon) DeletePodFromDB(podName string) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	return daemon.deletePodRestart(podName)
 }
 
 func (daemon *Daemon) SetVolumeId(podId, volName, dev_id string) error {
@@ -510,24 +625,19 @@ func (daemon *Daemon) DeleteVmByPod(podId string) error {
 }
 
 func (daemon *Daemon) GetVmByPodId(podId string) (string, error) {
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer glog.V(2).Infof("unlock read of PodList")
-	defer daemon.PodList.RUnlock()
 	pod, ok := daemon.PodList.Get(podId)
 	if !ok {
 		return "", fmt.Errorf("Not found Pod %s", podId)
 	}
+	pod.status.RLock()
+	defer pod.status.RUnlock()
 	return pod.status.Vm, nil
 }
 
 func (daemon *Daemon) GetPodByContainer(containerId string) (string, error) {
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer glog.V(2).Infof("unlock read of PodList")
-	defer daemon.PodList.RUnlock()
-
 	pod := daemon.PodList.Find(func(p *Pod) bool {
+		p.status.RLock()
+		defer p.status.RUnlock()
 		for _, c := range p.status.Containers {
 			if c.Id == containerId {
 				return true
@@ -544,11 +654,6 @@ func (daemon *Daemon) GetPodByContainer(containerId string) (string, error) {
 }
 
 func (daemon *Daemon) GetPodByContainerIdOrName(name string) (pod *Pod, idx int, err error) {
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer glog.V(2).Infof("unlock read of PodList")
-	defer daemon.PodList.RUnlock()
-
 	err = nil
 	wslash := name
 	if name[0] != '/' {
@@ -557,6 +662,8 @@ func (daemon *Daemon) GetPodByContainerIdOrName(name string) (pod *Pod, idx int,
 
 	var c *hypervisor.Container
 	pod = daemon.PodList.Find(func(p *Pod) bool {
+		p.status.RLock()
+		defer p.status.RUnlock()
 		for idx, c = range p.status.Containers {
 			if c.Name == wslash || c.Id == name {
 				return true
@@ -606,14 +713,35 @@ func (daemon *Daemon) RemovePod(podId string) {
 }
 
 func (daemon *Daemon) AddVm(vm *hypervisor.Vm) {
+	daemon.vmLock.Lock()
+	defer daemon.vmLock.Unlock()
 	daemon.VmList[vm.Id] = vm
 	glog.V(1).Infof("add new vm : %s", vm.Id)
 }
 
 func (daemon *Daemon) RemoveVm(vmId string) {
+	daemon.vmLock.Lock()
+	defer daemon.vmLock.Unlock()
 	delete(daemon.VmList, vmId)
 }
 
+func (daemon *Daemon) GetVm(vmId string) (*hypervisor.Vm, bool) {
+	daemon.vmLock.RLock()
+	defer daemon.vmLock.RUnlock()
+	vm, ok := daemon.VmList[vmId]
+	return vm, ok
+}
+
+func (daemon *Daemon) ListVms() []*hypervisor.Vm {
+	daemon.vmLock.RLock()
+	defer daemon.vmLock.RUnlock()
+	vms := make([]*hypervisor.Vm, 0, len(daemon.VmList))
+	for _, vm := range daemon.VmList {
+		vms = append(vms, vm)
+	}
+	return vms
+}
+
 func (daemon *Daemon) UpdateVmData(vmId string, data []byte) error {
 	key := fmt.Sprintf("vmdata-%s", vmId)
 	_, err := daemon.db.Get([]byte(key), nil)
@@ -656,17 +784,17 @@ func (daemon *Daemon) DeleteVmData(vmId string) error {
 
 func (daemon *Daemon) DestroyAllVm() error {
 	glog.V(0).Info("The daemon will stop all pod")
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-
 	daemon.PodList.Foreach(func(p *Pod) error {
+		p, err := daemon.lockPod(p.id)
+		if err != nil {
+			return nil
+		}
+		defer daemon.unlockPod(p)
 		if _, _, err := daemon.StopPod(p.id, "yes"); err != nil {
 			glog.V(1).Infof("fail to stop %s: %v", p.id, err)
 		}
 		return nil
 	})
-	daemon.PodList.Unlock()
-	glog.V(2).Infof("unlock PodList")
 	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("vm-")), nil)
 	for iter.Next() {
 		key := iter.Key()
@@ -692,8 +820,8 @@ func (daemon *Daemon) DestroyAndKeepVm() error {
 func (daemon *Daemon) shutdown() error {
 	glog.V(0).Info("The daemon will be shutdown")
 	glog.V(0).Info("Shutdown all VMs")
-	for vm := range daemon.VmList {
-		daemon.KillVm(vm)
+	for _, vm := range daemon.ListVms() {
+		daemon.KillVm(vm.Id)
 	}
 	daemon.db.Close()
 	glog.Flush()
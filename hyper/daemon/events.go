@@ -0,0 +1,257 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+)
+
+const (
+	EVENT_TYPE_POD   = "pod"
+	EVENT_TYPE_VM    = "vm"
+	EVENT_TYPE_EXEC  = "exec"
+	EVENT_TYPE_IMAGE = "image"
+
+	// the number of events kept for `since` queries
+	MAX_EVENTS_LOG = 1024
+)
+
+type Event struct {
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Time       int64             `json:"time"`
+	TimeNano   int64             `json:"timeNano"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type Events struct {
+	sync.Mutex
+	events      []*Event
+	subscribers map[chan *Event]struct{}
+}
+
+func NewEvents() *Events {
+	return &Events{
+		events:      []*Event{},
+		subscribers: make(map[chan *Event]struct{}),
+	}
+}
+
+// Subscribe returns the logged events and a channel for the new ones
+func (e *Events) Subscribe() ([]*Event, chan *Event) {
+	e.Lock()
+	defer e.Unlock()
+
+	ch := make(chan *Event, 128)
+	e.subscribers[ch] = struct{}{}
+	logged := make([]*Event, len(e.events))
+	copy(logged, e.events)
+	return logged, ch
+}
+
+func (e *Events) Evict(ch chan *Event) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.subscribers, ch)
+}
+
+func (e *Events) Log(ev *Event) {
+	e.Lock()
+	defer e.Unlock()
+
+	if len(e.events) >= MAX_EVENTS_LOG {
+		e.events = e.events[1:]
+	}
+	e.events = append(e.events, ev)
+	for ch := range e.subscribers {
+		// a slow subscriber should not block the daemon
+		select {
+		case ch <- ev:
+		default:
+			glog.Warningf("event %s %s of %s is dropped for a slow subscriber", ev.Type, ev.Action, ev.ID)
+		}
+	}
+}
+
+func (daemon *Daemon) LogEvent(typ, action, id string, attrs map[string]string) {
+	daemon.logEvent(typ, action, id, nil, attrs)
+}
+
+// LogPodEvent logs a pod event, the labels of the pod are attached to it
+func (daemon *Daemon) LogPodEvent(action, podId string, attrs map[string]string) {
+	if p, ok := daemon.PodList.Get(podId); ok {
+		daemon.logPodEvent(action, p, attrs)
+		return
+	}
+	daemon.logEvent(EVENT_TYPE_POD, action, podId, nil, attrs)
+}
+
+func (daemon *Daemon) logPodEvent(action string, p *Pod, attrs map[string]string) {
+	var labels map[string]string
+	if attrs == nil {
+		attrs = make(map[string]string)
+	}
+	attrs["name"] = p.status.Name
+	if p.spec != nil {
+		labels = p.spec.Labels
+	}
+	daemon.logEvent(EVENT_TYPE_POD, action, p.id, labels, attrs)
+}
+
+func (daemon *Daemon) logEvent(typ, action, id string, labels, attrs map[string]string) {
+	if daemon.events == nil {
+		return
+	}
+	now := time.Now()
+	daemon.events.Log(&Event{
+		Type:       typ,
+		Action:     action,
+		ID:         id,
+		Time:       now.Unix(),
+		TimeNano:   now.UnixNano(),
+		Labels:     labels,
+		Attributes: attrs,
+	})
+}
+
+type eventFilter map[string][]string
+
+// parseEventFilters parses the filters in `key=value` format, the filters
+// of the same key are ORed, and the different keys are ANDed.
+func parseEventFilters(args []string) (eventFilter, error) {
+	filters := eventFilter{}
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Bad format of filter (expected name=value): %s", arg)
+		}
+		switch kv[0] {
+		case "type", "event", "id", "label":
+		default:
+			return nil, fmt.Errorf("Invalid filter '%s'", kv[0])
+		}
+		filters[kv[0]] = append(filters[kv[0]], kv[1])
+	}
+	return filters, nil
+}
+
+func (f eventFilter) match(ev *Event) bool {
+	for key, values := range f {
+		matched := false
+		for _, v := range values {
+			switch key {
+			case "type":
+				matched = ev.Type == v
+			case "event":
+				matched = ev.Action == v
+			case "id":
+				matched = ev.ID == v || ev.Attributes["name"] == v
+			case "label":
+				kv := strings.SplitN(v, "=", 2)
+				value, ok := ev.Labels[kv[0]]
+				matched = ok && (len(kv) == 1 || value == kv[1])
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func parseEventTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid timestamp %s", s)
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// CmdEvents streams the daemon events to the client. The job returns when
+// `until` is reached, or the client closes the job's stdin.
+func (daemon *Daemon) CmdEvents(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not execute 'events' command without since and until!")
+	}
+	since, err := parseEventTime(job.Args[0])
+	if err != nil {
+		return err
+	}
+	until, err := parseEventTime(job.Args[1])
+	if err != nil {
+		return err
+	}
+	filters, err := parseEventFilters(job.Args[2:])
+	if err != nil {
+		return err
+	}
+
+	logged, ch := daemon.events.Subscribe()
+	defer daemon.events.Evict(ch)
+
+	enc := json.NewEncoder(job.Stdout)
+	send := func(ev *Event) error {
+		if !filters.match(ev) {
+			return nil
+		}
+		return enc.Encode(ev)
+	}
+
+	if !since.IsZero() {
+		for _, ev := range logged {
+			if ev.TimeNano < since.UnixNano() || (!until.IsZero() && ev.TimeNano > until.UnixNano()) {
+				continue
+			}
+			if err := send(ev); err != nil {
+				return nil
+			}
+		}
+	}
+
+	var timeout <-chan time.Time
+	if !until.IsZero() {
+		if !until.After(time.Now()) {
+			return nil
+		}
+		timeout = time.After(until.Sub(time.Now()))
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, job.Stdin)
+		close(closed)
+	}()
+
+	for {
+		select {
+		case ev := <-ch:
+			if err := send(ev); err != nil {
+				glog.V(1).Infof("events: client is gone, %v", err)
+				return nil
+			}
+		case <-timeout:
+			return nil
+		case <-closed:
+			return nil
+		}
+	}
+}
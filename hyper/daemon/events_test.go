@@ -0,0 +1,59 @@
+package daemon
+
+import (
+	"testing"
+)
+
+func TestEventFilters(t *testing.T) {
+	ev := &Event{
+		Type:       EVENT_TYPE_POD,
+		Action:     "start",
+		ID:         "pod-abc",
+		Labels:     map[string]string{"app": "web"},
+		Attributes: map[string]string{"name": "nginx"},
+	}
+
+	for _, c := range []struct {
+		filters []string
+		match   bool
+	}{
+		{nil, true},
+		{[]string{"type=pod"}, true},
+		{[]string{"type=vm"}, false},
+		{[]string{"type=vm", "type=pod"}, true},
+		{[]string{"type=pod", "event=stop"}, false},
+		{[]string{"id=nginx"}, true},
+		{[]string{"label=app"}, true},
+		{[]string{"label=app=web"}, true},
+		{[]string{"label=app=db"}, false},
+	} {
+		f, err := parseEventFilters(c.filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.match(ev) != c.match {
+			t.Errorf("filters %v should match: %v", c.filters, c.match)
+		}
+	}
+
+	if _, err := parseEventFilters([]string{"color=red"}); err == nil {
+		t.Error("unknown filter should be rejected")
+	}
+}
+
+func TestEventsLog(t *testing.T) {
+	events := NewEvents()
+	_, ch := events.Subscribe()
+	for i := 0; i < MAX_EVENTS_LOG+10; i++ {
+		events.Log(&Event{Type: EVENT_TYPE_VM, Action: "boot"})
+	}
+	events.Evict(ch)
+
+	logged, _ := events.Subscribe()
+	if len(logged) != MAX_EVENTS_LOG {
+		t.Fatalf("expect %d events logged, got %d", MAX_EVENTS_LOG, len(logged))
+	}
+	if len(ch) != cap(ch) {
+		t.Fatalf("the subscriber should get the events until its buffer is full")
+	}
+}
@@ -1,12 +1,87 @@
 package daemon
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/docker/docker/pkg/ioutils"
 	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/types"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
 )
 
+const (
+	// the number of finished exec sessions kept for inspect and exitcode
+	MAX_EXEC_SESSIONS = 1024
+)
+
+// ExecList keeps the exec sessions by their IDs
+type ExecList struct {
+	sync.Mutex
+	execs map[string]*types.ExecInfo
+	// the IDs in the order of creation, to evict the oldest finished ones
+	order []string
+}
+
+func NewExecList() *ExecList {
+	return &ExecList{
+		execs: make(map[string]*types.ExecInfo),
+	}
+}
+
+func (el *ExecList) Add(info *types.ExecInfo) error {
+	el.Lock()
+	defer el.Unlock()
+
+	if _, ok := el.execs[info.ID]; ok {
+		return fmt.Errorf("exec %s already exists", info.ID)
+	}
+	if len(el.order) >= MAX_EXEC_SESSIONS {
+		for i, id := range el.order {
+			if !el.execs[id].Running {
+				delete(el.execs, id)
+				el.order = append(el.order[:i], el.order[i+1:]...)
+				break
+			}
+		}
+	}
+	el.execs[info.ID] = info
+	el.order = append(el.order, info.ID)
+	return nil
+}
+
+// Get returns a copy of the exec session
+func (el *ExecList) Get(id string) (*types.ExecInfo, bool) {
+	el.Lock()
+	defer el.Unlock()
+
+	info, ok := el.execs[id]
+	if !ok {
+		return nil, false
+	}
+	c := *info
+	return &c, true
+}
+
+func (el *ExecList) Finish(id string, code int) {
+	el.Lock()
+	defer el.Unlock()
+
+	if info, ok := el.execs[id]; ok {
+		info.Running = false
+		info.ExitCode = code
+		info.FinishedAt = time.Now().Format(time.RFC3339)
+	}
+}
+
 func (daemon *Daemon) CmdExitCode(job *engine.Job) (err error) {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not execute 'exitstatus' command without container id!")
@@ -23,24 +98,32 @@ func (daemon *Daemon) CmdExitCode(job *engine.Job) (err error) {
 		vmId      string
 		podId     string
 	)
-	glog.V(1).Infof("Get container id is %s", container)
-	podId, err = daemon.GetPodByContainer(container)
-	if err != nil {
-		return
-	}
 
-	vmId, err = daemon.GetVmByPodId(podId)
-	if err != nil {
-		return err
-	}
+	// the exit code of an exec session is kept after the VM forgets it
+	if info, ok := daemon.execs.Get(tag); ok {
+		if !info.Running {
+			code = info.ExitCode
+		}
+	} else {
+		glog.V(1).Infof("Get container id is %s", container)
+		podId, err = daemon.GetPodByContainer(container)
+		if err != nil {
+			return
+		}
 
-	vm, ok := daemon.VmList[vmId]
-	if !ok {
-		return fmt.Errorf("Can not find VM whose Id is %s!", vmId)
-	}
+		vmId, err = daemon.GetVmByPodId(podId)
+		if err != nil {
+			return err
+		}
 
-	if _, ok := vm.ExitCodes[tag]; ok {
-		code = int(vm.ExitCodes[tag])
+		vm, ok := daemon.GetVm(vmId)
+		if !ok {
+			return fmt.Errorf("Can not find VM whose Id is %s!", vmId)
+		}
+
+		if c, ok := vm.ExitCode(tag); ok {
+			code = int(c)
+		}
 	}
 
 	v := &engine.Env{}
@@ -53,22 +136,36 @@ func (daemon *Daemon) CmdExitCode(job *engine.Job) (err error) {
 	return nil
 }
 
+// CmdExec runs the command in the container, or in the VM of the pod. The
+// tag is the ID of the exec session, and the env, user, workdir and detach
+// options are taken from the environment of the job. A detached exec returns
+// after the process is started, with the ID written to the stdout.
 func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not execute 'exec' command without any container ID!")
 	}
-	if len(job.Args) == 1 {
+	if len(job.Args) < 3 {
 		return fmt.Errorf("Can not execute 'exec' command without any command!")
 	}
 	var (
 		typeKey   = job.Args[0]
 		typeVal   = job.Args[1]
 		cmd       = job.Args[2]
-		tag       = job.Args[3]
+		tag       string
 		vmId      string
 		podId     string
 		container string
+		command   []string
 	)
+	if len(job.Args) > 3 {
+		tag = job.Args[3]
+	}
+	if tag == "" {
+		tag = "exec-" + utils.RandStr(10, "alphanum")
+	}
+	if err := json.Unmarshal([]byte(cmd), &command); err != nil {
+		return err
+	}
 
 	// We need find the vm id which running POD, and stop it
 	if typeKey == "pod" {
@@ -88,19 +185,101 @@ func (daemon *Daemon) CmdExec(job *engine.Job) (err error) {
 		return err
 	}
 
-	vm, ok := daemon.VmList[vmId]
+	vm, ok := daemon.GetVm(vmId)
 	if !ok {
 		return fmt.Errorf("Can not find VM whose Id is %s!", vmId)
 	}
 
-	err = vm.Exec(job.Stdin, job.Stdout, cmd, tag, container)
+	info := &types.ExecInfo{
+		ID:        tag,
+		Container: container,
+		Vm:        vmId,
+		Command:   command,
+		Env:       job.GetenvList("Env"),
+		User:      job.Getenv("User"),
+		Workdir:   job.Getenv("Workdir"),
+		Detach:    job.GetenvBool("Detach"),
+		Running:   true,
+		StartedAt: time.Now().Format(time.RFC3339),
+	}
+	if err := daemon.execs.Add(info); err != nil {
+		return err
+	}
+
+	if info.Detach {
+		go daemon.runExec(vm, info, nil, ioutils.NopWriteCloser(ioutil.Discard))
+
+		v := &engine.Env{}
+		v.Set("ID", info.ID)
+		if _, err := v.WriteTo(job.Stdout); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	return daemon.runExec(vm, info, job.Stdin, job.Stdout)
+}
+
+// runExec runs the process of the exec session, and keeps its exit code in
+// the session after it exits
+func (daemon *Daemon) runExec(vm *hypervisor.Vm, info *types.ExecInfo, stdin io.ReadCloser, stdout io.WriteCloser) error {
+	attrs := map[string]string{"vm": info.Vm, "tag": info.ID}
+	if info.Container != "" {
+		attrs["container"] = info.Container
+	}
+	daemon.LogEvent(EVENT_TYPE_EXEC, "start", info.ID, attrs)
+
+	err := vm.AddProcess(stdin, stdout, info.Container, info.ID, info.Command, info.Env, info.User, info.Workdir)
 
+	exitCode := -1
+	if code, ok := vm.TakeExitCode(info.ID); ok {
+		exitCode = int(code)
+	}
+	daemon.execs.Finish(info.ID, exitCode)
 	if err != nil {
+		glog.Errorf("exec %s failed: %v", info.ID, err)
 		return err
 	}
 
-	defer func() {
-		glog.V(2).Info("Defer function for exec!")
-	}()
+	exitAttrs := map[string]string{"exitCode": strconv.Itoa(exitCode)}
+	for k, v := range attrs {
+		exitAttrs[k] = v
+	}
+	daemon.LogEvent(EVENT_TYPE_EXEC, "exit", info.ID, exitAttrs)
+	return nil
+}
+
+// CmdExecInspect writes the exec session of the ID
+func (daemon *Daemon) CmdExecInspect(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not inspect exec without the exec ID")
+	}
+
+	info, ok := daemon.execs.Get(job.Args[0])
+	if !ok {
+		return fmt.Errorf("Can not find exec %s", job.Args[0])
+	}
+
+	// the values of the variables may carry secrets, only the names are
+	// shown
+	info.Env = envNames(info.Env)
+
+	v := &engine.Env{}
+	v.SetJson("data", info)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
 	return nil
 }
+
+// envNames returns the names of the KEY=VALUE variables
+func envNames(env []string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(env))
+	for _, e := range env {
+		names = append(names, strings.SplitN(e, "=", 2)[0])
+	}
+	return names
+}
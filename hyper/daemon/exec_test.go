@@ -0,0 +1,77 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/types"
+)
+
+func TestExecList(t *testing.T) {
+	execs := NewExecList()
+	if err := execs.Add(&types.ExecInfo{ID: "running", Running: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := execs.Add(&types.ExecInfo{ID: "running"}); err == nil {
+		t.Fatal("the duplicated exec ID should be rejected")
+	}
+
+	for i := 1; i < MAX_EXEC_SESSIONS+10; i++ {
+		id := fmt.Sprintf("exec-%d", i)
+		if err := execs.Add(&types.ExecInfo{ID: id, Running: true}); err != nil {
+			t.Fatal(err)
+		}
+		execs.Finish(id, i%256)
+	}
+
+	// the oldest finished ones are evicted, the running one is kept
+	if _, ok := execs.Get("running"); !ok {
+		t.Fatal("the running exec should not be evicted")
+	}
+	if _, ok := execs.Get("exec-1"); ok {
+		t.Fatal("the oldest finished exec should be evicted")
+	}
+	info, ok := execs.Get(fmt.Sprintf("exec-%d", MAX_EXEC_SESSIONS+9))
+	if !ok || info.Running || info.ExitCode != (MAX_EXEC_SESSIONS+9)%256 || info.FinishedAt == "" {
+		t.Fatalf("unexpected exec session %v", info)
+	}
+}
+
+func TestExecInspectHidesEnv(t *testing.T) {
+	daemon := &Daemon{execs: NewExecList()}
+	if err := daemon.execs.Add(&types.ExecInfo{ID: "exec-1", Env: []string{"TOKEN=secret", "DEBUG=1", "EMPTY"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	eng := engine.New("")
+	if err := eng.Register("execInspect", daemon.CmdExecInspect); err != nil {
+		t.Fatal(err)
+	}
+	job := eng.Job("execInspect", "exec-1")
+	out := bytes.NewBuffer(nil)
+	job.Stdout.Add(out)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Fatalf("inspect should not show the values of the variables: %s", out.String())
+	}
+
+	v := &engine.Env{}
+	if err := v.Decode(out); err != nil {
+		t.Fatal(err)
+	}
+	info := &types.ExecInfo{}
+	if err := v.GetJson("data", info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Env) != 3 || info.Env[0] != "TOKEN" || info.Env[1] != "DEBUG" || info.Env[2] != "EMPTY" {
+		t.Fatalf("expect the names of the variables, got %v", info.Env)
+	}
+	if stored, _ := daemon.execs.Get("exec-1"); stored.Env[0] != "TOKEN=secret" {
+		t.Fatal("inspect should not change the exec session")
+	}
+}
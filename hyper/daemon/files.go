@@ -0,0 +1,161 @@
+package daemon
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+const (
+	DEFAULT_FILE_MAX_SIZE int64 = 64 * 1024 * 1024
+	DEFAULT_FILE_TIMEOUT        = 60 * time.Second
+
+	IMAGE_URI_PREFIX = "image:"
+)
+
+// fileFetcher fetches the content of the files injected into the pods
+type fileFetcher struct {
+	dclient DockerInterface
+	// backoff returns how long to wait before the retry-th retry
+	backoff func(retry int) time.Duration
+}
+
+func newFileFetcher(dclient DockerInterface) *fileFetcher {
+	return &fileFetcher{
+		dclient: dclient,
+		backoff: func(retry int) time.Duration { return time.Duration(retry) * time.Second },
+	}
+}
+
+// fetch returns the decoded content of an injected file. The fetch is
+// retried for file.Retries times, and the content is verified against
+// file.MaxSize and file.Sha256 before anything gets into the pod.
+func (f *fileFetcher) fetch(file *pod.UserFile) (data []byte, err error) {
+	for i := 0; i <= file.Retries; i++ {
+		if i > 0 {
+			glog.Warningf("fetch file %s failed: %v, retry %d/%d", file.Name, err, i, file.Retries)
+			time.Sleep(f.backoff(i))
+		}
+		data, err = f.fetchOnce(file)
+		if err == nil {
+			return data, nil
+		}
+	}
+	return nil, err
+}
+
+func (f *fileFetcher) fetchOnce(file *pod.UserFile) ([]byte, error) {
+	var src io.Reader
+
+	if file.Uri != "" {
+		urisrc, err := f.openUri(file)
+		if err != nil {
+			return nil, err
+		}
+		defer urisrc.Close()
+		src = urisrc
+	} else {
+		src = strings.NewReader(file.Contents)
+	}
+
+	switch file.Encoding {
+	case "base64":
+		src = base64.NewDecoder(base64.StdEncoding, src)
+	default:
+	}
+
+	max := fileMaxSize(file)
+	data, err := ioutil.ReadAll(io.LimitReader(src, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("File %s exceeds the max size %d", file.Name, max)
+	}
+
+	if file.Sha256 != "" {
+		sum := sha256.Sum256(data)
+		if !strings.EqualFold(hex.EncodeToString(sum[:]), file.Sha256) {
+			return nil, fmt.Errorf("File %s checksum mismatch: expected sha256 %s, got %x", file.Name, file.Sha256, sum)
+		}
+	}
+
+	return data, nil
+}
+
+func fileMaxSize(file *pod.UserFile) int64 {
+	if file.MaxSize == 0 {
+		return DEFAULT_FILE_MAX_SIZE
+	}
+	return file.MaxSize
+}
+
+func (f *fileFetcher) openUri(file *pod.UserFile) (io.ReadCloser, error) {
+	switch {
+	case strings.HasPrefix(file.Uri, pod.SECRET_URI_PREFIX):
+		// the files are written into the rootfs, the secrets are only
+		// delivered in memory with the secrets of the containers
+		return nil, fmt.Errorf("File %s: secrets can not be injected as files, use the secrets of the container", file.Name)
+	case strings.HasPrefix(file.Uri, IMAGE_URI_PREFIX):
+		return imageFileReader(f.dclient, strings.TrimPrefix(file.Uri, IMAGE_URI_PREFIX), fileMaxSize(file))
+	}
+
+	timeout := DEFAULT_FILE_TIMEOUT
+	if file.Timeout > 0 {
+		timeout = time.Duration(file.Timeout) * time.Second
+	}
+	return utils.UriReaderWithTimeout(file.Uri, timeout)
+}
+
+// imageFileReader reads a file out of an image, the reference looks like
+// "busybox:latest#/etc/passwd"
+func imageFileReader(dclient DockerInterface, ref string, max int64) (io.ReadCloser, error) {
+	sep := strings.LastIndex(ref, "#")
+	if sep <= 0 || sep == len(ref)-1 {
+		return nil, fmt.Errorf("Malformed image file URI %s, should be image:<image>#<path>", ref)
+	}
+	image, resource := ref[:sep], ref[sep+1:]
+
+	id, _, err := dclient.SendCmdCreate("", image, []string{}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if _, _, err := dclient.SendCmdDelete(string(id)); err != nil {
+			glog.Warningf("failed to remove the temporary container %s: %v", string(id), err)
+		}
+	}()
+
+	archive, err := dclient.SendContainerCopy(string(id), resource)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return nil, fmt.Errorf("%s in image %s is not a regular file", resource, image)
+	}
+	// not to read a huge file into the memory, the size is checked by the
+	// caller
+	data, err := ioutil.ReadAll(io.LimitReader(tr, max+1))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,104 @@
+package daemon
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+func TestFetchUserFile(t *testing.T) {
+	var (
+		inputs = map[string]pod.UserFile{
+			"content":  {Name: "a", Encoding: "raw", Contents: "hello"},
+			"base64":   {Name: "b", Encoding: "base64", Contents: "aGVsbG8="},
+			"data":     {Name: "c", Uri: "data:,hello"},
+			"data64":   {Name: "d", Uri: "data:text/plain;base64,aGVsbG8="},
+			"checksum": {Name: "e", Contents: "hello", Sha256: "2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824"},
+			"mismatch": {Name: "f", Contents: "hellO", Sha256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", Retries: 1},
+			"oversize": {Name: "g", Contents: "hello", MaxSize: 4},
+			"secret":   {Name: "h", Uri: "secret:foo"},
+			"unknown":  {Name: "i", Uri: "ftp://localhost/hello"},
+		}
+		ok = map[string]bool{
+			"content":  true,
+			"base64":   true,
+			"data":     true,
+			"data64":   true,
+			"checksum": true,
+		}
+	)
+
+	var retries []int
+	fetcher := newFileFetcher(nil)
+	fetcher.backoff = func(retry int) time.Duration {
+		retries = append(retries, retry)
+		return 0
+	}
+
+	for name, f := range inputs {
+		data, err := fetcher.fetch(&f)
+		if !ok[name] {
+			if err == nil {
+				t.Errorf("%s: should fail, got %q", name, string(data))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", name, err)
+		} else if string(data) != "hello" {
+			t.Errorf("%s: got %q", name, string(data))
+		}
+	}
+
+	if len(retries) != 1 || retries[0] != 1 {
+		t.Errorf("only the mismatch should be retried once, got %v", retries)
+	}
+}
+
+// fakeImageDocker serves the files of the images from the content
+type fakeImageDocker struct {
+	DockerInterface
+	content []byte
+}
+
+func (d *fakeImageDocker) SendCmdCreate(name, image string, cmds []string, config interface{}) ([]byte, int, error) {
+	return []byte("tmp"), 0, nil
+}
+
+func (d *fakeImageDocker) SendCmdDelete(arg ...string) ([]byte, int, error) {
+	return nil, 0, nil
+}
+
+func (d *fakeImageDocker) SendContainerCopy(id, resource string) (io.ReadCloser, error) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: int64(len(d.content)), Typeflag: tar.TypeReg})
+	tw.Write(d.content)
+	tw.Close()
+	return ioutil.NopCloser(buf), nil
+}
+
+func TestFetchImageFile(t *testing.T) {
+	fetcher := newFileFetcher(&fakeImageDocker{content: bytes.Repeat([]byte("x"), 100)})
+
+	data, err := fetcher.fetch(&pod.UserFile{Name: "a", Uri: "image:busybox#/file"})
+	if err != nil || len(data) != 100 {
+		t.Fatalf("unexpected image file %d bytes, %v", len(data), err)
+	}
+
+	if _, err := fetcher.fetch(&pod.UserFile{Name: "b", Uri: "image:busybox#/file", MaxSize: 10}); err == nil {
+		t.Fatal("the file over the max size should fail")
+	}
+	r, err := imageFileReader(fetcher.dclient, "busybox#/file", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(r); len(data) != 11 {
+		t.Fatalf("only max+1 bytes should be read, got %d", len(data))
+	}
+}
@@ -0,0 +1,403 @@
+package daemon
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/types"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+const (
+	HEALTH_STARTING  = "starting"
+	HEALTH_HEALTHY   = "healthy"
+	HEALTH_UNHEALTHY = "unhealthy"
+
+	PROBE_LIVENESS  = "liveness"
+	PROBE_READINESS = "readiness"
+
+	EVENT_TYPE_CONTAINER = "container"
+
+	// defaults of the probe, the same as kubernetes
+	DEFAULT_PROBE_PERIOD            = 10
+	DEFAULT_PROBE_TIMEOUT           = 1
+	DEFAULT_PROBE_SUCCESS_THRESHOLD = 1
+	DEFAULT_PROBE_FAILURE_THRESHOLD = 3
+
+	// the output of a failed exec probe kept in the status
+	MAX_PROBE_OUTPUT = 1024
+)
+
+type probeStreak struct {
+	success int
+	failure int
+}
+
+type containerHealth struct {
+	status    string
+	ready     bool
+	restarts  int
+	liveness  probeStreak
+	readiness probeStreak
+	lastProbe time.Time
+	lastError string
+}
+
+// podHealth keeps the probe results of the containers of a running pod,
+// the probes are stopped by closing stop.
+type podHealth struct {
+	sync.Mutex
+	containers map[string]*containerHealth
+	stop       chan struct{}
+}
+
+func newPodHealth() *podHealth {
+	return &podHealth{
+		containers: make(map[string]*containerHealth),
+		stop:       make(chan struct{}),
+	}
+}
+
+func probeSettings(probe *pod.UserProbe) (delay, period, timeout time.Duration, success, failure int) {
+	delay = time.Duration(probe.InitialDelay) * time.Second
+	period = DEFAULT_PROBE_PERIOD * time.Second
+	if probe.Period > 0 {
+		period = time.Duration(probe.Period) * time.Second
+	}
+	timeout = DEFAULT_PROBE_TIMEOUT * time.Second
+	if probe.Timeout > 0 {
+		timeout = time.Duration(probe.Timeout) * time.Second
+	}
+	success, failure = DEFAULT_PROBE_SUCCESS_THRESHOLD, DEFAULT_PROBE_FAILURE_THRESHOLD
+	if probe.SuccessThreshold > 0 {
+		success = probe.SuccessThreshold
+	}
+	if probe.FailureThreshold > 0 {
+		failure = probe.FailureThreshold
+	}
+	return
+}
+
+// update records the result of a probe. It returns whether the health
+// status or readiness is changed, and whether the container should be
+// restarted.
+func (h *podHealth) update(container, kind string, probe *pod.UserProbe, err error) (changed, restart bool) {
+	h.Lock()
+	defer h.Unlock()
+
+	ch, ok := h.containers[container]
+	if !ok {
+		return false, false
+	}
+	_, _, _, successThreshold, failureThreshold := probeSettings(probe)
+
+	streak := &ch.liveness
+	if kind == PROBE_READINESS {
+		streak = &ch.readiness
+	}
+	ch.lastProbe = time.Now()
+	if err == nil {
+		streak.success++
+		streak.failure = 0
+		ch.lastError = ""
+	} else {
+		streak.failure++
+		streak.success = 0
+		ch.lastError = err.Error()
+	}
+
+	switch kind {
+	case PROBE_LIVENESS:
+		if streak.success >= successThreshold && ch.status != HEALTH_HEALTHY {
+			ch.status = HEALTH_HEALTHY
+			changed = true
+		} else if streak.failure >= failureThreshold {
+			if ch.status != HEALTH_UNHEALTHY {
+				ch.status = HEALTH_UNHEALTHY
+				changed = true
+			}
+			restart = true
+		}
+	case PROBE_READINESS:
+		if streak.success >= successThreshold && !ch.ready {
+			ch.ready = true
+			changed = true
+		} else if streak.failure >= failureThreshold && ch.ready {
+			ch.ready = false
+			changed = true
+		}
+	}
+	return
+}
+
+// restarted resets the probe results after the container is restarted
+func (h *podHealth) restarted(container string) {
+	h.Lock()
+	defer h.Unlock()
+
+	if ch, ok := h.containers[container]; ok {
+		ch.restarts++
+		ch.status = HEALTH_STARTING
+		ch.ready = false
+		ch.liveness = probeStreak{}
+		ch.readiness = probeStreak{}
+	}
+}
+
+func (h *podHealth) get(container string) *types.HealthStatus {
+	h.Lock()
+	defer h.Unlock()
+
+	ch, ok := h.containers[container]
+	if !ok {
+		return nil
+	}
+	status := &types.HealthStatus{
+		Status:        ch.status,
+		Ready:         ch.ready,
+		FailingStreak: ch.liveness.failure,
+		Restarts:      ch.restarts,
+		LastError:     ch.lastError,
+	}
+	if ch.readiness.failure > status.FailingStreak {
+		status.FailingStreak = ch.readiness.failure
+	}
+	if !ch.lastProbe.IsZero() {
+		status.LastProbe = ch.lastProbe.Format(time.RFC3339)
+	}
+	return status
+}
+
+// startProbes starts the probes of the containers of the running pod
+func (daemon *Daemon) startProbes(p *Pod) {
+	status, vm := p.snapshot()
+	if p.health != nil || vm == nil {
+		return
+	}
+
+	h := newPodHealth()
+	for i, c := range status.Containers {
+		if i >= len(p.spec.Containers) {
+			break
+		}
+		uc := &p.spec.Containers[i]
+		if uc.LivenessProbe == nil && uc.ReadinessProbe == nil {
+			continue
+		}
+		ch := &containerHealth{status: HEALTH_STARTING}
+		if uc.LivenessProbe == nil {
+			ch.status = HEALTH_HEALTHY
+		}
+		if uc.ReadinessProbe == nil {
+			ch.ready = true
+		}
+		h.containers[c.Id] = ch
+
+		if uc.LivenessProbe != nil {
+			go daemon.probeLoop(p, h, c.Id, PROBE_LIVENESS, uc.LivenessProbe)
+		}
+		if uc.ReadinessProbe != nil {
+			go daemon.probeLoop(p, h, c.Id, PROBE_READINESS, uc.ReadinessProbe)
+		}
+	}
+	if len(h.containers) > 0 {
+		glog.V(1).Infof("start the probes of %d containers of pod %s", len(h.containers), p.id)
+		p.health = h
+	}
+}
+
+func (p *Pod) stopProbes() {
+	if p.health != nil {
+		close(p.health.stop)
+		p.health = nil
+	}
+}
+
+func (p *Pod) healthStatus(container string) *types.HealthStatus {
+	h := p.health
+	if h == nil {
+		return nil
+	}
+	return h.get(container)
+}
+
+func (daemon *Daemon) probeLoop(p *Pod, h *podHealth, container, kind string, probe *pod.UserProbe) {
+	delay, period, timeout, _, _ := probeSettings(probe)
+
+	for {
+		select {
+		case <-time.After(delay):
+		case <-h.stop:
+			return
+		}
+		delay = period
+
+		err := daemon.runProbe(p, container, probe, timeout)
+		if err != nil {
+			glog.V(1).Infof("%s probe of container %s failed: %v", kind, container, err)
+		}
+		changed, restart := h.update(container, kind, probe, err)
+		if changed {
+			hs := h.get(container)
+			attrs := map[string]string{"pod": p.id, "probe": kind, "ready": strconv.FormatBool(hs.Ready)}
+			daemon.LogEvent(EVENT_TYPE_CONTAINER, "health_status: "+hs.Status, container, attrs)
+		}
+		if !restart {
+			continue
+		}
+
+		select {
+		case <-h.stop:
+			return
+		default:
+		}
+		glog.Infof("container %s of pod %s is unhealthy, restart it", container, p.id)
+		if err := daemon.restartContainer(p, container, "unhealthy"); err != nil {
+			glog.Errorf("failed to restart unhealthy container %s: %v", container, err)
+			continue
+		}
+		// give the restarted container the initial delay again
+		delay, _, _, _, _ = probeSettings(probe)
+	}
+}
+
+func (daemon *Daemon) runProbe(p *Pod, container string, probe *pod.UserProbe, timeout time.Duration) error {
+	vm := p.getVm()
+	if vm == nil {
+		return fmt.Errorf("pod %s is not running", p.id)
+	}
+
+	switch {
+	case probe.Exec != nil:
+		return execCommand(vm, container, probe.Exec.Command, timeout)
+	case probe.TcpSocket != nil:
+		ip, err := podIP(p, vm)
+		if err != nil {
+			return err
+		}
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(probe.TcpSocket.Port)), timeout)
+		if err != nil {
+			return err
+		}
+		conn.Close()
+		return nil
+	case probe.HttpGet != nil:
+		ip, err := podIP(p, vm)
+		if err != nil {
+			return err
+		}
+		return httpProbe(ip, probe.HttpGet, timeout)
+	}
+	return fmt.Errorf("probe without action")
+}
+
+func podIP(p *Pod, vm *hypervisor.Vm) (string, error) {
+	ips := p.status.GetPodIP(vm)
+	if len(ips) == 0 {
+		return "", fmt.Errorf("pod %s has no ip", p.id)
+	}
+	return ips[0], nil
+}
+
+func httpProbe(ip string, action *pod.UserHttpGetAction, timeout time.Duration) error {
+	scheme := strings.ToLower(action.Scheme)
+	if scheme == "" {
+		scheme = "http"
+	}
+	path := action.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// the certificate of the container is not trusted by the host
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(ip, strconv.Itoa(action.Port)), path))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("http probe got status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeOutput keeps the head of the output of an exec probe or hook
+type probeOutput struct {
+	sync.Mutex
+	buf []byte
+}
+
+func (o *probeOutput) Write(p []byte) (int, error) {
+	o.Lock()
+	defer o.Unlock()
+	if n := MAX_PROBE_OUTPUT - len(o.buf); n > 0 {
+		if n > len(p) {
+			n = len(p)
+		}
+		o.buf = append(o.buf, p[:n]...)
+	}
+	return len(p), nil
+}
+
+func (o *probeOutput) Close() error { return nil }
+
+func (o *probeOutput) String() string {
+	o.Lock()
+	defer o.Unlock()
+	return strings.TrimSpace(string(o.buf))
+}
+
+// execCommand runs the command in the container and fails if it exits
+// with non-zero code or does not exit in timeout, the process is killed then
+func execCommand(vm *hypervisor.Vm, container string, command []string, timeout time.Duration) error {
+	cmd, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	tag := "exec-" + utils.RandStr(8, "alphanum")
+	out := &probeOutput{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- vm.Exec(nil, out, string(cmd), tag, container)
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		if err := vm.KillProcess(container, tag, syscall.SIGKILL); err != nil {
+			glog.Warningf("failed to kill the timed out exec %s in container %s: %v", tag, container, err)
+		}
+		go func() {
+			<-done
+			vm.TakeExitCode(tag)
+		}()
+		return fmt.Errorf("exec timed out after %v", timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	code, ok := vm.TakeExitCode(tag)
+	if !ok || code != 0 {
+		return fmt.Errorf("exec exited with %d: %s", code, out.String())
+	}
+	return nil
+}
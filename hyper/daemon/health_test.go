@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+func TestProbeThresholds(t *testing.T) {
+	h := newPodHealth()
+	h.containers["c1"] = &containerHealth{status: HEALTH_STARTING}
+	probe := &pod.UserProbe{SuccessThreshold: 2, FailureThreshold: 2}
+	fail := errors.New("connection refused")
+
+	if changed, _ := h.update("c1", PROBE_LIVENESS, probe, nil); changed {
+		t.Fatal("status should not change before success threshold")
+	}
+	if changed, _ := h.update("c1", PROBE_LIVENESS, probe, nil); !changed || h.get("c1").Status != HEALTH_HEALTHY {
+		t.Fatal("container should become healthy")
+	}
+	if _, restart := h.update("c1", PROBE_LIVENESS, probe, fail); restart {
+		t.Fatal("container should not be restarted before failure threshold")
+	}
+	changed, restart := h.update("c1", PROBE_LIVENESS, probe, fail)
+	if !changed || !restart {
+		t.Fatal("container should be restarted after failure threshold")
+	}
+	s := h.get("c1")
+	if s.Status != HEALTH_UNHEALTHY || s.FailingStreak != 2 || s.LastError != fail.Error() {
+		t.Fatalf("unexpected health status %+v", s)
+	}
+
+	h.restarted("c1")
+	s = h.get("c1")
+	if s.Status != HEALTH_STARTING || s.Restarts != 1 || s.FailingStreak != 0 {
+		t.Fatalf("unexpected health status after restart %+v", s)
+	}
+
+	// readiness never restarts the container
+	h.update("c1", PROBE_READINESS, probe, nil)
+	h.update("c1", PROBE_READINESS, probe, nil)
+	if !h.get("c1").Ready {
+		t.Fatal("container should be ready")
+	}
+	h.update("c1", PROBE_READINESS, probe, fail)
+	if _, restart := h.update("c1", PROBE_READINESS, probe, fail); restart || h.get("c1").Ready {
+		t.Fatal("container should be not ready, without restart")
+	}
+}
+
+func TestHttpProbe(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	action := &pod.UserHttpGetAction{Path: "healthz", Port: p}
+
+	if err := httpProbe(host, action, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	status = http.StatusInternalServerError
+	if err := httpProbe(host, action, time.Second); err == nil {
+		t.Fatal("http probe should fail with status 500")
+	}
+}
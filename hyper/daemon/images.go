@@ -31,3 +31,17 @@ func (daemon *Daemon) CmdImages(job *engine.Job) error {
 
 	return nil
 }
+
+// CmdImageSave writes the images of the arguments, with their parents, as a
+// tar archive to the stdout
+func (daemon *Daemon) CmdImageSave(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not save images without any image")
+	}
+	return daemon.DockerCli.SendImageSave(job.Args, job.Stdout)
+}
+
+// CmdImageLoad loads the images of the tar archive read from the stdin
+func (daemon *Daemon) CmdImageLoad(job *engine.Job) error {
+	return daemon.DockerCli.SendImageLoad(job.Stdin, job.Stdout)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
@@ -69,10 +70,6 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not get Pod info without Pod ID")
 	}
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer daemon.PodList.RUnlock()
-	defer glog.V(2).Infof("unlock read of PodList")
 	var (
 		pod     *Pod
 		podId   string
@@ -92,10 +89,11 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 		}
 	}
 
+	ps, vm := pod.snapshot()
 	// Construct the PodInfo JSON structure
 	cStatus := []types.ContainerStatus{}
 	containers := []types.Container{}
-	for i, c := range pod.status.Containers {
+	for i, c := range ps.Containers {
 		ports := []types.ContainerPort{}
 		envs := []types.EnvironmentVar{}
 		vols := []types.VolumeMount{}
@@ -150,7 +148,7 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 			s.Waiting.Reason = "Pending"
 			s.Phase = "pending"
 		} else if c.Status == runvtypes.S_POD_RUNNING {
-			s.Running.StartedAt = pod.status.StartedAt
+			s.Running.StartedAt = ps.StartedAt
 			s.Phase = "running"
 		} else { // S_POD_FAILED or S_POD_SUCCEEDED
 			if c.Status == runvtypes.S_POD_FAILED {
@@ -162,9 +160,11 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 				s.Terminated.Reason = "Succeeded"
 				s.Phase = "succeeded"
 			}
-			s.Terminated.StartedAt = pod.status.StartedAt
-			s.Terminated.FinishedAt = pod.status.FinishedAt
+			s.Terminated.StartedAt = ps.StartedAt
+			s.Terminated.FinishedAt = ps.FinishedAt
 		}
+		s.RestartCount = pod.restarts.count(c.Id)
+		s.Health = pod.healthStatus(c.Id)
 		cStatus = append(cStatus, s)
 	}
 	podVoumes := []types.PodVolume{}
@@ -182,16 +182,27 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 		Memory:     pod.spec.Resource.Memory,
 	}
 	podIPs := []string{}
-	if pod.vm != nil {
-		podIPs = pod.status.GetPodIP(pod.vm)
+	if vm != nil {
+		podIPs = ps.GetPodIP(vm)
 	}
 	status := types.PodStatus{
 		Status:    cStatus,
 		HostIP:    utils.GetHostIP(),
 		PodIP:     podIPs,
-		StartTime: pod.status.StartedAt,
+		StartTime: ps.StartedAt,
+	}
+	rs := daemon.getPodRestart(pod.id)
+	status.RestartPolicy = ps.RestartPolicy
+	status.RestartCount = rs.Count
+	status.LastExitReason = rs.LastExitReason
+	status.LastExitCode = rs.LastExitCode
+	if ps.Status != runvtypes.S_POD_RUNNING {
+		status.FinishTime = ps.FinishedAt
+		if status.FinishTime == "" {
+			status.FinishTime = rs.FinishedAt
+		}
 	}
-	switch pod.status.Status {
+	switch ps.Status {
 	case runvtypes.S_POD_CREATED:
 		status.Phase = "Pending"
 		break
@@ -209,7 +220,7 @@ func (daemon *Daemon) CmdPodInfo(job *engine.Job) error {
 	data := types.PodInfo{
 		Kind:       "Pod",
 		ApiVersion: utils.APIVERSION,
-		Vm:         pod.status.Vm,
+		Vm:         ps.Vm,
 		Spec:       spec,
 		Status:     status,
 	}
@@ -226,10 +237,6 @@ func (daemon *Daemon) CmdPodStats(job *engine.Job) error {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not get Pod stats without Pod ID")
 	}
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer daemon.PodList.RUnlock()
-	defer glog.V(2).Infof("unlock read of PodList")
 	var (
 		pod   *Pod
 		podId string
@@ -248,11 +255,12 @@ func (daemon *Daemon) CmdPodStats(job *engine.Job) error {
 		}
 	}
 
-	if pod.vm == nil || pod.status.Status != runvtypes.S_POD_RUNNING {
+	ps, vm := pod.snapshot()
+	if vm == nil || ps.Status != runvtypes.S_POD_RUNNING {
 		return fmt.Errorf("Can not get pod stats for non-running pod (%s)", job.Args[0])
 	}
 
-	response := pod.vm.Stats()
+	response := vm.Stats()
 	if response.Data == nil {
 		return fmt.Errorf("Stats for pod %s is nil", job.Args[0])
 	}
@@ -270,12 +278,9 @@ func (daemon *Daemon) CmdContainerInfo(job *engine.Job) error {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not get Pod info without Pod ID")
 	}
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer daemon.PodList.RUnlock()
-	defer glog.V(2).Infof("unlock read of PodList")
 	var (
 		pod     *Pod
+		ps      *hypervisor.PodStatus
 		c       *hypervisor.Container
 		i       int = 0
 		imageid string
@@ -290,7 +295,8 @@ func (daemon *Daemon) CmdContainerInfo(job *engine.Job) error {
 		wslash = "/" + name
 	}
 	pod = daemon.PodList.Find(func(p *Pod) bool {
-		for i, c = range p.status.Containers {
+		ps = p.status.Snapshot()
+		for i, c = range ps.Containers {
 			if c.Name == wslash || c.Id == name {
 				return true
 			}
@@ -340,7 +346,7 @@ func (daemon *Daemon) CmdContainerInfo(job *engine.Job) error {
 		s.Waiting.Reason = "Pending"
 		s.Phase = "pending"
 	} else if c.Status == runvtypes.S_POD_RUNNING {
-		s.Running.StartedAt = pod.status.StartedAt
+		s.Running.StartedAt = ps.StartedAt
 		s.Phase = "running"
 	} else { // S_POD_FAILED or S_POD_SUCCEEDED
 		if c.Status == runvtypes.S_POD_FAILED {
@@ -352,9 +358,11 @@ func (daemon *Daemon) CmdContainerInfo(job *engine.Job) error {
 			s.Terminated.Reason = "Succeeded"
 			s.Phase = "succeeded"
 		}
-		s.Terminated.StartedAt = pod.status.StartedAt
-		s.Terminated.FinishedAt = pod.status.FinishedAt
+		s.Terminated.StartedAt = ps.StartedAt
+		s.Terminated.FinishedAt = ps.FinishedAt
 	}
+	s.RestartCount = pod.restarts.count(c.Id)
+	s.Health = pod.healthStatus(c.Id)
 	container := types.ContainerInfo{
 		Name:            c.Name,
 		ContainerID:     c.Id,
@@ -378,3 +386,64 @@ func (daemon *Daemon) CmdContainerInfo(job *engine.Job) error {
 
 	return nil
 }
+
+func (daemon *Daemon) CmdVmInfo(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not get VM info without VM ID")
+	}
+	// the VM of a running pod may be queried by the pod
+	vmId := job.Args[0]
+	if p, ok := daemon.PodList.Get(vmId); ok {
+		if _, vm := p.snapshot(); vm != nil {
+			vmId = vm.Id
+		}
+	}
+	vm, ok := daemon.GetVm(vmId)
+	if !ok {
+		return fmt.Errorf("Can not find the VM(%s)", job.Args[0])
+	}
+
+	info := types.VmInfo{
+		Id:     vm.Id,
+		Status: vmStatus(vm),
+		Vcpu:   vm.Cpu,
+		Memory: vm.Mem,
+	}
+	if vm.Pod != nil {
+		info.Pod = vm.Pod.Id
+	}
+	health, err := vm.GuestHealth()
+	if err != nil {
+		glog.Warningf("failed to get the guest health of vm %s: %v", vm.Id, err)
+	} else if health != nil {
+		info.Health = guestHealth(health)
+	}
+
+	v := &engine.Env{}
+	v.SetJson("data", info)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func guestHealth(h *runvtypes.GuestHealth) *types.GuestHealth {
+	health := &types.GuestHealth{
+		Status:        h.Status,
+		Reason:        h.Reason,
+		LastHeartbeat: h.LastHeartbeat.Format(time.RFC3339),
+		Uptime:        h.Uptime,
+		Load:          h.Load,
+		MemTotal:      h.MemTotal,
+		MemAvailable:  h.MemAvailable,
+	}
+	for _, fs := range h.Filesystems {
+		health.Filesystems = append(health.Filesystems, types.GuestFilesystem{
+			Path:  fs.Path,
+			Total: fs.Total,
+			Free:  fs.Free,
+		})
+	}
+	return health
+}
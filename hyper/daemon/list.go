@@ -2,9 +2,11 @@ package daemon
 
 import (
 	"fmt"
+	"path"
+	"strings"
 
-	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
+	hypertypes "github.com/hyperhq/hyper/types"
 	"github.com/hyperhq/runv/hypervisor"
 	"github.com/hyperhq/runv/hypervisor/types"
 )
@@ -17,6 +19,7 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 		dedicadedVM           bool           = false
 		vmId                  string         = ""
 		auxiliary             bool           = false
+		structured            bool           = false
 		pod                   *Pod           = nil
 		vm                    *hypervisor.Vm = nil
 		vmJsonResponse                       = []string{}
@@ -46,10 +49,10 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 		auxiliary = true
 	}
 
-	daemon.PodList.RLock()
-	glog.Infof("lock read of PodList")
-	defer glog.Infof("unlock read of PodList")
-	defer daemon.PodList.RUnlock()
+	// typed items instead of colon-joined strings, followed by the filters
+	if len(job.Args) > 4 && job.Args[4] == "json" {
+		structured = true
+	}
 
 	if dedicadedPod {
 		var ok bool
@@ -61,76 +64,88 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 
 	if dedicadedVM {
 		var ok bool
-		vm, ok = daemon.VmList[vmId]
+		vm, ok = daemon.GetVm(vmId)
 		if !ok || (vm == nil) {
 			return fmt.Errorf("Cannot find specified vm %s", vmId)
 		}
 	}
 
-	// Prepare the VM status to client
-	v := &engine.Env{}
-	v.Set("item", item)
+	// Select the vms and pods to show
+	var (
+		vms  []*hypervisor.Vm
+		pods []*Pod
+	)
 	if item == "vm" {
 		if !dedicadedPod && !dedicadedVM {
-			for v, info := range daemon.VmList {
-				vmJsonResponse = append(vmJsonResponse, v+":"+showVM(info))
+			for _, info := range daemon.ListVms() {
+				vms = append(vms, info)
 			}
 		} else if dedicadedPod && !dedicadedVM {
-			if v, ok := daemon.VmList[pod.status.Vm]; ok {
-				vmJsonResponse = append(vmJsonResponse, pod.status.Vm+":"+showVM(v))
+			if v, ok := daemon.GetVm(pod.status.Snapshot().Vm); ok {
+				vms = append(vms, v)
 			}
 		} else if !dedicadedPod && dedicadedVM {
-			vmJsonResponse = append(vmJsonResponse, vmId+":"+showVM(vm))
+			vms = append(vms, vm)
 		} else {
-			if pod.status.Vm == vmId {
-				vmJsonResponse = append(vmJsonResponse, vmId+":"+showVM(vm))
+			if pod.status.Snapshot().Vm == vmId {
+				vms = append(vms, vm)
 			}
 		}
-		v.SetList("vmData", vmJsonResponse)
-	}
-
-	if item == "pod" {
+	} else {
 		if !dedicadedPod && !dedicadedVM {
 			daemon.PodList.Foreach(func(p *Pod) error {
-				podJsonResponse = append(podJsonResponse, p.id+":"+showPod(p.status))
+				pods = append(pods, p)
 				return nil
 			})
 		} else if dedicadedPod && !dedicadedVM {
-			podJsonResponse = append(podJsonResponse, pod.id+":"+showPod(pod.status))
+			pods = append(pods, pod)
 		} else if !dedicadedPod && dedicadedVM {
 			daemon.PodList.Foreach(func(p *Pod) error {
-				if p.status.Vm == vmId {
-					podJsonResponse = append(podJsonResponse, p.id+":"+showPod(p.status))
+				if p.status.Snapshot().Vm == vmId {
+					pods = append(pods, p)
 				}
 				return nil
 			})
 		} else {
-			if pod.status.Vm == vmId {
-				podJsonResponse = append(podJsonResponse, pod.id+":"+showPod(pod.status))
+			if pod.status.Snapshot().Vm == vmId {
+				pods = append(pods, pod)
 			}
 		}
+	}
+
+	// Prepare the VM status to client
+	v := &engine.Env{}
+	v.Set("item", item)
+
+	if structured {
+		filters, err := parseListFilters(job.Args[5:])
+		if err != nil {
+			return err
+		}
+		v.SetJson("data", daemon.listItems(item, vms, pods, auxiliary, filters))
+		if _, err := v.WriteTo(job.Stdout); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if item == "vm" {
+		for _, info := range vms {
+			vmJsonResponse = append(vmJsonResponse, info.Id+":"+showVM(info))
+		}
+		v.SetList("vmData", vmJsonResponse)
+	}
+
+	if item == "pod" {
+		for _, p := range pods {
+			podJsonResponse = append(podJsonResponse, p.id+":"+showPod(p.status.Snapshot()))
+		}
 		v.SetList("podData", podJsonResponse)
 	}
 
 	if item == "container" {
-		if !dedicadedPod && !dedicadedVM {
-			daemon.PodList.Foreach(func(p *Pod) error {
-				containerJsonResponse = append(containerJsonResponse, showPodContainers(p.status, auxiliary)...)
-				return nil
-			})
-		} else if dedicadedPod && !dedicadedVM {
-			containerJsonResponse = append(containerJsonResponse, showPodContainers(pod.status, auxiliary)...)
-		} else if !dedicadedPod && dedicadedVM {
-			daemon.PodList.Foreach(func(p *Pod) error {
-				if p.status.Vm == vmId {
-					containerJsonResponse = append(containerJsonResponse, showPodContainers(p.status, auxiliary)...)
-				}
-				return nil
-			})
-		} else {
-			if pod.status.Vm == vmId {
-				containerJsonResponse = append(containerJsonResponse, showPodContainers(pod.status, auxiliary)...)
-			}
+		for _, p := range pods {
+			containerJsonResponse = append(containerJsonResponse, showPodContainers(p.status.Snapshot(), auxiliary)...)
 		}
 		v.SetList("cData", containerJsonResponse)
 	}
@@ -143,6 +158,15 @@ func (daemon *Daemon) CmdList(job *engine.Job) error {
 }
 
 func showVM(v *hypervisor.Vm) string {
+	p := ""
+	if v.Pod != nil {
+		p = v.Pod.Id
+	}
+
+	return p + ":" + vmStatus(v)
+}
+
+func vmStatus(v *hypervisor.Vm) string {
 	var status string
 	switch v.Status {
 	case types.S_VM_ASSOCIATED:
@@ -155,15 +179,15 @@ func showVM(v *hypervisor.Vm) string {
 		status = ""
 		break
 	}
-	p := ""
-	if v.Pod != nil {
-		p = v.Pod.Id
-	}
 
-	return p + ":" + status
+	return status
 }
 
 func showPod(pod *hypervisor.PodStatus) string {
+	return pod.Name + ":" + pod.Vm + ":" + podStatus(pod)
+}
+
+func podStatus(pod *hypervisor.PodStatus) string {
 	var status string
 
 	switch pod.Status {
@@ -185,12 +209,19 @@ func showPod(pod *hypervisor.PodStatus) string {
 		status = ""
 	}
 
-	return pod.Name + ":" + pod.Vm + ":" + status
+	return status
 }
 
 func showPodContainers(pod *hypervisor.PodStatus, aux bool) []string {
-
 	rsp := []string{}
+	for _, c := range podContainers(pod, aux) {
+		rsp = append(rsp, showContainer(c))
+	}
+	return rsp
+}
+
+func podContainers(pod *hypervisor.PodStatus, aux bool) []*hypervisor.Container {
+	containers := []*hypervisor.Container{}
 	filterServiceDiscovery := !aux && (pod.Type == "service-discovery")
 	proxyName := ServiceDiscoveryContainerName(pod.Name)
 
@@ -198,12 +229,16 @@ func showPodContainers(pod *hypervisor.PodStatus, aux bool) []string {
 		if filterServiceDiscovery && c.Name == proxyName {
 			continue
 		}
-		rsp = append(rsp, showContainer(c))
+		containers = append(containers, c)
 	}
-	return rsp
+	return containers
 }
 
 func showContainer(c *hypervisor.Container) string {
+	return c.Id + ":" + c.Name + ":" + c.PodId + ":" + containerStatus(c)
+}
+
+func containerStatus(c *hypervisor.Container) string {
 	var status string
 
 	switch c.Status {
@@ -219,5 +254,147 @@ func showContainer(c *hypervisor.Container) string {
 		status = ""
 	}
 
-	return c.Id + ":" + c.Name + ":" + c.PodId + ":" + status
+	return status
+}
+
+type listFilter map[string][]string
+
+// parseListFilters parses the filters in `key=value` format, the filters
+// of the same key are ORed, and the different keys are ANDed.
+func parseListFilters(args []string) (listFilter, error) {
+	filters := listFilter{}
+	for _, arg := range args {
+		if arg == "" {
+			continue
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Bad format of filter (expected name=value): %s", arg)
+		}
+		switch kv[0] {
+		case "label", "status":
+		case "name":
+			if _, err := path.Match(kv[1], ""); err != nil {
+				return nil, fmt.Errorf("Invalid name pattern %s: %v", kv[1], err)
+			}
+		default:
+			return nil, fmt.Errorf("Invalid filter '%s'", kv[0])
+		}
+		filters[kv[0]] = append(filters[kv[0]], kv[1])
+	}
+	return filters, nil
+}
+
+// match checks the name (a shell pattern), the status and the labels
+func (f listFilter) match(name, status string, labels map[string]string) bool {
+	for key, values := range f {
+		matched := false
+		for _, v := range values {
+			switch key {
+			case "name":
+				matched, _ = path.Match(v, strings.TrimPrefix(name, "/"))
+			case "status":
+				matched = status == v
+			case "label":
+				kv := strings.SplitN(v, "=", 2)
+				value, ok := labels[kv[0]]
+				matched = ok && (len(kv) == 1 || value == kv[1])
+			}
+			if matched {
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func podLabels(p *Pod) map[string]string {
+	if p.spec == nil {
+		return nil
+	}
+	return p.spec.Labels
+}
+
+func (daemon *Daemon) listItems(item string, vms []*hypervisor.Vm, pods []*Pod, aux bool, filters listFilter) *hypertypes.ListResponse {
+	res := &hypertypes.ListResponse{Item: item}
+
+	switch item {
+	case "vm":
+		res.Vms = []hypertypes.VmListItem{}
+		for _, vm := range vms {
+			var (
+				podId  string
+				labels map[string]string
+			)
+			if vm.Pod != nil {
+				podId = vm.Pod.Id
+				if p, ok := daemon.PodList.Get(podId); ok {
+					labels = podLabels(p)
+				}
+			}
+			status := vmStatus(vm)
+			if !filters.match(vm.Id, status, labels) {
+				continue
+			}
+			res.Vms = append(res.Vms, hypertypes.VmListItem{
+				ID:     vm.Id,
+				Pod:    podId,
+				Status: status,
+				Vcpu:   vm.Cpu,
+				Memory: vm.Mem,
+			})
+		}
+	case "pod":
+		res.Pods = []hypertypes.PodListItem{}
+		for _, p := range pods {
+			ps, vm := p.snapshot()
+			status := podStatus(ps)
+			if !filters.match(ps.Name, status, podLabels(p)) {
+				continue
+			}
+			info := hypertypes.PodListItem{
+				ID:         p.id,
+				Name:       ps.Name,
+				Vm:         ps.Vm,
+				Status:     status,
+				Type:       ps.Type,
+				Labels:     podLabels(p),
+				CreatedAt:  ps.CreatedAt,
+				StartedAt:  ps.StartedAt,
+				FinishedAt: ps.FinishedAt,
+			}
+			if p.spec != nil {
+				info.Vcpu = p.spec.Resource.Vcpu
+				info.Memory = p.spec.Resource.Memory
+			}
+			if vm != nil && ps.Status == types.S_POD_RUNNING {
+				info.PodIP = ps.GetPodIP(vm)
+			}
+			res.Pods = append(res.Pods, info)
+		}
+	case "container":
+		res.Containers = []hypertypes.ContainerListItem{}
+		for _, p := range pods {
+			for _, c := range podContainers(p.status.Snapshot(), aux) {
+				status := containerStatus(c)
+				if !filters.match(c.Name, status, podLabels(p)) {
+					continue
+				}
+				res.Containers = append(res.Containers, hypertypes.ContainerListItem{
+					ID:       c.Id,
+					Name:     strings.TrimPrefix(c.Name, "/"),
+					PodID:    c.PodId,
+					Image:    c.Image,
+					Status:   status,
+					ExitCode: c.ExitCode,
+					Labels:   podLabels(p),
+				})
+			}
+		}
+	}
+
+	return res
 }
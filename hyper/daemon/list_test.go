@@ -0,0 +1,30 @@
+package daemon
+
+import (
+	"testing"
+)
+
+func TestListFilters(t *testing.T) {
+	f, err := parseListFilters([]string{"name=web-*", "status=running", "status=pending", "label=app=nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"app": "nginx"}
+
+	if !f.match("/web-1", "pending", labels) {
+		t.Fatal("the filters of the same key should be ORed")
+	}
+	if f.match("db-1", "running", labels) {
+		t.Fatal("name pattern should not match")
+	}
+	if f.match("web-1", "running", map[string]string{"app": "redis"}) {
+		t.Fatal("label value should not match")
+	}
+
+	if _, err := parseListFilters([]string{"image=busybox"}); err == nil {
+		t.Fatal("unknown filter should be rejected")
+	}
+	if _, err := parseListFilters([]string{"name=[web"}); err == nil {
+		t.Fatal("bad name pattern should be rejected")
+	}
+}
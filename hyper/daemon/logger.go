@@ -0,0 +1,321 @@
+package daemon
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/daemon/logger/jsonfilelog"
+	"github.com/docker/docker/pkg/units"
+	"github.com/golang/glog"
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+const (
+	// LOG_OPT_COMPRESS asks hyperd to gzip the rotated json-file logs,
+	// it is not passed to the docker log driver.
+	LOG_OPT_COMPRESS = "compress"
+)
+
+// multiLogger sends the logs of a container to several drivers, the
+// first one able to read logs back serves `hyperctl logs`.
+type multiLogger struct {
+	loggers []logger.Logger
+}
+
+func (m *multiLogger) Log(msg *logger.Message) error {
+	var err error
+	for _, l := range m.loggers {
+		if e := l.Log(msg); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m *multiLogger) Name() string {
+	names := []string{}
+	for _, l := range m.loggers {
+		names = append(names, l.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (m *multiLogger) Close() error {
+	var err error
+	for _, l := range m.loggers {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (m *multiLogger) ReadLogs(config logger.ReadConfig) *logger.LogWatcher {
+	for _, l := range m.loggers {
+		if r, ok := l.(logger.LogReader); ok {
+			return r.ReadLogs(config)
+		}
+	}
+	w := logger.NewLogWatcher()
+	w.Err <- logger.ErrReadLogsNotSupported
+	close(w.Msg)
+	return w
+}
+
+// compressLogger gzips the files rotated by the json-file driver. The
+// archives are named <log>.<unix nano>.gz, and at most `keep` of them
+// are kept.
+type compressLogger struct {
+	*jsonfilelog.JSONFileLogger
+	maxSize int64
+	keep    int
+	written int64
+	running int32
+}
+
+func (l *compressLogger) Log(msg *logger.Message) error {
+	if err := l.JSONFileLogger.Log(msg); err != nil {
+		return err
+	}
+	// the rotation happens when the file exceeds maxSize, no need to
+	// look for rotated files more often than that
+	if atomic.AddInt64(&l.written, int64(len(msg.Line))) < l.maxSize/2 {
+		return nil
+	}
+	atomic.StoreInt64(&l.written, 0)
+	if atomic.CompareAndSwapInt32(&l.running, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&l.running, 0)
+			compressRotatedLogs(l.LogPath(), l.keep)
+		}()
+	}
+	return nil
+}
+
+func (l *compressLogger) Close() error {
+	err := l.JSONFileLogger.Close()
+	for !atomic.CompareAndSwapInt32(&l.running, 0, 1) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	compressRotatedLogs(l.LogPath(), l.keep)
+	return err
+}
+
+func compressRotatedLogs(logPath string, keep int) {
+	rotated, _ := filepath.Glob(logPath + ".[0-9]")
+	more, _ := filepath.Glob(logPath + ".[0-9][0-9]")
+	rotated = append(rotated, more...)
+
+	for _, f := range rotated {
+		// move it away first, the writer may rotate again meanwhile
+		tmp := fmt.Sprintf("%s.%d", logPath, time.Now().UnixNano())
+		if err := os.Rename(f, tmp); err != nil {
+			continue
+		}
+		if err := gzipFile(tmp, tmp+".gz"); err != nil {
+			glog.Warningf("failed to compress log %s: %v", f, err)
+			os.Rename(tmp, f)
+			continue
+		}
+		os.Remove(tmp)
+	}
+
+	archives, _ := filepath.Glob(logPath + ".*.gz")
+	if len(archives) <= keep {
+		return
+	}
+	sort.Strings(archives)
+	for _, a := range archives[:len(archives)-keep] {
+		os.Remove(a)
+	}
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// splitLogConfig separates the options handled by hyperd from the ones of
+// the docker log driver
+func splitLogConfig(cfg map[string]string) (map[string]string, bool, error) {
+	driverCfg := make(map[string]string)
+	compress := false
+	for k, v := range cfg {
+		if k != LOG_OPT_COMPRESS {
+			driverCfg[k] = v
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid log opt %s: %s", k, v)
+		}
+		compress = b
+	}
+	return driverCfg, compress, nil
+}
+
+func logDrivers(cfg *pod.PodLogConfig) []pod.LogDriverConfig {
+	drivers := []pod.LogDriverConfig{{Type: cfg.Type, Config: cfg.Config}}
+	for _, d := range cfg.Extra {
+		if d.Type != "" && d.Type != "none" {
+			drivers = append(drivers, d)
+		}
+	}
+	return drivers
+}
+
+func validateLogConfig(cfg *pod.PodLogConfig) error {
+	for _, d := range logDrivers(cfg) {
+		driverCfg, compress, err := splitLogConfig(d.Config)
+		if err != nil {
+			return err
+		}
+		if compress && d.Type != jsonfilelog.Name {
+			return fmt.Errorf("log opt %s is only supported by %s", LOG_OPT_COMPRESS, jsonfilelog.Name)
+		}
+		if compress && driverCfg["max-size"] == "" {
+			return fmt.Errorf("log opt %s requires max-size", LOG_OPT_COMPRESS)
+		}
+		if err := logger.ValidateLogOpts(d.Type, driverCfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newLogger creates the driver of a container, jsonPath is where the
+// json-file driver writes to
+func newLogger(cfg *pod.PodLogConfig, ctx logger.Context, jsonPath string) (logger.Logger, error) {
+	var loggers []logger.Logger
+
+	for _, d := range logDrivers(cfg) {
+		driverCfg, compress, err := splitLogConfig(d.Config)
+		if err != nil {
+			return nil, err
+		}
+		creator, err := logger.GetLogDriver(d.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		// the live file counts in max-file, the others are kept compressed.
+		// json-file needs to rotate at least once to hand the logs over.
+		keep := 1
+		if compress {
+			if n, _ := strconv.Atoi(driverCfg["max-file"]); n > 1 {
+				keep = n - 1
+			} else {
+				driverCfg["max-file"] = "2"
+			}
+		}
+
+		c := ctx
+		c.Config = driverCfg
+		if d.Type == jsonfilelog.Name {
+			c.LogPath = jsonPath
+			glog.V(1).Info("configure container log to ", c.LogPath)
+		}
+
+		l, err := creator(c)
+		if err != nil {
+			for _, l := range loggers {
+				l.Close()
+			}
+			return nil, err
+		}
+		if jl, ok := l.(*jsonfilelog.JSONFileLogger); ok && compress {
+			maxSize, _ := units.FromHumanSize(driverCfg["max-size"])
+			l = &compressLogger{JSONFileLogger: jl, maxSize: maxSize, keep: keep}
+		}
+		loggers = append(loggers, l)
+	}
+
+	if len(loggers) == 1 {
+		return loggers[0], nil
+	}
+	return &multiLogger{loggers: loggers}, nil
+}
+
+// logFilePath returns the json log file of the driver, if any
+func logFilePath(l logger.Logger) string {
+	switch d := l.(type) {
+	case *jsonfilelog.JSONFileLogger:
+		return d.LogPath()
+	case *compressLogger:
+		return d.LogPath()
+	case *multiLogger:
+		for _, sub := range d.loggers {
+			if p := logFilePath(sub); p != "" {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// RemovePodLogs deletes the logs of a removed pod, or schedules the
+// deletion if a retention period is configured.
+func (daemon *Daemon) RemovePodLogs(resourcePath string) {
+	if resourcePath == "" {
+		return
+	}
+	if daemon.LogRetention <= 0 {
+		os.RemoveAll(resourcePath)
+		return
+	}
+	glog.V(1).Infof("logs in %s will be removed in %v", resourcePath, daemon.LogRetention)
+	time.AfterFunc(daemon.LogRetention, func() {
+		os.RemoveAll(resourcePath)
+	})
+}
+
+// sweepPodLogs removes the logs left by pods which are gone for longer
+// than the retention period, e.g. across a daemon restart.
+func (daemon *Daemon) sweepPodLogs() {
+	dirs, err := ioutil.ReadDir(DefaultResourcePath)
+	if err != nil {
+		return
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || time.Since(d.ModTime()) < daemon.LogRetention {
+			continue
+		}
+		_, ok := daemon.PodList.Get(d.Name())
+		if !ok {
+			glog.V(1).Infof("remove logs of the gone pod %s", d.Name())
+			os.RemoveAll(filepath.Join(DefaultResourcePath, d.Name()))
+		}
+	}
+}
+
+func (daemon *Daemon) sweepPodLogsLoop() {
+	for {
+		daemon.sweepPodLogs()
+		time.Sleep(time.Hour)
+	}
+}
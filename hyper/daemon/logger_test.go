@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressRotatedLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "container-json.log")
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(logPath+".1", []byte("line\n"), 0640); err != nil {
+			t.Fatal(err)
+		}
+		compressRotatedLogs(logPath, 2)
+	}
+
+	if _, err := os.Stat(logPath + ".1"); !os.IsNotExist(err) {
+		t.Fatal("the rotated log should be compressed")
+	}
+	archives, _ := filepath.Glob(logPath + ".*.gz")
+	if len(archives) != 2 {
+		t.Fatalf("expect 2 archives, got %v", archives)
+	}
+}
+
+func TestSplitLogConfig(t *testing.T) {
+	cfg, compress, err := splitLogConfig(map[string]string{"max-size": "1m", "compress": "true"})
+	if err != nil || !compress {
+		t.Fatalf("compress should be enabled: %v", err)
+	}
+	if _, ok := cfg["compress"]; ok || cfg["max-size"] != "1m" {
+		t.Fatalf("unexpected driver config %v", cfg)
+	}
+	if _, _, err := splitLogConfig(map[string]string{"compress": "maybe"}); err == nil {
+		t.Fatal("invalid compress option should be rejected")
+	}
+}
@@ -91,7 +91,7 @@ func (daemon *Daemon) CmdLogs(job *engine.Job) (err error) {
 		return fmt.Errorf("logger not suppert read")
 	}
 
-	follow := config.follow && (pod.status.Status == types.S_POD_RUNNING)
+	follow := config.follow && (pod.status.Snapshot().Status == types.S_POD_RUNNING)
 	tailLines, err = strconv.Atoi(config.tail)
 	if err != nil {
 		tailLines = -1
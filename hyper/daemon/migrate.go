@@ -16,10 +16,11 @@ func (daemon *Daemon) CmdPodMigrate(job *engine.Job) error {
 	podId 	:= job.Args[0]
 	ip 		:= job.Args[1]
 	port 	:= job.Args[2]
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-	defer glog.V(2).Infof("unlock PodList")
-	defer daemon.PodList.Unlock()
+	p, err := daemon.lockPod(podId)
+	if err != nil {
+		return err
+	}
+	defer daemon.unlockPod(p)
 	code, cause, err := daemon.MigratePod(podId, ip, port)
 	if err != nil {
 		return err
@@ -1,7 +1,7 @@
 package daemon
 
 import (
-	"encoding/base64"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,11 +10,12 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	dockertypes "github.com/docker/docker/api/types"
 	"github.com/docker/docker/daemon/logger"
-	"github.com/docker/docker/daemon/logger/jsonfilelog"
 	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
 	"github.com/hyperhq/hyper/servicediscovery"
@@ -37,14 +38,11 @@ func (daemon *Daemon) CmdPodCreate(job *engine.Job) error {
 	}
 
 	podId := fmt.Sprintf("pod-%s", pod.RandStr(10, "alpha"))
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-	defer glog.V(2).Infof("unlock PodList")
-	defer daemon.PodList.Unlock()
 	err := daemon.CreatePod(podId, podArgs, autoRemove)
 	if err != nil {
 		return err
 	}
+	daemon.LogPodEvent("create", podId, nil)
 
 	// Prepare the VM status to client
 	v := &engine.Env{}
@@ -73,27 +71,19 @@ func (daemon *Daemon) CmdPodLabels(job *engine.Job) error {
 		return err
 	}
 
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer daemon.PodList.RUnlock()
-	defer glog.V(2).Infof("unlock read of PodList")
-
-	var (
-		pod *Pod
-		ok  bool
-	)
-	if strings.Contains(job.Args[0], "pod-") {
-		podId := job.Args[0]
-		pod, ok = daemon.PodList.Get(podId)
-		if !ok {
-			return fmt.Errorf("Can not get Pod info with pod ID(%s)", podId)
-		}
-	} else {
-		pod = daemon.PodList.GetByName(job.Args[0])
-		if pod == nil {
-			return fmt.Errorf("Can not get Pod info with pod name(%s)", job.Args[0])
+	podId := job.Args[0]
+	if !strings.Contains(podId, "pod-") {
+		p := daemon.PodList.GetByName(podId)
+		if p == nil {
+			return fmt.Errorf("Can not get Pod info with pod name(%s)", podId)
 		}
+		podId = p.id
+	}
+	pod, err := daemon.lockPod(podId)
+	if err != nil {
+		return fmt.Errorf("Can not get Pod info with pod ID(%s)", podId)
 	}
+	defer daemon.unlockPod(pod)
 
 	if pod.spec.Labels == nil {
 		pod.spec.Labels = make(map[string]string)
@@ -160,32 +150,23 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 
 	glog.Infof("pod:%s, vm:%s", podId, vmId)
 	// Do the status check for the given pod
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-	if _, ok := daemon.PodList.Get(podId); !ok {
-		glog.V(2).Infof("unlock PodList")
-		daemon.PodList.Unlock()
+	p, err := daemon.lockPod(podId)
+	if err != nil {
 		return fmt.Errorf("The pod(%s) can not be found, please create it first", podId)
 	}
 	var lazy bool = hypervisor.HDriver.SupportLazyMode() && vmId == ""
 
-
 	code, cause, err := daemon.StartPod(podId, "", vmId, nil, lazy, false, types.VM_KEEP_NONE, ttys)
+	daemon.unlockPod(p)
 	if err != nil {
 		glog.Error(err.Error())
-		glog.V(2).Infof("unlock PodList")
-		daemon.PodList.Unlock()
 		return err
 	}
 
 	if len(ttys) > 0 {
-		glog.V(2).Infof("unlock PodList")
-		daemon.PodList.Unlock()
 		daemon.GetExitCode(podId, tag, ttyCallback)
 		return nil
 	}
-	defer glog.V(2).Infof("unlock PodList")
-	defer daemon.PodList.Unlock()
 
 	// Prepare the VM status to client
 	v := &engine.Env{}
@@ -201,31 +182,108 @@ func (daemon *Daemon) CmdPodStart(job *engine.Job) error {
 
 // I'd like to move the remain part of this file to another file.
 type Pod struct {
+	// serializes the operations on the pod, e.g. start, stop and remove.
+	// The status and the vm are changed under the lock of the status as
+	// well, the readers without the pod lock use snapshot.
+	sync.Mutex
 	id         string
 	status     *hypervisor.PodStatus
 	spec       *pod.UserPod
 	vm         *hypervisor.Vm
 	containers []*hypervisor.ContainerInfo
 	volumes    []*hypervisor.VolumeInfo
+	// set while the pod is being stopped by the daemon, until its vm is
+	// down. It is read by the event handler, so only accessed atomically.
+	stopping int32
+	// the probe results of the containers, nil if there is no probe
+	health *podHealth
+	// the containers restarted inside the running VM
+	restarts containerRestarts
+}
+
+// lockPod finds the pod and takes its lock, the caller has to unlockPod it.
+// The pod may be removed or replaced while waiting for the lock, so it is
+// looked up again.
+func (daemon *Daemon) lockPod(podId string) (*Pod, error) {
+	for {
+		p, ok := daemon.PodList.Get(podId)
+		if !ok {
+			return nil, fmt.Errorf("Can not find pod(%s)", podId)
+		}
+		p.Lock()
+		glog.V(2).Infof("lock pod %s", podId)
+		if cur, ok := daemon.PodList.Get(podId); ok && cur == p {
+			return p, nil
+		}
+		glog.V(2).Infof("unlock pod %s", podId)
+		p.Unlock()
+	}
+}
+
+func (daemon *Daemon) unlockPod(p *Pod) {
+	glog.V(2).Infof("unlock pod %s", p.id)
+	p.Unlock()
+}
+
+// startStopping marks the pod as being stopped by the daemon, the exits of
+// its containers and of its vm are not failures then
+func (p *Pod) startStopping() {
+	atomic.StoreInt32(&p.stopping, 1)
+}
+
+func (p *Pod) isStopping() bool {
+	return atomic.LoadInt32(&p.stopping) == 1
+}
+
+// stopFinished clears the stopping mark, it returns whether the pod was
+// being stopped
+func (p *Pod) stopFinished() bool {
+	return atomic.SwapInt32(&p.stopping, 0) == 1
 }
 
 func (p *Pod) GetVM(daemon *Daemon, id string, lazy bool, keep int) (err error) {
 	if p == nil || p.spec == nil {
 		return errors.New("Pod: unable to create VM without resource info.")
 	}
-	p.vm, err = daemon.GetVM(id, &p.spec.Resource, lazy, keep)
+	vm, err := daemon.GetVM(id, &p.spec.Resource, lazy, keep)
+	p.setVm(vm)
 	return
 }
 
 func (p *Pod) SetVM(id string, vm *hypervisor.Vm) {
+	p.status.Lock()
+	defer p.status.Unlock()
 	p.status.Vm = id
 	p.vm = vm
 }
 
+// setVm changes the vm of the pod, the readers without the pod lock get it
+// with snapshot
+func (p *Pod) setVm(vm *hypervisor.Vm) {
+	p.status.Lock()
+	defer p.status.Unlock()
+	p.vm = vm
+}
+
+// getVm returns the vm of the pod, nil if it is not running
+func (p *Pod) getVm() *hypervisor.Vm {
+	p.status.RLock()
+	defer p.status.RUnlock()
+	return p.vm
+}
+
+// snapshot returns a copy of the status of the pod and its vm, for the
+// readers which don't hold the pod lock, e.g. list and info
+func (p *Pod) snapshot() (*hypervisor.PodStatus, *hypervisor.Vm) {
+	p.status.RLock()
+	defer p.status.RUnlock()
+	return p.status.Copy(), p.vm
+}
+
 func (p *Pod) KillVM(daemon *Daemon) {
 	if p.vm != nil {
 		daemon.KillVm(p.vm.Id)
-		p.vm = nil
+		p.setVm(nil)
 	}
 }
 
@@ -251,9 +309,15 @@ func CreatePod(daemon *Daemon, dclient DockerInterface, podId, podArgs string, a
 	if err = spec.Validate(); err != nil {
 		return nil, err
 	}
+	for idx, c := range spec.Containers {
+		if _, _, err = stopSettings(&c, -1, ""); err != nil {
+			return nil, fmt.Errorf("in container %d, %v", idx, err)
+		}
+	}
 
 	status := hypervisor.NewPod(podId, spec)
 	status.Handler.Handle = hyperHandlePodEvent
+	status.RestartPolicy = podRestartPolicy(spec)
 	status.Autoremove = autoremove
 	status.ResourcePath = resPath
 
@@ -358,6 +422,7 @@ func (p *Pod) PrepareContainers(sd Storage, dclient DockerInterface) (err error)
 		sharedDir = path.Join(hypervisor.BaseDir, p.vm.Id, hypervisor.ShareDirTag)
 	)
 
+	fetcher := newFileFetcher(dclient)
 	files := make(map[string](pod.UserFile))
 	for _, f := range p.spec.Files {
 		files[f.Name] = f
@@ -389,7 +454,7 @@ func (p *Pod) PrepareContainers(sd Storage, dclient DockerInterface) (err error)
 
 		processImageVolumes(info, c.Id, p.spec, &p.spec.Containers[i])
 
-		err = processInjectFiles(&p.spec.Containers[i], files, sd, c.Id, sd.RootPath(), sharedDir)
+		err = processInjectFiles(&p.spec.Containers[i], files, sd, fetcher, c.Id, sd.RootPath(), sharedDir)
 		if err != nil {
 			return err
 		}
@@ -417,7 +482,7 @@ func getContinerInfo(dclient DockerInterface, container *hypervisor.Container) (
 }
 
 func processInjectFiles(container *pod.UserContainer, files map[string]pod.UserFile, sd Storage,
-	id, rootPath, sharedDir string) error {
+	fetcher *fileFetcher, id, rootPath, sharedDir string) error {
 	for _, f := range container.Files {
 		targetPath := f.Path
 		if strings.HasSuffix(targetPath, "/") {
@@ -428,26 +493,13 @@ func processInjectFiles(container *pod.UserContainer, files map[string]pod.UserF
 			continue
 		}
 
-		var src io.Reader
-
-		if file.Uri != "" {
-			urisrc, err := utils.UriReader(file.Uri)
-			if err != nil {
-				return err
-			}
-			defer urisrc.Close()
-			src = urisrc
-		} else {
-			src = strings.NewReader(file.Contents)
-		}
-
-		switch file.Encoding {
-		case "base64":
-			src = base64.NewDecoder(base64.StdEncoding, src)
-		default:
+		data, err := fetcher.fetch(&file)
+		if err != nil {
+			glog.Errorf("got error when fetch file %s: %v", file.Name, err)
+			return err
 		}
 
-		err := sd.InjectFile(src, id, targetPath, sharedDir,
+		err = sd.InjectFile(bytes.NewReader(data), id, targetPath, sharedDir,
 			utils.PermInt(f.Perm), utils.UidInt(f.User), utils.UidInt(f.Group))
 		if err != nil {
 			glog.Error("got error when inject files ", err.Error())
@@ -572,36 +624,42 @@ func (p *Pod) PrepareVolume(daemon *Daemon, sd Storage) (err error) {
 
 	for _, v := range p.spec.Volumes {
 		var vol *hypervisor.VolumeInfo
-		if v.Source == "" {
-			vol, err = sd.CreateVolume(daemon, p.id, v.Name)
-			if err != nil {
-				return
-			}
+		vol, err = prepareVolume(daemon, sd, p.id, &v, sharedDir)
+		if err != nil {
+			return
+		}
 
-			v.Source = vol.Filepath
-			if sd.Type() != "devicemapper" {
-				v.Driver = "vfs"
+		p.volumes = append(p.volumes, vol)
+	}
 
-				vol.Filepath, err = storage.MountVFSVolume(v.Source, sharedDir)
-				if err != nil {
-					return
-				}
-				glog.V(1).Infof("dir %s is bound to %s", v.Source, vol.Filepath)
+	return nil
+}
 
-			} else { // type other than doesn't need to be mounted
-				v.Driver = "raw"
-			}
-		} else {
-			vol, err = ProbeExistingVolume(&v, sharedDir)
-			if err != nil {
-				return
-			}
+func prepareVolume(daemon *Daemon, sd Storage, podId string, v *pod.UserVolume, sharedDir string) (*hypervisor.VolumeInfo, error) {
+	if v.Source != "" {
+		return ProbeExistingVolume(v, sharedDir)
+	}
+
+	vol, err := sd.CreateVolume(daemon, podId, v.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	v.Source = vol.Filepath
+	if sd.Type() != "devicemapper" {
+		v.Driver = "vfs"
+
+		vol.Filepath, err = storage.MountVFSVolume(v.Source, sharedDir)
+		if err != nil {
+			return nil, err
 		}
+		glog.V(1).Infof("dir %s is bound to %s", v.Source, vol.Filepath)
 
-		p.volumes = append(p.volumes, vol)
+	} else { // type other than doesn't need to be mounted
+		v.Driver = "raw"
 	}
 
-	return nil
+	return vol, nil
 }
 
 func (p *Pod) Prepare(daemon *Daemon) (err error) {
@@ -618,6 +676,10 @@ func (p *Pod) Prepare(daemon *Daemon) (err error) {
 		return
 	}
 
+	if err = p.PrepareSecrets(daemon.GetSecret); err != nil {
+		return
+	}
+
 	if err = p.PrepareVolume(daemon, daemon.Storage); err != nil {
 		return
 	}
@@ -645,10 +707,7 @@ func (p *Pod) getLogger(daemon *Daemon) (err error) {
 		return nil
 	}
 
-	var (
-		needLogger []int = []int{}
-		creator    logger.Creator
-	)
+	var needLogger []int = []int{}
 
 	for i, c := range p.status.Containers {
 		if c.Logs.Driver == nil {
@@ -660,18 +719,13 @@ func (p *Pod) getLogger(daemon *Daemon) (err error) {
 		return nil
 	}
 
-	if err = logger.ValidateLogOpts(p.spec.LogConfig.Type, p.spec.LogConfig.Config); err != nil {
-		return
-	}
-	creator, err = logger.GetLogDriver(p.spec.LogConfig.Type)
-	if err != nil {
+	if err = validateLogConfig(&p.spec.LogConfig); err != nil {
 		return
 	}
 	glog.V(1).Infof("configuring log driver [%s] for %s", p.spec.LogConfig.Type, p.id)
 
 	for i, c := range p.status.Containers {
 		ctx := logger.Context{
-			Config:             p.spec.LogConfig.Config,
 			ContainerID:        c.Id,
 			ContainerName:      c.Name,
 			ContainerImageName: p.spec.Containers[i].Image,
@@ -684,12 +738,8 @@ func (p *Pod) getLogger(daemon *Daemon) (err error) {
 			ctx.ContainerImageID = p.containers[i].Image
 		}
 
-		if p.spec.LogConfig.Type == jsonfilelog.Name {
-			ctx.LogPath = filepath.Join(p.status.ResourcePath, fmt.Sprintf("%s-json.log", c.Id))
-			glog.V(1).Info("configure container log to ", ctx.LogPath)
-		}
-
-		if c.Logs.Driver, err = creator(ctx); err != nil {
+		jsonPath := filepath.Join(p.status.ResourcePath, fmt.Sprintf("%s-json.log", c.Id))
+		if c.Logs.Driver, err = newLogger(&p.spec.LogConfig, ctx, jsonPath); err != nil {
 			return
 		}
 		glog.V(1).Infof("configured logger for %s/%s (%s)", p.id, c.Id, c.Name)
@@ -710,20 +760,27 @@ func (p *Pod) startLogging(daemon *Daemon) (err error) {
 	}
 
 	for _, c := range p.status.Containers {
-		var stdout, stderr io.Reader
-
-		tag := "log-" + utils.RandStr(8, "alphanum")
-		if stdout, stderr, err = p.vm.GetLogOutput(c.Id, tag, nil); err != nil {
+		if err = p.startContainerLogging(c); err != nil {
 			return
 		}
-		c.Logs.Copier = logger.NewCopier(c.Id, map[string]io.Reader{"stdout": stdout, "stderr": stderr}, c.Logs.Driver)
-		c.Logs.Copier.Run()
+	}
 
-		if jl, ok := c.Logs.Driver.(*jsonfilelog.JSONFileLogger); ok {
-			c.Logs.LogPath = jl.LogPath()
-		}
+	return nil
+}
+
+// startContainerLogging copies the output of the container to its log driver
+func (p *Pod) startContainerLogging(c *hypervisor.Container) error {
+	tag := "log-" + utils.RandStr(8, "alphanum")
+	stdout, stderr, err := p.vm.GetLogOutput(c.Id, tag, nil)
+	if err != nil {
+		return err
 	}
+	c.Logs.Copier = logger.NewCopier(c.Id, map[string]io.Reader{"stdout": stdout, "stderr": stderr}, c.Logs.Driver)
+	c.Logs.Copier.Run()
 
+	if logPath := logFilePath(c.Logs.Driver); logPath != "" {
+		c.Logs.LogPath = logPath
+	}
 	return nil
 }
 
@@ -809,17 +866,14 @@ func (daemon *Daemon) GetExitCode(podId, tag string, callback chan *types.VmResp
 		ok  bool
 	)
 
-	daemon.PodList.Lock()
 	if pod, ok = daemon.PodList.Get(podId); !ok {
-		daemon.PodList.Unlock()
 		return fmt.Errorf("Can not find the POD instance of %s", podId)
 	}
-	if pod.vm == nil {
-		daemon.PodList.Unlock()
+	vm := pod.vm
+	if vm == nil {
 		return fmt.Errorf("pod %s is already stopped", podId)
 	}
-	daemon.PodList.Unlock()
-	return pod.vm.GetExitCode(tag, callback)
+	return vm.GetExitCode(tag, callback)
 }
 
 func (daemon *Daemon) StartPod(podId, podArgs, vmId string, config interface{}, lazy, autoremove bool, keep int, streams []*hypervisor.TtyIO) (int, string, error) {
@@ -842,6 +896,8 @@ func (daemon *Daemon) StartPod(podId, podArgs, vmId string, config interface{},
 	if err != nil {
 		return -1, "", err
 	}
+	daemon.LogPodEvent("start", podId, map[string]string{"vm": p.status.Vm})
+	daemon.startProbes(p)
 
 	return vmResponse.Code, vmResponse.Cause, nil
 }
@@ -877,6 +933,7 @@ func (daemon *Daemon) RestartPod(mypod *hypervisor.PodStatus) error {
 		glog.Error("Found an error while saving the Containers info")
 		return err
 	}
+	daemon.LogPodEvent("restart", mypod.Id, nil)
 
 	return nil
 }
@@ -890,57 +947,57 @@ func hyperHandlePodEvent(vmResponse *types.VmResponse, data interface{},
 			vm.Status = types.S_VM_IDLE
 			return false
 		}
-		stopLogger(mypod)
-		mypod.SetPodContainerStatus(vmResponse.Data.([]uint32))
-		vm.Status = types.S_VM_IDLE
-		if mypod.Autoremove == true {
-			daemon.CleanPod(mypod.Id)
-			return false
+		codes := vmResponse.Data.([]uint32)
+		exits := map[string]uint8{}
+		mypod.RLock()
+		for i, c := range mypod.Containers {
+			if i < len(codes) {
+				exits[c.Id] = uint8(codes[i])
+			}
 		}
+		mypod.RUnlock()
+		daemon.containersExited(mypod, vm, exits)
+	} else if vmResponse.Code == types.E_CONTAINER_FINISHED {
+		exit := vmResponse.Data.(*types.ContainerExit)
+		daemon.containersExited(mypod, vm, map[string]uint8{exit.Id: exit.Code})
+	} else if vmResponse.Code == types.E_GUEST_HEALTH && vmResponse.Reply == nil {
+		// the status of the guest is changed, not a reply to the query
+		health := vmResponse.Data.(*types.GuestHealth)
+		attrs := map[string]string{"pod": mypod.Id}
+		if health.Reason != "" {
+			attrs["reason"] = health.Reason
+		}
+		daemon.LogEvent(EVENT_TYPE_VM, "health_status: "+health.Status, vmResponse.VmId, attrs)
 	} else if vmResponse.Code == types.E_VM_SHUTDOWN {
-		if mypod.Status == types.S_POD_RUNNING {
+		action := "shutdown"
+		p, ok := daemon.PodList.Get(mypod.Id)
+		// the stop of the pod is finished once its vm is down
+		stopped := ok && p.stopFinished()
+		mypod.RLock()
+		running := mypod.Status == types.S_POD_RUNNING
+		mypod.RUnlock()
+		// the vm goes down while nobody asked for it
+		if running && ok && !stopped {
+			action = "crash"
+		}
+		daemon.LogEvent(EVENT_TYPE_VM, action, vmResponse.VmId, map[string]string{"pod": mypod.Id})
+		if running {
 			stopLogger(mypod)
-			mypod.Status = types.S_POD_SUCCEEDED
-			mypod.SetContainerStatus(types.S_POD_SUCCEEDED)
+			status := uint(types.S_POD_SUCCEEDED)
+			if action == "crash" {
+				status = types.S_POD_FAILED
+			}
+			mypod.Lock()
+			mypod.Status = status
+			mypod.FinishedAt = time.Now().Format(POD_TIME_FORMAT)
+			mypod.Unlock()
+			mypod.SetContainerStatus(uint32(status))
 		}
+		mypod.Lock()
 		mypod.Vm = ""
+		mypod.Unlock()
 		daemon.PodStopped(mypod.Id)
-		if mypod.Type == "kubernetes" {
-			switch mypod.Status {
-			case types.S_POD_SUCCEEDED:
-				if mypod.RestartPolicy == "always" {
-					daemon.RestartPod(mypod)
-					break
-				}
-				daemon.DeletePodFromDB(mypod.Id)
-				for _, c := range mypod.Containers {
-					glog.V(1).Infof("Ready to rm container: %s", c.Id)
-					if _, _, err := daemon.DockerCli.SendCmdDelete(c.Id); err != nil {
-						glog.V(1).Infof("Error to rm container: %s", err.Error())
-					}
-				}
-				daemon.DeletePodContainerFromDB(mypod.Id)
-				daemon.DeleteVolumeId(mypod.Id)
-				break
-			case types.S_POD_FAILED:
-				if mypod.RestartPolicy != "never" {
-					daemon.RestartPod(mypod)
-					break
-				}
-				daemon.DeletePodFromDB(mypod.Id)
-				for _, c := range mypod.Containers {
-					glog.V(1).Infof("Ready to rm container: %s", c.Id)
-					if _, _, err := daemon.DockerCli.SendCmdDelete(c.Id); err != nil {
-						glog.V(1).Infof("Error to rm container: %s", err.Error())
-					}
-				}
-				daemon.DeletePodContainerFromDB(mypod.Id)
-				daemon.DeleteVolumeId(mypod.Id)
-				break
-			default:
-				break
-			}
-		}
+		daemon.podExited(mypod, vmResponse.VmId, stopped, action == "crash")
 		return true
 	}
 
@@ -7,9 +7,11 @@ import (
 	"github.com/hyperhq/runv/hypervisor/types"
 )
 
+// PodList only guards the map of the pods, the operations on a pod are
+// serialized by the lock of the pod itself, see Daemon.lockPod.
 type PodList struct {
 	pods map[string]*Pod
-	sync.RWMutex
+	lock sync.RWMutex
 }
 
 func NewPodList() *PodList {
@@ -19,6 +21,9 @@ func NewPodList() *PodList {
 }
 
 func (pl *PodList) Get(id string) (*Pod, bool) {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
 	if pl.pods == nil {
 		return nil, false
 	}
@@ -27,6 +32,9 @@ func (pl *PodList) Get(id string) (*Pod, bool) {
 }
 
 func (pl *PodList) Put(p *Pod) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
 	if pl.pods == nil {
 		pl.pods = make(map[string]*Pod)
 	}
@@ -34,6 +42,9 @@ func (pl *PodList) Put(p *Pod) {
 }
 
 func (pl *PodList) Delete(id string) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
 	delete(pl.pods, id)
 }
 
@@ -61,17 +72,19 @@ func (pl *PodList) CountRunning() int64 {
 func (pl *PodList) CountStatus(status uint) (num int64) {
 	num = 0
 
-	pl.RLock()
-	defer pl.RUnlock()
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
 
 	if pl.pods == nil {
 		return
 	}
 
 	for _, pod := range pl.pods {
+		pod.status.RLock()
 		if pod.status.Status == status {
 			num++
 		}
+		pod.status.RUnlock()
 	}
 
 	return
@@ -79,15 +92,17 @@ func (pl *PodList) CountStatus(status uint) (num int64) {
 
 func (pl *PodList) CountContainers() (num int64) {
 	num = 0
-	pl.RLock()
-	defer pl.RUnlock()
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
 
 	if pl.pods == nil {
 		return
 	}
 
 	for _, pod := range pl.pods {
+		pod.status.RLock()
 		num += int64(len(pod.status.Containers))
+		pod.status.RUnlock()
 	}
 
 	return
@@ -96,8 +111,21 @@ func (pl *PodList) CountContainers() (num int64) {
 type PodOp func(*Pod) error
 type PodFilterOp func(*Pod) bool
 
-func (pl *PodList) Foreach(fn PodOp) error {
+// snapshot copies the pods out, so that fn can look up the list, or take
+// the lock of a pod, without holding the map lock
+func (pl *PodList) snapshot() []*Pod {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	pods := make([]*Pod, 0, len(pl.pods))
 	for _, p := range pl.pods {
+		pods = append(pods, p)
+	}
+	return pods
+}
+
+func (pl *PodList) Foreach(fn PodOp) error {
+	for _, p := range pl.snapshot() {
 		if err := fn(p); err != nil {
 			return err
 		}
@@ -106,7 +134,7 @@ func (pl *PodList) Foreach(fn PodOp) error {
 }
 
 func (pl *PodList) Find(fn PodFilterOp) *Pod {
-	for _, p := range pl.pods {
+	for _, p := range pl.snapshot() {
 		if fn(p) {
 			return p
 		}
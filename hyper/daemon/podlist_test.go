@@ -0,0 +1,262 @@
+package daemon
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/hyperhq/runv/hypervisor/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func newLockTestDaemon(t *testing.T, ids ...string) (*Daemon, *engine.Engine) {
+	daemon := &Daemon{
+		PodList: NewPodList(),
+		VmList:  make(map[string]*hypervisor.Vm),
+	}
+	for _, id := range ids {
+		daemon.PodList.Put(&Pod{
+			id:     id,
+			status: &hypervisor.PodStatus{Id: id, Name: id, Status: types.S_POD_CREATED},
+		})
+	}
+
+	eng := engine.New("")
+	for name, handler := range map[string]engine.Handler{
+		"list":    daemon.CmdList,
+		"podStop": daemon.CmdPodStop,
+	} {
+		if err := eng.Register(name, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return daemon, eng
+}
+
+func runJob(eng *engine.Engine, name string, args ...string) error {
+	job := eng.Job(name, args...)
+	job.Stdout.Add(bytes.NewBuffer(nil))
+	return job.Run()
+}
+
+func within(t *testing.T, what string, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		fn()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s is blocked", what)
+	}
+}
+
+// a slow operation on one pod, e.g. booting its VM, holds the lock of that
+// pod only, the others and the lookups go on.
+func TestPodLockDoesNotBlockOthers(t *testing.T) {
+	daemon, eng := newLockTestDaemon(t, "pod-a", "pod-b", "pod-c")
+
+	slow, err := daemon.lockPod("pod-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer daemon.unlockPod(slow)
+
+	within(t, "list", func() {
+		if err := runJob(eng, "list", "pod", "", "", "", "json"); err != nil {
+			t.Error(err)
+		}
+		if err := runJob(eng, "list", "pod", "pod-a"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	within(t, "stops of other pods", func() {
+		var wg sync.WaitGroup
+		for _, id := range []string{"pod-b", "pod-c"} {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				if err := runJob(eng, "podStop", id, "no"); err != nil {
+					t.Error(err)
+				}
+			}(id)
+		}
+		wg.Wait()
+	})
+}
+
+func TestPodLockSerializesOperations(t *testing.T) {
+	daemon, _ := newLockTestDaemon(t, "pod-a")
+
+	p, err := daemon.lockPod("pod-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan *Pod)
+	go func() {
+		p, err := daemon.lockPod("pod-a")
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- p
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("the second operation should wait for the first one")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the pod is replaced meanwhile, e.g. restarted, the waiter gets the new one
+	replaced := &Pod{id: "pod-a", status: p.status}
+	daemon.PodList.Put(replaced)
+	daemon.unlockPod(p)
+
+	select {
+	case got := <-locked:
+		if got != replaced {
+			t.Fatal("the lock of the replaced pod is taken")
+		}
+		daemon.unlockPod(got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second operation is not unblocked")
+	}
+
+	daemon.PodList.Delete("pod-a")
+	if _, err := daemon.lockPod("pod-a"); err == nil {
+		t.Fatal("a removed pod should not be locked")
+	}
+}
+
+func TestVmList(t *testing.T) {
+	daemon := &Daemon{VmList: make(map[string]*hypervisor.Vm)}
+	daemon.AddVm(&hypervisor.Vm{Id: "vm-a"})
+	daemon.AddVm(&hypervisor.Vm{Id: "vm-b"})
+
+	if vm, ok := daemon.GetVm("vm-a"); !ok || vm.Id != "vm-a" {
+		t.Fatal("vm-a should be found")
+	}
+	if len(daemon.ListVms()) != 2 {
+		t.Fatal("both VMs should be listed")
+	}
+
+	daemon.RemoveVm("vm-a")
+	if _, ok := daemon.GetVm("vm-a"); ok {
+		t.Fatal("a removed VM should not be found")
+	}
+}
+
+type noContainerDocker struct {
+	DockerInterface
+}
+
+func (d *noContainerDocker) GetContainerInfo(args ...string) (*dockertypes.ContainerJSON, error) {
+	return nil, errors.New("no such container")
+}
+
+// the status of a pod is changed by its start and stop, and by the events
+// of its VM, while list and info read it without the pod lock. Run it with
+// -race.
+func TestPodStatusReadWhileChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-podlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(path.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	daemon, eng := newLockTestDaemon(t)
+	daemon.db = db
+	daemon.DockerCli = &noContainerDocker{}
+	for name, handler := range map[string]engine.Handler{
+		"podInfo":       daemon.CmdPodInfo,
+		"containerInfo": daemon.CmdContainerInfo,
+	} {
+		if err := eng.Register(name, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status := hypervisor.NewPod("pod-a", &pod.UserPod{Name: "a"})
+	status.AddContainer("c1", "/web", "busybox", nil, types.S_POD_CREATED)
+	status.AddContainer("c2", "/db", "busybox", nil, types.S_POD_CREATED)
+	p := &Pod{
+		id:     "pod-a",
+		status: status,
+		spec:   &pod.UserPod{Name: "a", Containers: []pod.UserContainer{{Name: "web"}, {Name: "db"}}},
+	}
+	daemon.PodList.Put(p)
+
+	stop := make(chan struct{})
+	changed := make(chan struct{})
+	go func() {
+		defer close(changed)
+		vm := &hypervisor.Vm{Id: "vm-a", Keep: types.VM_KEEP_NONE}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			// started, the status is set as Vm.StartPod does
+			p.SetVM(vm.Id, nil)
+			p.status.Lock()
+			p.status.Status = types.S_POD_RUNNING
+			p.status.StartedAt = time.Now().Format(POD_TIME_FORMAT)
+			p.status.Unlock()
+			p.status.SetContainerStatus(types.S_POD_RUNNING)
+
+			// the pod finished in the VM
+			hyperHandlePodEvent(&types.VmResponse{
+				Code: types.E_POD_FINISHED,
+				Data: []uint32{0, 1},
+			}, daemon, p.status, vm)
+			p.SetVM("", nil)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, job := range [][]string{
+		{"list", "pod", "", "", "", "json"},
+		{"list", "container", "", "", "", "json"},
+		{"list", "pod"},
+		{"list", "container"},
+		{"podInfo", "pod-a"},
+		{"containerInfo", "web"},
+	} {
+		wg.Add(1)
+		go func(job []string) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := runJob(eng, job[0], job[1:]...); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(job)
+	}
+	wg.Wait()
+	close(stop)
+	<-changed
+
+	if n := daemon.PodList.CountContainers(); n != 2 {
+		t.Fatalf("unexpected number of containers %d", n)
+	}
+}
@@ -22,5 +22,6 @@ func (daemon *Daemon) CmdPull(job *engine.Job) error {
 	if err != nil {
 		return err
 	}
+	daemon.LogEvent(EVENT_TYPE_IMAGE, "pull", imgName, nil)
 	return nil
 }
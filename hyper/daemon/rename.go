@@ -1,7 +1,6 @@
 package daemon
 
 import (
-	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
 )
 
@@ -13,12 +12,9 @@ func (daemon *Daemon) CmdRename(job *engine.Job) error {
 	if err != nil {
 		return err
 	}
-	daemon.PodList.RLock()
-	glog.V(2).Infof("lock read of PodList")
-	defer glog.V(2).Infof("unlock read of PodList")
-	defer daemon.PodList.RUnlock()
-
 	daemon.PodList.Find(func(p *Pod) bool {
+		p.status.Lock()
+		defer p.status.Unlock()
 		for _, c := range p.status.Containers {
 			if c.Name == "/"+oldname {
 				c.Name = "/" + newname
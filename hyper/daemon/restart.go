@@ -0,0 +1,517 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/hyperhq/runv/hypervisor/types"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	CONTAINER_RESTART_BACKOFF_MIN = time.Second
+	CONTAINER_RESTART_BACKOFF_MAX = 5 * time.Minute
+	// a container running longer than this is considered recovered, and
+	// the backoff and retries are reset
+	CONTAINER_RESTART_RESET = 10 * time.Minute
+)
+
+type containerRestart struct {
+	// the total restarts shown in the pod info
+	count int
+	// the consecutive restarts, for the backoff and the retries limit
+	retries int
+	started time.Time
+	timer   *time.Timer
+}
+
+// containerRestarts keeps the restarts of the containers of a pod, the
+// containers are restarted inside the running VM.
+type containerRestarts struct {
+	sync.Mutex
+	containers map[string]*containerRestart
+}
+
+func (r *containerRestarts) get(container string) *containerRestart {
+	if r.containers == nil {
+		r.containers = make(map[string]*containerRestart)
+	}
+	cr, ok := r.containers[container]
+	if !ok {
+		cr = &containerRestart{started: time.Now()}
+		r.containers[container] = cr
+	}
+	return cr
+}
+
+func (r *containerRestarts) count(container string) int {
+	r.Lock()
+	defer r.Unlock()
+	if cr, ok := r.containers[container]; ok {
+		return cr.count
+	}
+	return 0
+}
+
+// stop cancels the pending restarts, the pod is stopped
+func (r *containerRestarts) stop() {
+	r.Lock()
+	defer r.Unlock()
+	for _, cr := range r.containers {
+		if cr.timer != nil {
+			cr.timer.Stop()
+			cr.timer = nil
+		}
+	}
+}
+
+// schedule decides whether the exited container should be restarted by
+// the policy, and after how long.
+func (r *containerRestarts) schedule(container string, maxRestarts int, restart func()) (time.Duration, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	cr := r.get(container)
+	if time.Since(cr.started) > CONTAINER_RESTART_RESET {
+		cr.retries = 0
+	}
+	if maxRestarts > 0 && cr.retries >= maxRestarts {
+		return 0, fmt.Errorf("container %s reached the restart limit %d", container, maxRestarts)
+	}
+
+	delay := restartBackoff(cr.retries)
+	cr.retries++
+	cr.timer = time.AfterFunc(delay, restart)
+	return delay, nil
+}
+
+// pending tells whether some exited container is going to be restarted
+func (r *containerRestarts) pending() bool {
+	r.Lock()
+	defer r.Unlock()
+	for _, cr := range r.containers {
+		if cr.timer != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// cancel drops the restart of the container, which failed
+func (r *containerRestarts) cancel(container string) {
+	r.Lock()
+	defer r.Unlock()
+	if cr, ok := r.containers[container]; ok {
+		cr.timer = nil
+	}
+}
+
+func (r *containerRestarts) restarted(container string) {
+	r.Lock()
+	defer r.Unlock()
+
+	cr := r.get(container)
+	cr.count++
+	cr.started = time.Now()
+	cr.timer = nil
+}
+
+func restartBackoff(retries int) time.Duration {
+	delay := CONTAINER_RESTART_BACKOFF_MIN
+	for i := 0; i < retries && delay < CONTAINER_RESTART_BACKOFF_MAX; i++ {
+		delay *= 2
+	}
+	if delay > CONTAINER_RESTART_BACKOFF_MAX {
+		delay = CONTAINER_RESTART_BACKOFF_MAX
+	}
+	return delay
+}
+
+func shouldRestartContainer(policy string, code uint8) bool {
+	switch strings.ToLower(policy) {
+	case "always":
+		return true
+	case "onfailure":
+		return code != 0
+	}
+	return false
+}
+
+// containersExited handles the exits of the containers of the running pod,
+// the exit codes by the container ids. The exits are reported by both the
+// containers and the finish of the pod, whichever comes first. It is the
+// one place deciding between the restarts of the containers inside the VM,
+// by their policies, and the finish of the pod, once all its containers
+// exited and none of them is going to be restarted.
+func (daemon *Daemon) containersExited(mypod *hypervisor.PodStatus, vm *hypervisor.Vm, exits map[string]uint8) {
+	p, ok := daemon.PodList.Get(mypod.Id)
+	if !ok || p.status != mypod {
+		return
+	}
+
+	type exited struct {
+		id    string
+		code  uint8
+		delay time.Duration
+		err   error
+	}
+	var (
+		died     []*exited
+		finished bool
+	)
+	p.status.Lock()
+	for i, c := range p.status.Containers {
+		code, ok := exits[c.Id]
+		if !ok || c.Status != types.S_POD_RUNNING {
+			continue
+		}
+		c.ExitCode = int(code)
+		if code == 0 {
+			c.Status = types.S_POD_SUCCEEDED
+		} else {
+			c.Status = types.S_POD_FAILED
+		}
+		e := &exited{id: c.Id, code: code, delay: -1}
+		died = append(died, e)
+		if i >= len(p.spec.Containers) || p.isStopping() {
+			continue
+		}
+		spec := &p.spec.Containers[i]
+		if shouldRestartContainer(spec.RestartPolicy, code) {
+			e.delay, e.err = p.restarts.schedule(c.Id, spec.MaxRestarts, func() {
+				daemon.policyRestart(p, vm, e.id)
+			})
+		}
+	}
+	if p.status.Status == types.S_POD_RUNNING && vm.Keep == types.VM_KEEP_NONE && !p.restarts.pending() {
+		finished = true
+		failed := false
+		for _, c := range p.status.Containers {
+			if c.Status == types.S_POD_RUNNING {
+				finished = false
+			}
+			failed = failed || c.Status == types.S_POD_FAILED
+		}
+		if finished {
+			p.status.Status = types.S_POD_SUCCEEDED
+			if failed {
+				p.status.Status = types.S_POD_FAILED
+			}
+			p.status.FinishedAt = time.Now().Format(POD_TIME_FORMAT)
+		}
+	}
+	p.status.Unlock()
+
+	for _, e := range died {
+		daemon.LogEvent(EVENT_TYPE_CONTAINER, "die", e.id, map[string]string{"pod": p.id, "exitCode": strconv.Itoa(int(e.code))})
+		if e.err != nil {
+			glog.Warning(e.err.Error())
+			daemon.LogEvent(EVENT_TYPE_CONTAINER, "restart_limit", e.id, map[string]string{"pod": p.id})
+		} else if e.delay >= 0 {
+			glog.V(1).Infof("container %s of pod %s exited with %d, restart it in %v", e.id, p.id, e.code, e.delay)
+		}
+	}
+	if finished {
+		daemon.podFinished(p, vm)
+	}
+}
+
+// podFinished cleans up the pod whose containers all exited
+func (daemon *Daemon) podFinished(p *Pod, vm *hypervisor.Vm) {
+	stopLogger(p.status)
+	vm.Status = types.S_VM_IDLE
+	if p.spec.RestartsContainers() {
+		// the VM is kept for the restarts of the containers
+		if err := vm.Shutdown(); err != nil {
+			glog.Errorf("failed to shut down the vm %s of pod %s: %v", vm.Id, p.id, err)
+		}
+	}
+	if p.status.Autoremove {
+		daemon.CleanPod(p.id)
+	}
+}
+
+// policyRestart restarts the container by its restart policy, a container
+// failed to restart is left exited, which may finish the pod
+func (daemon *Daemon) policyRestart(p *Pod, vm *hypervisor.Vm, container string) {
+	if err := daemon.restartContainer(p, container, "policy"); err != nil {
+		glog.Errorf("failed to restart container %s of pod %s: %v", container, p.id, err)
+		p.restarts.cancel(container)
+		daemon.containersExited(p.status, vm, nil)
+	}
+}
+
+// restartContainer restarts the container inside the running VM of the pod
+func (daemon *Daemon) restartContainer(p *Pod, container, reason string) error {
+	cur, err := daemon.lockPod(p.id)
+	if err != nil {
+		return err
+	}
+	defer daemon.unlockPod(cur)
+	if cur != p {
+		return fmt.Errorf("pod %s is replaced", p.id)
+	}
+
+	status, vm := p.snapshot()
+	if vm == nil || p.isStopping() || status.Status != types.S_POD_RUNNING {
+		return fmt.Errorf("pod %s is not running", p.id)
+	}
+
+	idx := -1
+	for i, pc := range status.Containers {
+		if pc.Id == container {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("can not find container %s in pod %s", container, p.id)
+	}
+	c := p.status.Containers[idx]
+
+	// the log copier stopped with the output of the exited container,
+	// attach it to the restarted one. A running container keeps its
+	// sessions while restarted.
+	if c.Logs.Driver != nil && status.Containers[idx].Status != types.S_POD_RUNNING {
+		if err := p.startContainerLogging(c); err != nil {
+			glog.Warningf("failed to attach the logs of container %s: %v", container, err)
+		}
+	}
+
+	if err := vm.RestartContainer(container); err != nil {
+		return err
+	}
+	p.status.Lock()
+	c.Status = types.S_POD_RUNNING
+	c.ExitCode = 0
+	p.status.Unlock()
+
+	p.restarts.restarted(container)
+	if h := p.health; h != nil {
+		h.restarted(container)
+	}
+	daemon.LogEvent(EVENT_TYPE_CONTAINER, "restart", container, map[string]string{"pod": p.id, "reason": reason})
+	return nil
+}
+
+const POD_TIME_FORMAT = "2006-01-02T15:04:05Z"
+
+// podRestart is the restart state of a pod, it is kept in the db so that
+// the pending restarts survive the restarts of hyperd
+type podRestart struct {
+	Count          int    `json:"count"`
+	Retries        int    `json:"retries"`
+	Pending        bool   `json:"pending"`
+	LastExitCode   int    `json:"lastExitCode"`
+	LastExitReason string `json:"lastExitReason,omitempty"`
+	FinishedAt     string `json:"finishedAt,omitempty"`
+	// the last vm of the pod, its console log is kept if the pod failed
+	Vm string `json:"vm,omitempty"`
+}
+
+func (daemon *Daemon) getPodRestart(podId string) *podRestart {
+	rs := &podRestart{}
+	data, err := daemon.db.Get([]byte(fmt.Sprintf("restart-%s", podId)), nil)
+	if err != nil {
+		return rs
+	}
+	if err := json.Unmarshal(data, rs); err != nil {
+		glog.Warningf("invalid restart record of pod %s: %v", podId, err)
+	}
+	return rs
+}
+
+func (daemon *Daemon) savePodRestart(podId string, rs *podRestart) error {
+	data, err := json.Marshal(rs)
+	if err != nil {
+		return err
+	}
+	return daemon.db.Put([]byte(fmt.Sprintf("restart-%s", podId)), data, nil)
+}
+
+func (daemon *Daemon) deletePodRestart(podId string) error {
+	return daemon.db.Delete([]byte(fmt.Sprintf("restart-%s", podId)), nil)
+}
+
+// podRestartVms returns the last VMs of the pods in the restart records
+func (daemon *Daemon) podRestartVms() map[string]bool {
+	vms := map[string]bool{}
+	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("restart-")), nil)
+	for iter.Next() {
+		rs := &podRestart{}
+		if err := json.Unmarshal(iter.Value(), rs); err == nil && rs.Vm != "" {
+			vms[rs.Vm] = true
+		}
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		glog.Warningf("failed to list the restart records: %v", err)
+	}
+	return vms
+}
+
+// podRestartPolicy returns the restart policy of the pod. A pod without
+// one follows the policy shared by all its containers, which is what
+// `hyperctl run --restart` sets. The policy of the others is unset, they
+// get the default policy of their type.
+func podRestartPolicy(spec *pod.UserPod) string {
+	if spec.RestartPolicy != "" {
+		return spec.RestartPolicy
+	}
+	policy := ""
+	for i, c := range spec.Containers {
+		if i == 0 {
+			policy = c.RestartPolicy
+		} else if !strings.EqualFold(c.RestartPolicy, policy) {
+			policy = ""
+			break
+		}
+	}
+	if policy == "" {
+		return defaultRestartPolicy(spec.Type)
+	}
+	return policy
+}
+
+// defaultRestartPolicy is the policy of the pods without one: the failed
+// kubernetes pods are restarted, as they always were, the others are not
+func defaultRestartPolicy(podType string) string {
+	if podType == "kubernetes" {
+		return "onFailure"
+	}
+	return "never"
+}
+
+func shouldRestartPod(policy string, status uint) bool {
+	switch strings.ToLower(policy) {
+	case "always":
+		return true
+	case "onfailure":
+		return status == types.S_POD_FAILED
+	}
+	return false
+}
+
+func podExitCode(mypod *hypervisor.PodStatus) int {
+	for _, c := range mypod.Containers {
+		if c.ExitCode != 0 {
+			return c.ExitCode
+		}
+	}
+	return 0
+}
+
+// podExited applies the restart policy of the pod once its VM is gone.
+// A pod stopped by the user is never restarted.
+func (daemon *Daemon) podExited(mypod *hypervisor.PodStatus, vmId string, stopped, crashed bool) {
+	rs := daemon.getPodRestart(mypod.Id)
+	if rs.Vm != "" && rs.Vm != vmId {
+		// only the console log of the last vm is kept
+		hypervisor.RemoveConsoleLog(rs.Vm)
+	}
+	rs.Vm = vmId
+	rs.Pending = false
+	rs.LastExitCode = podExitCode(mypod)
+	rs.FinishedAt = mypod.FinishedAt
+	switch {
+	case stopped:
+		rs.LastExitReason = "Stopped"
+	case crashed:
+		rs.LastExitReason = "Crashed"
+	case mypod.Status == types.S_POD_FAILED:
+		rs.LastExitReason = "Failed"
+	default:
+		rs.LastExitReason = "Succeeded"
+	}
+	if stopped || (!crashed && mypod.Status != types.S_POD_FAILED) {
+		// the console log is only kept for the post-mortem of failed pods
+		hypervisor.RemoveConsoleLog(vmId)
+	}
+
+	_, exist := daemon.PodList.Get(mypod.Id)
+	if exist && !stopped && !mypod.Autoremove && shouldRestartPod(mypod.RestartPolicy, mypod.Status) {
+		// the backoff is reset if the pod has been running long enough
+		if started, err := time.ParseInLocation(POD_TIME_FORMAT, mypod.StartedAt, time.Local); err == nil &&
+			time.Since(started) > CONTAINER_RESTART_RESET {
+			rs.Retries = 0
+		}
+		delay := restartBackoff(rs.Retries)
+		rs.Retries++
+		rs.Pending = true
+		if err := daemon.savePodRestart(mypod.Id, rs); err != nil {
+			glog.Errorf("failed to save the restart state of pod %s: %v", mypod.Id, err)
+		}
+		glog.V(1).Infof("pod %s exited (%s), restart it in %v", mypod.Id, rs.LastExitReason, delay)
+		daemon.schedulePodRestart(mypod.Id, delay)
+		return
+	}
+
+	if err := daemon.savePodRestart(mypod.Id, rs); err != nil {
+		glog.Errorf("failed to save the restart state of pod %s: %v", mypod.Id, err)
+	}
+
+	// the finished kubernetes pods are removed
+	if mypod.Type == "kubernetes" {
+		daemon.DeletePodFromDB(mypod.Id)
+		for _, c := range mypod.Containers {
+			glog.V(1).Infof("Ready to rm container: %s", c.Id)
+			if _, _, err := daemon.DockerCli.SendCmdDelete(c.Id); err != nil {
+				glog.V(1).Infof("Error to rm container: %s", err.Error())
+			}
+		}
+		daemon.DeletePodContainerFromDB(mypod.Id)
+		daemon.DeleteVolumeId(mypod.Id)
+	}
+}
+
+func (daemon *Daemon) schedulePodRestart(podId string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		p, err := daemon.lockPod(podId)
+		if err != nil {
+			// the pod is removed
+			return
+		}
+		defer daemon.unlockPod(p)
+
+		rs := daemon.getPodRestart(podId)
+		if !rs.Pending {
+			return
+		}
+		rs.Pending = false
+		if p.vm != nil {
+			// the pod is started by the user meanwhile
+			daemon.savePodRestart(podId, rs)
+			return
+		}
+
+		if err := daemon.RestartPod(p.status); err != nil {
+			glog.Errorf("failed to restart pod %s: %v", podId, err)
+		} else {
+			rs.Count++
+		}
+		if err := daemon.savePodRestart(podId, rs); err != nil {
+			glog.Errorf("failed to save the restart state of pod %s: %v", podId, err)
+		}
+	})
+}
+
+// resumePodRestarts picks up the restarts pending while hyperd was down
+func (daemon *Daemon) resumePodRestarts() {
+	daemon.PodList.Foreach(func(p *Pod) error {
+		if p.vm != nil {
+			return nil
+		}
+		if rs := daemon.getPodRestart(p.id); rs.Pending {
+			glog.V(1).Infof("resume the pending restart of pod %s", p.id)
+			daemon.schedulePodRestart(p.id, CONTAINER_RESTART_BACKOFF_MIN)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,181 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, d := range expected {
+		if b := restartBackoff(i); b != d {
+			t.Fatalf("backoff of %d retries should be %v, got %v", i, d, b)
+		}
+	}
+	if b := restartBackoff(100); b != CONTAINER_RESTART_BACKOFF_MAX {
+		t.Fatalf("backoff should be limited to %v, got %v", CONTAINER_RESTART_BACKOFF_MAX, b)
+	}
+}
+
+func TestShouldRestartContainer(t *testing.T) {
+	cases := []struct {
+		policy  string
+		code    uint8
+		restart bool
+	}{
+		{"never", 1, false},
+		{"", 1, false},
+		{"onFailure", 0, false},
+		{"onFailure", 137, true},
+		{"always", 0, true},
+		{"Always", 1, true},
+	}
+	for _, c := range cases {
+		if r := shouldRestartContainer(c.policy, c.code); r != c.restart {
+			t.Fatalf("policy %q with exit code %d: expected restart %v", c.policy, c.code, c.restart)
+		}
+	}
+}
+
+func TestScheduleRestartLimit(t *testing.T) {
+	var r containerRestarts
+	defer r.stop()
+
+	for i := 0; i < 2; i++ {
+		delay, err := r.schedule("c1", 2, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delay != restartBackoff(i) {
+			t.Fatalf("unexpected delay %v of retry %d", delay, i)
+		}
+		r.restarted("c1")
+	}
+	if _, err := r.schedule("c1", 2, func() {}); err == nil {
+		t.Fatal("restart should be refused after the limit")
+	}
+	if n := r.count("c1"); n != 2 {
+		t.Fatalf("expected 2 restarts, got %d", n)
+	}
+
+	// the container has been running long enough, the retries are reset
+	r.containers["c1"].started = time.Now().Add(-2 * CONTAINER_RESTART_RESET)
+	if delay, err := r.schedule("c1", 2, func() {}); err != nil || delay != CONTAINER_RESTART_BACKOFF_MIN {
+		t.Fatalf("retries should be reset, got %v, %v", delay, err)
+	}
+}
+
+func TestPodRestartPolicy(t *testing.T) {
+	cases := []struct {
+		typ        string
+		pod        string
+		containers []string
+		policy     string
+	}{
+		{"", "", nil, "never"},
+		{"", "always", []string{"never"}, "always"},
+		{"", "", []string{"onFailure", "onfailure"}, "onFailure"},
+		{"", "", []string{"always", "never"}, "never"},
+		{"", "", []string{"", ""}, "never"},
+		// the failed kubernetes pods without a policy are restarted
+		{"kubernetes", "", nil, "onFailure"},
+		{"kubernetes", "", []string{"", ""}, "onFailure"},
+		{"kubernetes", "", []string{"always", "never"}, "onFailure"},
+		{"kubernetes", "", []string{"always", "always"}, "always"},
+		{"kubernetes", "never", []string{"always"}, "never"},
+	}
+	for _, c := range cases {
+		spec := &pod.UserPod{Type: c.typ, RestartPolicy: c.pod}
+		for _, p := range c.containers {
+			spec.Containers = append(spec.Containers, pod.UserContainer{RestartPolicy: p})
+		}
+		if policy := podRestartPolicy(spec); policy != c.policy {
+			t.Fatalf("%s pod %q with containers %v: expected policy %q, got %q", c.typ, c.pod, c.containers, c.policy, policy)
+		}
+	}
+}
+
+func TestShouldRestartPod(t *testing.T) {
+	cases := []struct {
+		policy  string
+		status  uint
+		restart bool
+	}{
+		{"never", types.S_POD_FAILED, false},
+		{"onFailure", types.S_POD_SUCCEEDED, false},
+		{"onFailure", types.S_POD_FAILED, true},
+		{"always", types.S_POD_SUCCEEDED, true},
+		{"Always", types.S_POD_FAILED, true},
+	}
+	for _, c := range cases {
+		if r := shouldRestartPod(c.policy, c.status); r != c.restart {
+			t.Fatalf("policy %q with status %d: expected restart %v", c.policy, c.status, c.restart)
+		}
+	}
+}
+
+// the pod of a single container finishes together with the container, the
+// container is restarted inside the VM anyway, whichever exit comes first
+func TestSingleContainerRestart(t *testing.T) {
+	daemon := &Daemon{PodList: NewPodList()}
+	status := hypervisor.NewPod("pod-a", &pod.UserPod{Name: "a"})
+	status.AddContainer("c1", "/web", "busybox", nil, types.S_POD_RUNNING)
+	status.Status = types.S_POD_RUNNING
+	p := &Pod{
+		id:     "pod-a",
+		status: status,
+		spec: &pod.UserPod{
+			Name:       "a",
+			Containers: []pod.UserContainer{{Name: "web", RestartPolicy: "onFailure", MaxRestarts: 1}},
+		},
+	}
+	daemon.PodList.Put(p)
+	defer p.restarts.stop()
+
+	vm := &hypervisor.Vm{Id: "vm-a", Keep: types.VM_KEEP_NONE, VmChan: make(chan hypervisor.VmEvent, 1)}
+	finish := func(code uint32) {
+		hyperHandlePodEvent(&types.VmResponse{Code: types.E_POD_FINISHED, Data: []uint32{code}}, daemon, status, vm)
+	}
+	exit := func(code uint8) {
+		hyperHandlePodEvent(&types.VmResponse{Code: types.E_CONTAINER_FINISHED, Data: &types.ContainerExit{Id: "c1", Code: code}}, daemon, status, vm)
+	}
+
+	finish(1)
+	exit(1)
+	if status.Status != types.S_POD_RUNNING || status.Containers[0].Status != types.S_POD_FAILED {
+		t.Fatalf("the container should be exited in the running pod, got %d %d", status.Status, status.Containers[0].Status)
+	}
+	if !p.restarts.pending() || p.restarts.containers["c1"].retries != 1 {
+		t.Fatal("the container should be restarted once")
+	}
+	if len(vm.VmChan) != 0 {
+		t.Fatal("the VM should be kept for the restart")
+	}
+
+	// restarted, as restartContainer does
+	p.restarts.stop()
+	status.Containers[0].Status = types.S_POD_RUNNING
+	p.restarts.restarted("c1")
+
+	// the restart limit is reached, the pod is finished
+	exit(2)
+	finish(2)
+	if status.Status != types.S_POD_FAILED || status.FinishedAt == "" || status.Containers[0].ExitCode != 2 {
+		t.Fatalf("the pod should be finished, got %d", status.Status)
+	}
+	if p.restarts.pending() {
+		t.Fatal("the container should not be restarted again")
+	}
+	select {
+	case ev := <-vm.VmChan:
+		if _, ok := ev.(*hypervisor.ShutdownCommand); !ok {
+			t.Fatalf("unexpected vm event %v", ev)
+		}
+	default:
+		t.Fatal("the kept VM should be shut down")
+	}
+}
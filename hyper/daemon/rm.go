@@ -18,10 +18,11 @@ func (daemon *Daemon) CmdPodRm(job *engine.Job) (err error) {
 		cause = ""
 	)
 
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-	defer glog.V(2).Infof("unlock PodList")
-	defer daemon.PodList.Unlock()
+	p, err := daemon.lockPod(podId)
+	if err != nil {
+		return err
+	}
+	defer daemon.unlockPod(p)
 	code, cause, err = daemon.CleanPod(podId)
 	if err != nil {
 		return err
@@ -50,11 +51,12 @@ func (daemon *Daemon) CleanPod(podId string) (int, string, error) {
 	if !ok {
 		return -1, "", fmt.Errorf("Can not find that Pod(%s)", podId)
 	}
-	if pod.status.Status != types.S_POD_RUNNING {
+	if pod.status.Snapshot().Status != types.S_POD_RUNNING {
 		// If the pod type is kubernetes, we just remove the pod from the pod list.
 		// The persistent data has been removed since we got the E_VM_SHUTDOWN event.
 		if pod.status.Type == "kubernetes" {
 			daemon.RemovePod(podId)
+			daemon.RemovePodLogs(pod.status.ResourcePath)
 			code = types.E_OK
 		} else {
 			daemon.DeletePodFromDB(podId)
@@ -65,6 +67,7 @@ func (daemon *Daemon) CleanPod(podId string) (int, string, error) {
 				}
 			}
 			daemon.RemovePod(podId)
+			daemon.RemovePodLogs(pod.status.ResourcePath)
 			daemon.DeletePodContainerFromDB(podId)
 			daemon.DeleteVolumeId(podId)
 			code = types.E_OK
@@ -83,11 +86,13 @@ func (daemon *Daemon) CleanPod(podId string) (int, string, error) {
 				}
 			}
 			daemon.RemovePod(podId)
+			daemon.RemovePodLogs(pod.status.ResourcePath)
 			daemon.DeletePodContainerFromDB(podId)
 			daemon.DeleteVolumeId(podId)
 		}
 		code = types.E_OK
 	}
+	daemon.logPodEvent("remove", pod, nil)
 
 	return code, cause, nil
 }
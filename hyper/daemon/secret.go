@@ -0,0 +1,267 @@
+package daemon
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	MAX_SECRET_SIZE   = 512 * 1024
+	SECRET_MOUNT_PATH = "/run/secrets"
+	SECRET_TMPFS_SIZE = 4 * 1024 * 1024
+	SECRET_KEY_FILE   = "secret.key"
+)
+
+// secretRecord is what we put into leveldb, only Data is encrypted
+type secretRecord struct {
+	Name    string `json:"name"`
+	Size    int    `json:"size"`
+	Created int64  `json:"created"`
+	Data    []byte `json:"data"`
+}
+
+func (daemon *Daemon) CmdSecretCreate(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not execute 'secret create' command without secret name and content!")
+	}
+	name, content := job.Args[0], job.Args[1]
+
+	if err := daemon.CreateSecret(name, []byte(content)); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CmdSecretList(job *engine.Job) error {
+	secrets, err := daemon.ListSecrets()
+	if err != nil {
+		return err
+	}
+
+	var list []string
+	for _, s := range secrets {
+		list = append(list, fmt.Sprintf("%s:%d:%d", s.Name, s.Size, s.Created))
+	}
+
+	v := &engine.Env{}
+	v.SetList("secretList", list)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CmdSecretRemove(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not execute 'secret rm' command without secret name!")
+	}
+	name := job.Args[0]
+
+	if err := daemon.RemoveSecret(name); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CreateSecret(name string, data []byte) error {
+	if !pod.SecretNameReg.MatchString(name) {
+		return fmt.Errorf("Invalid secret name %s", name)
+	}
+	if len(data) > MAX_SECRET_SIZE {
+		return fmt.Errorf("Secret %s is too large, the limit is %d bytes", name, MAX_SECRET_SIZE)
+	}
+
+	// the check and the write of the record are done at once
+	daemon.secretLock.Lock()
+	defer daemon.secretLock.Unlock()
+
+	key := []byte(fmt.Sprintf("secret-%s", name))
+	if _, err := daemon.db.Get(key, nil); err == nil {
+		return fmt.Errorf("Secret %s already exists", name)
+	}
+
+	sealed, err := daemon.sealSecret(data)
+	if err != nil {
+		return err
+	}
+	record, err := json.Marshal(&secretRecord{
+		Name:    name,
+		Size:    len(data),
+		Created: time.Now().Unix(),
+		Data:    sealed,
+	})
+	if err != nil {
+		return err
+	}
+	return daemon.db.Put(key, record, nil)
+}
+
+func (daemon *Daemon) ListSecrets() ([]*secretRecord, error) {
+	var secrets []*secretRecord
+
+	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("secret-")), nil)
+	for iter.Next() {
+		var s secretRecord
+		if err := json.Unmarshal(iter.Value(), &s); err != nil {
+			glog.Warningf("invalid secret record %s: %v", string(iter.Key()), err)
+			continue
+		}
+		s.Data = nil
+		secrets = append(secrets, &s)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// GetSecret returns the decrypted content of the secret
+func (daemon *Daemon) GetSecret(name string) ([]byte, error) {
+	record, err := daemon.db.Get([]byte(fmt.Sprintf("secret-%s", name)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Can not find secret %s", name)
+	}
+
+	var s secretRecord
+	if err := json.Unmarshal(record, &s); err != nil {
+		return nil, err
+	}
+	return daemon.openSecret(s.Data)
+}
+
+func (daemon *Daemon) RemoveSecret(name string) error {
+	daemon.secretLock.Lock()
+	defer daemon.secretLock.Unlock()
+
+	key := []byte(fmt.Sprintf("secret-%s", name))
+	if _, err := daemon.db.Get(key, nil); err != nil {
+		return fmt.Errorf("Can not find secret %s", name)
+	}
+	return daemon.db.Delete(key, nil)
+}
+
+func (daemon *Daemon) secretCipher() (cipher.AEAD, error) {
+	if daemon.secretKey == nil {
+		return nil, fmt.Errorf("Secret store is not initialized")
+	}
+	block, err := aes.NewCipher(daemon.secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (daemon *Daemon) sealSecret(data []byte) ([]byte, error) {
+	gcm, err := daemon.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+func (daemon *Daemon) openSecret(sealed []byte) ([]byte, error) {
+	gcm, err := daemon.secretCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("Corrupted secret")
+	}
+	nonce, data := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, data, nil)
+}
+
+// loadSecretKey reads the key encrypting the secret store, a new one is
+// generated if the key file does not exist yet.
+func loadSecretKey(keyFile string) ([]byte, error) {
+	key, err := ioutil.ReadFile(keyFile)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("Invalid secret key file %s", keyFile)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyFile, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// secretLookup returns the plain content of a secret in the daemon side
+// secret store
+type secretLookup func(name string) ([]byte, error)
+
+// PrepareSecrets adds the secrets referenced by the containers as the files
+// of a read only tmpfs, which init mounts at SECRET_MOUNT_PATH in the
+// containers. The content only goes to the memory of the guest, it never
+// touches the disk of the host nor the container rootfs, and it is not in the
+// spec of the pod saved in the db.
+func (p *Pod) PrepareSecrets(lookup secretLookup) error {
+	for i := range p.spec.Containers {
+		c := &p.spec.Containers[i]
+		if len(c.Secrets) == 0 || i >= len(p.containers) {
+			continue
+		}
+
+		tmpfs := hypervisor.VmTmpfsDescriptor{
+			Path:     SECRET_MOUNT_PATH,
+			Size:     SECRET_TMPFS_SIZE,
+			ReadOnly: true,
+		}
+		for _, sr := range c.Secrets {
+			data, err := lookup(sr.Name)
+			if err != nil {
+				return err
+			}
+			target := sr.Path
+			if target == "" {
+				target = sr.Name
+			}
+			tmpfs.Files = append(tmpfs.Files, hypervisor.VmTmpfsFile{
+				Path:    target,
+				Mode:    0400,
+				Content: data,
+			})
+		}
+		p.containers[i].Tmpfs = append(p.containers[i].Tmpfs, tmpfs)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestSecretSealOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := path.Join(dir, SECRET_KEY_FILE)
+	key, err := loadSecretKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := loadSecretKey(keyFile); err != nil || !bytes.Equal(key, again) {
+		t.Fatal("the secret key should be persisted")
+	}
+
+	daemon := &Daemon{secretKey: key}
+	sealed, err := daemon.sealSecret([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("password")) {
+		t.Fatal("the secret is not encrypted")
+	}
+
+	data, err := daemon.openSecret(sealed)
+	if err != nil || string(data) != "password" {
+		t.Fatalf("open secret failed: %v, %q", err, string(data))
+	}
+
+	sealed[len(sealed)-1] ^= 0xff
+	if _, err := daemon.openSecret(sealed); err == nil {
+		t.Fatal("the corrupted secret should be rejected")
+	}
+}
+
+func TestCreateSecretOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(path.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	daemon := &Daemon{db: db, secretKey: bytes.Repeat([]byte("k"), 32)}
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		created []string
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := fmt.Sprintf("password-%d", i)
+			if daemon.CreateSecret("foo", []byte(content)) == nil {
+				lock.Lock()
+				created = append(created, content)
+				lock.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(created) != 1 {
+		t.Fatalf("the secret should be created once, got %v", created)
+	}
+	if data, err := daemon.GetSecret("foo"); err != nil || string(data) != created[0] {
+		t.Fatalf("expect %q, got %q %v", created[0], string(data), err)
+	}
+}
+
+func TestPrepareSecrets(t *testing.T) {
+	p := &Pod{
+		spec: &pod.UserPod{
+			Containers: []pod.UserContainer{
+				{Secrets: []pod.UserSecretReference{{Name: "foo"}, {Name: "bar", Path: "baz"}}},
+				{},
+			},
+		},
+		containers: []*hypervisor.ContainerInfo{{Id: "c1"}, {Id: "c2"}},
+	}
+	lookup := func(name string) ([]byte, error) {
+		if name == "missing" {
+			return nil, fmt.Errorf("Can not find secret %s", name)
+		}
+		return []byte(name + "-data"), nil
+	}
+
+	if err := p.PrepareSecrets(lookup); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.spec.Volumes) != 0 || len(p.spec.Containers[0].Volumes) != 0 {
+		t.Fatal("the secrets should not be in the spec of the pod")
+	}
+	if len(p.containers[1].Tmpfs) != 0 || len(p.containers[0].Tmpfs) != 1 {
+		t.Fatal("only the first container has secrets")
+	}
+	tmpfs := p.containers[0].Tmpfs[0]
+	if tmpfs.Path != SECRET_MOUNT_PATH || !tmpfs.ReadOnly || len(tmpfs.Files) != 2 {
+		t.Fatalf("unexpected secret tmpfs %v", tmpfs)
+	}
+	if f := tmpfs.Files[1]; f.Path != "baz" || string(f.Content) != "bar-data" || f.Mode != 0400 {
+		t.Fatalf("unexpected secret file %s %q %o", f.Path, string(f.Content), f.Mode)
+	}
+
+	p.spec.Containers[1].Secrets = []pod.UserSecretReference{{Name: "missing"}}
+	if err := p.PrepareSecrets(lookup); err == nil {
+		t.Fatal("a missing secret should fail")
+	}
+}
@@ -2,22 +2,57 @@ package daemon
 
 import (
 	"fmt"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/signal"
 	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
 	"github.com/hyperhq/runv/hypervisor/types"
 )
 
+const (
+	// the default grace period of the containers, the same as docker
+	DEFAULT_STOP_TIMEOUT = 10
+	DEFAULT_STOP_SIGNAL  = "SIGTERM"
+
+	// how long to wait for the containers killed, or the vm shutdown
+	// after all the containers exited
+	STOP_KILL_WAIT = 5 * time.Second
+)
+
 func (daemon *Daemon) CmdPodStop(job *engine.Job) error {
 	if len(job.Args) == 0 {
 		return fmt.Errorf("Can not execute 'stop' command without any pod name!")
 	}
 	podId := job.Args[0]
 	stopVm := job.Args[1]
-	daemon.PodList.Lock()
-	glog.V(2).Infof("lock PodList")
-	defer glog.V(2).Infof("unlock PodList")
-	defer daemon.PodList.Unlock()
-	code, cause, err := daemon.StopPod(podId, stopVm)
+	// the timeout and signal are optional, the containers use their own
+	// grace periods and stop signals by default
+	timeout, sig := -1, ""
+	if len(job.Args) > 2 && job.Args[2] != "" {
+		t, err := strconv.Atoi(job.Args[2])
+		if err != nil || t < 0 {
+			return fmt.Errorf("invalid stop timeout %s", job.Args[2])
+		}
+		timeout = t
+	}
+	if len(job.Args) > 3 && job.Args[3] != "" {
+		sig = job.Args[3]
+		if _, err := signal.ParseSignal(sig); err != nil {
+			return err
+		}
+	}
+	p, err := daemon.lockPod(podId)
+	if err != nil {
+		return err
+	}
+	defer daemon.unlockPod(p)
+	code, cause, err := daemon.StopPodWithTimeout(podId, stopVm, timeout, sig)
 	if err != nil {
 		return err
 	}
@@ -46,15 +81,28 @@ func (daemon *Daemon) PodStopped(podId string) {
 		return
 	}
 
+	pod.stopProbes()
+	pod.restarts.stop()
 	daemon.DeleteVmByPod(podId)
 	daemon.RemoveVm(pod.vm.Id)
+	daemon.LogPodEvent("stop", podId, map[string]string{"vm": pod.vm.Id})
 	if pod.status.Autoremove == true {
 		daemon.CleanPod(podId)
 	}
-	pod.vm = nil
+	pod.setVm(nil)
 }
 
+// StopPod stops the pod, the containers get their own stop signals and
+// grace periods
 func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
+	return daemon.StopPodWithTimeout(podId, stopVm, -1, "")
+}
+
+// StopPodWithTimeout stops the pod. The running containers get sig, or
+// their stop signals if sig is empty, and are killed if they are still
+// running after timeout seconds. A negative timeout means the grace
+// periods of the containers.
+func (daemon *Daemon) StopPodWithTimeout(podId, stopVm string, timeout int, sig string) (int, string, error) {
 	glog.V(1).Infof("Prepare to stop the POD: %s", podId)
 	// find the vm id which running POD, and stop it
 	pod, ok := daemon.PodList.Get(podId)
@@ -65,25 +113,264 @@ func (daemon *Daemon) StopPod(podId, stopVm string) (int, string, error) {
 	// we need to set the 'RestartPolicy' of the pod to 'never' if stop command is invoked
 	// for kubernetes
 	if pod.status.Type == "kubernetes" {
+		pod.status.Lock()
 		pod.status.RestartPolicy = "never"
+		pod.status.Unlock()
 	}
 
 	if pod.vm == nil {
 		return types.E_VM_SHUTDOWN, "", nil
 	}
 
-	vmId := pod.vm.Id
-	vmResponse := pod.vm.StopPod(pod.status, stopVm)
+	vm := pod.vm
+	vmId := vm.Id
+	// cleared by the handler of the vm shutdown, which may come after the
+	// stop returns
+	pod.startStopping()
+
+	w, err := watchExits(vm, pod.status.Containers)
+	if err != nil {
+		// the vm is gone, the pod is cleaned up by the handler of the shutdown
+		pod.stopFinished()
+		return types.E_VM_SHUTDOWN, "", nil
+	}
+	daemon.stopContainers(pod, vm, w, timeout, sig)
+	if w.allExited() && vm.Keep == types.VM_KEEP_NONE {
+		// all the containers exited, the vm goes down by itself
+		select {
+		case <-w.down:
+		case <-time.After(STOP_KILL_WAIT):
+		}
+	}
+	w.stop()
+	if w.isDown() {
+		// the pod is cleaned up by the handler of the vm shutdown
+		return types.E_VM_SHUTDOWN, "", nil
+	}
+
+	var code int
+	var cause string
+	pod.status.RLock()
+	running := pod.status.Status == types.S_POD_RUNNING
+	pod.status.RUnlock()
+	if running {
+		vmResponse := vm.StopPod(pod.status, stopVm)
+		code, cause = vmResponse.Code, vmResponse.Cause
+	} else {
+		// the pod finished before, the vm is left idle or going down
+		code = types.E_POD_STOPPED
+		if vm.Keep == types.VM_KEEP_NONE {
+			code = types.E_VM_SHUTDOWN
+		}
+	}
 
 	// Delete the Vm info for POD
 	daemon.DeleteVmByPod(podId)
 
-	if vmResponse.Code == types.E_VM_SHUTDOWN {
+	if code == types.E_VM_SHUTDOWN {
 		daemon.RemoveVm(vmId)
+	} else {
+		// the vm is kept, there is no shutdown to wait for
+		pod.stopFinished()
 	}
+	daemon.LogPodEvent("stop", podId, map[string]string{"vm": vmId})
 	if pod.status.Autoremove == true {
 		daemon.CleanPod(podId)
 	}
-	pod.vm = nil
-	return vmResponse.Code, vmResponse.Cause, nil
+	pod.setVm(nil)
+	return code, cause, nil
+}
+
+func stopSettings(uc *pod.UserContainer, timeout int, sig string) (time.Duration, syscall.Signal, error) {
+	if sig == "" {
+		sig = uc.StopSignal
+	}
+	if sig == "" {
+		sig = DEFAULT_STOP_SIGNAL
+	}
+	s, err := signal.ParseSignal(sig)
+	if err != nil {
+		return 0, 0, err
+	}
+	if timeout < 0 {
+		timeout = uc.TerminationGracePeriod
+		if timeout == 0 {
+			timeout = DEFAULT_STOP_TIMEOUT
+		}
+	}
+	return time.Duration(timeout) * time.Second, s, nil
+}
+
+// exitWatch follows the responses of the vm of a pod being stopped. The
+// channel of a running container is closed when it exits, and down, with
+// all of them, when the vm goes down.
+type exitWatch struct {
+	exits map[string]chan struct{}
+	down  chan struct{}
+	done  chan struct{}
+}
+
+// watchExits starts to watch the exits of the running containers, the
+// containers are checked after the responses are followed, not to miss the
+// exits in between
+func watchExits(vm *hypervisor.Vm, containers []*hypervisor.Container) (*exitWatch, error) {
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return nil, err
+	}
+
+	var running []string
+	for _, c := range containers {
+		if c.Status == types.S_POD_RUNNING {
+			running = append(running, c.Id)
+		}
+	}
+	w := newExitWatch(running)
+	go func() {
+		w.follow(Status)
+		vm.ReleaseResponseChan(Status)
+	}()
+	return w, nil
+}
+
+func newExitWatch(containers []string) *exitWatch {
+	w := &exitWatch{
+		exits: make(map[string]chan struct{}),
+		down:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	for _, id := range containers {
+		w.exits[id] = make(chan struct{})
+	}
+	return w
+}
+
+// follow closes the channels by the responses, until the vm goes down or
+// the watch is stopped
+func (w *exitWatch) follow(Status <-chan *types.VmResponse) {
+	pending := make(map[string]chan struct{})
+	for id, ch := range w.exits {
+		pending[id] = ch
+	}
+	exited := func(id string) {
+		if ch, ok := pending[id]; ok {
+			close(ch)
+			delete(pending, id)
+		}
+	}
+
+	for {
+		select {
+		case Response, ok := <-Status:
+			if !ok || Response.Code == types.E_VM_SHUTDOWN {
+				for id := range pending {
+					exited(id)
+				}
+				close(w.down)
+				return
+			}
+			switch Response.Code {
+			case types.E_CONTAINER_FINISHED:
+				if exit, ok := Response.Data.(*types.ContainerExit); ok {
+					exited(exit.Id)
+				}
+			case types.E_POD_FINISHED:
+				for id := range pending {
+					exited(id)
+				}
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *exitWatch) stop() {
+	close(w.done)
+}
+
+func (w *exitWatch) isDown() bool {
+	select {
+	case <-w.down:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *exitWatch) allExited() bool {
+	for _, ch := range w.exits {
+		select {
+		case <-ch:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// stopContainers stops the running containers of the pod gracefully, the
+// containers are stopped in parallel and it returns when all of them
+// exited or were killed.
+func (daemon *Daemon) stopContainers(p *Pod, vm *hypervisor.Vm, w *exitWatch, timeout int, sig string) {
+	var wg sync.WaitGroup
+	for i, c := range p.status.Containers {
+		exited, ok := w.exits[c.Id]
+		if !ok || i >= len(p.spec.Containers) {
+			continue
+		}
+		uc := &p.spec.Containers[i]
+		grace, s, err := stopSettings(uc, timeout, sig)
+		if err != nil {
+			glog.Warningf("container %s of pod %s: %v", c.Id, p.id, err)
+			continue
+		}
+		if grace == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, exited <-chan struct{}) {
+			defer wg.Done()
+			daemon.stopContainer(p, vm, id, uc, grace, s, exited)
+		}(c.Id, exited)
+	}
+	wg.Wait()
+}
+
+func (daemon *Daemon) stopContainer(p *Pod, vm *hypervisor.Vm, id string, uc *pod.UserContainer, grace time.Duration, s syscall.Signal, exited <-chan struct{}) {
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+
+	if uc.PreStop != nil && uc.PreStop.Exec != nil {
+		glog.V(1).Infof("run the preStop hook of container %s", id)
+		if err := execCommand(vm, id, uc.PreStop.Exec.Command, grace); err != nil {
+			glog.Warningf("preStop hook of container %s failed: %v", id, err)
+		}
+	}
+
+	select {
+	case <-exited:
+		return
+	default:
+	}
+	if err := vm.KillContainer(id, s); err != nil {
+		glog.Warningf("failed to signal container %s: %v", id, err)
+	}
+	daemon.LogEvent(EVENT_TYPE_CONTAINER, "kill", id, map[string]string{"pod": p.id, "signal": strconv.Itoa(int(s))})
+
+	select {
+	case <-exited:
+		return
+	case <-deadline.C:
+	}
+
+	glog.Infof("container %s of pod %s is still running after %v, kill it", id, p.id, grace)
+	if err := vm.KillContainer(id, syscall.SIGKILL); err != nil {
+		glog.Warningf("failed to kill container %s: %v", id, err)
+	}
+	daemon.LogEvent(EVENT_TYPE_CONTAINER, "kill", id, map[string]string{"pod": p.id, "signal": strconv.Itoa(int(syscall.SIGKILL))})
+	select {
+	case <-exited:
+	case <-time.After(STOP_KILL_WAIT):
+	}
 }
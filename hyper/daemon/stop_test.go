@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+func TestStopSettings(t *testing.T) {
+	cases := []struct {
+		container pod.UserContainer
+		timeout   int
+		sig       string
+		grace     time.Duration
+		signal    syscall.Signal
+	}{
+		{pod.UserContainer{}, -1, "", DEFAULT_STOP_TIMEOUT * time.Second, syscall.SIGTERM},
+		{pod.UserContainer{StopSignal: "SIGINT", TerminationGracePeriod: 30}, -1, "", 30 * time.Second, syscall.SIGINT},
+		{pod.UserContainer{StopSignal: "SIGINT", TerminationGracePeriod: 30}, 0, "QUIT", 0, syscall.SIGQUIT},
+		{pod.UserContainer{}, 5, "9", 5 * time.Second, syscall.SIGKILL},
+	}
+	for i, c := range cases {
+		grace, s, err := stopSettings(&c.container, c.timeout, c.sig)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if grace != c.grace || s != c.signal {
+			t.Fatalf("case %d: expected %v and %v, got %v and %v", i, c.grace, c.signal, grace, s)
+		}
+	}
+
+	if _, _, err := stopSettings(&pod.UserContainer{StopSignal: "SIGFOO"}, -1, ""); err == nil {
+		t.Fatal("invalid stop signal should fail")
+	}
+}
+
+func TestExitWatch(t *testing.T) {
+	Status := make(chan *types.VmResponse, 4)
+	w := newExitWatch([]string{"c1", "c2"})
+	followed := make(chan struct{})
+	go func() {
+		w.follow(Status)
+		close(followed)
+	}()
+
+	Status <- &types.VmResponse{Code: types.E_CONTAINER_FINISHED, Data: &types.ContainerExit{Id: "c1", Code: 0}}
+	select {
+	case <-w.exits["c1"]:
+	case <-time.After(time.Second):
+		t.Fatal("c1 should be exited")
+	}
+	if w.allExited() || w.isDown() {
+		t.Fatal("c2 is still running")
+	}
+
+	Status <- &types.VmResponse{Code: types.E_VM_SHUTDOWN}
+	select {
+	case <-followed:
+	case <-time.After(time.Second):
+		t.Fatal("the watch should be finished once the vm is down")
+	}
+	if !w.allExited() || !w.isDown() {
+		t.Fatal("all the containers should be exited with the vm")
+	}
+
+	w = newExitWatch([]string{"c1"})
+	stopped := make(chan struct{})
+	go func() {
+		w.follow(make(chan *types.VmResponse))
+		close(stopped)
+	}()
+	w.stop()
+	<-stopped
+	if w.allExited() || w.isDown() {
+		t.Fatal("the stopped watch should not close anything")
+	}
+}
+
+func TestPodStopping(t *testing.T) {
+	p := &Pod{}
+	if p.isStopping() || p.stopFinished() {
+		t.Fatal("a new pod should not be stopping")
+	}
+	p.startStopping()
+	if !p.isStopping() {
+		t.Fatal("the pod should be stopping")
+	}
+	if !p.stopFinished() {
+		t.Fatal("the stop should be finished once")
+	}
+	if p.isStopping() || p.stopFinished() {
+		t.Fatal("the stopping mark should be cleared")
+	}
+}
@@ -221,6 +221,9 @@ func (dms *DevMapperStorage) CreateVolume(daemon *Daemon, podId, shortName strin
 func (dms *DevMapperStorage) RemoveVolume(podId string, record []byte) error {
 	fields := strings.Split(string(record), ":")
 	dev_id, _ := strconv.Atoi(fields[1])
+	if err := dm.RemoveDevice(fields[0]); err != nil {
+		return err
+	}
 	if err := dm.DeleteVolume(dms.DmPoolData, dev_id); err != nil {
 		glog.Error(err.Error())
 		return err
@@ -0,0 +1,490 @@
+package daemon
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/pkg/mount"
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/storage"
+	dm "github.com/hyperhq/hyper/storage/devicemapper"
+	hypertypes "github.com/hyperhq/hyper/types"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/types"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	// the dirs of the VMs and the volumes younger than it are not orphaned,
+	// they may be being created
+	ORPHAN_GRACE_PERIOD = 10 * time.Minute
+
+	DEFAULT_GC_INTERVAL = 10 * time.Minute
+)
+
+const (
+	DU_IMAGES         = "Images"
+	DU_CONTAINERS     = "Containers"
+	DU_VOLUMES        = "Volumes"
+	DU_VOLUME_DEVICES = "Volume Devices"
+	DU_VM_DIRS        = "VM Dirs"
+)
+
+// diskEntry is a resource using the disk, the prunable ones are removed by
+// the prune
+type diskEntry struct {
+	id       string
+	size     int64
+	active   bool
+	prunable bool
+	remove   func() error
+	// measure computes the size if it is too costly to be known up front,
+	// see diskSize
+	measure func() int64
+}
+
+// diskSize returns the size of the entry, it is only measured with measure
+func (e *diskEntry) diskSize(measure bool) int64 {
+	if measure && e.measure != nil {
+		e.size = e.measure()
+		e.measure = nil
+	}
+	return e.size
+}
+
+type diskKind struct {
+	kind    string
+	entries []*diskEntry
+}
+
+// diskEntries finds the resources using the disk, by kind, in the order
+// they are pruned: the images are only dangling after the containers of the
+// stopped pods are removed. The stopped pods are only prunable with pods.
+func (daemon *Daemon) diskEntries(pods bool) ([]*diskKind, error) {
+	containers, usedImages := daemon.containerEntries(pods)
+	images, err := daemon.imageEntries(usedImages)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := []*diskKind{
+		{DU_CONTAINERS, containers},
+		{DU_IMAGES, images},
+		{DU_VOLUMES, daemon.volumeEntries()},
+	}
+	if daemon.Storage != nil && daemon.Storage.Type() == "devicemapper" {
+		kinds = append(kinds, &diskKind{DU_VOLUME_DEVICES, daemon.volumeDeviceEntries()})
+	}
+	kinds = append(kinds, &diskKind{DU_VM_DIRS, daemon.vmDirEntries()})
+	return kinds, nil
+}
+
+// containerEntries returns the containers of the pods, the ones of the stopped
+// pods are removed with their pods with pods, and the images used by them.
+// The pods to be restarted are not stopped for good, they are kept. The size
+// of a container is only measured on demand, it mounts the container.
+func (daemon *Daemon) containerEntries(pods bool) ([]*diskEntry, map[string]bool) {
+	var (
+		entries    []*diskEntry
+		usedImages = map[string]bool{}
+	)
+	daemon.PodList.Foreach(func(p *Pod) error {
+		podId := p.id
+		status := p.status.Snapshot()
+		stopped := status.Status == types.S_POD_FAILED || status.Status == types.S_POD_SUCCEEDED
+		prunable := pods && stopped && !daemon.getPodRestart(podId).Pending
+		for _, c := range status.Containers {
+			e := &diskEntry{
+				id:       c.Id,
+				active:   status.Status == types.S_POD_RUNNING,
+				prunable: prunable,
+			}
+			if info, err := daemon.DockerCli.GetContainerInfo(c.Id); err == nil {
+				usedImages[info.Image] = true
+			}
+			id := c.Id
+			e.measure = func() int64 {
+				info, err := daemon.DockerCli.GetContainerInfo(id, "size")
+				if err != nil || info.SizeRw == nil || *info.SizeRw < 0 {
+					return 0
+				}
+				return *info.SizeRw
+			}
+			if prunable {
+				e.remove = func() error { return daemon.pruneStoppedPod(podId) }
+			}
+			entries = append(entries, e)
+		}
+		return nil
+	})
+	return entries, usedImages
+}
+
+// pruneStoppedPod removes the pod and its containers, unless it is started
+// again, or going to be restarted, meanwhile
+func (daemon *Daemon) pruneStoppedPod(podId string) error {
+	p, err := daemon.lockPod(podId)
+	if err != nil {
+		// already removed with another container of the pod
+		return nil
+	}
+	defer daemon.unlockPod(p)
+
+	if p.status.Status != types.S_POD_FAILED && p.status.Status != types.S_POD_SUCCEEDED {
+		return fmt.Errorf("pod %s is not stopped", podId)
+	}
+	if daemon.getPodRestart(podId).Pending {
+		return fmt.Errorf("pod %s is going to be restarted", podId)
+	}
+	_, _, err = daemon.CleanPod(podId)
+	return err
+}
+
+// imageEntries returns the images, the dangling ones, untagged and not the
+// parent of any image, are pruned
+func (daemon *Daemon) imageEntries(usedImages map[string]bool) ([]*diskEntry, error) {
+	all, err := daemon.DockerCli.SendCmdImages("yes")
+	if err != nil {
+		return nil, err
+	}
+	top, err := daemon.DockerCli.SendCmdImages("no")
+	if err != nil {
+		return nil, err
+	}
+	dangling := map[string]bool{}
+	for _, img := range top {
+		if len(img.RepoTags) == 0 || (len(img.RepoTags) == 1 && img.RepoTags[0] == "<none>:<none>") {
+			dangling[img.ID] = true
+		}
+	}
+
+	var entries []*diskEntry
+	for _, img := range all {
+		id := img.ID
+		e := &diskEntry{
+			id:       id,
+			size:     img.Size,
+			active:   usedImages[id],
+			prunable: dangling[id] && !usedImages[id],
+		}
+		if e.prunable {
+			e.remove = func() error {
+				_, err := daemon.DockerCli.SendImageDelete(id, "no", "no")
+				return err
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// volumeEntries returns the dirs of the volumes of the pods, the ones of the
+// removed pods are orphaned
+func (daemon *Daemon) volumeEntries() []*diskEntry {
+	return orphanDirEntries(storage.VFS_VOLUME_ROOT, nil, func(name string) bool {
+		_, ok := daemon.PodList.Get(name)
+		return ok
+	})
+}
+
+// vmDirEntries returns the run dirs of the VMs, the ones of the VMs gone
+// without cleaning up are orphaned. The dirs of the last VMs of the pods
+// keep the console logs of the restarts, they are not orphaned.
+func (daemon *Daemon) vmDirEntries() []*diskEntry {
+	restartVms := daemon.podRestartVms()
+	return orphanDirEntries(hypervisor.BaseDir, []string{"vm-", "buildervm-"}, func(name string) bool {
+		_, ok := daemon.GetVm(name)
+		return ok || restartVms[name]
+	})
+}
+
+// orphanDirEntries returns the sub dirs of base with one of the prefixes,
+// the ones not active and older than ORPHAN_GRACE_PERIOD are prunable
+func orphanDirEntries(base string, prefixes []string, active func(name string) bool) []*diskEntry {
+	files, err := ioutil.ReadDir(base)
+	if err != nil {
+		return nil
+	}
+
+	var entries []*diskEntry
+	for _, fi := range files {
+		if !fi.IsDir() || !hasAnyPrefix(fi.Name(), prefixes) {
+			continue
+		}
+		dir := path.Join(base, fi.Name())
+		e := &diskEntry{
+			id:     fi.Name(),
+			size:   dirSize(dir),
+			active: active(fi.Name()),
+		}
+		if !e.active && time.Since(fi.ModTime()) > ORPHAN_GRACE_PERIOD {
+			e.prunable = true
+			e.remove = func() error { return removeOrphanDir(dir) }
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirSize returns the size of the files in dir, without the file systems
+// mounted in it, e.g. the rootfs of the containers in the share dir of a VM
+func dirSize(dir string) int64 {
+	root, err := os.Lstat(dir)
+	if err != nil {
+		return 0
+	}
+	dev := root.Sys().(*syscall.Stat_t).Dev
+
+	var size int64
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if fi.Sys().(*syscall.Stat_t).Dev != dev {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// removeOrphanDir unmounts what is left mounted in the dir, and removes it.
+// The dir is kept if anything can not be unmounted, not to remove the files
+// of the mounted file systems.
+func removeOrphanDir(dir string) error {
+	mounts, err := mount.GetMounts()
+	if err != nil {
+		return err
+	}
+	var points []string
+	for _, m := range mounts {
+		if m.Mountpoint == dir || strings.HasPrefix(m.Mountpoint, dir+"/") {
+			points = append(points, m.Mountpoint)
+		}
+	}
+	// the nested ones first
+	sort.Sort(sort.Reverse(sort.StringSlice(points)))
+	for _, p := range points {
+		if err := utils.Unmount(p); err != nil {
+			return fmt.Errorf("failed to umount %s: %v", p, err)
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// volumeDeviceEntries returns the devicemapper volumes, the ones of the
+// removed pods are unused
+func (daemon *Daemon) volumeDeviceEntries() []*diskEntry {
+	var entries []*diskEntry
+	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("vol-")), nil)
+	for iter.Next() {
+		// vol-<pod>-<dev id>: <volume>:<dev id>
+		key := strings.TrimPrefix(string(iter.Key()), "vol-")
+		fields := strings.Split(string(iter.Value()), ":")
+		i := strings.LastIndex(key, "-")
+		if i < 0 || len(fields) != 2 {
+			continue
+		}
+		podId := key[:i]
+
+		e := &diskEntry{id: fields[0]}
+		if size, err := dm.VolumeSize(fields[0]); err == nil {
+			e.size = size
+		}
+		_, e.active = daemon.PodList.Get(podId)
+		if !e.active {
+			e.prunable = true
+			e.remove = func() error { return daemon.DeleteVolumeId(podId) }
+		}
+		entries = append(entries, e)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		glog.Warningf("failed to list the volume devices: %v", err)
+	}
+	return entries
+}
+
+// DiskUsage returns the disk space used by each kind of resources, the
+// sizes costly to measure, e.g. of the containers, are only computed with
+// size
+func (daemon *Daemon) DiskUsage(size bool) ([]*hypertypes.DiskUsage, error) {
+	kinds, err := daemon.diskEntries(true)
+	if err != nil {
+		return nil, err
+	}
+
+	var usage []*hypertypes.DiskUsage
+	for _, k := range kinds {
+		du := &hypertypes.DiskUsage{Type: k.kind}
+		for _, e := range k.entries {
+			if e.measure != nil && !size {
+				du.Unmeasured = true
+			}
+			du.Total++
+			du.Size += e.diskSize(size)
+			if e.active {
+				du.Active++
+			}
+			if e.prunable {
+				du.Reclaimable += e.diskSize(size)
+			}
+		}
+		usage = append(usage, du)
+	}
+	return usage, nil
+}
+
+// Prune removes the dangling images, the stopped pods with their containers
+// if pods is set, and the volumes and the VM dirs left behind. Nothing is
+// removed with dryRun, the reports are what would be removed.
+func (daemon *Daemon) Prune(dryRun, pods bool) ([]*hypertypes.PruneReport, error) {
+	daemon.gcLock.Lock()
+	defer daemon.gcLock.Unlock()
+
+	var reports []*hypertypes.PruneReport
+	prune := func(k *diskKind) {
+		report := &hypertypes.PruneReport{Type: k.kind, Removed: []string{}}
+		for _, e := range k.entries {
+			if !e.prunable {
+				continue
+			}
+			// only the removed ones are measured, before they are gone
+			size := e.diskSize(true)
+			if !dryRun {
+				if err := e.remove(); err != nil {
+					glog.Warningf("failed to prune %s %s: %v", k.kind, e.id, err)
+					continue
+				}
+			}
+			report.Removed = append(report.Removed, e.id)
+			report.Reclaimed += size
+		}
+		reports = append(reports, report)
+	}
+
+	kinds, err := daemon.diskEntries(pods)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range kinds {
+		// the containers removed with the stopped pods leave more images
+		// dangling
+		if k.kind == DU_IMAGES && !dryRun {
+			_, usedImages := daemon.containerEntries(false)
+			if k.entries, err = daemon.imageEntries(usedImages); err != nil {
+				return nil, err
+			}
+		}
+		prune(k)
+	}
+	return reports, nil
+}
+
+// gcLoop prunes when the file system of the hyper root is used over the
+// threshold, in percent. The stopped pods are only removed with pods.
+func (daemon *Daemon) gcLoop(threshold int, interval time.Duration, pods bool) {
+	for {
+		time.Sleep(interval)
+
+		used, err := fsUsedPercent(utils.HYPER_ROOT)
+		if err != nil {
+			glog.Warningf("failed to get the disk usage of %s: %v", utils.HYPER_ROOT, err)
+			continue
+		}
+		if used < threshold {
+			continue
+		}
+		glog.Infof("%s is %d%% used, over the GC threshold %d%%, prune", utils.HYPER_ROOT, used, threshold)
+		reports, err := daemon.Prune(false, pods)
+		if err != nil {
+			glog.Errorf("failed to prune: %v", err)
+			continue
+		}
+		for _, r := range reports {
+			if len(r.Removed) > 0 {
+				glog.Infof("pruned %d %s, %d bytes reclaimed", len(r.Removed), r.Type, r.Reclaimed)
+			}
+		}
+	}
+}
+
+func fsUsedPercent(dir string) (int, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	total := uint64(st.Blocks)
+	if total == 0 {
+		return 0, nil
+	}
+	return int((total - uint64(st.Bavail)) * 100 / total), nil
+}
+
+// parseGCThreshold parses the GCThreshold of the config, the percent of the
+// disk used to trigger the GC, e.g. 85 or 85%
+func parseGCThreshold(value string) (int, error) {
+	threshold, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	if err != nil || threshold <= 0 || threshold > 100 {
+		return 0, fmt.Errorf("the threshold should be a percent between 1 and 100")
+	}
+	return threshold, nil
+}
+
+func (daemon *Daemon) CmdSystemDf(job *engine.Job) error {
+	size := len(job.Args) > 0 && job.Args[0] == "yes"
+	usage, err := daemon.DiskUsage(size)
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.SetJson("data", usage)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CmdSystemPrune(job *engine.Job) error {
+	dryRun := len(job.Args) > 0 && job.Args[0] == "yes"
+	reports, err := daemon.Prune(dryRun, true)
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.SetJson("data", reports)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package daemon
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	dockertypes "github.com/docker/docker/api/types"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/types"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestParseGCThreshold(t *testing.T) {
+	for value, expected := range map[string]int{"85": 85, "90%": 90, " 100% ": 100} {
+		threshold, err := parseGCThreshold(value)
+		if err != nil || threshold != expected {
+			t.Fatalf("parseGCThreshold(%q): expected %d, got %d %v", value, expected, threshold, err)
+		}
+	}
+	for _, value := range []string{"", "0", "101", "-5", "85G"} {
+		if _, err := parseGCThreshold(value); err == nil {
+			t.Fatalf("parseGCThreshold(%q) should fail", value)
+		}
+	}
+}
+
+func TestOrphanDirEntries(t *testing.T) {
+	base, err := ioutil.TempDir("", "hyper-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	old := time.Now().Add(-2 * ORPHAN_GRACE_PERIOD)
+	for _, name := range []string{"vm-active", "vm-orphan", "vm-new", "buildervm-orphan", "Pods"} {
+		dir := path.Join(base, name)
+		if err := os.MkdirAll(path.Join(dir, "sub"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "sub", "data"), make([]byte, 1000), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if name != "vm-new" {
+			os.Chtimes(dir, old, old)
+		}
+	}
+
+	entries := orphanDirEntries(base, []string{"vm-", "buildervm-"}, func(name string) bool {
+		return name == "vm-active"
+	})
+	prunable := map[string]bool{}
+	for _, e := range entries {
+		if e.size != 1000 {
+			t.Fatalf("the size of %s should be 1000, got %d", e.id, e.size)
+		}
+		if e.active != (e.id == "vm-active") {
+			t.Fatalf("only vm-active should be active, %s is %v", e.id, e.active)
+		}
+		if e.prunable {
+			prunable[e.id] = true
+			if err := e.remove(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected the 4 VM dirs, got %d", len(entries))
+	}
+	if len(prunable) != 2 || !prunable["vm-orphan"] || !prunable["buildervm-orphan"] {
+		t.Fatalf("only the old orphaned dirs should be pruned, got %v", prunable)
+	}
+
+	for name, exist := range map[string]bool{"vm-active": true, "vm-new": true, "Pods": true, "vm-orphan": false, "buildervm-orphan": false} {
+		if _, err := os.Stat(path.Join(base, name)); (err == nil) != exist {
+			t.Fatalf("%s should exist: %v", name, exist)
+		}
+	}
+}
+
+func TestPodRestartVms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(path.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	daemon := &Daemon{db: db}
+	daemon.savePodRestart("pod-a", &podRestart{Pending: true, Vm: "vm-a"})
+	daemon.savePodRestart("pod-b", &podRestart{Count: 1})
+	db.Put([]byte("pod-c"), []byte("{}"), nil)
+
+	vms := daemon.podRestartVms()
+	if len(vms) != 1 || !vms["vm-a"] {
+		t.Fatalf("only vm-a is in the restart records, got %v", vms)
+	}
+	if !daemon.getPodRestart("pod-a").Pending || daemon.getPodRestart("pod-b").Pending {
+		t.Fatal("only pod-a is going to be restarted")
+	}
+}
+
+// sizeDocker counts the containers mounted to measure their sizes
+type sizeDocker struct {
+	DockerInterface
+	measured []string
+}
+
+func (d *sizeDocker) GetContainerInfo(args ...string) (*dockertypes.ContainerJSON, error) {
+	info := &dockertypes.ContainerJSON{ContainerJSONBase: &dockertypes.ContainerJSONBase{Image: "image-" + args[0]}}
+	if len(args) > 1 && args[1] == "size" {
+		d.measured = append(d.measured, args[0])
+		size := int64(42)
+		info.SizeRw = &size
+	}
+	return info, nil
+}
+
+func TestContainerEntriesMeasuredOnDemand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-gc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(path.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	docker := &sizeDocker{}
+	daemon := &Daemon{db: db, PodList: NewPodList(), DockerCli: docker}
+	for id, status := range map[string]uint{"pod-running": types.S_POD_RUNNING, "pod-stopped": types.S_POD_SUCCEEDED} {
+		daemon.PodList.Put(&Pod{
+			id: id,
+			status: &hypervisor.PodStatus{
+				Id:         id,
+				Status:     status,
+				Containers: []*hypervisor.Container{{Id: "c-" + id}},
+			},
+		})
+	}
+
+	entries, usedImages := daemon.containerEntries(true)
+	if len(entries) != 2 || !usedImages["image-c-pod-running"] || !usedImages["image-c-pod-stopped"] {
+		t.Fatalf("unexpected containers %v, images %v", entries, usedImages)
+	}
+	if len(docker.measured) != 0 {
+		t.Fatalf("the containers should not be measured up front, measured %v", docker.measured)
+	}
+
+	for _, e := range entries {
+		if e.diskSize(false) != 0 {
+			t.Fatal("the size should be unknown until measured")
+		}
+		if e.prunable != (e.id == "c-pod-stopped") {
+			t.Fatalf("only the container of the stopped pod is prunable, %s is %v", e.id, e.prunable)
+		}
+		if e.prunable && (e.diskSize(true) != 42 || e.diskSize(true) != 42) {
+			t.Fatal("the measured size should be kept")
+		}
+	}
+	if len(docker.measured) != 1 || docker.measured[0] != "c-pod-stopped" {
+		t.Fatalf("only the prunable container should be measured once, measured %v", docker.measured)
+	}
+}
@@ -0,0 +1,401 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	MAX_TEMPLATE_SIZE = 256 * 1024
+
+	// the labels recording which template a pod comes from
+	TEMPLATE_LABEL         = "hyper.template"
+	TEMPLATE_CREATED_LABEL = "hyper.template.created"
+)
+
+var (
+	templateNameReg = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+	// ${name} or ${name:-default}
+	placeholderReg = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)(:-([^}]*))?\}`)
+)
+
+type templateParam struct {
+	Name     string `json:"name"`
+	Default  string `json:"default,omitempty"`
+	Required bool   `json:"required"`
+	// the placeholder is not in a json string, e.g. "vcpu": ${cpu}
+	Raw bool `json:"raw,omitempty"`
+}
+
+type templateRecord struct {
+	Name    string          `json:"name"`
+	Created int64           `json:"created"`
+	Params  []templateParam `json:"params"`
+	Spec    string          `json:"spec"`
+}
+
+type placeholder struct {
+	start, end int
+	param      templateParam
+}
+
+// parsePlaceholders finds the placeholders of the template, and whether
+// each one is inside a json string.
+func parsePlaceholders(spec string) []placeholder {
+	var (
+		res      []placeholder
+		inString bool
+		escaped  bool
+		last     int
+	)
+	for _, loc := range placeholderReg.FindAllStringSubmatchIndex(spec, -1) {
+		for i := last; i < loc[0]; i++ {
+			switch {
+			case escaped:
+				escaped = false
+			case spec[i] == '\\' && inString:
+				escaped = true
+			case spec[i] == '"':
+				inString = !inString
+			}
+		}
+		last = loc[1]
+
+		p := placeholder{start: loc[0], end: loc[1]}
+		p.param.Name = spec[loc[2]:loc[3]]
+		p.param.Raw = !inString
+		if loc[4] < 0 {
+			p.param.Required = true
+		} else {
+			p.param.Default = spec[loc[6]:loc[7]]
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+// templateParams merges the placeholders of the same parameter, a
+// parameter is required if any of its placeholders has no default.
+func templateParams(holders []placeholder) ([]templateParam, error) {
+	params := map[string]*templateParam{}
+	for _, h := range holders {
+		p, ok := params[h.param.Name]
+		if !ok {
+			cp := h.param
+			params[h.param.Name] = &cp
+			continue
+		}
+		if !h.param.Required && !p.Required && h.param.Default != p.Default {
+			return nil, fmt.Errorf("Parameter %s has different defaults", h.param.Name)
+		}
+		p.Required = p.Required || h.param.Required
+		p.Raw = p.Raw || h.param.Raw
+	}
+
+	res := []templateParam{}
+	for _, p := range params {
+		res = append(res, *p)
+	}
+	sort.Sort(byParamName(res))
+	return res, nil
+}
+
+type byParamName []templateParam
+
+func (s byParamName) Len() int           { return len(s) }
+func (s byParamName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byParamName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// a raw value is put into the json as is, it must be a scalar
+func validRawValue(v string) bool {
+	if v == "true" || v == "false" || v == "null" {
+		return true
+	}
+	_, err := strconv.ParseFloat(v, 64)
+	return err == nil
+}
+
+func renderTemplate(spec string, values map[string]string) (string, error) {
+	holders := parsePlaceholders(spec)
+	params, err := templateParams(holders)
+	if err != nil {
+		return "", err
+	}
+
+	known := map[string]bool{}
+	missing := []string{}
+	for _, p := range params {
+		known[p.Name] = true
+		if _, ok := values[p.Name]; !ok && p.Required {
+			missing = append(missing, p.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("Missing template parameters: %s", strings.Join(missing, ", "))
+	}
+	for k := range values {
+		if !known[k] {
+			return "", fmt.Errorf("Unknown template parameter %s", k)
+		}
+	}
+
+	var buf []byte
+	last := 0
+	for _, h := range holders {
+		v, ok := values[h.param.Name]
+		if !ok {
+			v = h.param.Default
+		}
+		if h.param.Raw {
+			if !validRawValue(v) {
+				return "", fmt.Errorf("Invalid value %q of parameter %s, a number or boolean is expected", v, h.param.Name)
+			}
+		} else {
+			// escape it for the json string, without the quotes
+			quoted, _ := json.Marshal(v)
+			v = string(quoted[1 : len(quoted)-1])
+		}
+		buf = append(buf, spec[last:h.start]...)
+		buf = append(buf, v...)
+		last = h.end
+	}
+	buf = append(buf, spec[last:]...)
+	return string(buf), nil
+}
+
+// validateTemplate checks that the template is a json document once the
+// placeholders are filled
+func validateTemplate(spec string) ([]templateParam, error) {
+	holders := parsePlaceholders(spec)
+	params, err := templateParams(holders)
+	if err != nil {
+		return nil, err
+	}
+
+	// the defaults are checked as well
+	values := map[string]string{}
+	for _, p := range params {
+		if !p.Required {
+			continue
+		}
+		if p.Raw {
+			values[p.Name] = "0"
+		} else {
+			values[p.Name] = "x"
+		}
+	}
+	rendered, err := renderTemplate(spec, values)
+	if err != nil {
+		return nil, err
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(rendered), &v); err != nil {
+		return nil, fmt.Errorf("Template is not a valid pod json: %v", err)
+	}
+	return params, nil
+}
+
+func (daemon *Daemon) CmdTemplateCreate(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not execute 'template create' command without template name and content!")
+	}
+	name, content := job.Args[0], job.Args[1]
+
+	if err := daemon.CreateTemplate(name, content); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CmdTemplateList(job *engine.Job) error {
+	templates, err := daemon.ListTemplates()
+	if err != nil {
+		return err
+	}
+
+	var list []string
+	for _, t := range templates {
+		params := []string{}
+		for _, p := range t.Params {
+			if p.Required {
+				params = append(params, p.Name)
+			} else {
+				params = append(params, p.Name+"?")
+			}
+		}
+		list = append(list, fmt.Sprintf("%s:%d:%s", t.Name, t.Created, strings.Join(params, ",")))
+	}
+
+	v := &engine.Env{}
+	v.SetList("templateList", list)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CmdTemplateRemove(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not execute 'template rm' command without template name!")
+	}
+	name := job.Args[0]
+
+	if err := daemon.RemoveTemplate(name); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", name)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CmdTemplateRender instantiates a template, the args are the template name
+// and the parameters in key=value format. The pod json is returned.
+func (daemon *Daemon) CmdTemplateRender(job *engine.Job) error {
+	if len(job.Args) == 0 {
+		return fmt.Errorf("Can not execute 'template render' command without template name!")
+	}
+	values := map[string]string{}
+	for _, arg := range job.Args[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("Bad format of parameter (expected key=value): %s", arg)
+		}
+		values[kv[0]] = kv[1]
+	}
+
+	spec, err := daemon.RenderTemplate(job.Args[0], values)
+	if err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", job.Args[0])
+	v.Set("Spec", spec)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CreateTemplate(name, spec string) error {
+	if !templateNameReg.MatchString(name) {
+		return fmt.Errorf("Invalid template name %s", name)
+	}
+	if len(spec) > MAX_TEMPLATE_SIZE {
+		return fmt.Errorf("Template %s is too large, the limit is %d bytes", name, MAX_TEMPLATE_SIZE)
+	}
+
+	key := []byte(fmt.Sprintf("template-%s", name))
+	if _, err := daemon.db.Get(key, nil); err == nil {
+		return fmt.Errorf("Template %s already exists", name)
+	}
+
+	params, err := validateTemplate(spec)
+	if err != nil {
+		return err
+	}
+	record, err := json.Marshal(&templateRecord{
+		Name:    name,
+		Created: time.Now().Unix(),
+		Params:  params,
+		Spec:    spec,
+	})
+	if err != nil {
+		return err
+	}
+	return daemon.db.Put(key, record, nil)
+}
+
+func (daemon *Daemon) ListTemplates() ([]*templateRecord, error) {
+	var templates []*templateRecord
+
+	iter := daemon.db.NewIterator(util.BytesPrefix([]byte("template-")), nil)
+	for iter.Next() {
+		var t templateRecord
+		if err := json.Unmarshal(iter.Value(), &t); err != nil {
+			glog.Warningf("invalid template record %s: %v", string(iter.Key()), err)
+			continue
+		}
+		t.Spec = ""
+		templates = append(templates, &t)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+func (daemon *Daemon) getTemplate(name string) (*templateRecord, error) {
+	record, err := daemon.db.Get([]byte(fmt.Sprintf("template-%s", name)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Can not find template %s", name)
+	}
+
+	var t templateRecord
+	if err := json.Unmarshal(record, &t); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (daemon *Daemon) RemoveTemplate(name string) error {
+	key := []byte(fmt.Sprintf("template-%s", name))
+	if _, err := daemon.db.Get(key, nil); err != nil {
+		return fmt.Errorf("Can not find template %s", name)
+	}
+	return daemon.db.Delete(key, nil)
+}
+
+// RenderTemplate fills the template with the values, and validates the
+// result as a pod spec. The template is recorded in the pod labels.
+func (daemon *Daemon) RenderTemplate(name string, values map[string]string) (string, error) {
+	t, err := daemon.getTemplate(name)
+	if err != nil {
+		return "", err
+	}
+	return instantiateTemplate(t, values)
+}
+
+func instantiateTemplate(t *templateRecord, values map[string]string) (string, error) {
+	rendered, err := renderTemplate(t.Spec, values)
+	if err != nil {
+		return "", err
+	}
+
+	spec, err := pod.ProcessPodBytes([]byte(rendered))
+	if err != nil {
+		return "", fmt.Errorf("Template %s: %v", t.Name, err)
+	}
+	if spec.Labels == nil {
+		spec.Labels = make(map[string]string)
+	}
+	spec.Labels[TEMPLATE_LABEL] = t.Name
+	spec.Labels[TEMPLATE_CREATED_LABEL] = strconv.FormatInt(t.Created, 10)
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+const testTemplate = `{
+	"id": "${name}",
+	"containers": [{
+		"image": "${image:-busybox}",
+		"command": ["echo", "${msg:-hello}"]
+	}],
+	"resource": {"vcpu": ${cpu:-1}, "memory": ${memory:-128}},
+	"tty": ${tty:-false}
+}`
+
+func TestTemplateParams(t *testing.T) {
+	params, err := validateTemplate(testTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []templateParam{
+		{Name: "cpu", Default: "1", Raw: true},
+		{Name: "image", Default: "busybox"},
+		{Name: "memory", Default: "128", Raw: true},
+		{Name: "msg", Default: "hello"},
+		{Name: "name", Required: true},
+		{Name: "tty", Default: "false", Raw: true},
+	}
+	if len(params) != len(expected) {
+		t.Fatalf("expected %d params, got %v", len(expected), params)
+	}
+	for i := range expected {
+		if params[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], params[i])
+		}
+	}
+
+	if _, err := validateTemplate(`{"id": "${name}"`); err == nil {
+		t.Fatal("invalid json should be rejected")
+	}
+	if _, err := validateTemplate(`{"id": "${name:-a}", "hostname": "${name:-b}"}`); err == nil {
+		t.Fatal("different defaults should be rejected")
+	}
+	if _, err := validateTemplate(`{"resource": {"vcpu": ${cpu:-two}}}`); err == nil {
+		t.Fatal("invalid raw default should be rejected")
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	out, err := renderTemplate(testTemplate, map[string]string{
+		"name": "web",
+		"msg":  `quote" and \ backslash`,
+		"cpu":  "2",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec pod.UserPod
+	if err := json.Unmarshal([]byte(out), &spec); err != nil {
+		t.Fatalf("rendered template is not valid json: %v\n%s", err, out)
+	}
+	if spec.Name != "web" || spec.Resource.Vcpu != 2 || spec.Resource.Memory != 128 {
+		t.Fatalf("unexpected rendered pod: %s", out)
+	}
+	if spec.Containers[0].Image != "busybox" || spec.Containers[0].Command[1] != `quote" and \ backslash` {
+		t.Fatalf("string values should be escaped: %s", out)
+	}
+
+	if _, err := renderTemplate(testTemplate, map[string]string{}); err == nil {
+		t.Fatal("missing required parameter should be rejected")
+	}
+	if _, err := renderTemplate(testTemplate, map[string]string{"name": "web", "foo": "bar"}); err == nil {
+		t.Fatal("unknown parameter should be rejected")
+	}
+	// a raw value must not be able to inject json
+	if _, err := renderTemplate(testTemplate, map[string]string{"name": "web", "cpu": `1, "tty": true`}); err == nil {
+		t.Fatal("non scalar raw value should be rejected")
+	}
+}
+
+func TestInstantiateTemplate(t *testing.T) {
+	record := &templateRecord{
+		Name:    "web",
+		Created: 1234,
+		Spec:    testTemplate,
+	}
+	out, err := instantiateTemplate(record, map[string]string{"name": "web-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec pod.UserPod
+	if err := json.Unmarshal([]byte(out), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.Labels[TEMPLATE_LABEL] != "web" || spec.Labels[TEMPLATE_CREATED_LABEL] != "1234" {
+		t.Fatalf("template labels are missing: %v", spec.Labels)
+	}
+}
@@ -21,7 +21,11 @@ func (daemon *Daemon) CmdTty(job *engine.Job) (err error) {
 		vmid      string
 	)
 
-	if strings.Contains(podID, "pod-") {
+	// resize the tty of an exec session by its ID
+	if info, ok := daemon.execs.Get(podID); ok {
+		vmid = info.Vm
+		tag = info.ID
+	} else if strings.Contains(podID, "pod-") {
 		container = ""
 		vmid, err = daemon.GetVmByPodId(podID)
 		if err != nil {
@@ -41,7 +45,7 @@ func (daemon *Daemon) CmdTty(job *engine.Job) (err error) {
 		}
 	}
 
-	vm, ok := daemon.VmList[vmid]
+	vm, ok := daemon.GetVm(vmid)
 	if !ok {
 		return fmt.Errorf("vm %s doesn't exist!")
 	}
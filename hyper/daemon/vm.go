@@ -72,7 +72,7 @@ func (daemon *Daemon) CmdVmCreate(job *engine.Job) (err error) {
 
 func (daemon *Daemon) CmdVmKill(job *engine.Job) error {
 	vmId := job.Args[0]
-	if _, ok := daemon.VmList[vmId]; !ok {
+	if _, ok := daemon.GetVm(vmId); !ok {
 		return fmt.Errorf("Can not find the VM(%s)", vmId)
 	}
 	code, cause, err := daemon.KillVm(vmId)
@@ -93,13 +93,14 @@ func (daemon *Daemon) CmdVmKill(job *engine.Job) error {
 }
 
 func (daemon *Daemon) KillVm(vmId string) (int, string, error) {
-	vm, ok := daemon.VmList[vmId]
+	vm, ok := daemon.GetVm(vmId)
 	if !ok {
 		return 0, "", nil
 	}
 	ret1, ret2, err := vm.Kill()
 	if err == nil {
 		daemon.RemoveVm(vmId)
+		daemon.LogEvent(EVENT_TYPE_VM, "shutdown", vmId, nil)
 	}
 	return ret1, ret2, err
 }
@@ -117,17 +118,16 @@ func (p *Pod) AssociateVm(daemon *Daemon, vmId string) error {
 	}
 	glog.V(1).Infof("The data for vm(%s) is %v", vmId, vmData)
 
-	p.vm = daemon.NewVm(vmId, p.spec.Resource.Vcpu, p.spec.Resource.Memory, false, types.VM_KEEP_NONE)
-	p.status.Vm = vmId
+	p.SetVM(vmId, daemon.NewVm(vmId, p.spec.Resource.Vcpu, p.spec.Resource.Memory, false, types.VM_KEEP_NONE))
 
 	err = p.vm.AssociateVm(p.status, vmData)
 	if err != nil {
-		p.vm = nil
-		p.status.Vm = ""
+		p.SetVM("", nil)
 		return err
 	}
 
 	daemon.AddVm(p.vm)
+	daemon.startProbes(p)
 	return nil
 }
 
@@ -137,7 +137,7 @@ func (daemon *Daemon) ReleaseAllVms() (int, error) {
 		err error = nil
 	)
 
-	for _, vm := range daemon.VmList {
+	for _, vm := range daemon.ListVms() {
 		ret, err = vm.ReleaseVm()
 		if err != nil {
 			/* FIXME: continue to release other vms? */
@@ -180,6 +180,10 @@ func (daemon *Daemon) StartVm(vmId string, cpu, mem int, lazy bool, keep int) (*
 	}
 
 	daemon.AddVm(vm)
+	daemon.LogEvent(EVENT_TYPE_VM, "boot", vm.Id, map[string]string{
+		"cpu":    strconv.Itoa(cpu),
+		"memory": strconv.Itoa(mem),
+	})
 	return vm, nil
 }
 
@@ -203,7 +207,7 @@ func (daemon *Daemon) GetVM(vmId string, resource *pod.UserResource, lazy bool,
 		return daemon.StartVm("", resource.Vcpu, resource.Memory, lazy, keep)
 	}
 
-	vm, ok := daemon.VmList[vmId]
+	vm, ok := daemon.GetVm(vmId)
 	if !ok {
 		return nil, fmt.Errorf("The VM %s doesn't exist", vmId)
 	}
@@ -227,7 +231,7 @@ func (daemon *Daemon) NewVm(id string, cpu, memory int, lazy bool, keep int) *hy
 	if vmId == "" {
 		for {
 			vmId = fmt.Sprintf("vm-%s", pod.RandStr(10, "alpha"))
-			if _, ok := daemon.VmList[vmId]; !ok {
+			if _, ok := daemon.GetVm(vmId); !ok {
 				break
 			}
 		}
@@ -0,0 +1,289 @@
+package daemon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/storage"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/hyperhq/runv/hypervisor/types"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// volumeHotplug hot adds and removes the volumes of a running pod, it is
+// the VM of the pod
+type volumeHotplug interface {
+	AttachVolume(info *hypervisor.VolumeInfo, mounts []hypervisor.VolumeMount) error
+	DetachVolume(name string) error
+}
+
+type volumeMount struct {
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	ReadOnly  bool   `json:"readOnly"`
+}
+
+func (daemon *Daemon) CmdPodVolumeAttach(job *engine.Job) error {
+	if len(job.Args) < 3 {
+		return fmt.Errorf("Can not execute 'volume attach' command without pod id, volume and mounts!")
+	}
+	var (
+		podId  = job.Args[0]
+		volume pod.UserVolume
+		mounts []volumeMount
+	)
+	if err := json.Unmarshal([]byte(job.Args[1]), &volume); err != nil {
+		return fmt.Errorf("Invalid volume: %v", err)
+	}
+	if err := json.Unmarshal([]byte(job.Args[2]), &mounts); err != nil {
+		return fmt.Errorf("Invalid volume mounts: %v", err)
+	}
+
+	p, err := daemon.lockPod(podId)
+	if err != nil {
+		return err
+	}
+	defer daemon.unlockPod(p)
+
+	if err := daemon.AttachVolume(podId, &volume, mounts); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.Set("Volume", volume.Name)
+	v.SetInt("Code", types.E_OK)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) CmdPodVolumeDetach(job *engine.Job) error {
+	if len(job.Args) < 2 {
+		return fmt.Errorf("Can not execute 'volume detach' command without pod id and volume name!")
+	}
+	podId, name := job.Args[0], job.Args[1]
+
+	p, err := daemon.lockPod(podId)
+	if err != nil {
+		return err
+	}
+	defer daemon.unlockPod(p)
+
+	if err := daemon.DetachVolume(podId, name); err != nil {
+		return err
+	}
+
+	v := &engine.Env{}
+	v.Set("ID", podId)
+	v.Set("Volume", name)
+	v.SetInt("Code", types.E_OK)
+	if _, err := v.WriteTo(job.Stdout); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (daemon *Daemon) runningPod(podId string) (*Pod, error) {
+	p, ok := daemon.PodList.Get(podId)
+	if !ok {
+		return nil, fmt.Errorf("Can not find that Pod(%s)", podId)
+	}
+	if status, vm := p.snapshot(); status.Status != types.S_POD_RUNNING || vm == nil {
+		return nil, fmt.Errorf("Pod(%s) is not running", podId)
+	}
+	return p, nil
+}
+
+// AttachVolume hot adds a volume to a running pod, and mounts it into the
+// given containers. A volume without source is created by the storage
+// driver, and removed together with the pod.
+func (daemon *Daemon) AttachVolume(podId string, volume *pod.UserVolume, mounts []volumeMount) error {
+	p, err := daemon.runningPod(podId)
+	if err != nil {
+		return err
+	}
+	sharedDir := path.Join(hypervisor.BaseDir, p.vm.Id, hypervisor.ShareDirTag)
+	return daemon.attachVolume(p, p.vm, sharedDir, volume, mounts)
+}
+
+func (daemon *Daemon) attachVolume(p *Pod, vm volumeHotplug, sharedDir string, volume *pod.UserVolume, mounts []volumeMount) error {
+	podId := p.id
+	if volume.Name == "" {
+		return fmt.Errorf("Volume name is required")
+	}
+	if len(mounts) == 0 {
+		return fmt.Errorf("Volume %s is not mounted to any container", volume.Name)
+	}
+
+	for _, v := range p.spec.Volumes {
+		if v.Name == volume.Name {
+			return fmt.Errorf("Volume %s already exists in pod %s", volume.Name, podId)
+		}
+	}
+
+	var (
+		vmMounts []hypervisor.VolumeMount
+		refs     = map[int]pod.UserVolumeReference{}
+	)
+	for _, m := range mounts {
+		idx := -1
+		for i, c := range p.status.Containers {
+			if c.Id == m.Container || strings.TrimPrefix(c.Name, "/") == m.Container {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			return fmt.Errorf("Can not find container %s in pod %s", m.Container, podId)
+		}
+		if !path.IsAbs(m.Path) {
+			return fmt.Errorf("Mount point %s should be an absolute path", m.Path)
+		}
+		vmMounts = append(vmMounts, hypervisor.VolumeMount{
+			Container: p.status.Containers[idx].Id,
+			Path:      m.Path,
+			ReadOnly:  m.ReadOnly,
+		})
+		refs[idx] = pod.UserVolumeReference{
+			Path:     m.Path,
+			Volume:   volume.Name,
+			ReadOnly: m.ReadOnly,
+		}
+	}
+
+	// the spec keeps the volume as given, prepareVolume fills in the source
+	// of the volume it creates
+	spec := *volume
+	vol, err := prepareVolume(daemon, daemon.Storage, podId, volume, sharedDir)
+	if err != nil {
+		daemon.releaseVolume(podId, &spec, nil, sharedDir)
+		return err
+	}
+
+	if err := vm.AttachVolume(vol, vmMounts); err != nil {
+		glog.Error(err.Error())
+		daemon.releaseVolume(podId, &spec, vol, sharedDir)
+		return err
+	}
+
+	p.volumes = append(p.volumes, vol)
+	p.spec.Volumes = append(p.spec.Volumes, spec)
+	for idx, ref := range refs {
+		c := &p.spec.Containers[idx]
+		c.Volumes = append(c.Volumes, ref)
+	}
+	return daemon.writePodSpec(p)
+}
+
+// DetachVolume hot removes a volume from a running pod, the volume created
+// by the storage driver is removed as well.
+func (daemon *Daemon) DetachVolume(podId, name string) error {
+	p, err := daemon.runningPod(podId)
+	if err != nil {
+		return err
+	}
+	sharedDir := path.Join(hypervisor.BaseDir, p.vm.Id, hypervisor.ShareDirTag)
+	return daemon.detachVolume(p, p.vm, sharedDir, name)
+}
+
+func (daemon *Daemon) detachVolume(p *Pod, vm volumeHotplug, sharedDir, name string) error {
+	podId := p.id
+	idx := -1
+	for i, v := range p.spec.Volumes {
+		if v.Name == name {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("Can not find volume %s in pod %s", name, podId)
+	}
+
+	if err := vm.DetachVolume(name); err != nil {
+		glog.Error(err.Error())
+		return err
+	}
+
+	var vol *hypervisor.VolumeInfo
+	for i, v := range p.volumes {
+		if v.Name == name {
+			vol = v
+			p.volumes = append(p.volumes[:i], p.volumes[i+1:]...)
+			break
+		}
+	}
+	daemon.releaseVolume(podId, &p.spec.Volumes[idx], vol, sharedDir)
+
+	p.spec.Volumes = append(p.spec.Volumes[:idx], p.spec.Volumes[idx+1:]...)
+	for i := range p.spec.Containers {
+		c := &p.spec.Containers[i]
+		refs := c.Volumes[:0]
+		for _, ref := range c.Volumes {
+			if ref.Volume != name {
+				refs = append(refs, ref)
+			}
+		}
+		c.Volumes = refs
+	}
+	return daemon.writePodSpec(p)
+}
+
+// releaseVolume undoes prepareVolume: the volume is unbound from the share
+// dir of the VM, and the volume created by the storage driver, for the spec
+// without source, is removed.
+func (daemon *Daemon) releaseVolume(podId string, spec *pod.UserVolume, vol *hypervisor.VolumeInfo, sharedDir string) {
+	if vol != nil && vol.Fstype == "dir" {
+		target := path.Join(sharedDir, vol.Filepath)
+		if err := utils.Unmount(target); err != nil {
+			glog.Warningf("failed to umount volume %s of pod %s: %v", vol.Name, podId, err)
+		} else {
+			os.Remove(target)
+		}
+	}
+	if spec.Source != "" {
+		return
+	}
+	if err := daemon.removeStorageVolume(podId, spec.Name); err != nil {
+		glog.Warningf("failed to remove volume %s of pod %s: %v", spec.Name, podId, err)
+	}
+}
+
+// removeStorageVolume removes a volume created by the storage driver, the
+// volume of a pod in DeleteVolumeId
+func (daemon *Daemon) removeStorageVolume(podId, name string) error {
+	dms, ok := daemon.Storage.(*DevMapperStorage)
+	if !ok {
+		return os.RemoveAll(path.Join(storage.VFS_VOLUME_ROOT, podId, name))
+	}
+
+	volName := fmt.Sprintf("%s-%s-%s", dms.VolPoolName, podId, name)
+	iter := daemon.db.NewIterator(util.BytesPrefix([]byte(fmt.Sprintf("vol-%s-", podId))), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if strings.Split(string(iter.Value()), ":")[0] != volName {
+			continue
+		}
+		if err := dms.RemoveVolume(podId, iter.Value()); err != nil {
+			return err
+		}
+		return daemon.db.Delete(iter.Key(), nil)
+	}
+	return iter.Error()
+}
+
+// writePodSpec saves the spec of the pod changed at runtime, so that it is
+// still there when the pod is started again
+func (daemon *Daemon) writePodSpec(p *Pod) error {
+	data, err := json.Marshal(p.spec)
+	if err != nil {
+		return err
+	}
+	return daemon.WritePodToDB(p.id, data)
+}
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+type fakeHotplug struct {
+	err      error
+	attached map[string][]hypervisor.VolumeMount
+}
+
+func (f *fakeHotplug) AttachVolume(info *hypervisor.VolumeInfo, mounts []hypervisor.VolumeMount) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.attached[info.Name] = mounts
+	return nil
+}
+
+func (f *fakeHotplug) DetachVolume(name string) error {
+	if f.err != nil {
+		return f.err
+	}
+	if _, ok := f.attached[name]; !ok {
+		return errors.New("volume not attached")
+	}
+	delete(f.attached, name)
+	return nil
+}
+
+func savedSpec(t *testing.T, daemon *Daemon, podId string) *pod.UserPod {
+	data, err := daemon.db.Get([]byte("pod-"+podId), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &pod.UserPod{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestAttachDetachVolume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-volume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(path.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	daemon := &Daemon{db: db}
+
+	source := path.Join(dir, "disk.img")
+	if err := ioutil.WriteFile(source, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Pod{
+		id: "pod-test",
+		status: &hypervisor.PodStatus{
+			Containers: []*hypervisor.Container{
+				{Id: "c1", Name: "/web"},
+				{Id: "c2", Name: "/db"},
+			},
+		},
+		spec: &pod.UserPod{
+			Name:       "test",
+			Containers: []pod.UserContainer{{Name: "web"}, {Name: "db"}},
+		},
+	}
+	vm := &fakeHotplug{attached: map[string][]hypervisor.VolumeMount{}}
+	volume := pod.UserVolume{Name: "data", Source: source, Driver: "raw"}
+	mounts := []volumeMount{{Container: "web", Path: "/data", ReadOnly: true}}
+
+	// a failed hotplug leaves the pod as it was
+	vm.err = errors.New("hotplug failed")
+	v := volume
+	if err := daemon.attachVolume(p, vm, dir, &v, mounts); err == nil {
+		t.Fatal("the failed hotplug should fail the attach")
+	}
+	if len(p.volumes) != 0 || len(p.spec.Volumes) != 0 || len(p.spec.Containers[0].Volumes) != 0 {
+		t.Fatalf("the failed attach should be rolled back: %v %v", p.volumes, p.spec)
+	}
+	if _, err := db.Get([]byte("pod-"+p.id), nil); err == nil {
+		t.Fatal("the failed attach should not save the spec")
+	}
+
+	vm.err = nil
+	bad := []volumeMount{{Container: "cache", Path: "/data"}}
+	v = volume
+	if err := daemon.attachVolume(p, vm, dir, &v, bad); err == nil {
+		t.Fatal("attaching to an unknown container should fail")
+	}
+
+	v = volume
+	if err := daemon.attachVolume(p, vm, dir, &v, mounts); err != nil {
+		t.Fatal(err)
+	}
+	if m := vm.attached["data"]; len(m) != 1 || m[0].Container != "c1" || m[0].Path != "/data" || !m[0].ReadOnly {
+		t.Fatalf("unexpected volume mounts %v", m)
+	}
+	spec := savedSpec(t, daemon, p.id)
+	if len(spec.Volumes) != 1 || spec.Volumes[0].Name != "data" || spec.Volumes[0].Source != source {
+		t.Fatalf("the volume should be saved in the spec: %v", spec.Volumes)
+	}
+	if refs := spec.Containers[0].Volumes; len(refs) != 1 || refs[0].Volume != "data" || refs[0].Path != "/data" {
+		t.Fatalf("the mount should be saved in the spec: %v", refs)
+	}
+	if len(spec.Containers[1].Volumes) != 0 {
+		t.Fatal("the volume should not be mounted to the other container")
+	}
+
+	v = volume
+	if err := daemon.attachVolume(p, vm, dir, &v, mounts); err == nil {
+		t.Fatal("attaching a volume twice should fail")
+	}
+
+	// a failed detach keeps the volume
+	vm.err = errors.New("hotplug failed")
+	if err := daemon.detachVolume(p, vm, dir, "data"); err == nil {
+		t.Fatal("the failed hotplug should fail the detach")
+	}
+	if len(p.volumes) != 1 || len(p.spec.Volumes) != 1 {
+		t.Fatal("the failed detach should keep the volume")
+	}
+
+	vm.err = nil
+	if err := daemon.detachVolume(p, vm, dir, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if len(vm.attached) != 0 || len(p.volumes) != 0 {
+		t.Fatal("the volume should be detached")
+	}
+	spec = savedSpec(t, daemon, p.id)
+	if len(spec.Volumes) != 0 || len(spec.Containers[0].Volumes) != 0 {
+		t.Fatalf("the volume should be removed from the spec: %v", spec)
+	}
+	if err := daemon.detachVolume(p, vm, dir, "data"); err == nil {
+		t.Fatal("detaching an unknown volume should fail")
+	}
+}
@@ -22,9 +22,10 @@ import (
 	"github.com/hyperhq/hyper/lib/docker/builder"
 	"github.com/hyperhq/hyper/lib/docker/builder/dockerfile"
 	"github.com/hyperhq/hyper/lib/docker/daemon/daemonbuilder"
+	"github.com/hyperhq/hyper/types"
 )
 
-func (cli Docker) SendImageBuild(name string, size int, ctx io.ReadCloser) ([]byte, int, error) {
+func (cli Docker) SendImageBuild(name string, size int, ctx io.ReadCloser, config *types.ImageBuildConfig) ([]string, int, error) {
 	var (
 		authConfigs   = map[string]cliconfig.AuthConfig{}
 		buildConfig   = &dockerfile.Config{}
@@ -61,6 +62,11 @@ func (cli Docker) SendImageBuild(name string, size int, ctx io.ReadCloser) ([]by
 	buildConfig.CPUSetCpus = ""     // r.FormValue("cpusetcpus")
 	buildConfig.CPUSetMems = ""     // r.FormValue("cpusetmems")
 	buildConfig.CgroupParent = ""   // r.FormValue("cgroupparent")
+	buildConfig.VmCpu = config.VmCpu
+	buildConfig.VmMemory = config.VmMemory
+	buildConfig.Secrets = config.Secrets
+	buildConfig.SSHAgents = config.SSHAgents
+	buildConfig.SSHAgentUid = config.SSHAgentUid
 
 	if i := runconfig.IsolationLevel(isolation); i != "" {
 		if !runconfig.IsolationLevel.IsValid(i) {
@@ -146,5 +152,5 @@ func (cli Docker) SendImageBuild(name string, size int, ctx io.ReadCloser) ([]by
 	if err := cli.daemon.TagImage(repo, tag, string(imgID), true); err != nil {
 		return nil, -1, errf(err)
 	}
-	return nil, 0, nil
+	return b.CacheImages(), 0, nil
 }
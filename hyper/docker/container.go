@@ -1,14 +1,18 @@
 package docker
 
 import (
+	"io"
+
 	"github.com/docker/docker/api/types"
 	"github.com/golang/glog"
 )
 
 func (cli *Docker) GetContainerInfo(args ...string) (*types.ContainerJSON, error) {
 	containerId := args[0]
+	// the size of the container is computed if asked by the "size" argument
+	size := len(args) > 1 && args[1] == "size"
 	glog.V(1).Infof("ready to get the container(%s) info", containerId)
-	containerJSON, err := cli.daemon.ContainerInspect(containerId, false)
+	containerJSON, err := cli.daemon.ContainerInspect(containerId, size)
 	if err != nil {
 		return nil, err
 	}
@@ -18,3 +22,11 @@ func (cli *Docker) GetContainerInfo(args ...string) (*types.ContainerJSON, error
 func (cli Docker) SendContainerRename(oldName, newName string) error {
 	return cli.daemon.ContainerRename(oldName, newName)
 }
+
+func (cli Docker) SendContainerCopy(id, resource string) (io.ReadCloser, error) {
+	container, err := cli.daemon.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return container.Copy(resource)
+}
@@ -1,6 +1,10 @@
 package docker
 
-import "github.com/docker/docker/api/types"
+import (
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
 
 func (cli Docker) SendCmdImages(all string) ([]*types.Image, error) {
 	var (
@@ -16,3 +20,13 @@ func (cli Docker) SendCmdImages(all string) ([]*types.Image, error) {
 
 	return images, nil
 }
+
+// SendImageSave writes the images, with all their parents, as a tar archive
+func (cli Docker) SendImageSave(names []string, out io.Writer) error {
+	return cli.daemon.Repositories().ImageExport(names, out)
+}
+
+// SendImageLoad loads the images of a tar archive written by SendImageSave
+func (cli Docker) SendImageLoad(in io.ReadCloser, out io.Writer) error {
+	return cli.daemon.Repositories().Load(in, out)
+}
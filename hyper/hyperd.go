@@ -211,6 +211,13 @@ func mainDaemon(opts *Options) {
 	}
 
 	job := eng.Job("serveapi", defaultHost...)
+	for _, key := range []string{"TlsCert", "TlsKey", "TlsCaCert", "AuthzPolicy", "AuditLog", "AuditLogMaxSize", "AuditLogMaxFiles"} {
+		value, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, key)
+		job.Setenv(key, value)
+	}
+	if plugins, _ := cfg.GetValue(goconfig.DEFAULT_SECTION, "AuthzPlugins"); plugins != "" {
+		job.SetenvList("AuthzPlugins", strings.Split(plugins, ","))
+	}
 
 	// The serve API job never exits unless an error occurs
 	// We need to start it as a goroutine and wait on it so
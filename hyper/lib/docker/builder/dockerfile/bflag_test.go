@@ -184,4 +184,28 @@ func TestBuilderFlags(t *testing.T) {
 	if !flBool1.IsTrue() {
 		t.Fatalf("Teset %s, bool1 should be true", bf.Args)
 	}
+
+	// ---
+
+	bf = NewBFlags()
+	flStrs := bf.AddStrings("mount")
+	bf.Args = []string{"--mount=type=secret,id=a", "--mount=type=ssh"}
+
+	if err = bf.Parse(); err != nil {
+		t.Fatalf("Test %q was supposed to work: %s", bf.Args, err)
+	}
+
+	if len(flStrs.Values) != 2 || flStrs.Values[0] != "type=secret,id=a" || flStrs.Values[1] != "type=ssh" {
+		t.Fatalf("Test %s, mount should be both values, got %q", bf.Args, flStrs.Values)
+	}
+
+	// ---
+
+	bf = NewBFlags()
+	flStrs = bf.AddStrings("mount")
+	bf.Args = []string{"--mount"}
+
+	if err = bf.Parse(); err == nil {
+		t.Fatalf("Test %q was supposed to fail", bf.Args)
+	}
 }
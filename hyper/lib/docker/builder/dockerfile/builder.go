@@ -19,6 +19,7 @@ import (
 	"github.com/hyperhq/hyper/lib/docker/builder"
 	"github.com/hyperhq/hyper/lib/docker/daemon"
 	rand "github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
 )
 
 var validCommitCommands = map[string]bool{
@@ -71,6 +72,16 @@ type Config struct {
 	CPUSetMems   string
 	CgroupParent string
 	Ulimits      []*ulimit.Ulimit
+
+	// the resources of the builder VM, in which all the steps are run
+	VmCpu    int
+	VmMemory int // in MiB
+
+	// the secrets and the ssh agent sockets for `RUN --mount`, by id, the
+	// agents must be run by SSHAgentUid
+	Secrets     map[string][]byte
+	SSHAgents   map[string]string
+	SSHAgentUid uint32
 }
 
 // Builder is a Dockerfile builder
@@ -92,13 +103,19 @@ type Builder struct {
 	runConfig        *runconfig.Config // runconfig for cmd, run, entrypoint etc.
 	flags            *BFlags
 	tmpContainers    map[string]struct{}
-	tmpPods          map[string]struct{}
-	image            string // imageID
+	vm               *hypervisor.Vm // the builder VM, named by Name
+	buildPod         string         // the pod in the builder VM
+	secretDir        string         // the tmpfs of the secrets, in the share dir of the VM
+	image            string         // imageID
 	noBaseImage      bool
 	maintainer       string
 	cmdSet           bool
 	disableCommit    bool
 	cacheBusted      bool
+	inStage          bool           // a FROM has started the current stage
+	stageName        string         // the name of the current stage
+	stageImages      []string       // the images of the finished stages
+	stageNames       map[string]int // the index of the named stages
 	cancelled        chan struct{}
 	cancelOnce       sync.Once
 	allowedBuildArgs map[string]bool // list of build-time args that are allowed for expansion/substitution and passing to commands in 'run'.
@@ -124,10 +141,6 @@ func NewBuilder(d *daemon.Daemon, config *Config, docker builder.Docker, context
 		return nil, err
 	}
 	vmId := "buildervm-" + rand.RandStr(10, "number")
-	defer func() {
-		glog.V(1).Infof("Kill VM(%s)...", vmId)
-		hyper.KillVm(vmId)
-	}()
 
 	b = &Builder{
 		Config:           config,
@@ -140,7 +153,7 @@ func NewBuilder(d *daemon.Daemon, config *Config, docker builder.Docker, context
 		context:          context,
 		runConfig:        new(runconfig.Config),
 		tmpContainers:    map[string]struct{}{},
-		tmpPods:          map[string]struct{}{},
+		stageNames:       map[string]int{},
 		cancelled:        make(chan struct{}),
 		id:               stringid.GenerateNonCryptoID(),
 		allowedBuildArgs: make(map[string]bool),
@@ -162,9 +175,11 @@ func NewBuilder(d *daemon.Daemon, config *Config, docker builder.Docker, context
 //
 // * read the dockerfile from context
 // * parse the dockerfile if not already parsed
-// * walk the AST and execute it by dispatching to handlers. If Remove
-//   or ForceRemove is set, additional cleanup around containers happens after
-//   processing.
+// * walk the AST and execute it by dispatching to handlers. All the steps
+//   run in the same builder VM, which is killed after processing. If Remove
+//   or ForceRemove is set, additional cleanup around containers happens then.
+//   Each FROM starts a new stage, and the image of the last stage is the
+//   result of the build.
 // * Print a happy message and return the image ID.
 // * NOT tag the image, that is responsibility of the caller.
 //
@@ -173,6 +188,9 @@ func (b *Builder) Build() (string, error) {
 	defer func() {
 		b.docker.Release(b.id, b.activeImages)
 	}()
+	// the builder VM is kept for all the steps, their containers are
+	// removed from it when they exit
+	defer b.releaseBuildVm()
 
 	// If Dockerfile was not parsed yet, extract it from the Context
 	if b.dockerfile == nil {
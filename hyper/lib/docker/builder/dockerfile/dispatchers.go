@@ -168,25 +168,41 @@ func add(b *Builder, args []string, attributes map[string]bool, original string)
 	return b.runContextCommand(args, true, true, "ADD")
 }
 
-// COPY foo /path
+// COPY [--from=stage] foo /path
 //
-// Same as 'ADD' but without the tar and remote url handling.
+// Same as 'ADD' but without the tar and remote url handling. With --from,
+// the sources are taken from a previous stage, by name or index, or from an
+// image, instead of the build context.
 //
 func dispatchCopy(b *Builder, args []string, attributes map[string]bool, original string) error {
 	if len(args) < 2 {
 		return derr.ErrorCodeAtLeastTwoArgs.WithArgs("COPY")
 	}
 
+	flFrom := b.flags.AddString("from", "")
 	if err := b.flags.Parse(); err != nil {
 		return err
 	}
 
+	if flFrom.Value != "" {
+		context, err := b.stageContext(flFrom.Value, args[:len(args)-1])
+		if err != nil {
+			return err
+		}
+		defer context.Close()
+
+		buildContext := b.context
+		b.context = context
+		defer func() { b.context = buildContext }()
+	}
+
 	return b.runContextCommand(args, false, false, "COPY")
 }
 
-// FROM imagename
+// FROM imagename [AS name]
 //
-// This sets the image the dockerfile will build on top of.
+// This sets the image the dockerfile will build on top of, and starts a new
+// stage of the build. The base can also be the name of a previous stage.
 //
 func from(b *Builder, args []string, attributes map[string]bool, original string) error {
 	if len(args) != 1 {
@@ -197,7 +213,25 @@ func from(b *Builder, args []string, attributes map[string]bool, original string
 		return err
 	}
 
-	name := args[0]
+	name, stageName, err := parseFrom(args[0])
+	if err != nil {
+		return err
+	}
+	if err := b.startStage(stageName); err != nil {
+		return err
+	}
+
+	if i, ok := b.stageNames[strings.ToLower(name)]; ok {
+		if b.stageImages[i] == "" {
+			b.noBaseImage = true
+			return nil
+		}
+		img, err := b.docker.LookupImage(b.stageImages[i])
+		if err != nil {
+			return err
+		}
+		return b.processImageFrom(img)
+	}
 
 	// Windows cannot support a container with no base image.
 	if name == NoBaseImageSpecifier {
@@ -209,10 +243,7 @@ func from(b *Builder, args []string, attributes map[string]bool, original string
 		return nil
 	}
 
-	var (
-		image *image.Image
-		err   error
-	)
+	var image *image.Image
 	// TODO: don't use `name`, instead resolve it to a digest
 	if !b.Pull {
 		image, err = b.docker.LookupImage(name)
@@ -301,10 +332,20 @@ func run(b *Builder, args []string, attributes map[string]bool, original string)
 		return derr.ErrorCodeMissingFrom
 	}
 
+	flMount := b.flags.AddStrings("mount")
 	if err := b.flags.Parse(); err != nil {
 		return err
 	}
 
+	var mounts []*runMount
+	for _, v := range flMount.Values {
+		m, err := parseRunMount(v)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, m)
+	}
+
 	args = handleJSONArgs(args, attributes)
 
 	if !attributes["json"] {
@@ -404,7 +445,7 @@ func run(b *Builder, args []string, attributes map[string]bool, original string)
 	b.docker.Mount(c)
 	defer b.docker.Unmount(c)
 
-	err = b.run(c)
+	err = b.runContainer(c, mounts)
 	if err != nil {
 		return err
 	}
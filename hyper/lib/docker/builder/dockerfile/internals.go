@@ -27,17 +27,13 @@ import (
 	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/docker/pkg/progressreader"
 	"github.com/docker/docker/pkg/streamformatter"
+	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/docker/pkg/stringutils"
 	"github.com/docker/docker/pkg/system"
 	"github.com/docker/docker/pkg/tarsum"
 	"github.com/docker/docker/pkg/urlutil"
-	"github.com/golang/glog"
-	hyperdaemon "github.com/hyperhq/hyper/daemon"
 	"github.com/hyperhq/hyper/lib/docker/builder"
 	"github.com/hyperhq/hyper/lib/docker/daemon"
-	"github.com/hyperhq/hyper/utils"
-	"github.com/hyperhq/runv/hypervisor"
-	"github.com/hyperhq/runv/hypervisor/types"
 )
 
 func (b *Builder) commit(id string, autoCmd *stringutils.StrSlice, comment string) error {
@@ -193,64 +189,11 @@ func (b *Builder) runContextCommand(args []string, allowRemote bool, allowLocalD
 		return nil
 	}
 
-	// Create the Pod
-	podId := fmt.Sprintf("buildpod-%s", utils.RandStr(10, "alpha"))
-	tempSrcDir := fmt.Sprintf("/var/run/hyper/temp/%s/", podId)
-	if err := os.MkdirAll(tempSrcDir, 0755); err != nil {
-		glog.Errorf(err.Error())
-		return err
-	}
-	if _, err := os.Stat(tempSrcDir); err != nil {
-		glog.Errorf(err.Error())
-		return err
-	}
-	shellDir := fmt.Sprintf("/var/run/hyper/shell/%s/", podId)
-	if err := os.MkdirAll(shellDir, 0755); err != nil {
-		glog.Errorf(err.Error())
-		return err
-	}
-	copyshell, err1 := os.Create(shellDir + "/exec-copy.sh")
-	if err1 != nil {
-		glog.Errorf(err1.Error())
-		return err1
-	}
-	fmt.Fprintf(copyshell, "#!/bin/sh\n")
-
-	podString, err := MakeCopyPod(podId, b.image, b.runConfig.WorkingDir, tempSrcDir, dest, shellDir)
-	if err != nil {
-		return err
-	}
-	err = b.Hyperdaemon.CreatePod(podId, podString, false)
+	container, _, err := b.docker.Create(b.runConfig, nil)
 	if err != nil {
 		return err
 	}
-	// Get the container
-	var (
-		containerId string = ""
-		container   *daemon.Container
-	)
-
-	ps, ok := b.Hyperdaemon.PodList.GetStatus(podId)
-	if !ok {
-		return fmt.Errorf("Cannot find pod %s", podId)
-	}
-	for _, i := range ps.Containers {
-		containerId = i.Id
-	}
-	container, err = b.Daemon.Get(containerId)
-	if err != nil {
-		glog.Error(err.Error())
-		return err
-	}
-
-	/*
-		container, _, err := b.docker.Create(b.runConfig, nil)
-		if err != nil {
-			return err
-		}
-		defer b.docker.Unmount(container)
-	*/
-	b.tmpPods[podId] = struct{}{}
+	defer b.docker.Unmount(container)
 	b.tmpContainers[container.ID] = struct{}{}
 
 	comment := fmt.Sprintf("%s %s in %s", cmdName, origPaths, dest)
@@ -267,91 +210,20 @@ func (b *Builder) runContextCommand(args []string, allowRemote bool, allowLocalD
 		}
 	}
 
-	for _, info := range infos {
-		if err := b.docker.Copy(container, tempSrcDir, info.FileInfo, info.decompress); err != nil {
-			return err
-		}
-		if strings.HasSuffix(dest, string(os.PathSeparator)) == true {
-			fmt.Fprintf(copyshell, fmt.Sprintf("cp /tmp/src/%s %s\n", info.FileInfo.Name(), filepath.Join(dest, info.FileInfo.Name())))
-		} else {
-			fmt.Fprintf(copyshell, fmt.Sprintf("cp /tmp/src/%s %s\n", info.FileInfo.Name(), dest))
-		}
+	// the files are copied to the new layer of the container on the host,
+	// nothing is run in the builder VM
+	destPath, err := container.GetResourcePath(dest)
+	if err != nil {
+		return err
 	}
-
-	fmt.Fprintf(copyshell, "umount /tmp/src/\n")
-	fmt.Fprintf(copyshell, "umount /tmp/shell/\n")
-	fmt.Fprintf(copyshell, "rm -rf /tmp/shell/\n")
-	fmt.Fprintf(copyshell, "rm -rf /tmp/src/\n")
-	copyshell.Close()
-	// start or replace pod
-	vm, ok := b.Hyperdaemon.VmList[b.Name]
-	if !ok {
-		glog.Warningf("can not find VM(%s)", b.Name)
-
-		bo := &hypervisor.BootConfig{
-			CPU:    1,
-			Memory: 512,
-			Kernel: b.Hyperdaemon.Kernel,
-			Initrd: b.Hyperdaemon.Initrd,
-			Bios:   b.Hyperdaemon.Bios,
-			Cbfs:   b.Hyperdaemon.Cbfs,
-			Vbox:   b.Hyperdaemon.VboxImage,
-		}
-
-		vm = b.Hyperdaemon.NewVm(b.Name, 1, 512, false, types.VM_KEEP_AFTER_FINISH)
-
-		err = vm.Launch(bo)
-		if err != nil {
-			return err
-		}
-
-		b.Hyperdaemon.AddVm(vm)
+	// GetResourcePath cleans the trailing slash
+	if strings.HasSuffix(dest, string(os.PathSeparator)) {
+		destPath += string(os.PathSeparator)
 	}
-	if vm.Status == types.S_VM_IDLE {
-		code, cause, err := b.Hyperdaemon.StartPod(podId, "", b.Name, nil, false, false, types.VM_KEEP_AFTER_FINISH, []*hypervisor.TtyIO{})
-		if err != nil {
-			glog.Errorf("Code is %d, Cause is %s, %s", code, cause, err.Error())
-			b.Hyperdaemon.KillVm(b.Name)
-			return err
-		}
-		vm = b.Hyperdaemon.VmList[b.Name]
-		// wait for cmd finish
-		Status, err := vm.GetResponseChan()
-		if err != nil {
-			glog.Error(err.Error())
-			return err
-		}
-		defer vm.ReleaseResponseChan(Status)
-
-		var vmResponse *types.VmResponse
-		for {
-			vmResponse = <-Status
-			if vmResponse.VmId == b.Name {
-				if vmResponse.Code == types.E_POD_FINISHED {
-					glog.Infof("Got E_POD_FINISHED code response")
-					break
-				}
-			}
-		}
-
-		pod, ok := b.Hyperdaemon.PodList.Get(podId)
-		if !ok {
-			return fmt.Errorf("Cannot find pod %s", podId)
-		}
-		pod.SetVM(b.Name, vm)
-
-		// release pod from VM
-		glog.Warningf("start stop pod")
-		code, cause, err = b.Hyperdaemon.StopPod(podId, "no")
-		if err != nil {
-			glog.Errorf("Code is %d, Cause is %s, %s", code, cause, err.Error())
-			b.Hyperdaemon.KillVm(b.Name)
+	for _, info := range infos {
+		if err := b.docker.Copy(container, destPath, info.FileInfo, info.decompress); err != nil {
 			return err
 		}
-		glog.Warningf("stop pod finish")
-	} else {
-		glog.Errorf("Vm is not IDLE")
-		return fmt.Errorf("Vm is not IDLE")
 	}
 
 	if err := b.commit(container.ID, cmd, comment); err != nil {
@@ -628,106 +500,18 @@ func (b *Builder) probeCache() (bool, error) {
 	return true, nil
 }
 
-func (b *Builder) run(c *daemon.Container) error {
-	//start the pod
-	var (
-		mycontainer *hypervisor.Container
-		code        int
-		cause       string
-		err         error
-	)
-	b.Hyperdaemon.PodList.Find(func(p *hyperdaemon.Pod) bool {
-		ps := p.Status()
-		if ps == nil {
-			return false
-		}
-		for _, con := range ps.Containers {
-			if con.Id == c.ID {
-				mycontainer = con
-				return true
-			}
-		}
-		return false
-	})
-
-	if mycontainer == nil {
-		return fmt.Errorf("can not find that container(%s)", c.ID)
-	}
-	podId := mycontainer.PodId
-	// start or replace pod
-	vm, ok := b.Hyperdaemon.VmList[b.Name]
-	if !ok {
-		glog.Warningf("can not find VM(%s)", b.Name)
-
-		bo := &hypervisor.BootConfig{
-			CPU:    1,
-			Memory: 512,
-			Kernel: b.Hyperdaemon.Kernel,
-			Initrd: b.Hyperdaemon.Initrd,
-			Bios:   b.Hyperdaemon.Bios,
-			Cbfs:   b.Hyperdaemon.Cbfs,
-			Vbox:   b.Hyperdaemon.VboxImage,
-		}
-
-		vm = b.Hyperdaemon.NewVm(b.Name, 1, 512, false, types.VM_KEEP_AFTER_FINISH)
-
-		err = vm.Launch(bo)
-		if err != nil {
-			return err
-		}
-
-		b.Hyperdaemon.AddVm(vm)
-	}
-	if vm.Status == types.S_VM_IDLE {
-		code, cause, err = b.Hyperdaemon.StartPod(podId, "", b.Name, nil, false, false, types.VM_KEEP_AFTER_FINISH, []*hypervisor.TtyIO{})
-		if err != nil {
-			glog.Errorf("Code is %d, Cause is %s, %s", code, cause, err.Error())
-			b.Hyperdaemon.KillVm(b.Name)
-			return err
-		}
-		vm = b.Hyperdaemon.VmList[b.Name]
-		// wait for cmd finish
-		Status, err := vm.GetResponseChan()
-		if err != nil {
-			glog.Error(err.Error())
-			return err
-		}
-		defer vm.ReleaseResponseChan(Status)
-		var vmResponse *types.VmResponse
-		for {
-			vmResponse = <-Status
-			if vmResponse.VmId == b.Name {
-				if vmResponse.Code == types.E_POD_FINISHED {
-					glog.Infof("Got E_POD_FINISHED code response")
-					break
-				}
-			}
-		}
-
-		pod, ok := b.Hyperdaemon.PodList.Get(podId)
-		if !ok {
-			return fmt.Errorf("Cannot find pod %s", podId)
+func (b *Builder) clearTmp() {
+	for c := range b.tmpContainers {
+		rmConfig := &daemon.ContainerRmConfig{
+			ForceRemove:  true,
+			RemoveVolume: true,
 		}
-		pod.SetVM(b.Name, vm)
-		// release pod from VM
-		code, cause, err = b.Hyperdaemon.StopPod(podId, "no")
-		if err != nil {
-			glog.Errorf("Code is %d, Cause is %s, %s", code, cause, err.Error())
-			b.Hyperdaemon.KillVm(b.Name)
-			return err
+		if err := b.docker.Remove(c, rmConfig); err != nil {
+			fmt.Fprintf(b.Stdout, "Error removing intermediate container %s: %v\n", stringid.TruncateID(c), err)
+			return
 		}
-	} else {
-		glog.Errorf("Vm is not IDLE")
-		return fmt.Errorf("Vm is not IDLE")
-	}
-	return nil
-}
-
-func (b *Builder) clearTmp() {
-	for p := range b.tmpPods {
-		b.Hyperdaemon.CleanPod(p)
-		delete(b.tmpPods, p)
-		fmt.Fprintf(b.Stdout, "Removing intermediate pod %s\n", p)
+		delete(b.tmpContainers, c)
+		fmt.Fprintf(b.Stdout, "Removing intermediate container %s\n", stringid.TruncateID(c))
 	}
 }
 
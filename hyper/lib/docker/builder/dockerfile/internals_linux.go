@@ -8,9 +8,7 @@ import (
 	"path/filepath"
 
 	"github.com/docker/docker/pkg/stringid"
-	"github.com/golang/glog"
 	"github.com/hyperhq/hyper/lib/docker/daemon"
-	"github.com/hyperhq/hyper/utils"
 )
 
 func fixPermissions(source, destination string, uid, gid int, destExisted bool) error {
@@ -52,37 +50,14 @@ func (b *Builder) create() (*daemon.Container, error) {
 	b.runConfig.Image = b.image
 	config := *b.runConfig
 
-	// Create the Pod
-
-	podId := fmt.Sprintf("buildpod-%s", utils.RandStr(10, "alpha"))
-	podString, err := MakeBasicPod(podId, b.image, b.runConfig.Cmd.Slice())
-	if err != nil {
-		return nil, err
-	}
-	err = b.Hyperdaemon.CreatePod(podId, podString, false)
-	if err != nil {
-		return nil, err
-	}
-	// Get the container
-	var (
-		containerId string = ""
-		c           *daemon.Container
-	)
-	ps, ok := b.Hyperdaemon.PodList.GetStatus(podId)
-	if !ok {
-		return nil, fmt.Errorf("Cannot find pod %s", podId)
-	}
-	for _, i := range ps.Containers {
-		containerId = i.Id
-	}
-	c, err = b.Daemon.Get(containerId)
+	// the new layer of the container is the snapshot in which the step
+	// runs, it is added to the builder pod by runContainer
+	c, _, err := b.docker.Create(b.runConfig, nil)
 	if err != nil {
-		glog.Error(err.Error())
 		return nil, err
 	}
 
 	b.tmpContainers[c.ID] = struct{}{}
-	b.tmpPods[podId] = struct{}{}
 	fmt.Fprintf(b.Stdout, " ---> Running in %s\n", stringid.TruncateID(c.ID))
 
 	if config.Cmd.Len() > 0 {
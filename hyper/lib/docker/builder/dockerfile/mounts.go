@@ -0,0 +1,256 @@
+package dockerfile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	hyperdaemon "github.com/hyperhq/hyper/daemon"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/pod"
+)
+
+const (
+	// the tmpfs keeping the secrets of the steps, in the share dir of the
+	// builder VM
+	BUILD_SECRET_VOLUME = "build-secrets"
+
+	DEFAULT_SSH_ID     = "default"
+	DEFAULT_SSH_TARGET = "/run/ssh-agent.sock"
+)
+
+// runMount is a `RUN --mount=type=secret|ssh,...`, which is only there for
+// the command of the step, and not in the committed image
+type runMount struct {
+	Type     string
+	ID       string
+	Target   string
+	Required bool
+}
+
+// parseRunMount parses the value of `--mount`, the comma separated key=value
+// options of the mount
+func parseRunMount(value string) (*runMount, error) {
+	m := &runMount{}
+	for _, field := range strings.Split(value, ",") {
+		key, val := field, ""
+		if i := strings.Index(field, "="); i >= 0 {
+			key, val = field[:i], field[i+1:]
+		}
+		switch strings.ToLower(key) {
+		case "type":
+			m.Type = val
+		case "id":
+			m.ID = val
+		case "target", "dst", "destination":
+			m.Target = val
+		case "required":
+			m.Required = val == "" || strings.ToLower(val) == "true"
+		default:
+			return nil, fmt.Errorf("unknown mount option %q", field)
+		}
+	}
+
+	switch m.Type {
+	case "secret":
+		if m.ID == "" && m.Target != "" {
+			m.ID = path.Base(m.Target)
+		}
+		if m.ID == "" {
+			return nil, fmt.Errorf("secret mount requires an id")
+		}
+		if m.Target == "" {
+			m.Target = path.Join(hyperdaemon.SECRET_MOUNT_PATH, m.ID)
+		}
+		m.Target = path.Clean(m.Target)
+		if !strings.HasPrefix(m.Target, hyperdaemon.SECRET_MOUNT_PATH+"/") {
+			return nil, fmt.Errorf("secret %s must be mounted under %s", m.ID, hyperdaemon.SECRET_MOUNT_PATH)
+		}
+	case "ssh":
+		if m.ID == "" {
+			m.ID = DEFAULT_SSH_ID
+		}
+		if m.Target == "" {
+			m.Target = DEFAULT_SSH_TARGET
+		}
+		if !path.IsAbs(m.Target) {
+			return nil, fmt.Errorf("ssh mount target %s must be an absolute path", m.Target)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported mount type %q, only secret and ssh are supported", m.Type)
+	}
+	return m, nil
+}
+
+// prepareSecrets writes the secrets of the step into the tmpfs volume of the
+// builder VM, and mounts the volume read only into the container of the step.
+// The returned func removes the secrets once the step is finished.
+func (b *Builder) prepareSecrets(vm *hypervisor.Vm, spec *pod.UserContainer, mounts []*runMount) (func(), error) {
+	var files []string
+	cleanup := func() {
+		for _, f := range files {
+			os.Remove(f)
+		}
+	}
+
+	for _, m := range mounts {
+		if m.Type != "secret" {
+			continue
+		}
+		data, ok := b.Secrets[m.ID]
+		if !ok {
+			if m.Required {
+				cleanup()
+				return nil, fmt.Errorf("secret %s is required but not given to the build", m.ID)
+			}
+			continue
+		}
+		if len(data) > hyperdaemon.MAX_SECRET_SIZE {
+			cleanup()
+			return nil, fmt.Errorf("Secret %s is too large, the limit is %d bytes", m.ID, hyperdaemon.MAX_SECRET_SIZE)
+		}
+		if err := b.attachSecretVolume(vm); err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		file := path.Join(b.secretDir, strings.TrimPrefix(m.Target, hyperdaemon.SECRET_MOUNT_PATH+"/"))
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			cleanup()
+			return nil, err
+		}
+		if err := ioutil.WriteFile(file, data, 0400); err != nil {
+			cleanup()
+			return nil, err
+		}
+		files = append(files, file)
+	}
+
+	if len(files) > 0 {
+		spec.Volumes = append(spec.Volumes, pod.UserVolumeReference{
+			Path:     hyperdaemon.SECRET_MOUNT_PATH,
+			Volume:   BUILD_SECRET_VOLUME,
+			ReadOnly: true,
+		})
+	}
+	return cleanup, nil
+}
+
+// attachSecretVolume mounts the tmpfs for the secrets in the share dir of the
+// builder VM, and adds it to the VM as a volume when a step first needs it
+func (b *Builder) attachSecretVolume(vm *hypervisor.Vm) error {
+	if b.secretDir != "" {
+		return nil
+	}
+
+	dir := path.Join(hypervisor.BaseDir, vm.Id, hypervisor.ShareDirTag, BUILD_SECRET_VOLUME)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := utils.MountTmpfs(dir, hyperdaemon.SECRET_TMPFS_SIZE); err != nil {
+		glog.Errorf("failed to mount tmpfs on %s: %v", dir, err)
+		return err
+	}
+	b.secretDir = dir
+
+	return vm.AttachVolume(&hypervisor.VolumeInfo{
+		Name:     BUILD_SECRET_VOLUME,
+		Filepath: BUILD_SECRET_VOLUME,
+		Fstype:   "dir",
+	}, nil)
+}
+
+// releaseSecrets unmounts the tmpfs of the secrets, so nothing is left once
+// the build is finished
+func (b *Builder) releaseSecrets() {
+	if b.secretDir == "" {
+		return
+	}
+	if err := utils.Unmount(b.secretDir); err != nil {
+		glog.Warningf("failed to umount the build secrets %s: %v", b.secretDir, err)
+	}
+	os.Remove(b.secretDir)
+	b.secretDir = ""
+}
+
+// sshForward is the ssh agent socket forwarded into the container of a step
+type sshForward struct {
+	session uint64
+	agent   string
+}
+
+// prepareSSH makes init listen on the target of the ssh mounts in the
+// container of the step, and points SSH_AUTH_SOCK to the first one
+func (b *Builder) prepareSSH(info *hypervisor.ContainerInfo, spec *pod.UserContainer, mounts []*runMount) ([]*sshForward, error) {
+	var forwards []*sshForward
+	for _, m := range mounts {
+		if m.Type != "ssh" {
+			continue
+		}
+		agent, ok := b.SSHAgents[m.ID]
+		if !ok {
+			if m.Required {
+				return nil, fmt.Errorf("ssh agent %s is required but not given to the build", m.ID)
+			}
+			continue
+		}
+
+		session := hypervisor.NewSocketSession()
+		info.Sockets = append(info.Sockets, hypervisor.VmSocketDescriptor{
+			Path:    m.Target,
+			Session: session,
+		})
+		if len(forwards) == 0 {
+			spec.Envs = append(spec.Envs, pod.UserEnvironmentVar{Env: "SSH_AUTH_SOCK", Value: m.Target})
+		}
+		forwards = append(forwards, &sshForward{session: session, agent: agent})
+	}
+	return forwards, nil
+}
+
+// dialSSHAgent connects to the ssh agent socket, which must be served by a
+// process of uid, the socket checked when the build was requested may have
+// been replaced since
+func dialSSHAgent(socket string, uid uint32) (net.Conn, error) {
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := socketPeerUid(c.(*net.UnixConn))
+	if err == nil && uid != 0 && owner != uid {
+		err = fmt.Errorf("ssh agent %s is run by uid %d rather than %d", socket, owner, uid)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// forwardSSH relays the connections to the ssh agents of uid until stop is
+// closed, the returned channel is closed when all the relays are finished
+func forwardSSH(vm *hypervisor.Vm, forwards []*sshForward, uid uint32, stop <-chan struct{}) <-chan struct{} {
+	finished := make(chan struct{})
+	remain := make(chan struct{}, len(forwards))
+	for _, f := range forwards {
+		go func(f *sshForward) {
+			dial := func() (net.Conn, error) { return dialSSHAgent(f.agent, uid) }
+			if err := vm.ForwardSocket(f.session, dial, stop); err != nil {
+				glog.Warningf("failed to forward the ssh agent %s: %v", f.agent, err)
+			}
+			remain <- struct{}{}
+		}(f)
+	}
+	go func() {
+		for range forwards {
+			<-remain
+		}
+		close(finished)
+	}()
+	return finished
+}
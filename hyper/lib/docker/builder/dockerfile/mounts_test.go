@@ -0,0 +1,37 @@
+package dockerfile
+
+import (
+	"testing"
+)
+
+func TestParseRunMount(t *testing.T) {
+	valid := map[string]runMount{
+		"type=secret,id=npmrc":                         {Type: "secret", ID: "npmrc", Target: "/run/secrets/npmrc"},
+		"type=secret,id=token,target=/run/secrets/a/b": {Type: "secret", ID: "token", Target: "/run/secrets/a/b"},
+		"type=secret,dst=/run/secrets/key,required":    {Type: "secret", ID: "key", Target: "/run/secrets/key", Required: true},
+		"type=ssh": {Type: "ssh", ID: "default", Target: "/run/ssh-agent.sock"},
+		"type=ssh,id=github,target=/tmp/agent,required=false": {Type: "ssh", ID: "github", Target: "/tmp/agent"},
+	}
+	for value, expected := range valid {
+		m, err := parseRunMount(value)
+		if err != nil {
+			t.Fatalf("parseRunMount(%q): %v", value, err)
+		}
+		if *m != expected {
+			t.Fatalf("parseRunMount(%q): expected %+v, got %+v", value, expected, *m)
+		}
+	}
+
+	for _, value := range []string{
+		"type=bind,source=/etc",
+		"type=secret",
+		"type=secret,id=a,target=/etc/passwd",
+		"type=secret,id=a,target=/run/secrets/../x",
+		"type=ssh,target=agent.sock",
+		"type=ssh,mode=0600",
+	} {
+		if _, err := parseRunMount(value); err == nil {
+			t.Fatalf("parseRunMount(%q) should fail", value)
+		}
+	}
+}
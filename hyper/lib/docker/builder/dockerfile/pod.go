@@ -2,80 +2,44 @@ package dockerfile
 
 import (
 	"fmt"
+	"strings"
 
 	dockerimage "github.com/docker/docker/image"
 	"github.com/hyperhq/hyper/utils"
 	"github.com/hyperhq/runv/hypervisor/pod"
 )
 
-func MakeBasicPod(podName, image string, cmd []string) (string, error) {
-	return MakePod(podName, image, "", "", "", "", cmd, []string{})
-}
-
-func MakeCopyPod(podName, image, workdir, src, dst, shellDir string) (string, error) {
-	return MakePod(podName, image, workdir, src, dst, shellDir, []string{"/bin/sh", "/tmp/shell/exec-copy.sh"}, []string{})
-}
+// the command keeping the container of the builder pod, and so the pod,
+// running while the containers of the steps come and go
+var buildKeeperCmd = []string{"/bin/sh", "-c", "while true; do sleep 3600; done"}
 
-func MakePod(podName, image, workdir, src, vol, shellDir string, cmds, entrys []string) (string, error) {
+// MakeBuildPod makes the pod kept in the builder VM for the whole build
+func MakeBuildPod(podName, image string, cpu, memory int) (string, error) {
 	if image == "" {
 		return "", fmt.Errorf("image can not be null")
 	}
 	if err := dockerimage.ValidateID(image); err == nil {
 		image = image[:12]
 	}
-	var (
-		env           = []pod.UserEnvironmentVar{}
-		containerList = []pod.UserContainer{}
-		volList       = []pod.UserVolume{}
-		cVols         = []pod.UserVolumeReference{}
-	)
-	if src != "" {
-		myVol1 := pod.UserVolumeReference{
-			Path:     "/tmp/src/",
-			Volume:   "source",
-			ReadOnly: false,
-		}
-		myVol2 := pod.UserVolumeReference{
-			Path:     "/tmp/shell/",
-			Volume:   "shell",
-			ReadOnly: false,
-		}
-		cVols = append(cVols, myVol1)
-		cVols = append(cVols, myVol2)
-		vol1 := pod.UserVolume{
-			Name:   "source",
-			Source: src,
-			Driver: "vfs",
-		}
-		vol2 := pod.UserVolume{
-			Name:   "shell",
-			Source: shellDir,
-			Driver: "vfs",
-		}
-		volList = append(volList, vol1)
-		volList = append(volList, vol2)
-	}
 
 	var container = pod.UserContainer{
 		Name:          "image-builder",
 		Image:         image,
-		Command:       cmds,
-		Workdir:       workdir,
-		Entrypoint:    entrys,
+		Command:       buildKeeperCmd,
+		Entrypoint:    []string{},
 		Ports:         []pod.UserContainerPort{},
-		Envs:          env,
-		Volumes:       cVols,
+		Envs:          []pod.UserEnvironmentVar{},
+		Volumes:       []pod.UserVolumeReference{},
 		Files:         []pod.UserFileReference{},
 		RestartPolicy: "never",
 	}
-	containerList = append(containerList, container)
 
 	var userPod = &pod.UserPod{
 		Name:       podName,
-		Containers: containerList,
-		Resource:   pod.UserResource{Vcpu: 1, Memory: 512},
+		Containers: []pod.UserContainer{container},
+		Resource:   pod.UserResource{Vcpu: cpu, Memory: memory},
 		Files:      []pod.UserFile{},
-		Volumes:    volList,
+		Volumes:    []pod.UserVolume{},
 		Tty:        false,
 	}
 
@@ -85,3 +49,27 @@ func MakePod(podName, image, workdir, src, vol, shellDir string, cmds, entrys []
 	}
 	return string(jsonString), nil
 }
+
+// MakeStepContainer makes the container running the command of a step in
+// the builder pod
+func MakeStepContainer(name, image, workdir string, env, cmd []string) *pod.UserContainer {
+	envs := []pod.UserEnvironmentVar{}
+	for _, e := range env {
+		if i := strings.Index(e, "="); i > 0 {
+			envs = append(envs, pod.UserEnvironmentVar{Env: e[:i], Value: e[i+1:]})
+		}
+	}
+
+	return &pod.UserContainer{
+		Name:          name,
+		Image:         image,
+		Command:       cmd,
+		Workdir:       workdir,
+		Entrypoint:    []string{},
+		Ports:         []pod.UserContainerPort{},
+		Envs:          envs,
+		Volumes:       []pod.UserVolumeReference{},
+		Files:         []pod.UserFileReference{},
+		RestartPolicy: "never",
+	}
+}
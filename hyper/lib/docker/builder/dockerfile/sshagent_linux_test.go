@@ -0,0 +1,49 @@
+// +build linux
+
+package dockerfile
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestDialSSHAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	uid := uint32(os.Getuid())
+	c, err := dialSSHAgent(socket, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	if _, err := dialSSHAgent(socket, uid+1); err == nil {
+		t.Fatal("the agent run by the other user should be refused")
+	}
+	if _, err := dialSSHAgent(path.Join(dir, "missing.sock"), uid); err == nil {
+		t.Fatal("dialing the missing agent should fail")
+	}
+}
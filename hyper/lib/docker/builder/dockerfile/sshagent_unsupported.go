@@ -0,0 +1,14 @@
+// +build !linux
+
+package dockerfile
+
+import (
+	"fmt"
+	"net"
+)
+
+// the owner of the ssh agent can not be checked, the agents are not
+// forwarded
+func socketPeerUid(c *net.UnixConn) (uint32, error) {
+	return 0, fmt.Errorf("the owner of the ssh agent is unknown on this platform")
+}
@@ -0,0 +1,157 @@
+package dockerfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/runconfig"
+	"github.com/hyperhq/hyper/lib/docker/builder"
+)
+
+var validStageName = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+// parseFrom splits the argument of FROM into the base and the optional name
+// of the stage, `FROM <base> [AS <name>]`.
+func parseFrom(arg string) (base, name string, err error) {
+	parts := strings.Fields(arg)
+	switch {
+	case len(parts) == 1:
+		return parts[0], "", nil
+	case len(parts) == 3 && strings.EqualFold(parts[1], "as"):
+		name = strings.ToLower(parts[2])
+		if !validStageName.MatchString(name) {
+			return "", "", fmt.Errorf("invalid name for build stage: %q, name can't start with a number or contain symbols", parts[2])
+		}
+		return parts[0], name, nil
+	}
+	return "", "", fmt.Errorf("FROM requires either one or three arguments: FROM <image> [AS <name>]")
+}
+
+// startStage finishes the current stage, if any, and resets the state of the
+// builder for the stage started by a FROM
+func (b *Builder) startStage(name string) error {
+	if b.inStage {
+		b.stageImages = append(b.stageImages, b.image)
+		if b.stageName != "" {
+			b.stageNames[b.stageName] = len(b.stageImages) - 1
+		}
+	}
+	if name != "" {
+		if _, ok := b.stageNames[name]; ok {
+			return fmt.Errorf("duplicate name for build stage: %s", name)
+		}
+	}
+
+	b.inStage = true
+	b.stageName = name
+	b.image = ""
+	b.noBaseImage = false
+	b.maintainer = ""
+	b.cmdSet = false
+	b.cacheBusted = false
+	b.runConfig = new(runconfig.Config)
+	return nil
+}
+
+// stageImage returns the image of a finished stage, referenced by its name or
+// its index
+func (b *Builder) stageImage(ref string) (string, bool) {
+	if i, ok := b.stageNames[strings.ToLower(ref)]; ok {
+		return b.stageImages[i], true
+	}
+	if i, err := strconv.Atoi(ref); err == nil && i >= 0 && i < len(b.stageImages) {
+		return b.stageImages[i], true
+	}
+	return "", false
+}
+
+// stageContext makes the context of `COPY --from`, with the sources taken
+// from the image of a previous stage, or from any other image. The files are
+// hashed like the ones of the build context, so that the cache of the step
+// follows their content.
+func (b *Builder) stageContext(ref string, srcs []string) (builder.ModifiableContext, error) {
+	imgID, ok := b.stageImage(ref)
+	if !ok {
+		img, err := b.docker.LookupImage(ref)
+		if img == nil {
+			if img, err = b.docker.Pull(ref); err != nil {
+				return nil, err
+			}
+		}
+		imgID = img.ID
+	}
+	if imgID == "" {
+		return nil, fmt.Errorf("build stage %s has no image to copy from", ref)
+	}
+
+	container, _, err := b.docker.Create(&runconfig.Config{Image: imgID}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer b.docker.Unmount(container)
+	b.tmpContainers[container.ID] = struct{}{}
+
+	root, err := container.GetResourcePath("/")
+	if err != nil {
+		return nil, err
+	}
+
+	var includes []string
+	for _, src := range srcs {
+		includes = append(includes, copyIncludePath(src))
+	}
+	content, err := archive.TarWithOptions(root, &archive.TarOptions{
+		Compression:  archive.Uncompressed,
+		IncludeFiles: includes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	return builder.MakeTarSumContext(content)
+}
+
+// copyIncludePath returns the part of the source path before any wildcard,
+// relative to the root of the image
+func copyIncludePath(src string) string {
+	src = strings.TrimPrefix(filepath.Clean(filepath.FromSlash(src)), string(os.PathSeparator))
+
+	var parts []string
+	for _, p := range strings.Split(src, string(os.PathSeparator)) {
+		if containsWildcards(p) {
+			break
+		}
+		if p == "" {
+			continue
+		}
+		parts = append(parts, p)
+	}
+	if len(parts) == 0 {
+		return "."
+	}
+	return filepath.Join(parts...)
+}
+
+// CacheImages returns the images of all the steps of the build, the cache
+// from which the same Dockerfile is built again without running the steps.
+func (b *Builder) CacheImages() []string {
+	var (
+		images []string
+		seen   = map[string]bool{}
+	)
+	for _, ids := range [][]string{b.stageImages, b.activeImages} {
+		for _, id := range ids {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				images = append(images, id)
+			}
+		}
+	}
+	return images
+}
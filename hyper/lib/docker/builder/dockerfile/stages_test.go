@@ -0,0 +1,47 @@
+package dockerfile
+
+import (
+	"testing"
+)
+
+func TestParseFrom(t *testing.T) {
+	valid := []struct {
+		arg, base, name string
+	}{
+		{"busybox", "busybox", ""},
+		{"busybox:latest AS build", "busybox:latest", "build"},
+		{"golang as Builder-1", "golang", "builder-1"},
+	}
+	for _, v := range valid {
+		base, name, err := parseFrom(v.arg)
+		if err != nil {
+			t.Fatalf("parseFrom(%q): %v", v.arg, err)
+		}
+		if base != v.base || name != v.name {
+			t.Fatalf("parseFrom(%q): expected %q %q, got %q %q", v.arg, v.base, v.name, base, name)
+		}
+	}
+
+	for _, arg := range []string{"busybox build", "busybox AS", "busybox AS 1st", "busybox AS a b"} {
+		if _, _, err := parseFrom(arg); err == nil {
+			t.Fatalf("parseFrom(%q) should fail", arg)
+		}
+	}
+}
+
+func TestCopyIncludePath(t *testing.T) {
+	cases := map[string]string{
+		"/go/bin/app":  "go/bin/app",
+		"go/bin/":      "go/bin",
+		"/go/bin/*.so": "go/bin",
+		"/usr/*/lib":   "usr",
+		"*":            ".",
+		"/":            ".",
+		"./src/../app": "app",
+	}
+	for src, expected := range cases {
+		if got := copyIncludePath(src); got != expected {
+			t.Fatalf("copyIncludePath(%q): expected %q, got %q", src, expected, got)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package dockerfile
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/lib/docker/daemon"
+	"github.com/hyperhq/hyper/utils"
+	"github.com/hyperhq/runv/hypervisor"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+const (
+	// the resources of the builder VM if they are not given by the build
+	DEFAULT_BUILD_VM_CPU    = 1
+	DEFAULT_BUILD_VM_MEMORY = 512
+)
+
+// startBuildVm boots the builder VM when the first step needs it, with the
+// builder pod to which the containers of the steps are added. The VM is kept
+// until the build is finished.
+func (b *Builder) startBuildVm() (*hypervisor.Vm, error) {
+	if b.vm != nil {
+		return b.vm, nil
+	}
+
+	cpu, memory := b.VmCpu, b.VmMemory
+	if cpu <= 0 {
+		cpu = DEFAULT_BUILD_VM_CPU
+	}
+	if memory <= 0 {
+		memory = DEFAULT_BUILD_VM_MEMORY
+	}
+
+	bo := &hypervisor.BootConfig{
+		CPU:    cpu,
+		Memory: memory,
+		Kernel: b.Hyperdaemon.Kernel,
+		Initrd: b.Hyperdaemon.Initrd,
+		Bios:   b.Hyperdaemon.Bios,
+		Cbfs:   b.Hyperdaemon.Cbfs,
+		Vbox:   b.Hyperdaemon.VboxImage,
+	}
+	vm := b.Hyperdaemon.NewVm(b.Name, cpu, memory, false, types.VM_KEEP_AFTER_FINISH)
+	if err := vm.Launch(bo); err != nil {
+		return nil, err
+	}
+	b.Hyperdaemon.AddVm(vm)
+	b.vm = vm
+
+	podId := fmt.Sprintf("buildpod-%s", utils.RandStr(10, "alpha"))
+	podString, err := MakeBuildPod(podId, b.image, cpu, memory)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.Hyperdaemon.CreatePod(podId, podString, false); err != nil {
+		return nil, err
+	}
+	b.buildPod = podId
+
+	code, cause, err := b.Hyperdaemon.StartPod(podId, "", b.Name, nil, false, false, types.VM_KEEP_AFTER_FINISH, []*hypervisor.TtyIO{})
+	if err != nil {
+		glog.Errorf("Code is %d, Cause is %s, %s", code, cause, err.Error())
+		return nil, err
+	}
+	glog.V(1).Infof("builder VM %s is running pod %s", b.Name, podId)
+	return vm, nil
+}
+
+// releaseBuildVm removes the builder pod and kills the builder VM
+func (b *Builder) releaseBuildVm() {
+	b.releaseSecrets()
+	if b.buildPod != "" {
+		if _, _, err := b.Hyperdaemon.CleanPod(b.buildPod); err != nil {
+			glog.Warningf("failed to remove the builder pod %s: %v", b.buildPod, err)
+		}
+		b.buildPod = ""
+	}
+	if b.vm != nil {
+		glog.V(1).Infof("Kill VM(%s)...", b.Name)
+		b.Hyperdaemon.KillVm(b.Name)
+		b.vm = nil
+	}
+}
+
+// runContainer adds the container of the step to the builder pod, and
+// waits for its command to exit. The secrets and the ssh agents of the mounts
+// are only there until the command exits, and the container is removed from
+// the builder VM then, so that its rootfs can be committed.
+func (b *Builder) runContainer(c *daemon.Container, mounts []*runMount) (err error) {
+	vm, err := b.startBuildVm()
+	if err != nil {
+		return err
+	}
+
+	sharedDir := path.Join(hypervisor.BaseDir, vm.Id, hypervisor.ShareDirTag)
+	info, err := b.Hyperdaemon.Storage.PrepareContainer(c.ID, sharedDir)
+	if err != nil {
+		return err
+	}
+
+	cmd := b.runConfig.Cmd.Slice()
+	spec := MakeStepContainer(stringid.TruncateID(c.ID), b.image, b.runConfig.WorkingDir, b.runConfig.Env, cmd)
+
+	removeSecrets, err := b.prepareSecrets(vm, spec, mounts)
+	if err != nil {
+		return err
+	}
+	defer removeSecrets()
+
+	forwards, err := b.prepareSSH(info, spec, mounts)
+	if err != nil {
+		return err
+	}
+
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseResponseChan(Status)
+
+	if err := vm.NewContainer(spec, info); err != nil {
+		return err
+	}
+	defer func() {
+		if b.vm == nil {
+			return
+		}
+		if rmErr := vm.RemoveContainer(c.ID); rmErr != nil && err == nil {
+			err = rmErr
+		}
+	}()
+
+	if len(forwards) > 0 {
+		stop := make(chan struct{})
+		finished := forwardSSH(vm, forwards, b.SSHAgentUid, stop)
+		defer func() {
+			close(stop)
+			<-finished
+		}()
+	}
+
+	for {
+		vmResponse, ok := <-Status
+		if !ok {
+			return fmt.Errorf("builder VM %s is gone", b.Name)
+		}
+		switch vmResponse.Code {
+		case types.E_CONTAINER_FINISHED:
+			exit := vmResponse.Data.(*types.ContainerExit)
+			if exit.Id != c.ID {
+				continue
+			}
+			if exit.Code != 0 {
+				return fmt.Errorf("The command '%s' returned a non-zero code: %d", strings.Join(cmd, " "), exit.Code)
+			}
+			return nil
+		case types.E_VM_SHUTDOWN:
+			b.vm = nil
+			return fmt.Errorf("builder VM %s is shut down", b.Name)
+		}
+	}
+}
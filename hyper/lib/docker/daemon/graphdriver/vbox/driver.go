@@ -339,7 +339,7 @@ func (d *Driver) VmMountLayer(id string) error {
 		return err
 	}
 	podId := fmt.Sprintf("pull-%s", utils.RandStr(10, "alpha"))
-	vm, ok := d.daemon.VmList[d.pullVm]
+	vm, ok := d.daemon.GetVm(d.pullVm)
 	if !ok {
 		return fmt.Errorf("can not find VM(%s)", d.pullVm)
 	}
@@ -350,7 +350,7 @@ func (d *Driver) VmMountLayer(id string) error {
 			d.daemon.KillVm(d.pullVm)
 			return err
 		}
-		vm := d.daemon.VmList[d.pullVm]
+		vm, _ := d.daemon.GetVm(d.pullVm)
 		// wait for cmd finish
 		Status, err := vm.GetResponseChan()
 		if err != nil {
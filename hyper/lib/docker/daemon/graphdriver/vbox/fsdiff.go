@@ -76,7 +76,7 @@ func (d *Driver) Diff(id, parent string) (diff archive.Archive, err error) {
 	}
 
 	// start or replace pod
-	vm, ok := d.daemon.VmList[d.pullVm]
+	vm, ok := d.daemon.GetVm(d.pullVm)
 	if !ok {
 		return nil, fmt.Errorf("can not find VM(%s)", d.pullVm)
 	}
@@ -87,7 +87,7 @@ func (d *Driver) Diff(id, parent string) (diff archive.Archive, err error) {
 			d.daemon.KillVm(d.pullVm)
 			return nil, err
 		}
-		vm := d.daemon.VmList[d.pullVm]
+		vm, _ := d.daemon.GetVm(d.pullVm)
 		// wait for cmd finish
 		Status, err := vm.GetResponseChan()
 		if err != nil {
@@ -0,0 +1,543 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/pkg/units"
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/lib/version"
+)
+
+const (
+	AUDIT_DEFAULT_MAX_SIZE  = 100 * 1024 * 1024
+	AUDIT_DEFAULT_MAX_FILES = 5
+
+	// the request bodies larger than this are not recorded
+	AUDIT_MAX_BODY = 64 * 1024
+	// the head of the response kept to find the created IDs
+	AUDIT_MAX_RESPONSE = 4096
+
+	AUDIT_REDACTED = "<redacted>"
+)
+
+var (
+	auditLog *AuditLog
+
+	// the bodies of these routes are never recorded
+	auditSecretRoutes = map[string]bool{
+		"/auth":          true,
+		"/secret/create": true,
+	}
+)
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+	Time     time.Time   `json:"time"`
+	User     string      `json:"user,omitempty"`
+	Uid      *uint32     `json:"uid,omitempty"`
+	Remote   string      `json:"remote,omitempty"`
+	Method   string      `json:"method"`
+	Route    string      `json:"route"`
+	URI      string      `json:"uri"`
+	Pod      string      `json:"pod,omitempty"`
+	Vm       string      `json:"vm,omitempty"`
+	Body     interface{} `json:"body,omitempty"`
+	BodySize int64       `json:"bodySize,omitempty"`
+	Code     int         `json:"code"`
+	Error    string      `json:"error,omitempty"`
+	Duration int64       `json:"durationMs"`
+	// the decision of the authorizers, e.g. "denied: <reason>"
+	Authz string `json:"authz,omitempty"`
+}
+
+// AuditLog is an append-only file of json lines, it is rotated to
+// <path>.1 ... <path>.<maxFiles-1> once it grows beyond maxSize.
+type AuditLog struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func NewAuditLog(path, maxSize, maxFiles string) (*AuditLog, error) {
+	l := &AuditLog{
+		path:     path,
+		maxSize:  AUDIT_DEFAULT_MAX_SIZE,
+		maxFiles: AUDIT_DEFAULT_MAX_FILES,
+	}
+	if maxSize != "" {
+		size, err := units.FromHumanSize(maxSize)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("Invalid audit log size %s", maxSize)
+		}
+		l.maxSize = size
+	}
+	if maxFiles != "" {
+		n, err := strconv.Atoi(maxFiles)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("Invalid audit log files %s", maxFiles)
+		}
+		l.maxFiles = n
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.size = f, fi.Size()
+	return nil
+}
+
+func (l *AuditLog) rotate() error {
+	l.file.Close()
+	l.file = nil
+	for i := l.maxFiles - 1; i > 0; i-- {
+		from := l.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", l.path, i-1)
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", l.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if l.maxFiles == 1 {
+		os.Remove(l.path)
+	}
+	return l.open()
+}
+
+func (l *AuditLog) Log(e *AuditEntry) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		glog.Errorf("audit: can not marshal entry: %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			glog.Errorf("audit: %v", err)
+			return
+		}
+	}
+	if l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			glog.Errorf("audit: failed to rotate %s: %v", l.path, err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		glog.Errorf("audit: failed to write %s: %v", l.path, err)
+	}
+}
+
+func (l *AuditLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// files returns the log files, the oldest first
+func (l *AuditLog) files() []string {
+	files := []string{}
+	for i := l.maxFiles - 1; i > 0; i-- {
+		f := fmt.Sprintf("%s.%d", l.path, i)
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return append(files, l.path)
+}
+
+// AuditQuery selects the entries returned to `hyperctl audit`
+type AuditQuery struct {
+	Since  time.Time
+	Until  time.Time
+	User   string
+	Pod    string
+	Route  string
+	Failed bool
+	Limit  int
+}
+
+func (q *AuditQuery) match(e *AuditEntry) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && e.Time.After(q.Until) {
+		return false
+	}
+	if q.User != "" && e.User != q.User && (e.Uid == nil || strconv.FormatUint(uint64(*e.Uid), 10) != q.User) {
+		return false
+	}
+	if q.Pod != "" && e.Pod != q.Pod {
+		return false
+	}
+	if q.Route != "" {
+		if ok, _ := path.Match(q.Route, e.Route); !ok {
+			return false
+		}
+	}
+	if q.Failed && e.Code < 400 {
+		return false
+	}
+	return true
+}
+
+// Search returns the matched entries, the latest `Limit` ones if it is set
+func (l *AuditLog) Search(q *AuditQuery) ([]*AuditEntry, error) {
+	l.Lock()
+	files := l.files()
+	l.Unlock()
+
+	res := []*AuditEntry{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 4*AUDIT_MAX_BODY)
+		for scanner.Scan() {
+			var e AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue
+			}
+			if q.match(&e) {
+				res = append(res, &e)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[len(res)-q.Limit:]
+	}
+	return res, nil
+}
+
+// auditRecorder keeps the status code and the head of the response
+type auditRecorder struct {
+	http.ResponseWriter
+	code     int
+	hijacked bool
+	head     bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(code int) {
+	if rec.code == 0 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *auditRecorder) Write(data []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+	if n := AUDIT_MAX_RESPONSE - rec.head.Len(); n > 0 {
+		if n > len(data) {
+			n = len(data)
+		}
+		rec.head.Write(data[:n])
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+func (rec *auditRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := rec.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		rec.hijacked = true
+		rec.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (rec *auditRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *auditRecorder) CloseNotify() <-chan bool {
+	return rec.ResponseWriter.(http.CloseNotifier).CloseNotify()
+}
+
+func auditable(method string) bool {
+	return method == "POST" || method == "DELETE" || method == "PUT"
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range []string{"password", "passwd", "secret", "token", "auth", "credential", "privatekey"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return key == "key"
+}
+
+// sanitize strips the secrets from a decoded json body, an env entry
+// like {"env": "DB_PASSWORD", "value": "..."} is redacted too, and so is
+// the content of the files injected into a pod.
+func sanitize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if name, ok := t["env"].(string); ok && isSensitiveKey(name) {
+			if _, ok := t["value"]; ok {
+				t["value"] = AUDIT_REDACTED
+			}
+		}
+		if files, ok := t["files"].([]interface{}); ok {
+			for _, f := range files {
+				sanitizeFile(f)
+			}
+		}
+		for k, sub := range t {
+			if isSensitiveKey(k) {
+				t[k] = AUDIT_REDACTED
+				continue
+			}
+			t[k] = sanitize(sub)
+		}
+	case []interface{}:
+		for i, sub := range t {
+			t[i] = sanitize(sub)
+		}
+	}
+	return v
+}
+
+// sanitizeFile redacts the content of a pod file, given inline or as a
+// data uri
+func sanitizeFile(v interface{}) {
+	f, ok := v.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if _, ok := f["content"]; ok {
+		f["content"] = AUDIT_REDACTED
+	}
+	if uri, ok := f["uri"].(string); ok && strings.HasPrefix(uri, "data:") {
+		f["uri"] = AUDIT_REDACTED
+	}
+}
+
+func sanitizeURI(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	changed := false
+	for k := range q {
+		if isSensitiveKey(k) {
+			q.Set(k, AUDIT_REDACTED)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// peerUid returns the uid of the client, it is known for the clients of the
+// local unix socket only
+func peerUid(r *http.Request) (uint32, bool) {
+	var uid, pid uint32
+	var seq uint64
+	if n, _ := fmt.Sscanf(r.RemoteAddr, "uid=%d,pid=%d@%d", &uid, &pid, &seq); n == 3 {
+		return uid, true
+	}
+	return 0, false
+}
+
+// newAuditEntry records the request, its json body is read and put back
+func newAuditEntry(route string, r *http.Request) *AuditEntry {
+	e := &AuditEntry{
+		Time:   time.Now(),
+		Method: r.Method,
+		Route:  route,
+		URI:    sanitizeURI(r.RequestURI),
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		e.User = r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	if uid, ok := peerUid(r); ok {
+		e.Uid = &uid
+	} else {
+		e.Remote = r.RemoteAddr
+	}
+
+	if err := parseForm(r); err == nil {
+		e.Pod = r.Form.Get("podId")
+		if e.Pod == "" {
+			e.Pod = r.Form.Get("podName")
+		}
+		if e.Pod == "" && r.Form.Get("type") == "pod" {
+			e.Pod = r.Form.Get("value")
+		}
+		e.Vm = r.Form.Get("vm")
+		if e.Vm == "" {
+			e.Vm = r.Form.Get("vmId")
+		}
+	}
+
+	if r.Body == nil || r.ContentLength == 0 {
+		return e
+	}
+	e.BodySize = r.ContentLength
+	if auditSecretRoutes[route] {
+		e.Body = AUDIT_REDACTED
+		return e
+	}
+	if r.ContentLength < 0 || r.ContentLength > AUDIT_MAX_BODY || checkForJson(r) != nil {
+		return e
+	}
+	data, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return e
+	}
+	var body interface{}
+	if err := json.Unmarshal(data, &body); err == nil {
+		e.Body = sanitize(body)
+	}
+	return e
+}
+
+// authorized records the pod the request is authorized on, if the request
+// does not name it
+func (e *AuditEntry) authorized(pod string) {
+	if e != nil && e.Pod == "" {
+		e.Pod = pod
+	}
+}
+
+// authorizedAs records the decision of the authorizers
+func (e *AuditEntry) authorizedAs(decision string) {
+	if e != nil {
+		e.Authz = decision
+	}
+}
+
+func (e *AuditEntry) finish(rec *auditRecorder, err error) {
+	e.Duration = int64(time.Since(e.Time) / time.Millisecond)
+	e.Code = rec.code
+	if e.Code == 0 {
+		e.Code = http.StatusOK
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	if e.Pod == "" && !rec.hijacked {
+		// e.g. the ID of the created pod
+		var res struct {
+			ID string `json:"ID"`
+		}
+		if json.Unmarshal(rec.head.Bytes(), &res) == nil && strings.HasPrefix(res.ID, "pod-") {
+			e.Pod = res.ID
+		}
+	}
+}
+
+func getAudit(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if auditLog == nil {
+		return fmt.Errorf("The audit log is not enabled")
+	}
+
+	q := &AuditQuery{
+		User:  r.Form.Get("user"),
+		Pod:   r.Form.Get("pod"),
+		Route: r.Form.Get("route"),
+	}
+	var err error
+	if q.Since, err = parseAuditTime(r.Form.Get("since")); err != nil {
+		return err
+	}
+	if q.Until, err = parseAuditTime(r.Form.Get("until")); err != nil {
+		return err
+	}
+	if q.Failed, err = getBoolParam(r.Form.Get("failed")); err != nil {
+		return err
+	}
+	if limit := r.Form.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return fmt.Errorf("Bad parameter limit %s", limit)
+		}
+	}
+	if q.Route != "" {
+		if _, err := path.Match(q.Route, ""); err != nil {
+			return fmt.Errorf("Bad parameter route %s", q.Route)
+		}
+	}
+
+	entries, err := auditLog.Search(q)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, entries)
+}
+
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Bad parameter timestamp %s", s)
+	}
+	return time.Unix(0, int64(sec*float64(time.Second))), nil
+}
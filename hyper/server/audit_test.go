@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditLogRotateAndSearch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	l, err := NewAuditLog(path, "1k", "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 100; i++ {
+		pod := "pod-a"
+		if i%2 == 1 {
+			pod = "pod-b"
+		}
+		l.Log(&AuditEntry{Method: "POST", Route: "/pod/start", URI: "/pod/start?podId=" + pod, Pod: pod, Code: http.StatusOK})
+	}
+
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 3 {
+		t.Fatalf("expect 3 log files, got %v", files)
+	}
+
+	entries, err := l.Search(&AuditQuery{Pod: "pod-b", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Pod != "pod-b" {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+	if entries, _ := l.Search(&AuditQuery{Failed: true}); len(entries) != 0 {
+		t.Fatalf("no failed call is logged, got %d", len(entries))
+	}
+}
+
+func TestAuditEntrySanitized(t *testing.T) {
+	body := `{"id": "web", "containers": [{"envs": [{"env": "DB_PASSWORD", "value": "s3cret"}, {"env": "PORT", "value": "80"}]}], "auth": {"token": "x"}}`
+	r, _ := http.NewRequest("POST", "/pod/create?password=p", bytes.NewBufferString(body))
+	r.RequestURI = "/pod/create?password=p"
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = "uid=1000,pid=42@1"
+
+	e := newAuditEntry("/pod/create", r)
+	if e.Uid == nil || *e.Uid != 1000 {
+		t.Fatal("the peer uid should be recorded")
+	}
+	if strings.Contains(e.URI, "password=p") {
+		t.Fatalf("the query is not sanitized: %s", e.URI)
+	}
+
+	entry := e.Body.(map[string]interface{})
+	if entry["auth"] != AUDIT_REDACTED {
+		t.Fatal("auth should be redacted")
+	}
+	envs := entry["containers"].([]interface{})[0].(map[string]interface{})["envs"].([]interface{})
+	if envs[0].(map[string]interface{})["value"] != AUDIT_REDACTED || envs[1].(map[string]interface{})["value"] != "80" {
+		t.Fatalf("unexpected envs %v", envs)
+	}
+
+	// the handler can still read the body
+	if data, _ := ioutil.ReadAll(r.Body); string(data) != body {
+		t.Fatal("the request body is consumed")
+	}
+}
+
+func TestAuditEntryFilesSanitized(t *testing.T) {
+	body := `{"id": "web", "files": [{"name": "npmrc", "encoding": "raw", "content": "//registry/:_authToken=x"}, {"name": "cfg", "uri": "data:,user=admin"}, {"name": "site", "uri": "http://example.com/site.conf"}], "containers": [{"files": [{"filename": "npmrc", "path": "/root/.npmrc"}]}]}`
+	r, _ := http.NewRequest("POST", "/pod/create", bytes.NewBufferString(body))
+	r.RequestURI = "/pod/create"
+	r.Header.Set("Content-Type", "application/json")
+
+	e := newAuditEntry("/pod/create", r)
+	files := e.Body.(map[string]interface{})["files"].([]interface{})
+	if files[0].(map[string]interface{})["content"] != AUDIT_REDACTED {
+		t.Fatalf("the content of the file should be redacted: %v", files[0])
+	}
+	if files[1].(map[string]interface{})["uri"] != AUDIT_REDACTED {
+		t.Fatalf("the data uri of the file should be redacted: %v", files[1])
+	}
+	if files[2].(map[string]interface{})["uri"] != "http://example.com/site.conf" {
+		t.Fatalf("the uri of the file should be kept: %v", files[2])
+	}
+	refs := e.Body.(map[string]interface{})["containers"].([]interface{})[0].(map[string]interface{})["files"].([]interface{})
+	if refs[0].(map[string]interface{})["path"] != "/root/.npmrc" {
+		t.Fatalf("the file references should be kept: %v", refs)
+	}
+}
@@ -0,0 +1,487 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/types"
+)
+
+const (
+	// the endpoint called on the authz plugins
+	AUTHZ_PLUGIN_ENDPOINT = "/AuthzPlugin.AuthzReq"
+	AUTHZ_PLUGIN_TIMEOUT  = 10 * time.Second
+)
+
+// AuthzRequest describes a client request to the authorizers. User and
+// Groups are the CN and OU of the client certificate, they are empty if the
+// client is not authenticated, e.g. on the unix socket.
+type AuthzRequest struct {
+	User   string            `json:"user"`
+	Groups []string          `json:"groups,omitempty"`
+	Method string            `json:"method"`
+	Route  string            `json:"route"`
+	URI    string            `json:"uri"`
+	Pod    string            `json:"pod,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type AuthzResponse struct {
+	Allow bool   `json:"allow"`
+	Msg   string `json:"msg,omitempty"`
+	Err   string `json:"err,omitempty"`
+}
+
+type Authorizer interface {
+	Name() string
+	Authorize(req *AuthzRequest) (*AuthzResponse, error)
+}
+
+// authzChain allows a request only if all the authorizers allow it
+type authzChain []Authorizer
+
+// NewAuthorizer creates the authorizer of the api server from the policy
+// file and the plugin sockets, nil is returned if none is configured.
+func NewAuthorizer(policy string, plugins []string) (Authorizer, error) {
+	var chain authzChain
+	if policy != "" {
+		p, err := newPolicyAuthorizer(policy)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, p)
+	}
+	for _, sock := range plugins {
+		if sock = strings.TrimSpace(sock); sock != "" {
+			chain = append(chain, newPluginAuthorizer(sock))
+		}
+	}
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	return chain, nil
+}
+
+func (c authzChain) Name() string {
+	names := []string{}
+	for _, a := range c {
+		names = append(names, a.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c authzChain) Authorize(req *AuthzRequest) (*AuthzResponse, error) {
+	for _, a := range c {
+		res, err := a.Authorize(req)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", a.Name(), err)
+		}
+		if !res.Allow {
+			if res.Msg == "" {
+				res.Msg = fmt.Sprintf("denied by %s", a.Name())
+			}
+			return res, nil
+		}
+	}
+	return &AuthzResponse{Allow: true}, nil
+}
+
+// AuthzPolicy is the built-in policy file, e.g.
+//
+//	{
+//	  "default": "deny",
+//	  "rules": [
+//	    {"groups": ["ops"], "routes": ["*"]},
+//	    {"users": ["web-ci"], "routes": ["GET *", "POST /pod/*", "POST /exec"], "labels": {"team": "web"}}
+//	  ]
+//	}
+//
+// A request is allowed if any rule matches, otherwise the default applies.
+type AuthzPolicy struct {
+	Default string      `json:"default"`
+	Rules   []AuthzRule `json:"rules"`
+}
+
+// AuthzRule matches the clients by the certificate CN (users) or OU
+// (groups), "*" in users stands for any authenticated client, and a rule
+// without users and groups applies to every client. A route is
+// "[METHOD] PATTERN", with PATTERN in path.Match syntax. If labels are
+// given, the rule only matches the requests on a pod carrying them.
+type AuthzRule struct {
+	Users  []string          `json:"users,omitempty"`
+	Groups []string          `json:"groups,omitempty"`
+	Routes []string          `json:"routes"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func (p *AuthzPolicy) validate() error {
+	switch p.Default {
+	case "", "allow", "deny":
+	default:
+		return fmt.Errorf("Invalid default policy %s", p.Default)
+	}
+	for _, rule := range p.Rules {
+		for _, route := range rule.Routes {
+			_, pattern := splitRoute(route)
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid route %s: %v", route, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *AuthzPolicy) decide(req *AuthzRequest) *AuthzResponse {
+	for i, rule := range p.Rules {
+		if rule.match(req) {
+			return &AuthzResponse{Allow: true, Msg: fmt.Sprintf("rule %d", i)}
+		}
+	}
+	if p.Default == "allow" {
+		return &AuthzResponse{Allow: true, Msg: "default"}
+	}
+	return &AuthzResponse{Allow: false, Msg: fmt.Sprintf("%s %s is not allowed for user %q", req.Method, req.Route, req.User)}
+}
+
+func splitRoute(route string) (string, string) {
+	fields := strings.Fields(route)
+	if len(fields) == 2 {
+		return strings.ToUpper(fields[0]), fields[1]
+	}
+	return "*", route
+}
+
+func (rule *AuthzRule) match(req *AuthzRequest) bool {
+	return rule.matchClient(req) && rule.matchRoute(req) && rule.matchLabels(req)
+}
+
+func (rule *AuthzRule) matchClient(req *AuthzRequest) bool {
+	if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+		return true
+	}
+	for _, u := range rule.Users {
+		if (u == "*" && req.User != "") || (u != "*" && u == req.User) {
+			return true
+		}
+	}
+	for _, g := range rule.Groups {
+		for _, ou := range req.Groups {
+			if g == ou {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (rule *AuthzRule) matchRoute(req *AuthzRequest) bool {
+	for _, route := range rule.Routes {
+		method, pattern := splitRoute(route)
+		if method != "*" && method != req.Method {
+			continue
+		}
+		if pattern == "*" {
+			return true
+		}
+		if ok, _ := path.Match(pattern, req.Route); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *AuthzRule) matchLabels(req *AuthzRequest) bool {
+	for k, v := range rule.Labels {
+		if value, ok := req.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// policyAuthorizer reloads the policy file once it is modified
+type policyAuthorizer struct {
+	sync.Mutex
+	file   string
+	mtime  time.Time
+	policy *AuthzPolicy
+}
+
+func newPolicyAuthorizer(file string) (*policyAuthorizer, error) {
+	p := &policyAuthorizer{file: file}
+	if err := p.reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *policyAuthorizer) Name() string {
+	return "policy"
+}
+
+func (p *policyAuthorizer) reload() error {
+	fi, err := os.Stat(p.file)
+	if err != nil {
+		return fmt.Errorf("Can not read authz policy: %v", err)
+	}
+	if p.policy != nil && fi.ModTime().Equal(p.mtime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(p.file)
+	if err != nil {
+		return fmt.Errorf("Can not read authz policy: %v", err)
+	}
+	var policy AuthzPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return fmt.Errorf("Can not parse authz policy %s: %v", p.file, err)
+	}
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	glog.V(1).Infof("authz policy %s is loaded, %d rules", p.file, len(policy.Rules))
+	p.policy = &policy
+	p.mtime = fi.ModTime()
+	return nil
+}
+
+func (p *policyAuthorizer) Authorize(req *AuthzRequest) (*AuthzResponse, error) {
+	p.Lock()
+	defer p.Unlock()
+	// keep using the last good policy if the new one is broken
+	if err := p.reload(); err != nil {
+		glog.Errorf("%v, keep the previous policy", err)
+	}
+	return p.policy.decide(req), nil
+}
+
+// pluginAuthorizer posts the request as JSON to a plugin listening on a
+// unix socket
+type pluginAuthorizer struct {
+	socket string
+	client *http.Client
+}
+
+func newPluginAuthorizer(socket string) *pluginAuthorizer {
+	return &pluginAuthorizer{
+		socket: socket,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(_, _ string) (net.Conn, error) {
+					return net.DialTimeout("unix", socket, AUTHZ_PLUGIN_TIMEOUT)
+				},
+			},
+			Timeout: AUTHZ_PLUGIN_TIMEOUT,
+		},
+	}
+}
+
+func (p *pluginAuthorizer) Name() string {
+	return "plugin:" + p.socket
+}
+
+func (p *pluginAuthorizer) Authorize(req *AuthzRequest) (*AuthzResponse, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Post("http://authz"+AUTHZ_PLUGIN_ENDPOINT, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	var res AuthzResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Err != "" {
+		return nil, fmt.Errorf("%s", res.Err)
+	}
+	return &res, nil
+}
+
+// newAuthzRequest collects the client identity and the pod targeted by the
+// request, the latter is needed by the label selectors. The pods and the
+// containers are resolved the way the daemon does.
+func newAuthzRequest(eng *engine.Engine, method, route string, r *http.Request) *AuthzRequest {
+	req := &AuthzRequest{
+		Method: method,
+		Route:  route,
+		URI:    r.RequestURI,
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		subject := r.TLS.PeerCertificates[0].Subject
+		req.User = subject.CommonName
+		req.Groups = subject.OrganizationalUnit
+	}
+
+	if route == "/pod/create" {
+		// the pod does not exist yet, it is authorized on the labels stored
+		// by the daemon once created, see authorizeCreatedPod
+		return req
+	}
+	if err := parseForm(r); err != nil {
+		return req
+	}
+
+	pod := r.Form.Get("podId")
+	if pod == "" {
+		pod = r.Form.Get("podName")
+	}
+	if pod == "" {
+		pod = r.Form.Get("pod")
+	}
+	container := r.Form.Get("container")
+	switch r.Form.Get("type") {
+	case "pod":
+		pod = r.Form.Get("value")
+	case "container":
+		container = r.Form.Get("value")
+	}
+
+	if pod != "" {
+		req.Pod, req.Labels = podForAuthz(eng, pod)
+	} else if container != "" {
+		if list, err := listForAuthz(eng, "container"); err == nil {
+			// the full ID, or the name with or without the leading slash
+			for _, c := range list.Containers {
+				if c.ID == container || c.Name == strings.TrimPrefix(container, "/") {
+					req.Pod, req.Labels = c.PodID, c.Labels
+					break
+				}
+			}
+		}
+	}
+	return req
+}
+
+// podForAuthz returns the ID and the labels of the pod, which is looked up
+// by the ID if it looks like one, otherwise by the name
+func podForAuthz(eng *engine.Engine, pod string) (string, map[string]string) {
+	list, err := listForAuthz(eng, "pod")
+	if err != nil {
+		return "", nil
+	}
+	byId := strings.Contains(pod, "pod-")
+	for _, p := range list.Pods {
+		if (byId && p.ID == pod) || (!byId && p.Name == pod) {
+			return p.ID, p.Labels
+		}
+	}
+	return "", nil
+}
+
+func listForAuthz(eng *engine.Engine, item string) (*types.ListResponse, error) {
+	job := eng.Job("list", item, "", "", "yes", "json")
+	stdoutBuf := bytes.NewBuffer(nil)
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return nil, err
+	}
+
+	var dat struct {
+		Data types.ListResponse `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return nil, err
+	}
+	return &dat.Data, nil
+}
+
+// authorize returns nil if the request is allowed, the decision is always
+// logged, and recorded in the audit entry of the request if there is one.
+func authorize(authz Authorizer, req *AuthzRequest, entry *AuditEntry) error {
+	entry.authorized(req.Pod)
+	res, err := authz.Authorize(req)
+	if err != nil {
+		glog.Errorf("authz: user=%q groups=%v %s %s pod=%s: error: %v", req.User, req.Groups, req.Method, req.URI, req.Pod, err)
+		entry.authorizedAs("error: " + err.Error())
+		return fmt.Errorf("Authorization failed: %v", err)
+	}
+	if !res.Allow {
+		glog.Warningf("authz: user=%q groups=%v %s %s pod=%s: denied: %s", req.User, req.Groups, req.Method, req.URI, req.Pod, res.Msg)
+		entry.authorizedAs("denied: " + res.Msg)
+		return fmt.Errorf("Authorization denied: %s", res.Msg)
+	}
+	glog.Infof("authz: user=%q groups=%v %s %s pod=%s: allowed: %s", req.User, req.Groups, req.Method, req.URI, req.Pod, res.Msg)
+	entry.authorizedAs("allowed: " + res.Msg)
+	return nil
+}
+
+// heldResponse keeps the response until the request is authorized
+type heldResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (h *heldResponse) Header() http.Header {
+	return h.header
+}
+
+func (h *heldResponse) WriteHeader(code int) {
+	if h.code == 0 {
+		h.code = code
+	}
+}
+
+func (h *heldResponse) Write(data []byte) (int, error) {
+	return h.body.Write(data)
+}
+
+func (h *heldResponse) flush(w http.ResponseWriter) {
+	for k, v := range h.header {
+		w.Header()[k] = v
+	}
+	if h.code != 0 {
+		w.WriteHeader(h.code)
+	}
+	w.Write(h.body.Bytes())
+}
+
+// authorizeCreatedPod wraps the creation of a pod, which is authorized on
+// the labels stored by the daemon rather than the ones in the request. The
+// response is held until then, and the pod denied is removed.
+func authorizeCreatedPod(eng *engine.Engine, authz Authorizer, method, route string, r *http.Request, entry *AuditEntry, create func(http.ResponseWriter) error) func(http.ResponseWriter) error {
+	return func(w http.ResponseWriter) error {
+		held := &heldResponse{header: http.Header{}}
+		if err := create(held); err != nil {
+			return err
+		}
+		var created struct {
+			ID string `json:"ID"`
+		}
+		if err := json.Unmarshal(held.body.Bytes(), &created); err != nil || created.ID == "" {
+			return fmt.Errorf("Authorization failed: the created pod is unknown")
+		}
+
+		req := newAuthzRequest(eng, method, route, r)
+		req.Pod, req.Labels = podForAuthz(eng, created.ID)
+		if req.Pod == "" {
+			req.Pod = created.ID
+		}
+		if err := authorize(authz, req, entry); err != nil {
+			job := eng.Job("podRm", created.ID)
+			job.Stdout.Add(ioutil.Discard)
+			if err := job.Run(); err != nil {
+				glog.Errorf("failed to remove the pod %s denied: %v", created.ID, err)
+			}
+			return err
+		}
+		held.flush(w)
+		return nil
+	}
+}
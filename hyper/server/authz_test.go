@@ -0,0 +1,220 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperhq/hyper/engine"
+	"github.com/hyperhq/hyper/lib/version"
+	"github.com/hyperhq/hyper/types"
+)
+
+func TestAuthzPolicy(t *testing.T) {
+	policy := &AuthzPolicy{
+		Rules: []AuthzRule{
+			{Groups: []string{"ops"}, Routes: []string{"*"}},
+			{Users: []string{"web-ci"}, Routes: []string{"GET *", "POST /pod/*"}, Labels: map[string]string{"team": "web"}},
+		},
+	}
+	if err := policy.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	web := map[string]string{"team": "web"}
+	cases := []struct {
+		req   AuthzRequest
+		allow bool
+	}{
+		{AuthzRequest{User: "alice", Groups: []string{"ops"}, Method: "DELETE", Route: "/vm"}, true},
+		{AuthzRequest{User: "web-ci", Method: "POST", Route: "/pod/start", Labels: web}, true},
+		{AuthzRequest{User: "web-ci", Method: "POST", Route: "/pod/start", Labels: map[string]string{"team": "db"}}, false},
+		{AuthzRequest{User: "web-ci", Method: "DELETE", Route: "/pod", Labels: web}, false},
+		{AuthzRequest{User: "", Method: "GET", Route: "/list"}, false},
+	}
+	for i, c := range cases {
+		if res := policy.decide(&c.req); res.Allow != c.allow {
+			t.Errorf("case %d: expect allow=%v, got %v (%s)", i, c.allow, res.Allow, res.Msg)
+		}
+	}
+
+	policy.Default = "allow"
+	if res := policy.decide(&AuthzRequest{Method: "GET", Route: "/list"}); !res.Allow {
+		t.Error("the default policy should apply")
+	}
+
+	bad := &AuthzPolicy{Rules: []AuthzRule{{Routes: []string{"GET /pod/["}}}}
+	if err := bad.validate(); err == nil {
+		t.Error("bad route pattern should be rejected")
+	}
+}
+
+func TestAuthzPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "authz.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(AUTHZ_PLUGIN_ENDPOINT, func(w http.ResponseWriter, r *http.Request) {
+		var req AuthzRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(&AuthzResponse{Allow: req.Method == "GET", Msg: "read only"})
+	})
+	go http.Serve(l, mux)
+
+	authz, err := NewAuthorizer("", []string{sock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := authz.Authorize(&AuthzRequest{Method: "GET", Route: "/list"}); err != nil || !res.Allow {
+		t.Fatalf("GET should be allowed: %v", err)
+	}
+	if res, err := authz.Authorize(&AuthzRequest{Method: "DELETE", Route: "/vm"}); err != nil || res.Allow {
+		t.Fatalf("DELETE should be denied: %v", err)
+	}
+}
+
+// fakeAuthzDaemon keeps the pods and their containers for the list job, the
+// created pods carry the labels the daemon decides
+type fakeAuthzDaemon struct {
+	pods       []types.PodListItem
+	containers []types.ContainerListItem
+	stored     map[string]string
+	removed    []string
+}
+
+func (d *fakeAuthzDaemon) engine(t *testing.T) *engine.Engine {
+	eng := engine.New("")
+	for name, handler := range map[string]engine.Handler{
+		"list": func(job *engine.Job) error {
+			res := types.ListResponse{Item: job.Args[0], Pods: d.pods, Containers: d.containers}
+			return json.NewEncoder(job.Stdout).Encode(map[string]interface{}{"data": res})
+		},
+		"podCreate": func(job *engine.Job) error {
+			d.pods = append(d.pods, types.PodListItem{ID: "pod-new", Name: "new", Labels: d.stored})
+			v := &engine.Env{}
+			v.Set("ID", "pod-new")
+			v.SetInt("Code", 0)
+			v.Set("Cause", "")
+			_, err := v.WriteTo(job.Stdout)
+			return err
+		},
+		"podRm": func(job *engine.Job) error {
+			d.removed = append(d.removed, job.Args[0])
+			return nil
+		},
+	} {
+		if err := eng.Register(name, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return eng
+}
+
+func authzTestRequest(method, uri, body string) *http.Request {
+	r, _ := http.NewRequest(method, uri, strings.NewReader(body))
+	r.RequestURI = uri
+	r.Header.Set("Content-Type", "application/json")
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "web-ci"}}}}
+	return r
+}
+
+func TestAuthzStoredLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hyper-authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policy := filepath.Join(dir, "policy.json")
+	if err := ioutil.WriteFile(policy, []byte(`{"default": "deny", "rules": [{"users": ["web-ci"], "routes": ["*"], "labels": {"team": "web"}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	authz, err := NewAuthorizer(policy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditLog, err = NewAuditLog(filepath.Join(dir, "audit.log"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		auditLog.Close()
+		auditLog = nil
+	}()
+
+	d := &fakeAuthzDaemon{
+		pods: []types.PodListItem{
+			{ID: "pod-web", Name: "web", Labels: map[string]string{"team": "web"}},
+			{ID: "pod-db", Name: "pod-web-db", Labels: map[string]string{"team": "db"}},
+		},
+		containers: []types.ContainerListItem{
+			{ID: "c0123456789", Name: "nginx", PodID: "pod-web", Labels: map[string]string{"team": "web"}},
+		},
+		stored: map[string]string{"team": "db"},
+	}
+	eng := d.engine(t)
+
+	// the pods and the containers are resolved exactly
+	for uri, pod := range map[string]string{
+		"/pod/info?podName=web":                 "pod-web",
+		"/pod/info?podName=pod-web":             "pod-web",
+		"/pod/info?podName=pod-web-db":          "",
+		"/container/info?container=nginx":       "pod-web",
+		"/container/info?container=/nginx":      "pod-web",
+		"/container/info?container=c0123456789": "pod-web",
+		"/container/info?container=c0123":       "",
+	} {
+		route := uri[:strings.Index(uri, "?")]
+		if req := newAuthzRequest(eng, "GET", route, authzTestRequest("GET", uri, "")); req.Pod != pod {
+			t.Errorf("%s: expect pod %q, got %q", uri, pod, req.Pod)
+		}
+	}
+
+	create := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h := makeHttpHandler(eng, false, "POST", "/pod/create", postPodCreate, "", version.Version("1.0"), authz)
+		h(w, authzTestRequest("POST", "/pod/create", `{"labels": {"team": "web"}}`))
+		return w
+	}
+
+	// the labels asked in the request are not the ones stored
+	if w := create(); w.Code != http.StatusForbidden || strings.Contains(w.Body.String(), "pod-new") {
+		t.Fatalf("the pod stored with the other labels should be denied, got %d %s", w.Code, w.Body.String())
+	}
+	if len(d.removed) != 1 || d.removed[0] != "pod-new" {
+		t.Fatalf("the denied pod should be removed, removed %v", d.removed)
+	}
+
+	d.pods = d.pods[:2]
+	d.stored = map[string]string{"team": "web"}
+	if w := create(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "pod-new") {
+		t.Fatalf("the pod stored with the labels should be allowed, got %d %s", w.Code, w.Body.String())
+	}
+
+	entries, err := auditLog.Search(&AuditQuery{Pod: "pod-new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !strings.HasPrefix(entries[0].Authz, "denied") || !strings.HasPrefix(entries[1].Authz, "allowed") {
+		t.Fatalf("the decisions should be audited, got %v", entries)
+	}
+}
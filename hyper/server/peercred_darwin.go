@@ -0,0 +1,13 @@
+// +build darwin
+
+package server
+
+import (
+	"net"
+)
+
+// the peer credentials are not available, the audit log has no uid of the
+// unix socket clients
+func newUnixPeerListener(l net.Listener) net.Listener {
+	return l
+}
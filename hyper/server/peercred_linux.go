@@ -0,0 +1,67 @@
+// +build linux
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// unixPeerAddr carries the credentials of a unix socket client to the
+// handlers, through http.Request.RemoteAddr.
+type unixPeerAddr struct {
+	uid, pid uint32
+	seq      uint64
+}
+
+func (a *unixPeerAddr) Network() string {
+	return "unix"
+}
+
+func (a *unixPeerAddr) String() string {
+	return fmt.Sprintf("uid=%d,pid=%d@%d", a.uid, a.pid, a.seq)
+}
+
+type unixPeerConn struct {
+	*net.UnixConn
+	addr *unixPeerAddr
+}
+
+func (c *unixPeerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+type unixPeerListener struct {
+	net.Listener
+	seq uint64
+}
+
+func (l *unixPeerListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil
+	}
+	f, err := uc.File()
+	if err != nil {
+		return conn, nil
+	}
+	defer f.Close()
+	cred, err := syscall.GetsockoptUcred(int(f.Fd()), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return conn, nil
+	}
+	return &unixPeerConn{
+		UnixConn: uc,
+		addr:     &unixPeerAddr{uid: cred.Uid, pid: uint32(cred.Pid), seq: atomic.AddUint64(&l.seq, 1)},
+	}, nil
+}
+
+func newUnixPeerListener(l net.Listener) net.Listener {
+	return &unixPeerListener{Listener: l}
+}
@@ -14,6 +14,7 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -126,6 +127,8 @@ func httpError(w http.ResponseWriter, err error) {
 		statusCode = http.StatusUnauthorized
 	} else if strings.Contains(errStr, "hasn't been activated") {
 		statusCode = http.StatusForbidden
+	} else if strings.HasPrefix(errStr, "authorization denied") || strings.HasPrefix(errStr, "authorization failed") {
+		statusCode = http.StatusForbidden
 	}
 
 	if err != nil {
@@ -189,7 +192,13 @@ func getList(eng *engine.Engine, version version.Version, w http.ResponseWriter,
 	vm := r.Form.Get("vm")
 
 	glog.V(1).Infof("List type is %s, specified pod: [%s], specified vm: [%s], list auxiliary pod: %s", item, pod, vm, auxiliary)
-	job := eng.Job("list", item, pod, vm, auxiliary)
+	args := []string{item, pod, vm, auxiliary}
+	// the typed list is returned since 1.18
+	structured := !version.LessThan("1.18")
+	if structured {
+		args = append(append(args, "json"), r.Form["filter"]...)
+	}
+	job := eng.Job("list", args...)
 
 	stdoutBuf := bytes.NewBuffer(nil)
 
@@ -200,6 +209,13 @@ func getList(eng *engine.Engine, version version.Version, w http.ResponseWriter,
 	}
 
 	str := engine.Tail(stdoutBuf, 1)
+	if structured {
+		var dat map[string]interface{}
+		if err := json.Unmarshal([]byte(str), &dat); err != nil {
+			return err
+		}
+		return writeJSON(w, http.StatusOK, dat["data"])
+	}
 	type listResponse struct {
 		Item    string   `json:"item"`
 		PodData []string `json:"podData"`
@@ -382,13 +398,216 @@ func getContainerLogs(eng *engine.Engine, version version.Version, w http.Respon
 	return nil
 }
 
+func getEvents(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	args := append([]string{r.Form.Get("since"), r.Form.Get("until")}, r.Form["filter"]...)
+	job := eng.Job("events", args...)
+
+	w.Header().Set("Content-Type", "application/json")
+	output := ioutils.NewWriteFlusher(w)
+	job.Stdout.Add(output)
+
+	// the job stops streaming once its stdin is closed
+	done := make(chan struct{})
+	defer close(done)
+	closed, closer := io.Pipe()
+	job.Stdin.Add(closed)
+	go func() {
+		var notify <-chan bool
+		if cn, ok := w.(http.CloseNotifier); ok {
+			notify = cn.CloseNotify()
+		}
+		select {
+		case <-notify:
+		case <-done:
+		}
+		closer.Close()
+	}()
+
+	output.Flush()
+	if err := job.Run(); err != nil {
+		output.Write([]byte(err.Error()))
+		return err
+	}
+
+	return nil
+}
+
+// setContainerPathStat sets the stat of the path in the container to the
+// header of the response, the same as docker
+func setContainerPathStat(eng *engine.Engine, w http.ResponseWriter, container, path string) error {
+	job := eng.Job("containerStatPath", container, path)
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var dat map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return err
+	}
+	w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(dat["data"]))
+	return nil
+}
+
+func headContainerArchive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	if err := setContainerPathStat(eng, w, r.Form.Get("container"), r.Form.Get("path")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func getContainerArchive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	container, path := r.Form.Get("container"), r.Form.Get("path")
+	if err := setContainerPathStat(eng, w, container, path); err != nil {
+		return err
+	}
+
+	job := eng.Job("containerGetArchive", container, path)
+	w.Header().Set("Content-Type", "application/x-tar")
+	output := ioutils.NewWriteFlusher(w)
+	job.Stdout.Add(output)
+	return job.Run()
+}
+
+func putContainerArchive(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	noOverwriteDirNonDir := "no"
+	if v, _ := strconv.ParseBool(r.Form.Get("noOverwriteDirNonDir")); v {
+		noOverwriteDirNonDir = "yes"
+	}
+	job := eng.Job("containerPutArchive", r.Form.Get("container"), r.Form.Get("path"), noOverwriteDirNonDir)
+	job.Stdin.Add(r.Body)
+	if err := job.Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func getVmInfo(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("vmInfo", r.Form.Get("id"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, dat["data"])
+}
+
+func getSystemDf(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("systemDf", r.Form.Get("size"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var dat map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, dat["data"])
+}
+
+func postSystemPrune(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("systemPrune", r.Form.Get("dryrun"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var dat map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return err
+	}
+	return writeJSON(w, http.StatusOK, dat["data"])
+}
+
+func getVmConsole(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("vmConsole", r.Form.Get("id"), r.Form.Get("follow"))
+
+	w.Header().Set("Content-Type", "plain/text")
+	output := ioutils.NewWriteFlusher(w)
+	job.Stdout.Add(output)
+
+	// the job stops following once its stdin is closed
+	done := make(chan struct{})
+	defer close(done)
+	closed, closer := io.Pipe()
+	job.Stdin.Add(closed)
+	go func() {
+		var notify <-chan bool
+		if cn, ok := w.(http.CloseNotifier); ok {
+			notify = cn.CloseNotify()
+		}
+		select {
+		case <-notify:
+		case <-done:
+		}
+		closer.Close()
+	}()
+
+	if err := job.Run(); err != nil {
+		output.Write([]byte(err.Error()))
+		return err
+	}
+
+	return nil
+}
+
 func postStop(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
 	}
 
 	glog.V(1).Infof("Stop the POD name is %s", r.Form.Get("podName"))
-	job := eng.Job("podStop", r.Form.Get("podId"), r.Form.Get("stopVm"))
+	job := eng.Job("podStop", r.Form.Get("podId"), r.Form.Get("stopVm"), r.Form.Get("time"), r.Form.Get("signal"))
 	stdoutBuf := bytes.NewBuffer(nil)
 	job.Stdout.Add(stdoutBuf)
 
@@ -485,6 +704,36 @@ func postExec(eng *engine.Engine, version version.Version, w http.ResponseWriter
 		errStream io.Writer
 	)
 
+	if env := r.Form.Get("env"); env != "" {
+		var envs []string
+		if err := json.Unmarshal([]byte(env), &envs); err != nil {
+			return err
+		}
+		job.SetenvList("Env", envs)
+	}
+	job.Setenv("User", r.Form.Get("user"))
+	job.Setenv("Workdir", r.Form.Get("workdir"))
+
+	// a detached exec replies the ID of the session, without streams
+	if r.Form.Get("detach") == "yes" {
+		job.SetenvBool("Detach", true)
+		stdoutBuf := bytes.NewBuffer(nil)
+		job.Stdout.Add(stdoutBuf)
+		if err := job.Run(); err != nil {
+			return err
+		}
+
+		var (
+			env engine.Env
+			dat map[string]interface{}
+		)
+		if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+			return err
+		}
+		env.Set("ID", dat["ID"].(string))
+		return writeJSONEnv(w, http.StatusCreated, env)
+	}
+
 	// Setting up the streaming http interface.
 	inStream, outStream, err := hijackServer(w)
 	if err != nil {
@@ -510,6 +759,27 @@ func postExec(eng *engine.Engine, version version.Version, w http.ResponseWriter
 	return nil
 }
 
+func getExecInspect(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("execInspect", r.Form.Get("id"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, dat["data"])
+}
+
 func postAttach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -546,6 +816,35 @@ func postAttach(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	return nil
 }
 
+func postVmAttachConsole(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("vmAttachConsole", r.Form.Get("id"))
+
+	// Setting up the streaming http interface.
+	inStream, outStream, err := hijackServer(w)
+	if err != nil {
+		return err
+	}
+	defer closeStreams(inStream, outStream)
+
+	fmt.Fprintf(outStream, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+
+	job.Stdin.Add(inStream)
+	job.Stdout.Add(outStream)
+	job.Stderr.Set(outStream)
+
+	job.SetCloseIO(false)
+	if err := job.Run(); err != nil {
+		fmt.Fprintf(outStream, "Error attaching the console of VM %s: %s\r\n", r.Form.Get("id"), err.Error())
+		return err
+	}
+
+	return nil
+}
+
 func postAuth(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	job := eng.Job("auth")
 	job.Stdin.Add(r.Body)
@@ -906,6 +1205,56 @@ func postImageBuild(eng *engine.Engine, version version.Version, w http.Response
 	w.Header().Set("Content-Type", "application/json")
 	glog.V(1).Infof("Image name is %s", r.Form.Get("name"))
 	job := eng.Job("build", r.Form.Get("name"), fmt.Sprintf("%d", r.ContentLength))
+	imageBuildConfig := &types.ImageBuildConfig{}
+	if cpu := r.Form.Get("cpu"); cpu != "" {
+		n, err := strconv.Atoi(cpu)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid cpu value: %s", cpu)
+		}
+		imageBuildConfig.VmCpu = n
+	}
+	if memory := r.Form.Get("memory"); memory != "" {
+		n, err := strconv.Atoi(memory)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid memory value: %s", memory)
+		}
+		imageBuildConfig.VmMemory = n
+	}
+	// the secrets are sent in a header rather than the form, to be kept out
+	// of the logs of the requests
+	if secretsEncoded := r.Header.Get("X-Build-Secrets"); secretsEncoded != "" {
+		secretsJson := base64.NewDecoder(base64.URLEncoding, strings.NewReader(secretsEncoded))
+		if err := json.NewDecoder(secretsJson).Decode(&imageBuildConfig.Secrets); err != nil {
+			return fmt.Errorf("invalid build secrets: %v", err)
+		}
+	}
+	if len(r.Form["ssh"]) > 0 {
+		// the daemon connects to the agents for the client, which must be
+		// on this host and own them
+		uid, ok := peerUid(r)
+		if !ok {
+			return fmt.Errorf("ssh agents are forwarded for the clients of the local unix socket only")
+		}
+		imageBuildConfig.SSHAgentUid = uid
+	}
+	for _, ssh := range r.Form["ssh"] {
+		id, socket := ssh, ""
+		if i := strings.Index(ssh, "="); i >= 0 {
+			id, socket = ssh[:i], ssh[i+1:]
+		}
+		if id == "" || socket == "" {
+			return fmt.Errorf("invalid ssh value: %s", ssh)
+		}
+		if err := checkSSHAgent(socket, imageBuildConfig.SSHAgentUid); err != nil {
+			return err
+		}
+		if imageBuildConfig.SSHAgents == nil {
+			imageBuildConfig.SSHAgents = map[string]string{}
+		}
+		imageBuildConfig.SSHAgents[id] = socket
+	}
+	job.SetenvJson("ImageBuildConfig", imageBuildConfig)
+	exportCache := r.Form.Get("exportcache") == "yes"
 	stdoutBuf := bytes.NewBuffer(nil)
 
 	job.Stdout.Add(stdoutBuf)
@@ -914,10 +1263,60 @@ func postImageBuild(eng *engine.Engine, version version.Version, w http.Response
 	if err := job.Run(); err != nil {
 		sf := streamformatter.NewJSONStreamFormatter()
 		output.Write(sf.FormatError(err))
+		return nil
+	}
+
+	// the images of the build cache are sent at the end of the stream, for
+	// the client to save them
+	if exportCache {
+		var dat map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+			return err
+		}
+		return json.NewEncoder(output).Encode(map[string]json.RawMessage{"cacheImages": dat["CacheImages"]})
+	}
+	return nil
+}
+
+// checkSSHAgent makes sure the ssh agent socket is owned by uid, the daemon
+// must not connect to the sockets of the others for the client
+func checkSSHAgent(socket string, uid uint32) error {
+	if !filepath.IsAbs(socket) {
+		return fmt.Errorf("invalid ssh agent %s: the path is not absolute", socket)
+	}
+	fi, err := os.Stat(socket)
+	if err != nil {
+		return fmt.Errorf("invalid ssh agent %s: %v", socket, err)
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("invalid ssh agent %s: not a socket", socket)
+	}
+	if uid != 0 && st.Uid != uid {
+		return fmt.Errorf("ssh agent %s is not owned by the client", socket)
 	}
 	return nil
 }
 
+func getImageSave(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("imagesave", r.Form["names"]...)
+	w.Header().Set("Content-Type", "application/x-tar")
+	output := ioutils.NewWriteFlusher(w)
+	job.Stdout.Add(output)
+	return job.Run()
+}
+
+func postImageLoad(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("imageload")
+	job.Stdin.Add(r.Body)
+	job.Stdout.Add(ioutils.NewWriteFlusher(w))
+	return job.Run()
+}
+
 func postImagePush(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -1140,6 +1539,216 @@ func delService(eng *engine.Engine, version version.Version, w http.ResponseWrit
 	return writeJSONEnv(w, http.StatusOK, env)
 }
 
+func postPodVolumeAttach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("podVolumeAttach", r.Form.Get("podId"), r.Form.Get("volume"), r.Form.Get("mounts"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.Set("Volume", dat["Volume"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postPodVolumeDetach(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("podVolumeDetach", r.Form.Get("podId"), r.Form.Get("volume"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		env             engine.Env
+		dat             map[string]interface{}
+		returnedJSONstr string
+	)
+	returnedJSONstr = engine.Tail(stdoutBuf, 1)
+	if err := json.Unmarshal([]byte(returnedJSONstr), &dat); err != nil {
+		return err
+	}
+
+	env.Set("ID", dat["ID"].(string))
+	env.Set("Volume", dat["Volume"].(string))
+	env.SetInt("Code", (int)(dat["Code"].(float64)))
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getSecrets(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("secretList")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	str := engine.Tail(stdoutBuf, 1)
+	type response struct {
+		Secrets []string `json:"secretList"`
+	}
+	var res response
+	if err := json.Unmarshal([]byte(str), &res); err != nil {
+		return err
+	}
+	var env engine.Env
+	env.SetList("secretList", res.Secrets)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postSecretCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	// the daemon rejects anything larger than this, so don't read more
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+
+	job := eng.Job("secretCreate", r.Form.Get("name"), string(data))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var env engine.Env
+	env.Set("ID", r.Form.Get("name"))
+	return writeJSONEnv(w, http.StatusCreated, env)
+}
+
+func delSecret(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("secretRemove", r.Form.Get("name"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var env engine.Env
+	env.Set("ID", r.Form.Get("name"))
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getTemplates(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("templateList")
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	type response struct {
+		Templates []string `json:"templateList"`
+	}
+	var res response
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &res); err != nil {
+		return err
+	}
+
+	var env engine.Env
+	env.SetList("templateList", res.Templates)
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func getTemplateRender(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	args := append([]string{r.Form.Get("name")}, r.Form["set"]...)
+	job := eng.Job("templateRender", args...)
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var dat map[string]interface{}
+	if err := json.Unmarshal([]byte(engine.Tail(stdoutBuf, 1)), &dat); err != nil {
+		return err
+	}
+
+	var env engine.Env
+	env.Set("ID", dat["ID"].(string))
+	env.Set("Spec", dat["Spec"].(string))
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
+func postTemplateCreate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		return err
+	}
+
+	job := eng.Job("templateCreate", r.Form.Get("name"), string(data))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var env engine.Env
+	env.Set("ID", r.Form.Get("name"))
+	return writeJSONEnv(w, http.StatusCreated, env)
+}
+
+func delTemplate(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+
+	job := eng.Job("templateRemove", r.Form.Get("name"))
+	stdoutBuf := bytes.NewBuffer(nil)
+
+	job.Stdout.Add(stdoutBuf)
+	if err := job.Run(); err != nil {
+		return err
+	}
+
+	var env engine.Env
+	env.Set("ID", r.Form.Get("name"))
+	return writeJSONEnv(w, http.StatusOK, env)
+}
+
 func optionsHandler(eng *engine.Engine, version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	w.WriteHeader(http.StatusOK)
 	return nil
@@ -1156,7 +1765,7 @@ func ping(eng *engine.Engine, version version.Version, w http.ResponseWriter, r
 	return err
 }
 
-func makeHttpHandler(eng *engine.Engine, logging bool, localMethod string, localRoute string, handlerFunc HttpApiFunc, corsHeaders string, dockerVersion version.Version) http.HandlerFunc {
+func makeHttpHandler(eng *engine.Engine, logging bool, localMethod string, localRoute string, handlerFunc HttpApiFunc, corsHeaders string, dockerVersion version.Version, authz Authorizer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// log the request
 		glog.V(0).Infof("Calling %s %s", localMethod, localRoute)
@@ -1184,7 +1793,32 @@ func makeHttpHandler(eng *engine.Engine, logging bool, localMethod string, local
 			return
 		}
 
-		if err := handlerFunc(eng, version, w, r, mux.Vars(r)); err != nil {
+		var err error
+		var entry *AuditEntry
+		// the decisions of the authorizers are audited for all the methods
+		if auditLog != nil && (auditable(localMethod) || authz != nil) {
+			rec := &auditRecorder{ResponseWriter: w}
+			entry = newAuditEntry(localRoute, r)
+			w = rec
+			defer func() {
+				entry.finish(rec, err)
+				auditLog.Log(entry)
+			}()
+		}
+
+		handle := func(w http.ResponseWriter) error {
+			return handlerFunc(eng, version, w, r, mux.Vars(r))
+		}
+		if authz != nil && localMethod != "OPTIONS" {
+			if localRoute == "/pod/create" {
+				handle = authorizeCreatedPod(eng, authz, localMethod, localRoute, r, entry, handle)
+			} else if err = authorize(authz, newAuthzRequest(eng, localMethod, localRoute, r), entry); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+
+		if err = handle(w); err != nil {
 			glog.Errorf("Handler for %s %s returned error: %s", localMethod, localRoute, err)
 			httpError(w, err)
 		}
@@ -1218,50 +1852,76 @@ func AttachProfiler(router *mux.Router) {
 	router.HandleFunc("/debug/pprof/threadcreate", pprof.Handler("threadcreate").ServeHTTP)
 }
 
-func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders string, dockerVersion string) *mux.Router {
+func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders string, dockerVersion string, authz Authorizer) *mux.Router {
 	r := mux.NewRouter()
 	if os.Getenv("DEBUG") != "" {
 		AttachProfiler(r)
 	}
 	m := map[string]map[string]HttpApiFunc{
 		"GET": {
-			"/container/info": getContainerInfo,
-			"/container/logs": getContainerLogs,
-			"/info":           getInfo,
-			"/images/get":     getImages,
-			"/list":           getList,
-			"/pod/info":       getPodInfo,
-			"/pod/stats":      getPodStats,
-			"/service/list":   getServices,
-			"/exitcode":       getExitCode,
-			"/version":        getVersion,
+			"/audit":             getAudit,
+			"/container/archive": getContainerArchive,
+			"/container/info":    getContainerInfo,
+			"/container/logs":    getContainerLogs,
+			"/events":            getEvents,
+			"/exec/inspect":      getExecInspect,
+			"/info":              getInfo,
+			"/images/get":        getImages,
+			"/image/save":        getImageSave,
+			"/list":              getList,
+			"/pod/info":          getPodInfo,
+			"/pod/stats":         getPodStats,
+			"/service/list":      getServices,
+			"/secret/list":       getSecrets,
+			"/template/list":     getTemplates,
+			"/template/render":   getTemplateRender,
+			"/exitcode":          getExitCode,
+			"/version":           getVersion,
+			"/vm/console":        getVmConsole,
+			"/vm/info":           getVmInfo,
+			"/system/df":         getSystemDf,
 		},
 		"POST": {
-			"/auth":             postAuth,
-			"/attach":           postAttach,
-			"/container/create": postContainerCreate,
-			"/container/commit": postContainerCommit,
-			"/container/rename": postContainerRename,
-			"/exec":             postExec,
-			"/image/create":     postImageCreate,
-			"/image/build":      postImageBuild,
-			"/image/push":       postImagePush,
-			"/pod/create":       postPodCreate,
-			"/pod/labels":       postPodLabels,
-			"/pod/start":        postPodStart,
-			"/pod/stop":         postStop,
-			"/pod/migrate":		 postMigrate,
-			"/pod/listen":		 postListen,
-			"/service/add":      postServiceAdd,
-			"/service/update":   postServiceUpdate,
-			"/tty/resize":       postTtyResize,
-			"/vm/create":        postVmCreate,
+			"/auth":              postAuth,
+			"/attach":            postAttach,
+			"/container/create":  postContainerCreate,
+			"/container/commit":  postContainerCommit,
+			"/container/rename":  postContainerRename,
+			"/exec":              postExec,
+			"/image/create":      postImageCreate,
+			"/image/build":       postImageBuild,
+			"/image/load":        postImageLoad,
+			"/image/push":        postImagePush,
+			"/pod/create":        postPodCreate,
+			"/pod/labels":        postPodLabels,
+			"/pod/start":         postPodStart,
+			"/secret/create":     postSecretCreate,
+			"/system/prune":      postSystemPrune,
+			"/template/create":   postTemplateCreate,
+			"/pod/stop":          postStop,
+			"/pod/migrate":       postMigrate,
+			"/pod/listen":        postListen,
+			"/pod/volume/attach": postPodVolumeAttach,
+			"/pod/volume/detach": postPodVolumeDetach,
+			"/service/add":       postServiceAdd,
+			"/service/update":    postServiceUpdate,
+			"/tty/resize":        postTtyResize,
+			"/vm/create":         postVmCreate,
+			"/vm/console/attach": postVmAttachConsole,
+		},
+		"HEAD": {
+			"/container/archive": headContainerArchive,
+		},
+		"PUT": {
+			"/container/archive": putContainerArchive,
 		},
 		"DELETE": {
-			"/image":   delImages,
-			"/pod":     delPod,
-			"/service": delService,
-			"/vm":      delVm,
+			"/image":    delImages,
+			"/pod":      delPod,
+			"/secret":   delSecret,
+			"/template": delTemplate,
+			"/service":  delService,
+			"/vm":       delVm,
 		},
 		"OPTIONS": {
 			"": optionsHandler,
@@ -1283,7 +1943,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 			localMethod := method
 
 			// build the handler function
-			f := makeHttpHandler(eng, logging, localMethod, localRoute, localFct, corsHeaders, version.Version(dockerVersion))
+			f := makeHttpHandler(eng, logging, localMethod, localRoute, localFct, corsHeaders, version.Version(dockerVersion), authz)
 
 			// add the new route
 			if localRoute == "" {
@@ -1302,7 +1962,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, corsHeaders stri
 // FIXME: refactor this to be part of Server and not require re-creating a new
 // router each time. This requires first moving ListenAndServe into Server.
 func ServeRequest(eng *engine.Engine, apiversion version.Version, w http.ResponseWriter, req *http.Request) {
-	router := createRouter(eng, false, true, "", "")
+	router := createRouter(eng, false, true, "", "", nil)
 	// Insert APIVERSION into the request as a convenience
 	req.URL.Path = fmt.Sprintf("/v%s%s", apiversion, req.URL.Path)
 	router.ServeHTTP(w, req)
@@ -1377,12 +2037,21 @@ func allocateDaemonPort(addr string) error {
 }
 
 func setupTcpHttp(addr string, job *engine.Job) (*HttpServer, error) {
-	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"))
+	authz, err := NewAuthorizer(job.Getenv("AuthzPolicy"), job.GetenvList("AuthzPlugins"))
+	if err != nil {
+		return nil, err
+	}
+	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"), authz)
 
 	l, err := newListener("tcp", addr, job.GetenvBool("BufferRequests"))
 	if err != nil {
 		return nil, err
 	}
+	if cert := job.Getenv("TlsCert"); cert != "" {
+		if l, err = setupTls(cert, job.Getenv("TlsKey"), job.Getenv("TlsCaCert"), l); err != nil {
+			return nil, err
+		}
+	}
 	if err := allocateDaemonPort(addr); err != nil {
 		return nil, err
 	}
@@ -1407,6 +2076,17 @@ func ServeApi(job *engine.Job) error {
 	)
 	activationLock = make(chan struct{})
 
+	if path := job.Getenv("AuditLog"); path != "" && auditLog == nil {
+		l, err := NewAuditLog(path, job.Getenv("AuditLogMaxSize"), job.Getenv("AuditLogMaxFiles"))
+		if err != nil {
+			return fmt.Errorf("Can not open audit log %s: %v", path, err)
+		}
+		auditLog = l
+		job.Eng.OnShutdown(func() {
+			auditLog.Close()
+		})
+	}
+
 	for _, protoAddr := range protoAddrs {
 		protoAddrParts := strings.SplitN(protoAddr, "://", 2)
 		if len(protoAddrParts) != 2 {
@@ -1457,7 +2137,11 @@ func NewServer(proto, addr string, job *engine.Job) (Server, error) {
 }
 
 func setupUnixHttp(addr string, job *engine.Job) (*HttpServer, error) {
-	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"))
+	authz, err := NewAuthorizer(job.Getenv("AuthzPolicy"), job.GetenvList("AuthzPlugins"))
+	if err != nil {
+		return nil, err
+	}
+	r := createRouter(job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("CorsHeaders"), job.Getenv("Version"), authz)
 
 	if err := syscall.Unlink(addr); err != nil && !os.IsNotExist(err) {
 		return nil, err
@@ -1469,6 +2153,7 @@ func setupUnixHttp(addr string, job *engine.Job) (*HttpServer, error) {
 	if err != nil {
 		return nil, err
 	}
+	l = newUnixPeerListener(l)
 
 	if err := setSocketGroup(addr, job.Getenv("SocketGroup")); err != nil {
 		return nil, err
@@ -247,6 +247,39 @@ func CreateVolume(poolName, volName, dev_id string, size int, restore bool) erro
 	return nil
 }
 
+// VolumeSize returns the space allocated to the thin volume in the pool
+func VolumeSize(volName string) (int64, error) {
+	parms := fmt.Sprintf("dmsetup status %s", volName)
+	res, err := exec.Command("/bin/sh", "-c", parms).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf(string(res))
+	}
+	// <start> <length> thin <mapped sectors> <highest mapped sector>
+	fields := strings.Fields(string(res))
+	if len(fields) < 4 || fields[2] != "thin" {
+		return 0, fmt.Errorf("unexpected status of %s: %s", volName, string(res))
+	}
+	var sectors int64
+	if _, err := fmt.Sscanf(fields[3], "%d", &sectors); err != nil {
+		return 0, fmt.Errorf("unexpected status of %s: %s", volName, string(res))
+	}
+	return sectors * 512, nil
+}
+
+// RemoveDevice deactivates the device of the volume, so that the thin volume
+// can be deleted from the pool
+func RemoveDevice(volName string) error {
+	if _, err := os.Stat("/dev/mapper/" + volName); err != nil {
+		return nil
+	}
+	parms := fmt.Sprintf("dmsetup remove \"/dev/mapper/%s\"", volName)
+	if res, err := exec.Command("/bin/sh", "-c", parms).CombinedOutput(); err != nil {
+		glog.Error(string(res))
+		return fmt.Errorf(string(res))
+	}
+	return nil
+}
+
 func DeleteVolume(dm *DeviceMapper, dev_id int) error {
 	var parms string
 	// Delete the thin pool for test
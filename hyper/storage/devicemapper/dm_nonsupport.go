@@ -64,6 +64,14 @@ func CreateVolume(poolName, volName, dev_id string, size int, restore bool) erro
 	return nil
 }
 
+func VolumeSize(volName string) (int64, error) {
+	return 0, nil
+}
+
+func RemoveDevice(volName string) error {
+	return nil
+}
+
 func DeleteVolume(dm *DeviceMapper, dev_id int) error {
 	return nil
 }
@@ -10,8 +10,11 @@ import (
 	"github.com/hyperhq/hyper/utils"
 )
 
+// the dir of the volumes of the pods, in the sub dirs named by the pods
+const VFS_VOLUME_ROOT = "/var/tmp/hyper"
+
 func CreateVFSVolume(podId, shortName string) (string, error) {
-	volName := path.Join("/var/tmp/hyper", podId, shortName)
+	volName := path.Join(VFS_VOLUME_ROOT, podId, shortName)
 	if _, err := os.Stat(volName); err != nil && os.IsNotExist(err) {
 		if err := os.MkdirAll(volName, os.FileMode(0777)); err != nil {
 			return "", err
@@ -36,6 +36,17 @@ type TermStatus struct {
 	FinishedAt string `json:"finishedAt"`
 }
 
+// HealthStatus is the result of the probes of the container
+type HealthStatus struct {
+	// starting, healthy or unhealthy, by the liveness probe
+	Status        string `json:"status"`
+	Ready         bool   `json:"ready"`
+	FailingStreak int    `json:"failingStreak"`
+	Restarts      int    `json:"restarts"`
+	LastProbe     string `json:"lastProbe,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+}
+
 type ContainerStatus struct {
 	Name        string        `json:"name"`
 	ContainerID string        `json:"containerID"`
@@ -43,6 +54,9 @@ type ContainerStatus struct {
 	Waiting     WaitingStatus `json:"waiting"`
 	Running     RunningStatus `json:"running"`
 	Terminated  TermStatus    `json:"terminated"`
+	// the restarts of the container inside the running VM
+	RestartCount int           `json:"restartCount"`
+	Health       *HealthStatus `json:"health,omitempty"`
 }
 
 type ContainerInfo struct {
@@ -60,3 +74,20 @@ type ContainerInfo struct {
 	ImagePullPolicy string           `json:"imagePullPolicy"`
 	Status          ContainerStatus  `json:"status"`
 }
+
+// ExecInfo is the session of a process executed in a container, or in the
+// VM of a pod
+type ExecInfo struct {
+	ID         string   `json:"id"`
+	Container  string   `json:"container,omitempty"`
+	Vm         string   `json:"vm"`
+	Command    []string `json:"command"`
+	Env        []string `json:"env,omitempty"` // only the names in inspect
+	User       string   `json:"user,omitempty"`
+	Workdir    string   `json:"workdir,omitempty"`
+	Detach     bool     `json:"detach"`
+	Running    bool     `json:"running"`
+	ExitCode   int      `json:"exitCode"`
+	StartedAt  string   `json:"startedAt"`
+	FinishedAt string   `json:"finishedAt,omitempty"`
+}
@@ -0,0 +1,42 @@
+package types
+
+// List JSON Data Structure, returned by /list since API version 1.18
+type PodListItem struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Vm         string            `json:"vm"`
+	Status     string            `json:"status"`
+	Type       string            `json:"type,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	PodIP      []string          `json:"podIP,omitempty"`
+	Vcpu       int               `json:"vcpu"`
+	Memory     int               `json:"memory"`
+	CreatedAt  string            `json:"createdAt"`
+	StartedAt  string            `json:"startedAt,omitempty"`
+	FinishedAt string            `json:"finishedAt,omitempty"`
+}
+
+type ContainerListItem struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	PodID    string            `json:"podID"`
+	Image    string            `json:"image"`
+	Status   string            `json:"status"`
+	ExitCode int               `json:"exitCode"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+type VmListItem struct {
+	ID     string `json:"id"`
+	Pod    string `json:"pod,omitempty"`
+	Status string `json:"status"`
+	Vcpu   int    `json:"vcpu"`
+	Memory int    `json:"memory"`
+}
+
+type ListResponse struct {
+	Item       string              `json:"item"`
+	Pods       []PodListItem       `json:"pods,omitempty"`
+	Containers []ContainerListItem `json:"containers,omitempty"`
+	Vms        []VmListItem        `json:"vms,omitempty"`
+}
@@ -48,6 +48,12 @@ type PodStatus struct {
 	PodIP     []string          `json:"podIP"`
 	StartTime string            `json:"startTime"`
 	Status    []ContainerStatus `json:"containerStatus"`
+	// the restarts of the pod by its restart policy
+	FinishTime     string `json:"finishTime,omitempty"`
+	RestartPolicy  string `json:"restartPolicy,omitempty"`
+	RestartCount   int    `json:"restartCount"`
+	LastExitReason string `json:"lastExitReason,omitempty"`
+	LastExitCode   int    `json:"lastExitCode"`
 }
 
 type PodInfo struct {
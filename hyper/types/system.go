@@ -0,0 +1,22 @@
+package types
+
+// DiskUsage is the disk space used by a kind of resources, reported by
+// `hyperctl system df`
+type DiskUsage struct {
+	Type   string `json:"type"`
+	Total  int    `json:"total"`
+	Active int    `json:"active"`
+	Size   int64  `json:"size"`
+	// the space freed by `hyperctl system prune`
+	Reclaimable int64 `json:"reclaimable"`
+	// the sizes are not measured, see `hyperctl system df --size`
+	Unmeasured bool `json:"unmeasured,omitempty"`
+}
+
+// PruneReport is the resources of a kind removed by the prune, or to be
+// removed by a dry run
+type PruneReport struct {
+	Type      string   `json:"type"`
+	Removed   []string `json:"removed"`
+	Reclaimed int64    `json:"reclaimed"`
+}
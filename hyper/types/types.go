@@ -14,3 +14,19 @@ type ImagePullConfig struct {
 	MetaHeaders map[string][]string
 	AuthConfig  *cliconfig.AuthConfig
 }
+
+type ImageBuildConfig struct {
+	// the resources of the builder VM, the defaults of the builder are
+	// used if they are not set
+	VmCpu    int
+	VmMemory int // in MiB
+
+	// the secrets for `RUN --mount=type=secret`, by id, they are never
+	// written into the image
+	Secrets map[string][]byte
+	// the ssh agent sockets on the host for `RUN --mount=type=ssh`, by id,
+	// they are forwarded for the local clients only and must be served by
+	// the processes of SSHAgentUid, the uid of the client
+	SSHAgents   map[string]string
+	SSHAgentUid uint32
+}
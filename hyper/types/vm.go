@@ -0,0 +1,29 @@
+package types
+
+type GuestFilesystem struct {
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+// GuestHealth is the status of the guest reported by its heartbeats
+type GuestHealth struct {
+	// healthy, memory-pressure, disk-pressure or unresponsive
+	Status        string            `json:"status"`
+	Reason        string            `json:"reason,omitempty"`
+	LastHeartbeat string            `json:"lastHeartbeat"`
+	Uptime        uint64            `json:"uptime"`
+	Load          [3]float64        `json:"load"`
+	MemTotal      uint64            `json:"memTotal"`
+	MemAvailable  uint64            `json:"memAvailable"`
+	Filesystems   []GuestFilesystem `json:"filesystems"`
+}
+
+type VmInfo struct {
+	Id     string       `json:"id"`
+	Status string       `json:"status"`
+	Pod    string       `json:"pod,omitempty"`
+	Vcpu   int          `json:"vcpu"`
+	Memory int          `json:"memory"`
+	Health *GuestHealth `json:"health,omitempty"`
+}
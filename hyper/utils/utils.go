@@ -3,15 +3,19 @@ package utils
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -28,7 +32,7 @@ var (
 )
 
 const (
-	APIVERSION = "1.17"
+	APIVERSION = "1.18"
 )
 
 func MatchesContentType(contentType, expectedType string) bool {
@@ -40,12 +44,29 @@ func MatchesContentType(contentType, expectedType string) bool {
 }
 
 func UriReader(uri string) (io.ReadCloser, error) {
+	return UriReaderWithTimeout(uri, 0)
+}
+
+// UriReaderWithTimeout is like UriReader, but gives up a http(s) fetch
+// after timeout. A zero timeout means no timeout.
+func UriReaderWithTimeout(uri string, timeout time.Duration) (io.ReadCloser, error) {
 	if strings.HasPrefix(uri, "http:") || strings.HasPrefix(uri, "https:") {
-		req, _ := http.NewRequest("GET", uri, nil)
-		resp, err := http.DefaultClient.Do(req)
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return nil, err
+		}
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Do(req)
 		if err != nil {
 			return nil, err
 		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("Fetch %s failed: %s", uri, resp.Status)
+		}
+		if resp.ContentLength >= 0 {
+			return &sizedReader{ReadCloser: resp.Body, remain: resp.ContentLength, uri: uri}, nil
+		}
 		return resp.Body, nil
 	} else if strings.HasPrefix(uri, "file://") {
 		src := strings.TrimPrefix(uri, "file://")
@@ -54,10 +75,53 @@ func UriReader(uri string) (io.ReadCloser, error) {
 			return nil, err
 		}
 		return f, nil
+	} else if strings.HasPrefix(uri, "data:") {
+		data, err := DecodeDataUri(uri)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
 	}
 	return nil, fmt.Errorf("Unsupported URI: %s", uri)
 }
 
+// sizedReader reports a truncated body instead of a silent EOF
+type sizedReader struct {
+	io.ReadCloser
+	remain int64
+	uri    string
+}
+
+func (r *sizedReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.remain -= int64(n)
+	if err == io.EOF && r.remain > 0 {
+		return n, fmt.Errorf("Fetch %s failed: %d bytes missing", r.uri, r.remain)
+	}
+	return n, err
+}
+
+// DecodeDataUri returns the content of a RFC 2397 data URI, e.g.
+// "data:text/plain;base64,SGVsbG8=" or "data:,Hello%2C%20World".
+func DecodeDataUri(uri string) ([]byte, error) {
+	if !strings.HasPrefix(uri, "data:") {
+		return nil, fmt.Errorf("%s is not a data URI", uri)
+	}
+	comma := strings.Index(uri, ",")
+	if comma < 0 {
+		return nil, fmt.Errorf("Malformed data URI: missing comma")
+	}
+	meta, data := uri[len("data:"):comma], uri[comma+1:]
+	if strings.HasSuffix(meta, ";base64") {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	str, err := url.QueryUnescape(strings.Replace(data, "+", "%2B", -1))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(str), nil
+}
+
 // FormatMountLabel returns a string to be used by the mount command.
 // The format of this string will be used to alter the labeling of the mountpoint.
 // The string returned is suitable to be used as the options field of the mount command.
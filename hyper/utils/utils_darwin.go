@@ -17,3 +17,12 @@ func Mount(source string, target string, fstype string, flags uintptr, data stri
 	}
 	return nil
 }
+
+// MountTmpfs is not supported on darwin, the target is left as a plain directory
+func MountTmpfs(target string, size int64) error {
+	return nil
+}
+
+func Unmount(target string) error {
+	return nil
+}
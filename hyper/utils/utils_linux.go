@@ -3,6 +3,7 @@
 package utils
 
 import (
+	"fmt"
 	"syscall"
 )
 
@@ -16,3 +17,13 @@ func Mount(source string, target string, fstype string, flags uintptr, data stri
 	}
 	return nil
 }
+
+// MountTmpfs mounts an in-memory filesystem limited to size bytes on target
+func MountTmpfs(target string, size int64) error {
+	flags := uintptr(syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC)
+	return syscall.Mount("tmpfs", target, "tmpfs", flags, fmt.Sprintf("size=%d,mode=0700", size))
+}
+
+func Unmount(target string) error {
+	return syscall.Unmount(target, syscall.MNT_DETACH)
+}
@@ -19,11 +19,44 @@ type WindowSize struct {
 }
 
 type TtyIO struct {
-	Stdin     io.ReadCloser
-	Stdout    io.WriteCloser
+	Stdin  io.ReadCloser
+	Stdout io.WriteCloser
+	// Stderr receives the stderr of a non-terminal process, it is
+	// merged into Stdout if nil
+	Stderr    io.WriteCloser
 	ClientTag string
 	Callback  chan *types.VmResponse
 	ExitCode  uint8
+	// the signal which terminated the process, 0 if it exited
+	ExitSignal uint8
+	OOMKilled  bool
+}
+
+// ExitStatus is carried by the last message of a finished session. Older
+// hyperstart sends the exit code only, the signal which terminated the
+// process and the flags are appended after it.
+type ExitStatus struct {
+	Code      uint8
+	Signal    uint8
+	OOMKilled bool
+}
+
+const (
+	EXIT_FLAG_OOM_KILLED = 0x1
+)
+
+func parseExitStatus(msg []byte) *ExitStatus {
+	status := &ExitStatus{Code: 255}
+	if len(msg) > 0 {
+		status.Code = msg[0]
+	}
+	if len(msg) > 1 {
+		status.Signal = msg[1]
+	}
+	if len(msg) > 2 {
+		status.OOMKilled = msg[2]&EXIT_FLAG_OOM_KILLED != 0
+	}
+	return status
 }
 
 func (tty *TtyIO) WaitForFinish() error {
@@ -36,8 +69,15 @@ func (tty *TtyIO) WaitForFinish() error {
 
 	glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
 	if Response.Code == types.E_EXEC_FINISH {
-		tty.ExitCode = Response.Data.(uint8)
-		glog.V(1).Infof("Exit code %d", tty.ExitCode)
+		switch status := Response.Data.(type) {
+		case *ExitStatus:
+			tty.ExitCode = status.Code
+			tty.ExitSignal = status.Signal
+			tty.OOMKilled = status.OOMKilled
+		case uint8:
+			tty.ExitCode = status
+		}
+		glog.V(1).Infof("Exit code %d, signal %d, oom killed %v", tty.ExitCode, tty.ExitSignal, tty.OOMKilled)
 	}
 
 	close(tty.Callback)
@@ -171,12 +211,9 @@ func waitPts(ctx *VmContext) {
 				glog.V(1).Infof("session %d closed by peer, close pty", res.session)
 				ta.closed = true
 			} else if ta.closed {
-				var code uint8 = 255
-				if len(res.message) == 1 {
-					code = uint8(res.message[0])
-				}
-				glog.V(1).Infof("session %d, exit code", res.session, code)
-				ctx.ptys.Close(res.session, code)
+				status := parseExitStatus(res.message)
+				glog.V(1).Infof("session %d, exit code %d, signal %d", res.session, status.Code, status.Signal)
+				ctx.ptys.closeWithStatus(res.session, status)
 			} else {
 				for _, tty := range ta.attachments {
 					if tty.Stdout != nil {
@@ -224,10 +261,10 @@ func (ta *ttyAttachments) detach(tty *TtyIO) {
 	}
 }
 
-func (ta *ttyAttachments) close(code uint8) []string {
+func (ta *ttyAttachments) close(status *ExitStatus) []string {
 	tags := []string{}
 	for _, t := range ta.attachments {
-		tags = append(tags, t.Close(code))
+		tags = append(tags, t.closeWithStatus(status))
 	}
 	ta.attachments = []*TtyIO{}
 	return tags
@@ -242,6 +279,12 @@ func (ta *ttyAttachments) isTty() bool {
 }
 
 func (tty *TtyIO) Close(code uint8) string {
+	return tty.closeWithStatus(&ExitStatus{Code: code})
+}
+
+// closeWithStatus does not close Stderr, it is closed together with the
+// stderr session which may still have pending output
+func (tty *TtyIO) closeWithStatus(status *ExitStatus) string {
 
 	glog.V(1).Info("Close tty ", tty.ClientTag)
 
@@ -255,12 +298,26 @@ func (tty *TtyIO) Close(code uint8) string {
 		tty.Callback <- &types.VmResponse{
 			Code:  types.E_EXEC_FINISH,
 			Cause: "Command finished",
-			Data:  code,
+			Data:  status,
 		}
 	}
 	return tty.ClientTag
 }
 
+// stderrIO returns the attachment of the stderr session of the client
+func (tty *TtyIO) stderrIO() *TtyIO {
+	stderr := tty.Stderr
+	if stderr == nil {
+		stderr = tty.Stdout
+	}
+	return &TtyIO{
+		Stdin:     nil,
+		Stdout:    stderr,
+		ClientTag: tty.ClientTag,
+		Callback:  nil,
+	}
+}
+
 func (pts *pseudoTtys) nextAttachId() uint64 {
 	pts.lock.Lock()
 	id := pts.attachId
@@ -306,9 +363,13 @@ func (pts *pseudoTtys) Detach(session uint64, tty *TtyIO) {
 }
 
 func (pts *pseudoTtys) Close(session uint64, code uint8) {
+	pts.closeWithStatus(session, &ExitStatus{Code: code})
+}
+
+func (pts *pseudoTtys) closeWithStatus(session uint64, status *ExitStatus) {
 	if ta, ok := pts.ttys[session]; ok {
 		pts.lock.Lock()
-		tags := ta.close(code)
+		tags := ta.close(status)
 		delete(pts.ttys, session)
 		pts.lock.Unlock()
 		for _, t := range tags {
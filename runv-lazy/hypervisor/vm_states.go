@@ -244,13 +244,7 @@ func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 	ctx.ptys.ptyConnect(false, cmd.Process.Terminal, cmd.Process.Stdio, cmd.TtyIO)
 	ctx.ptys.clientReg(cmd.ClientTag, cmd.Process.Stdio)
 	if !cmd.Process.Terminal {
-		stderrIO := &TtyIO{
-			Stdin:     nil,
-			Stdout:    cmd.TtyIO.Stdout,
-			ClientTag: cmd.TtyIO.ClientTag,
-			Callback:  nil,
-		}
-		ctx.ptys.ptyConnect(false, cmd.Process.Terminal, cmd.Process.Stderr, stderrIO)
+		ctx.ptys.ptyConnect(false, cmd.Process.Terminal, cmd.Process.Stderr, cmd.TtyIO.stderrIO())
 	}
 	ctx.vm <- &DecodedMessage{
 		Code:    INIT_EXECCMD,
@@ -308,12 +302,7 @@ func (ctx *VmContext) attachTty2Container(process *VmProcess, cmd *AttachCommand
 	if session > 0 {
 		stderrIO := cmd.Stderr
 		if stderrIO == nil {
-			stderrIO = &TtyIO{
-				Stdin:     nil,
-				Stdout:    cmd.Streams.Stdout,
-				ClientTag: cmd.Streams.ClientTag,
-				Callback:  nil,
-			}
+			stderrIO = cmd.Streams.stderrIO()
 		}
 		ctx.ptys.ptyConnect(true, process.Terminal, session, stderrIO)
 	}
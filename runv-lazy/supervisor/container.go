@@ -37,17 +37,9 @@ func (c *Container) start(p *Process) {
 
 	go func() {
 		err := c.run(p)
-		e := Event{
-			ID:        c.Id,
-			Type:      EventExit,
-			Timestamp: time.Now(),
-			PID:       p.Id,
-			Status:    -1,
+		for _, e := range p.exitEvents(err) {
+			c.ownerPod.sv.Events.notifySubscribers(e)
 		}
-		if err == nil {
-			e.Status = int(p.stdio.ExitCode)
-		}
-		c.ownerPod.sv.Events.notifySubscribers(e)
 	}()
 }
 
@@ -175,26 +167,14 @@ func (c *Container) addProcess(processId, stdin, stdout, stderr string, spec *sp
 	c.ownerPod.sv.Events.notifySubscribers(e)
 
 	go func() {
+		// AddProcess returns after the process finished
 		err := c.ownerPod.vm.AddProcess(c.Id, spec.Terminal, spec.Args, spec.Env, spec.Cwd, p.stdio)
 		if err != nil {
 			glog.V(1).Infof("add process to container failed: %v\n", err)
-		} else {
-			err = p.stdio.WaitForFinish()
-		}
-
-		e := Event{
-			ID:        c.Id,
-			Type:      EventExit,
-			Timestamp: time.Now(),
-			PID:       processId,
 		}
-		if err != nil {
-			e.Status = -1
-			glog.V(1).Infof("get exit code failed %s\n", err.Error())
-		} else {
-			e.Status = int(p.stdio.ExitCode)
+		for _, e := range p.exitEvents(err) {
+			c.ownerPod.sv.Events.notifySubscribers(e)
 		}
-		c.ownerPod.sv.Events.notifySubscribers(e)
 	}()
 	return p, nil
 }
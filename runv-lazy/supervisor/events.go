@@ -15,6 +15,7 @@ const (
 	EventExit           = "exit"
 	EventContainerStart = "start-container"
 	EventProcessStart   = "start-process"
+	EventOOM            = "oom"
 )
 
 var (
@@ -27,6 +28,9 @@ type Event struct {
 	Timestamp time.Time `json:"timestamp"`
 	PID       string    `json:"pid,omitempty"`
 	Status    int       `json:"status,omitempty"`
+	// the signal which terminated the process
+	Signal    int  `json:"signal,omitempty"`
+	OOMKilled bool `json:"oomKilled,omitempty"`
 }
 
 // TODO: copied code, including two bugs
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/hyperhq/runv/hypervisor"
@@ -47,15 +48,20 @@ func (p *Process) setupIO() error {
 	if err != nil {
 		return err
 	}
-	// TODO: setup stderr
-	if p.Spec.Terminal {
-	}
 	p.stdio = &hypervisor.TtyIO{
 		ClientTag: p.inerId,
 		Stdin:     stdin,
 		Stdout:    stdout,
 		Callback:  make(chan *types.VmResponse, 1),
 	}
+	// the terminal merges stderr into stdout
+	if !p.Spec.Terminal && p.Stderr != "" {
+		stderr, err := os.OpenFile(p.Stderr, syscall.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		p.stdio.Stderr = stderr
+	}
 	glog.Infof("process setupIO() success")
 
 	return nil
@@ -84,6 +90,35 @@ func (p *Process) signal(sig int) error {
 	}
 }
 
+// exitEvents returns the events describing how the process finished, err
+// is set if its exit status is unknown
+func (p *Process) exitEvents(err error) []Event {
+	e := Event{
+		ID:        p.ownerCont.Id,
+		Type:      EventExit,
+		Timestamp: time.Now(),
+		PID:       p.Id,
+		Status:    -1,
+	}
+	if err != nil {
+		glog.V(1).Infof("get exit code failed %s\n", err.Error())
+		return []Event{e}
+	}
+
+	e.Status = int(p.stdio.ExitCode)
+	e.Signal = int(p.stdio.ExitSignal)
+	e.OOMKilled = p.stdio.OOMKilled
+	if !e.OOMKilled {
+		return []Event{e}
+	}
+	return []Event{{
+		ID:        p.ownerCont.Id,
+		Type:      EventOOM,
+		Timestamp: e.Timestamp,
+		PID:       p.Id,
+	}, e}
+}
+
 func (p *Process) reap() {
 	p.closeStdin()
 }
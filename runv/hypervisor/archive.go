@@ -0,0 +1,193 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+const (
+	// the tar stream is sent through the init channel in chunks
+	ARCHIVE_CHUNK_SIZE = 64 * 1024
+
+	// the time to wait for init to reply a chunk
+	INIT_ARCHIVE_TIMEOUT = 60 * time.Second
+)
+
+// the sessions of the tar streams kept by init
+var archiveSession uint64
+
+func (ctx *VmContext) archiveCmd(ev VmEvent) {
+	var code uint32
+	switch ev.(type) {
+	case *StatPathCommand:
+		code = INIT_STATPATH
+	case *GetArchiveCommand:
+		code = INIT_GETARCHIVE
+	case *PutArchiveCommand:
+		code = INIT_PUTARCHIVE
+	case *ForwardSocketCommand:
+		code = INIT_FORWARDSOCK
+	}
+
+	msg, err := json.Marshal(ev)
+	if err != nil {
+		ctx.reportArchive(ev, []byte("archive command parse failed: "+err.Error()), true)
+		return
+	}
+	switch cmd := ev.(type) {
+	case *PutArchiveCommand:
+		msg = append(msg, cmd.Data...)
+	case *ForwardSocketCommand:
+		msg = append(msg, cmd.Data...)
+	}
+	ctx.vm <- &DecodedMessage{
+		Code:    code,
+		Message: msg,
+		Event:   ev,
+		Timeout: INIT_ARCHIVE_TIMEOUT,
+	}
+}
+
+func (ctx *VmContext) reportArchive(reply VmEvent, data []byte, err bool) {
+	response := &types.VmResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_ARCHIVE,
+		Reply: reply,
+		Data:  data,
+	}
+	if err {
+		response.Cause = "archive failed"
+		if len(data) > 0 {
+			response.Cause = string(data)
+		}
+		response.Data = nil
+	}
+	ctx.client <- response
+}
+
+// archiveRequest sends the command to the VM and waits for the reply
+func (vm *Vm) archiveRequest(cmd VmEvent) ([]byte, error) {
+	PodEvent, err := vm.GetRequestChan()
+	if err != nil {
+		return nil, err
+	}
+	defer vm.ReleaseRequestChan(PodEvent)
+
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return nil, err
+	}
+	defer vm.ReleaseResponseChan(Status)
+
+	PodEvent <- cmd
+
+	for {
+		Response, ok := <-Status
+		if !ok {
+			return nil, fmt.Errorf("vm %s is gone", vm.Id)
+		}
+		if Response.Reply != cmd {
+			continue
+		}
+		glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
+		if Response.Code != types.E_ARCHIVE || Response.Cause != "" {
+			return nil, errors.New(Response.Cause)
+		}
+		data, _ := Response.Data.([]byte)
+		return data, nil
+	}
+}
+
+// StatPath returns the stat of the path in the container
+func (vm *Vm) StatPath(container, path string) (*types.PathStat, error) {
+	data, err := vm.archiveRequest(&StatPathCommand{
+		Container: container,
+		Path:      path,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Stat container %s path %s failed: %v", container, path, err)
+	}
+
+	stat := &types.PathStat{}
+	if err := json.Unmarshal(data, stat); err != nil {
+		return nil, fmt.Errorf("Stat container %s path %s failed: %v", container, path, err)
+	}
+	return stat, nil
+}
+
+// GetArchive writes the tar stream of the path in the container to w, the
+// entries of the stream are rooted at the base name of the path
+func (vm *Vm) GetArchive(container, path string, w io.Writer) error {
+	session := atomic.AddUint64(&archiveSession, 1)
+	var offset int64 = 0
+
+	for {
+		data, err := vm.archiveRequest(&GetArchiveCommand{
+			Container: container,
+			Path:      path,
+			Session:   session,
+			Offset:    offset,
+		})
+		if err != nil {
+			return fmt.Errorf("Read container %s path %s failed: %v", container, path, err)
+		}
+		if len(data) == 0 {
+			return nil
+		}
+		if _, err := w.Write(data); err != nil {
+			// let init drop the rest of the stream
+			vm.archiveRequest(&GetArchiveCommand{
+				Container: container,
+				Path:      path,
+				Session:   session,
+				Offset:    offset,
+				Done:      true,
+			})
+			return err
+		}
+		offset += int64(len(data))
+	}
+}
+
+// PutArchive extracts the tar stream read from r to the directory path in
+// the container, keeping the mode and ownership of the entries
+func (vm *Vm) PutArchive(container, path string, noOverwriteDirNonDir bool, r io.Reader) error {
+	session := atomic.AddUint64(&archiveSession, 1)
+	var offset int64 = 0
+	buf := make([]byte, ARCHIVE_CHUNK_SIZE)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		// the extraction is finished even if the stream is broken, init
+		// fails it for the truncated stream
+		_, perr := vm.archiveRequest(&PutArchiveCommand{
+			Container:            container,
+			Path:                 path,
+			Session:              session,
+			Offset:               offset,
+			Eof:                  err != nil,
+			NoOverwriteDirNonDir: noOverwriteDirNonDir,
+			Data:                 buf[:n],
+		})
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if perr != nil {
+			return fmt.Errorf("Write container %s path %s failed: %v", container, path, perr)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		offset += int64(n)
+	}
+}
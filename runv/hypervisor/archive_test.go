@@ -0,0 +1,93 @@
+package hypervisor
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+// fakeArchiveVm serves the archive commands from the content like init
+func fakeArchiveVm(content []byte, written *bytes.Buffer, chunks *int) *Vm {
+	status := make(chan *types.VmResponse, 128)
+	vm := &Vm{
+		Id:      "vm-test",
+		VmChan:  make(chan VmEvent, 128),
+		clients: CreateFanout(status, 128, false),
+	}
+	go func() {
+		for ev := range vm.VmChan {
+			r := &types.VmResponse{VmId: vm.Id, Code: types.E_ARCHIVE, Reply: ev}
+			switch cmd := ev.(type) {
+			case *GetArchiveCommand:
+				end := cmd.Offset + 10
+				if end > int64(len(content)) {
+					end = int64(len(content))
+				}
+				r.Data = content[cmd.Offset:end]
+			case *PutArchiveCommand:
+				if int64(written.Len()) != cmd.Offset {
+					r.Cause = "bad offset"
+				}
+				written.Write(cmd.Data)
+				*chunks++
+				if cmd.Eof && bytes.Contains(written.Bytes(), []byte("broken")) {
+					r.Cause = "unexpected EOF"
+				}
+			case *StatPathCommand:
+				r.Data = []byte(`{"name":"etc","mode":2147484141}`)
+			}
+			status <- r
+		}
+	}()
+	return vm
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read(p []byte) (int, error) {
+	return copy(p, "broken"), errors.New("read failed")
+}
+
+func TestGetArchive(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4)
+	vm := fakeArchiveVm(content, nil, nil)
+	defer close(vm.VmChan)
+
+	out := &bytes.Buffer{}
+	if err := vm.GetArchive("c1", "/etc", out); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes(), content) {
+		t.Fatalf("expect %q, got %q", content, out.Bytes())
+	}
+
+	stat, err := vm.StatPath("c1", "/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Name != "etc" || !stat.Mode.IsDir() {
+		t.Fatalf("unexpected stat %v", stat)
+	}
+}
+
+func TestPutArchive(t *testing.T) {
+	written := &bytes.Buffer{}
+	chunks := 0
+	vm := fakeArchiveVm(nil, written, &chunks)
+	defer close(vm.VmChan)
+
+	content := bytes.Repeat([]byte("x"), ARCHIVE_CHUNK_SIZE*2+1)
+	if err := vm.PutArchive("c1", "/tmp", true, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written.Bytes(), content) || chunks != 3 {
+		t.Fatalf("expect %d bytes in 3 chunks, got %d bytes in %d chunks", len(content), written.Len(), chunks)
+	}
+
+	written.Reset()
+	if err := vm.PutArchive("c1", "/tmp", true, brokenReader{}); err == nil || err.Error() != "read failed" {
+		t.Fatalf("expect the read error, got %v", err)
+	}
+}
@@ -0,0 +1,173 @@
+package hypervisor
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hyperhq/runv/lib/telnet"
+)
+
+// the console log is rotated to ConsoleLogName.1 once it grows over half
+// of ConsoleLogSize, so at most ConsoleLogSize of the latest output is kept
+var ConsoleLogSize int64 = 1024 * 1024
+
+// ConsoleLogPath returns the path of the console log of the VM, the log is
+// left in the home dir of the VM after it exits
+func ConsoleLogPath(vmId string) string {
+	return filepath.Join(BaseDir, vmId, ConsoleLogName)
+}
+
+// consoleLog keeps the console output of the VM in a size capped file
+type consoleLog struct {
+	path string
+	file *os.File
+	size int64
+}
+
+func newConsoleLog(path string) (*consoleLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &consoleLog{path: path, file: f, size: fi.Size()}, nil
+}
+
+func (l *consoleLog) rotate() error {
+	l.file.Close()
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	l.file = f
+	l.size = 0
+	return nil
+}
+
+func (l *consoleLog) WriteLine(line string) error {
+	if l.file == nil {
+		return fmt.Errorf("console log %s is closed", l.path)
+	}
+	if l.size+int64(len(line))+1 > ConsoleLogSize/2 && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			l.file = nil
+			return err
+		}
+	}
+	n, err := l.file.WriteString(line + "\n")
+	l.size += int64(n)
+	return err
+}
+
+func (l *consoleLog) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// ReadConsoleLog returns the console output kept of the VM
+func ReadConsoleLog(vmId string) ([]byte, error) {
+	path := ConsoleLogPath(vmId)
+	old, err := ioutil.ReadFile(path + ".1")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	cur, err := ioutil.ReadFile(path)
+	if err != nil && (!os.IsNotExist(err) || old == nil) {
+		return nil, err
+	}
+	return append(old, cur...), nil
+}
+
+// RemoveConsoleLog removes the console log of the exited VM
+func RemoveConsoleLog(vmId string) error {
+	path := ConsoleLogPath(vmId)
+	if err := os.Remove(path + ".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// vmConsole shares the serial console of the VM, which accepts only one
+// connection, between the console log and an interactive client
+type vmConsole struct {
+	sync.Mutex
+	conn   *telnet.Conn
+	output io.Writer
+}
+
+// the consoles of the VMs running in this process
+var consoles = struct {
+	sync.Mutex
+	m map[string]*vmConsole
+}{m: make(map[string]*vmConsole)}
+
+func registerConsole(vmId string, c *vmConsole) {
+	consoles.Lock()
+	consoles.m[vmId] = c
+	consoles.Unlock()
+}
+
+func unregisterConsole(vmId string) {
+	consoles.Lock()
+	delete(consoles.m, vmId)
+	consoles.Unlock()
+}
+
+// Write forwards the console output to the attached client, the output is
+// dropped if nobody is attached
+func (c *vmConsole) Write(p []byte) (int, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.output != nil {
+		if _, err := c.output.Write(p); err != nil {
+			c.output = nil
+		}
+	}
+	return len(p), nil
+}
+
+// AttachConsole connects stdin and stdout to the serial console of the VM
+// until stdin is closed. Only one client can be attached at a time.
+func (vm *Vm) AttachConsole(stdin io.Reader, stdout io.Writer) error {
+	consoles.Lock()
+	c, ok := consoles.m[vm.Id]
+	consoles.Unlock()
+	if !ok {
+		return fmt.Errorf("the console of vm %s is not available", vm.Id)
+	}
+
+	c.Lock()
+	if c.output != nil {
+		c.Unlock()
+		return fmt.Errorf("the console of vm %s is attached by another client", vm.Id)
+	}
+	c.output = stdout
+	c.Unlock()
+
+	defer func() {
+		c.Lock()
+		c.output = nil
+		c.Unlock()
+	}()
+
+	_, err := io.Copy(c.conn, stdin)
+	return err
+}
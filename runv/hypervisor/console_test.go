@@ -0,0 +1,59 @@
+package hypervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConsoleLogRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "console")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	size := ConsoleLogSize
+	ConsoleLogSize = 64
+	defer func() { ConsoleLogSize = size }()
+
+	path := filepath.Join(dir, ConsoleLogName)
+	l, err := newConsoleLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"0123456789", "abcdefghij", "ABCDEFGHIJ", "klmnopqrst"} {
+		if err := l.WriteLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	old, err := ioutil.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(old) != "0123456789\nabcdefghij\n" || string(cur) != "ABCDEFGHIJ\nklmnopqrst\n" {
+		t.Fatalf("unexpected rotated logs %q and %q", old, cur)
+	}
+	if int64(len(old)+len(cur)) > ConsoleLogSize {
+		t.Fatalf("the console log is over the size limit")
+	}
+
+	// the log is appended after the VM context is restored
+	l, err = newConsoleLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.WriteLine("uvwxyz")
+	l.Close()
+	if cur, _ = ioutil.ReadFile(path); !strings.HasSuffix(string(cur), "klmnopqrst\nuvwxyz\n") {
+		t.Fatalf("unexpected log %q", cur)
+	}
+}
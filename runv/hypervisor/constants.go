@@ -5,6 +5,7 @@ const (
 	HyperSockName   = "hyper.sock"
 	TtySockName     = "tty.sock"
 	ConsoleSockName = "console.sock"
+	ConsoleLogName  = "console.log"
 	ShareDirTag     = "share_dir"
 	DefaultKernel   = "/var/lib/hyper/kernel"
 	DefaultInitrd   = "/var/lib/hyper/hyper-initrd.img"
@@ -37,6 +38,9 @@ const (
 	EVENT_SERIAL_DELETE
 	EVENT_TTY_OPEN
 	EVENT_TTY_CLOSE
+	EVENT_CONTAINER_FINISH
+	EVENT_GUEST_HEARTBEAT
+	EVENT_GUEST_UNRESPONSIVE
 	COMMAND_GET_POD_IP
 	COMMAND_RUN_POD
 	COMMAND_REPLACE_POD
@@ -55,6 +59,15 @@ const (
 	COMMAND_WINDOWSIZE
 	COMMAND_ACK
 	COMMAND_GET_POD_STATS
+	COMMAND_ATTACH_VOLUME
+	COMMAND_DETACH_VOLUME
+	COMMAND_RESTART_CONTAINER
+	COMMAND_GET_GUEST_HEALTH
+	COMMAND_STAT_PATH
+	COMMAND_GET_ARCHIVE
+	COMMAND_PUT_ARCHIVE
+	COMMAND_FORWARD_SOCKET
+	COMMAND_REMOVE_CONTAINER
 	ERROR_INIT_FAIL
 	ERROR_QMP_FAIL
 	ERROR_INTERRUPTED
@@ -82,6 +95,26 @@ const (
 	INIT_READFILE
 	INIT_NEWCONTAINER
 	INIT_KILLCONTAINER
+	INIT_MOUNTVOLUME
+	INIT_UMOUNTVOLUME
+	INIT_VERSION
+	INIT_CANCEL
+	INIT_HEARTBEAT
+	INIT_STATPATH
+	INIT_GETARCHIVE
+	INIT_PUTARCHIVE
+	INIT_FORWARDSOCK
+	INIT_REMOVECONTAINER
+)
+
+// Versions of the protocol between runv and init. Version 2 carries the id
+// of the request in every message, so the replies may come out of order.
+const (
+	INIT_PROTO_V1 = 1
+	INIT_PROTO_V2 = 2
+
+	// the newest version supported by runv
+	INIT_PROTO_MAX = INIT_PROTO_V2
 )
 
 const (
@@ -137,6 +170,12 @@ func EventString(ev int) string {
 		return "EVENT_TTY_OPEN"
 	case EVENT_TTY_CLOSE:
 		return "EVENT_TTY_CLOSE"
+	case EVENT_CONTAINER_FINISH:
+		return "EVENT_CONTAINER_FINISH"
+	case EVENT_GUEST_HEARTBEAT:
+		return "EVENT_GUEST_HEARTBEAT"
+	case EVENT_GUEST_UNRESPONSIVE:
+		return "EVENT_GUEST_UNRESPONSIVE"
 	case COMMAND_GET_POD_IP:
 		return "COMMAND_GET_POD_IP"
 	case COMMAND_RUN_POD:
@@ -169,6 +208,24 @@ func EventString(ev int) string {
 		return "COMMAND_ACK"
 	case COMMAND_GET_POD_STATS:
 		return "COMMAND_GET_POD_STATS"
+	case COMMAND_ATTACH_VOLUME:
+		return "COMMAND_ATTACH_VOLUME"
+	case COMMAND_DETACH_VOLUME:
+		return "COMMAND_DETACH_VOLUME"
+	case COMMAND_RESTART_CONTAINER:
+		return "COMMAND_RESTART_CONTAINER"
+	case COMMAND_GET_GUEST_HEALTH:
+		return "COMMAND_GET_GUEST_HEALTH"
+	case COMMAND_STAT_PATH:
+		return "COMMAND_STAT_PATH"
+	case COMMAND_GET_ARCHIVE:
+		return "COMMAND_GET_ARCHIVE"
+	case COMMAND_PUT_ARCHIVE:
+		return "COMMAND_PUT_ARCHIVE"
+	case COMMAND_FORWARD_SOCKET:
+		return "COMMAND_FORWARD_SOCKET"
+	case COMMAND_REMOVE_CONTAINER:
+		return "COMMAND_REMOVE_CONTAINER"
 	case ERROR_INIT_FAIL:
 		return "ERROR_INIT_FAIL"
 	case ERROR_QMP_FAIL:
@@ -34,6 +34,14 @@ type VmContext struct {
 	client chan *types.VmResponse
 	vm     chan *DecodedMessage
 
+	// the version of the protocol talking with init
+	initVersion int
+
+	// the latest health reported by the heartbeats of the guest
+	guestHealth    *types.GuestHealth
+	heartbeats     uint64
+	heartbeatTimer *time.Timer
+
 	DCtx DriverContext
 
 	HomeDir         string
@@ -59,6 +67,11 @@ type VmContext struct {
 
 	progress *processingList
 
+	// volumes being hot added or removed on a running pod
+	hotplugVolumes map[string]VmEvent
+	// containers being removed from a running pod, by the index
+	pendingRemovals map[int]*RemoveContainerCommand
+
 	// Internal Helper
 	handler stateHandler
 	current string
@@ -108,6 +121,7 @@ func InitContext(id string, hub chan VmEvent, client chan *types.VmResponse, dc
 		client:          client,
 		DCtx:            dc,
 		vm:              vmChannel,
+		initVersion:     INIT_PROTO_V1,
 		ptys:            newPts(),
 		ttySessions:     make(map[string]uint64),
 		pendingTtys:     []*AttachCommand{},
@@ -123,6 +137,8 @@ func InitContext(id string, hub chan VmEvent, client chan *types.VmResponse, dc
 		vmSpec:          nil,
 		devices:         newDeviceMap(),
 		progress:        newProcessingList(),
+		hotplugVolumes:  make(map[string]VmEvent),
+		pendingRemovals: make(map[int]*RemoveContainerCommand),
 		lock:            &sync.Mutex{},
 		wait:            false,
 		Keep:            keep,
@@ -229,6 +245,9 @@ func (ctx *VmContext) Close() {
 	defer ctx.lock.Unlock()
 	ctx.ClosePendingTtys()
 	ctx.unsetTimeout()
+	if ctx.heartbeatTimer != nil {
+		ctx.heartbeatTimer.Stop()
+	}
 	ctx.DCtx.Close()
 	close(ctx.vm)
 	close(ctx.client)
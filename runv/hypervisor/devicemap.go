@@ -127,6 +127,8 @@ func (ctx *VmContext) setContainerInfo(index int, container *VmContainer, info *
 
 	container.Id = info.Id
 	container.Rootfs = info.Rootfs
+	container.Sockets = info.Sockets
+	container.Tmpfs = info.Tmpfs
 
 	cmd := container.Entrypoint
 	if len(container.Entrypoint) == 0 && len(info.Entrypoint) > 0 {
@@ -28,6 +28,24 @@ type PodFinished struct {
 	result []uint32
 }
 
+// ContainerFinished is sent when a container exits while the others may
+// still be running
+type ContainerFinished struct {
+	Index int
+	Code  uint8
+}
+
+// GuestHeartbeat is sent when init reports the status of the guest
+type GuestHeartbeat struct {
+	msg []byte
+}
+
+// GuestUnresponsive is sent when init misses its heartbeats after the
+// seq-th one
+type GuestUnresponsive struct {
+	seq uint64
+}
+
 type VmTimeout struct{}
 
 type InitFailedEvent struct {
@@ -46,6 +64,10 @@ type GetPodStatsCommand struct {
 	Id string
 }
 
+type GetGuestHealthCommand struct {
+	Id string
+}
+
 type RunPodCommand struct {
 	Spec       *pod.UserPod
 	Containers []*ContainerInfo
@@ -70,15 +92,31 @@ type NewContainerCommand struct {
 }
 
 type ExecCommand struct {
-	Container string   `json:"container,omitempty"`
-	Sequence  uint64   `json:"seq"`
-	Command   []string `json:"cmd"`
-	Streams   *TtyIO   `json:"-"`
+	Container string             `json:"container,omitempty"`
+	Sequence  uint64             `json:"seq"`
+	Command   []string           `json:"cmd"`
+	Envs      []VmEnvironmentVar `json:"envs,omitempty"`
+	User      string             `json:"user,omitempty"`
+	Workdir   string             `json:"workdir,omitempty"`
+	Streams   *TtyIO             `json:"-"`
 }
 
 type KillCommand struct {
-	Container string         `json:"container"`
-	Signal    syscall.Signal `json:"signal"`
+	Container string `json:"container"`
+	// the session of the exec process to signal, the container is signaled
+	// if it is 0
+	Process uint64         `json:"process,omitempty"`
+	Signal  syscall.Signal `json:"signal"`
+	// the tag of the exec process, resolved to Process
+	Tag string `json:"-"`
+}
+
+type RestartContainerCommand struct {
+	Container string `json:"container"`
+}
+
+type RemoveContainerCommand struct {
+	Container string `json:"container"`
 }
 
 type WriteFileCommand struct {
@@ -87,11 +125,66 @@ type WriteFileCommand struct {
 	Data      []byte `json:"-"`
 }
 
+type VolumeMount struct {
+	Container string
+	Path      string
+	ReadOnly  bool
+}
+
+type AttachVolumeCommand struct {
+	Volume *VolumeInfo
+	Mounts []VolumeMount
+}
+
+type DetachVolumeCommand struct {
+	Name string
+}
+
 type ReadFileCommand struct {
 	Container string `json:"container"`
 	File      string `json:"file"`
 }
 
+type StatPathCommand struct {
+	Container string `json:"container"`
+	Path      string `json:"path"`
+}
+
+// GetArchiveCommand reads the chunk at Offset of the tar stream of Path,
+// the stream is kept by init for the Session until it is read out or Done
+type GetArchiveCommand struct {
+	Container string `json:"container"`
+	Path      string `json:"path"`
+	Session   uint64 `json:"seq"`
+	Offset    int64  `json:"offset"`
+	Done      bool   `json:"done"`
+}
+
+// PutArchiveCommand writes the chunk at Offset of the tar stream extracted
+// to Path, the extraction of the Session finishes with the chunk of Eof
+type PutArchiveCommand struct {
+	Container            string `json:"container"`
+	Path                 string `json:"path"`
+	Session              uint64 `json:"seq"`
+	Offset               int64  `json:"offset"`
+	Eof                  bool   `json:"eof"`
+	NoOverwriteDirNonDir bool   `json:"noOverwriteDirNonDir"`
+	Data                 []byte `json:"-"`
+}
+
+// ForwardSocketCommand relays the connections to the unix socket listened by
+// init for the Session, see VmSocketDescriptor. The Data written by the host
+// to the connection Conn is sent with the command, and init replies the data
+// read from one of the connections, see types.SocketForwardReply. The
+// connection is closed with Eof, and the listener is closed with Done.
+type ForwardSocketCommand struct {
+	Session uint64 `json:"seq"`
+	Conn    uint64 `json:"conn"`
+	Eof     bool   `json:"eof"`
+	Done    bool   `json:"done"`
+	Data    []byte `json:"-"`
+}
+
 type StopPodCommand struct{}
 
 type MigratePodCommand struct{
@@ -146,6 +239,11 @@ type ContainerInfo struct {
 	Entrypoint []string
 	Cmd        []string
 	Envs       map[string]string
+	// the unix sockets listened by init in the container before its
+	// process is started
+	Sockets []VmSocketDescriptor
+	// the tmpfs mounted by init in the container, e.g. for the secrets
+	Tmpfs []VmTmpfsDescriptor
 }
 
 type ContainerUnmounted struct {
@@ -235,6 +333,9 @@ func (qe *VmStop) Event() int 				 { return EVENT_VM_STOP }
 func (qe *VmKilledEvent) Event() int         { return EVENT_VM_KILL }
 func (qe *VmTimeout) Event() int             { return EVENT_VM_TIMEOUT }
 func (qe *PodFinished) Event() int           { return EVENT_POD_FINISH }
+func (qe *ContainerFinished) Event() int     { return EVENT_CONTAINER_FINISH }
+func (qe *GuestHeartbeat) Event() int        { return EVENT_GUEST_HEARTBEAT }
+func (qe *GuestUnresponsive) Event() int     { return EVENT_GUEST_UNRESPONSIVE }
 func (qe *InitConnectedEvent) Event() int    { return EVENT_INIT_CONNECTED }
 func (qe *ContainerCreatedEvent) Event() int { return EVENT_CONTAINER_ADD }
 func (qe *ContainerUnmounted) Event() int    { return EVENT_CONTAINER_DELETE }
@@ -251,14 +352,23 @@ func (qe *RunPodCommand) Event() int         { return COMMAND_RUN_POD }
 func (qe *ListenPodCommand) Event() int 	 { return COMMAND_LISTEN_POD }
 func (qe *GetPodIPCommand) Event() int       { return COMMAND_GET_POD_IP }
 func (qe *GetPodStatsCommand) Event() int    { return COMMAND_GET_POD_STATS }
+func (qe *GetGuestHealthCommand) Event() int { return COMMAND_GET_GUEST_HEALTH }
+func (qe *StatPathCommand) Event() int       { return COMMAND_STAT_PATH }
+func (qe *GetArchiveCommand) Event() int     { return COMMAND_GET_ARCHIVE }
+func (qe *PutArchiveCommand) Event() int     { return COMMAND_PUT_ARCHIVE }
+func (qe *ForwardSocketCommand) Event() int  { return COMMAND_FORWARD_SOCKET }
 func (qe *StopPodCommand) Event() int        { return COMMAND_STOP_POD }
 func (qe *MigratePodCommand) Event() int 	 { return COMMAND_MIGRATE_POD}
 func (qe *ReplacePodCommand) Event() int     { return COMMAND_REPLACE_POD }
 func (qe *NewContainerCommand) Event() int   { return COMMAND_NEWCONTAINER }
 func (qe *ExecCommand) Event() int           { return COMMAND_EXEC }
 func (qe *KillCommand) Event() int           { return COMMAND_KILL }
+func (qe *RestartContainerCommand) Event() int { return COMMAND_RESTART_CONTAINER }
+func (qe *RemoveContainerCommand) Event() int  { return COMMAND_REMOVE_CONTAINER }
 func (qe *WriteFileCommand) Event() int      { return COMMAND_WRITEFILE }
 func (qe *ReadFileCommand) Event() int       { return COMMAND_READFILE }
+func (qe *AttachVolumeCommand) Event() int   { return COMMAND_ATTACH_VOLUME }
+func (qe *DetachVolumeCommand) Event() int   { return COMMAND_DETACH_VOLUME }
 func (qe *AttachCommand) Event() int         { return COMMAND_ATTACH }
 func (qe *WindowSizeCommand) Event() int     { return COMMAND_WINDOWSIZE }
 func (qe *ShutdownCommand) Event() int       { return COMMAND_SHUTDOWN }
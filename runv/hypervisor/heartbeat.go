@@ -0,0 +1,113 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+const (
+	// init sends the heartbeat in the interval, if it is not told in the
+	// heartbeat
+	DEFAULT_HEARTBEAT_INTERVAL = 10 * time.Second
+
+	// the guest is unresponsive after missing the heartbeats
+	HEARTBEAT_MISSED_LIMIT = 3
+)
+
+// The guest is under pressure if the available memory or the free space of
+// a filesystem is lower than the ratio of the total.
+var (
+	GuestMemoryPressureRatio = 0.1
+	GuestDiskPressureRatio   = 0.05
+)
+
+// guestHeartbeat is the payload of INIT_HEARTBEAT
+type guestHeartbeat struct {
+	Interval     uint64                  `json:"interval"`
+	Uptime       uint64                  `json:"uptime"`
+	Load         [3]float64              `json:"load"`
+	MemTotal     uint64                  `json:"memTotal"`
+	MemAvailable uint64                  `json:"memAvailable"`
+	Filesystems  []types.GuestFilesystem `json:"filesystems"`
+}
+
+// guestStatus judges the status of the guest from the heartbeat
+func guestStatus(hb *guestHeartbeat) (string, string) {
+	if hb.MemTotal > 0 && float64(hb.MemAvailable) < float64(hb.MemTotal)*GuestMemoryPressureRatio {
+		return types.GUEST_MEMORY_PRESSURE, fmt.Sprintf("%d of %d bytes memory available", hb.MemAvailable, hb.MemTotal)
+	}
+	for _, fs := range hb.Filesystems {
+		if fs.Total > 0 && float64(fs.Free) < float64(fs.Total)*GuestDiskPressureRatio {
+			return types.GUEST_DISK_PRESSURE, fmt.Sprintf("%d of %d bytes free on %s", fs.Free, fs.Total, fs.Path)
+		}
+	}
+	return types.GUEST_HEALTHY, ""
+}
+
+func (ctx *VmContext) onGuestHeartbeat(ev *GuestHeartbeat) {
+	hb := &guestHeartbeat{}
+	if err := json.Unmarshal(ev.msg, hb); err != nil {
+		glog.Warningf("invalid heartbeat of vm %s: %v", ctx.Id, err)
+		return
+	}
+
+	// the guest is unresponsive if the next heartbeats are missed
+	interval := DEFAULT_HEARTBEAT_INTERVAL
+	if hb.Interval > 0 {
+		interval = time.Duration(hb.Interval) * time.Second
+	}
+	ctx.heartbeats++
+	seq := ctx.heartbeats
+	if ctx.heartbeatTimer != nil {
+		ctx.heartbeatTimer.Stop()
+	}
+	ctx.heartbeatTimer = time.AfterFunc(interval*HEARTBEAT_MISSED_LIMIT, func() {
+		defer func() { recover() }()
+		ctx.Hub <- &GuestUnresponsive{seq: seq}
+	})
+
+	health := &types.GuestHealth{
+		LastHeartbeat: time.Now(),
+		Uptime:        hb.Uptime,
+		Load:          hb.Load,
+		MemTotal:      hb.MemTotal,
+		MemAvailable:  hb.MemAvailable,
+		Filesystems:   hb.Filesystems,
+	}
+	health.Status, health.Reason = guestStatus(hb)
+	ctx.updateGuestHealth(health)
+}
+
+func (ctx *VmContext) onGuestUnresponsive(ev *GuestUnresponsive) {
+	// a heartbeat came after the timer fired
+	if ev.seq != ctx.heartbeats || ctx.guestHealth == nil {
+		return
+	}
+	health := *ctx.guestHealth
+	health.Status = types.GUEST_UNRESPONSIVE
+	health.Reason = "no heartbeat since " + health.LastHeartbeat.Format(time.RFC3339)
+	glog.Warningf("vm %s is unresponsive: %s", ctx.Id, health.Reason)
+	ctx.updateGuestHealth(&health)
+}
+
+// updateGuestHealth keeps the health, and reports it to the daemon if the
+// status of the guest is changed
+func (ctx *VmContext) updateGuestHealth(health *types.GuestHealth) {
+	old := ctx.guestHealth
+	ctx.guestHealth = health
+	if old != nil && old.Status == health.Status {
+		return
+	}
+	glog.Infof("guest of vm %s is %s %s", ctx.Id, health.Status, health.Reason)
+	h := *health
+	ctx.client <- &types.VmResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_GUEST_HEALTH,
+		Cause: health.Reason,
+		Data:  &h,
+	}
+}
@@ -0,0 +1,76 @@
+package hypervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+func TestGuestStatus(t *testing.T) {
+	cases := []struct {
+		hb     guestHeartbeat
+		status string
+	}{
+		{guestHeartbeat{}, types.GUEST_HEALTHY},
+		{guestHeartbeat{MemTotal: 1000, MemAvailable: 500}, types.GUEST_HEALTHY},
+		{guestHeartbeat{MemTotal: 1000, MemAvailable: 50}, types.GUEST_MEMORY_PRESSURE},
+		{guestHeartbeat{Filesystems: []types.GuestFilesystem{{"/", 1000, 500}, {"/data", 1000, 10}}}, types.GUEST_DISK_PRESSURE},
+	}
+	for i, c := range cases {
+		if status, _ := guestStatus(&c.hb); status != c.status {
+			t.Fatalf("case %d: expect %s, got %s", i, c.status, status)
+		}
+	}
+}
+
+func TestGuestHeartbeat(t *testing.T) {
+	ctx := &VmContext{
+		Id:     "vm-test",
+		Hub:    make(chan VmEvent, 16),
+		client: make(chan *types.VmResponse, 16),
+	}
+	health := func() *types.GuestHealth {
+		select {
+		case r := <-ctx.client:
+			if r.Code != types.E_GUEST_HEALTH {
+				t.Fatalf("expect guest health, got %d", r.Code)
+			}
+			return r.Data.(*types.GuestHealth)
+		case <-time.After(5 * time.Second):
+			t.Fatal("guest health not reported")
+		}
+		return nil
+	}
+
+	ctx.onGuestHeartbeat(&GuestHeartbeat{msg: []byte(`{"interval":1,"uptime":5,"memTotal":1000,"memAvailable":500}`)})
+	if h := health(); h.Status != types.GUEST_HEALTHY || h.Uptime != 5 {
+		t.Fatalf("unexpected health %v", h)
+	}
+
+	// the same status is not reported again
+	ctx.onGuestHeartbeat(&GuestHeartbeat{msg: []byte(`{"interval":1,"uptime":6,"memTotal":1000,"memAvailable":400}`)})
+	if len(ctx.client) != 0 {
+		t.Fatal("unchanged health should not be reported")
+	}
+	if ctx.guestHealth.Uptime != 6 {
+		t.Fatalf("the latest heartbeat is not kept: %v", ctx.guestHealth)
+	}
+
+	// a stale timer is ignored
+	ctx.onGuestUnresponsive(&GuestUnresponsive{seq: 1})
+	if len(ctx.client) != 0 {
+		t.Fatal("stale unresponsive event should be ignored")
+	}
+
+	select {
+	case ev := <-ctx.Hub:
+		ctx.onGuestUnresponsive(ev.(*GuestUnresponsive))
+	case <-time.After(5 * time.Second):
+		t.Fatal("missed heartbeats are not noticed")
+	}
+	if h := health(); h.Status != types.GUEST_UNRESPONSIVE {
+		t.Fatalf("expect unresponsive, got %v", h)
+	}
+	ctx.heartbeatTimer.Stop()
+}
@@ -0,0 +1,359 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+func isBlockVolume(info *BlockDescriptor) bool {
+	return info.Format == "raw" || info.Format == "qcow2" || info.Format == "vdi" || info.Format == "rbd"
+}
+
+// attachVolume hot adds a volume to the running pod. A block volume is
+// inserted into the VM first, and mounted after EVENT_BLOCK_INSERTED.
+func (ctx *VmContext) attachVolume(cmd *AttachVolumeCommand) {
+	name := cmd.Volume.Name
+	if _, ok := ctx.devices.volumeMap[name]; ok {
+		ctx.reportVolume(cmd, fmt.Sprintf("volume %s already exists", name))
+		return
+	}
+	if _, ok := ctx.hotplugVolumes[name]; ok {
+		ctx.reportVolume(cmd, fmt.Sprintf("volume %s is being hotplugged", name))
+		return
+	}
+
+	vol := &volumeInfo{
+		info: &BlockDescriptor{
+			Name:     name,
+			Filename: cmd.Volume.Filepath,
+			Format:   cmd.Volume.Format,
+			Fstype:   cmd.Volume.Fstype,
+		},
+		pos:      make(map[int]string),
+		readOnly: make(map[int]bool),
+	}
+	for _, m := range cmd.Mounts {
+		idx := ctx.Lookup(m.Container)
+		if idx < 0 {
+			ctx.reportVolume(cmd, fmt.Sprintf("can not find container %s", m.Container))
+			return
+		}
+		vol.pos[idx] = m.Path
+		vol.readOnly[idx] = m.ReadOnly
+	}
+
+	ctx.lock.Lock()
+	ctx.devices.volumeMap[name] = vol
+	ctx.hotplugVolumes[name] = cmd
+	ctx.lock.Unlock()
+
+	if cmd.Volume.Fstype == "dir" {
+		vol.info.Fstype = ""
+		for i, mount := range vol.pos {
+			ctx.vmSpec.Containers[i].Fsmap = append(ctx.vmSpec.Containers[i].Fsmap, VmFsmapDescriptor{
+				Source:   vol.info.Filename,
+				Path:     mount,
+				ReadOnly: vol.readOnly[i],
+			})
+		}
+		ctx.mountVolume(name)
+		return
+	}
+
+	vol.info.ScsiId = ctx.nextScsiId()
+	glog.V(1).Infof("hot add volume %s (%s)", name, vol.info.Filename)
+	ctx.DCtx.AddDisk(ctx, "volume", vol.info)
+}
+
+// onHotplugBlockInserted returns false if the block device is not a hot added volume
+func (ctx *VmContext) onHotplugBlockInserted(info *BlockdevInsertedEvent) bool {
+	cmd, ok := ctx.hotplugVolumes[info.Name]
+	if !ok {
+		return false
+	}
+	if _, ok := cmd.(*AttachVolumeCommand); !ok {
+		return false
+	}
+	ctx.mountVolume(info.Name)
+	return true
+}
+
+func (ctx *VmContext) volumeHotplugMessage(name string) *VmVolumeHotplug {
+	vol := ctx.devices.volumeMap[name]
+	msg := &VmVolumeHotplug{
+		Name:    name,
+		Volumes: make(map[string][]VmVolumeDescriptor),
+		Fsmap:   make(map[string][]VmFsmapDescriptor),
+	}
+	for i, mount := range vol.pos {
+		c := ctx.vmSpec.Containers[i]
+		if vol.info.Fstype == "" {
+			msg.Fsmap[c.Id] = append(msg.Fsmap[c.Id], VmFsmapDescriptor{
+				Source:   vol.info.Filename,
+				Path:     mount,
+				ReadOnly: vol.readOnly[i],
+			})
+			continue
+		}
+		for _, v := range c.Volumes {
+			if v.Device == vol.info.DeviceName && v.Mount == mount {
+				msg.Volumes[c.Id] = append(msg.Volumes[c.Id], v)
+			}
+		}
+	}
+	return msg
+}
+
+func (ctx *VmContext) mountVolume(name string) {
+	ctx.sendVolumeHotplug(INIT_MOUNTVOLUME, name, ctx.hotplugVolumes[name])
+}
+
+func (ctx *VmContext) sendVolumeHotplug(code uint32, name string, cmd VmEvent) {
+	msg, err := json.Marshal(ctx.volumeHotplugMessage(name))
+	if err != nil {
+		ctx.reportVolume(cmd, "Generated wrong volume profile "+err.Error())
+		return
+	}
+	ctx.vm <- &DecodedMessage{
+		Code:    code,
+		Message: msg,
+		Event:   cmd,
+		Timeout: INIT_VOLUME_TIMEOUT,
+	}
+}
+
+// detachVolume umounts the volume in the containers, the block device is
+// removed from the VM after the guest acknowledged.
+func (ctx *VmContext) detachVolume(cmd *DetachVolumeCommand) {
+	if _, ok := ctx.devices.volumeMap[cmd.Name]; !ok {
+		ctx.reportVolume(cmd, fmt.Sprintf("can not find volume %s", cmd.Name))
+		return
+	}
+	if _, ok := ctx.hotplugVolumes[cmd.Name]; ok {
+		ctx.reportVolume(cmd, fmt.Sprintf("volume %s is being hotplugged", cmd.Name))
+		return
+	}
+	ctx.hotplugVolumes[cmd.Name] = cmd
+	ctx.sendVolumeHotplug(INIT_UMOUNTVOLUME, cmd.Name, cmd)
+}
+
+func (ctx *VmContext) onVolumeHotplugAck(reply VmEvent, fail bool) {
+	switch cmd := reply.(type) {
+	case *AttachVolumeCommand:
+		name := cmd.Volume.Name
+		delete(ctx.hotplugVolumes, name)
+		if !fail {
+			glog.Infof("volume %s is mounted", name)
+			ctx.reportVolume(cmd, "")
+			return
+		}
+		vol := ctx.devices.volumeMap[name]
+		ctx.forgetVolume(name)
+		if vol != nil && isBlockVolume(vol.info) {
+			ctx.DCtx.RemoveDisk(ctx, vol.info, &VolumeUnmounted{Name: name, Success: false})
+		}
+		ctx.reportVolume(cmd, fmt.Sprintf("mount volume %s failed", name))
+	case *DetachVolumeCommand:
+		if fail {
+			delete(ctx.hotplugVolumes, cmd.Name)
+			ctx.reportVolume(cmd, fmt.Sprintf("umount volume %s failed", cmd.Name))
+			return
+		}
+		vol := ctx.devices.volumeMap[cmd.Name]
+		if isBlockVolume(vol.info) {
+			glog.V(1).Infof("hot remove volume %s (%s)", cmd.Name, vol.info.DeviceName)
+			ctx.DCtx.RemoveDisk(ctx, vol.info, &VolumeUnmounted{Name: cmd.Name, Success: true})
+		} else {
+			go UmountVolume(ctx.ShareDir, vol.info.Filename, cmd.Name, ctx.Hub)
+		}
+	}
+}
+
+// onHotplugVolumeRemoved returns false if the volume is not being hot removed
+func (ctx *VmContext) onHotplugVolumeRemoved(v *VolumeUnmounted) bool {
+	reply, ok := ctx.hotplugVolumes[v.Name]
+	if !ok {
+		return false
+	}
+	cmd, ok := reply.(*DetachVolumeCommand)
+	if !ok {
+		return false
+	}
+
+	delete(ctx.hotplugVolumes, v.Name)
+	ctx.forgetVolume(v.Name)
+	if !v.Success {
+		ctx.reportVolume(cmd, fmt.Sprintf("remove volume %s failed", v.Name))
+		return true
+	}
+	glog.Infof("volume %s is detached", v.Name)
+	ctx.reportVolume(cmd, "")
+	return true
+}
+
+// forgetVolume removes the volume from the device map and the vm spec
+func (ctx *VmContext) forgetVolume(name string) {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+
+	vol, ok := ctx.devices.volumeMap[name]
+	if !ok {
+		return
+	}
+	for i, mount := range vol.pos {
+		c := &ctx.vmSpec.Containers[i]
+		volumes := []VmVolumeDescriptor{}
+		for _, v := range c.Volumes {
+			if vol.info.DeviceName == "" || v.Device != vol.info.DeviceName || v.Mount != mount {
+				volumes = append(volumes, v)
+			}
+		}
+		c.Volumes = volumes
+		fsmap := []VmFsmapDescriptor{}
+		for _, f := range c.Fsmap {
+			if vol.info.Fstype != "" || f.Source != vol.info.Filename || f.Path != mount {
+				fsmap = append(fsmap, f)
+			}
+		}
+		c.Fsmap = fsmap
+	}
+	delete(ctx.devices.volumeMap, name)
+}
+
+// removeContainer removes an exited container from the running pod. The
+// container is removed from the guest first, then its rootfs is released on
+// the host, the block device is ejected from the VM and the dm device of the
+// host is removed.
+func (ctx *VmContext) removeContainer(cmd *RemoveContainerCommand) {
+	idx := ctx.Lookup(cmd.Container)
+	if idx < 0 {
+		ctx.reportContainerCmd(cmd, fmt.Sprintf("container %s is not in the pod", cmd.Container))
+		return
+	}
+	if _, ok := ctx.pendingRemovals[idx]; ok {
+		ctx.reportContainerCmd(cmd, fmt.Sprintf("container %s is being removed", cmd.Container))
+		return
+	}
+	removeCmd, err := json.Marshal(*cmd)
+	if err != nil {
+		ctx.Hub <- &InitFailedEvent{
+			Reason: "Generated wrong remove profile " + err.Error(),
+		}
+		return
+	}
+	ctx.pendingRemovals[idx] = cmd
+	ctx.vm <- &DecodedMessage{
+		Code:    INIT_REMOVECONTAINER,
+		Message: removeCmd,
+		Event:   cmd,
+	}
+}
+
+func (ctx *VmContext) onContainerRemoveAck(reply VmEvent, fail bool) {
+	cmd := reply.(*RemoveContainerCommand)
+	idx := ctx.Lookup(cmd.Container)
+	if fail {
+		delete(ctx.pendingRemovals, idx)
+		ctx.reportContainerCmd(cmd, fmt.Sprintf("remove container %s failed", cmd.Container))
+		return
+	}
+
+	c := ctx.vmSpec.Containers[idx]
+	if c.Fstype == "" {
+		glog.V(1).Infof("hot remove container %s, unmount %s", cmd.Container, c.Image)
+		ctx.releaseContainerDir(idx, c.Image)
+		return
+	}
+	if _, image := ctx.containerImage(idx); image != nil {
+		glog.V(1).Infof("hot remove container %s, eject %s", cmd.Container, image.info.DeviceName)
+		ctx.DCtx.RemoveDisk(ctx, image.info, &ContainerUnmounted{Index: idx, Success: true})
+		return
+	}
+	ctx.containerRemoved(idx, "")
+}
+
+// onHotplugContainerRemoved returns false if the container is not being hot
+// removed
+func (ctx *VmContext) onHotplugContainerRemoved(c *ContainerUnmounted) bool {
+	if _, ok := ctx.pendingRemovals[c.Index]; !ok {
+		return false
+	}
+	if !c.Success {
+		ctx.containerRemoved(c.Index, "release the rootfs failed")
+		return true
+	}
+	if name, image := ctx.containerImage(c.Index); image != nil {
+		if strings.HasPrefix(image.info.Filename, "/dev/mapper/") {
+			go UmountDMDevice(image.info.Filename, name, ctx.Hub)
+			return true
+		}
+		if strings.HasPrefix(image.info.Filename, "/dev/rbd/rbd/") {
+			go UmountRbdDevice(image.info.Filename, name, ctx.Hub)
+			return true
+		}
+	}
+	ctx.containerRemoved(c.Index, "")
+	return true
+}
+
+// onHotplugContainerReleased returns false if the device is not the image of
+// a container being hot removed
+func (ctx *VmContext) onHotplugContainerReleased(b *BlockdevRemovedEvent) bool {
+	image, ok := ctx.devices.imageMap[b.Name]
+	if !ok {
+		return false
+	}
+	if _, ok := ctx.pendingRemovals[image.pos]; !ok {
+		return false
+	}
+	if !b.Success {
+		ctx.containerRemoved(image.pos, fmt.Sprintf("remove device %s failed", image.info.Filename))
+		return true
+	}
+	ctx.containerRemoved(image.pos, "")
+	return true
+}
+
+// releaseContainerDir unmounts the rootfs of the container from the share
+// dir, ContainerUnmounted is sent to the hub when it is done
+func (ctx *VmContext) releaseContainerDir(idx int, image string) {
+	switch {
+	case supportAufs():
+		go UmountAufsContainer(ctx.ShareDir, image, idx, ctx.Hub)
+	case supportOverlay():
+		go UmountOverlayContainer(ctx.ShareDir, image, idx, ctx.Hub)
+	default:
+		go func() { ctx.Hub <- &ContainerUnmounted{Index: idx, Success: true} }()
+	}
+}
+
+func (ctx *VmContext) containerImage(idx int) (string, *imageInfo) {
+	for name, image := range ctx.devices.imageMap {
+		if image.pos == idx {
+			return name, image
+		}
+	}
+	return "", nil
+}
+
+// containerRemoved forgets the image of the removed container, and reports
+// the removal. The container keeps its place in the vm spec, as the other
+// containers are referred to by the index.
+func (ctx *VmContext) containerRemoved(idx int, cause string) {
+	cmd := ctx.pendingRemovals[idx]
+	delete(ctx.pendingRemovals, idx)
+
+	ctx.lock.Lock()
+	if name, image := ctx.containerImage(idx); image != nil {
+		delete(ctx.devices.imageMap, name)
+	}
+	ctx.lock.Unlock()
+
+	if cause == "" {
+		glog.Infof("container %s is removed", cmd.Container)
+	}
+	ctx.reportContainerCmd(cmd, cause)
+}
@@ -0,0 +1,62 @@
+package hypervisor
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+func TestRemoveContainer(t *testing.T) {
+	shareDir, err := ioutil.TempDir("", "remove-container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(shareDir)
+
+	ctx := &VmContext{
+		Id:              "vm-test",
+		Hub:             make(chan VmEvent, 1),
+		vm:              make(chan *DecodedMessage, 1),
+		client:          make(chan *types.VmResponse, 1),
+		lock:            &sync.Mutex{},
+		ShareDir:        shareDir,
+		devices:         newDeviceMap(),
+		pendingRemovals: make(map[int]*RemoveContainerCommand),
+		vmSpec: &VmPod{
+			Containers: []VmContainer{{Id: "c1", Image: "/c1/rootfs"}},
+		},
+	}
+
+	cmd := &RemoveContainerCommand{Container: "c1"}
+	ctx.removeContainer(cmd)
+	msg := <-ctx.vm
+	if msg.Code != INIT_REMOVECONTAINER || msg.Event != cmd {
+		t.Fatalf("unexpected remove message %d %s", msg.Code, string(msg.Message))
+	}
+
+	ctx.removeContainer(&RemoveContainerCommand{Container: "c1"})
+	if r := <-ctx.client; r.Code != types.E_FAILED {
+		t.Fatalf("removing a container twice should fail, got %d", r.Code)
+	}
+
+	// the rootfs is released after init removed the container
+	ctx.onContainerRemoveAck(cmd, false)
+	ev := <-ctx.Hub
+	if !ctx.onHotplugContainerRemoved(ev.(*ContainerUnmounted)) {
+		t.Fatal("the container should be being removed")
+	}
+	if r := <-ctx.client; r.Code != types.E_OK || r.Reply != cmd {
+		t.Fatalf("unexpected response %d %s", r.Code, r.Cause)
+	}
+	if len(ctx.pendingRemovals) != 0 {
+		t.Fatal("the removal should be finished")
+	}
+
+	ctx.removeContainer(&RemoveContainerCommand{Container: "c2"})
+	if r := <-ctx.client; r.Code != types.E_FAILED {
+		t.Fatalf("removing an unknown container should fail, got %d", r.Code)
+	}
+}
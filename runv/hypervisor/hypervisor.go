@@ -11,17 +11,13 @@ import (
 func (ctx *VmContext) startSocks() {
 	go waitInitReady(ctx)
 	go waitPts(ctx)
-	if glog.V(1) {
-		go waitConsoleOutput(ctx)
-	}
+	go waitConsoleOutput(ctx)
 }
 
 func (ctx *VmContext) startSocksInListen() {
 	go startHyperSock(ctx)
 	go waitPts(ctx)
-	if glog.V(1) {
-		go waitConsoleOutput(ctx)
-	}
+	go waitConsoleOutput(ctx)
 }
 
 func (ctx *VmContext) loop() {
@@ -102,9 +98,7 @@ func VmAssociate(vmId string, hub chan VmEvent, client chan *types.VmResponse,
 
 	go waitPts(context)
 	go connectToInit(context)
-	if glog.V(1) {
-		go waitConsoleOutput(context)
-	}
+	go waitConsoleOutput(context)
 
 	context.Become(stateRunning, "RUNNING")
 
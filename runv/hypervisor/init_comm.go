@@ -1,8 +1,10 @@
 package hypervisor
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
 	"time"
 
@@ -11,11 +13,36 @@ import (
 	"github.com/hyperhq/runv/lib/utils"
 )
 
+const (
+	// the time to wait for init to accept the protocol version
+	INIT_VERSION_TIMEOUT = 10 * time.Second
+
+	// the requests answered at once by init are canceled if not replied
+	INIT_WINSIZE_TIMEOUT  = 10 * time.Second
+	INIT_READFILE_TIMEOUT = 60 * time.Second
+	INIT_VOLUME_TIMEOUT   = 60 * time.Second
+
+	// the largest message taken from init, the connection fails with a
+	// longer one
+	INIT_MAX_MESSAGE_SIZE = 16 << 20
+)
+
 // Message
 type DecodedMessage struct {
 	Code    uint32
 	Message []byte
 	Event   VmEvent
+
+	// Id identifies the request to init, it is assigned when the request
+	// is sent and is carried in the messages of protocol version 2
+	Id uint32
+	// Timeout cancels the request if init does not reply in time
+	Timeout time.Duration
+
+	timer    *time.Timer
+	done     chan struct{}   // closed when the request no longer times out
+	target   *DecodedMessage // the request canceled by INIT_CANCEL
+	canceled bool            // the reply to the request is dropped
 }
 
 type FinishCmd struct {
@@ -39,13 +66,32 @@ func waitConsoleOutput(ctx *VmContext) {
 	}
 	glog.V(1).Infof("connected %s as telnet mode.", ctx.ConsoleSockName)
 
+	// the console output is kept for the post-mortem of the VM
+	clog, err := newConsoleLog(ctx.HomeDir + ConsoleLogName)
+	if err != nil {
+		glog.Error("fail to open the console log of ", ctx.Id, ": ", err.Error())
+	} else {
+		defer clog.Close()
+	}
+
+	// the output is also forwarded to the client attached to the console
+	console := &vmConsole{conn: tc}
+	registerConsole(ctx.Id, console)
+	defer unregisterConsole(ctx.Id)
+
 	cout := make(chan string, 128)
-	go TtyLiner(tc, cout)
+	go TtyLiner(bufio.NewReader(io.TeeReader(tc, console)), cout)
 
 	for {
 		line, ok := <-cout
 		if ok {
 			glog.V(1).Info("[console] ", line)
+			if clog != nil {
+				if err := clog.WriteLine(line); err != nil {
+					glog.Error("fail to write the console log of ", ctx.Id, ": ", err.Error())
+					clog = nil
+				}
+			}
 		} else {
 			glog.Info("console output end")
 			break
@@ -54,55 +100,104 @@ func waitConsoleOutput(ctx *VmContext) {
 }
 
 func NewVmMessage(m *DecodedMessage) []byte {
-	length := len(m.Message) + 8
+	return newVmMessage(m, INIT_PROTO_V1)
+}
+
+func ReadVmMessage(conn *net.UnixConn) (*DecodedMessage, error) {
+	return readVmMessage(conn, INIT_PROTO_V1)
+}
+
+// initHeaderSize returns the size of the message header, it is code and
+// length in version 1, followed by the request id in version 2
+func initHeaderSize(version int) int {
+	if version >= INIT_PROTO_V2 {
+		return 12
+	}
+	return 8
+}
+
+func newVmMessage(m *DecodedMessage, version int) []byte {
+	header := initHeaderSize(version)
+	length := len(m.Message) + header
 	msg := make([]byte, length)
 	binary.BigEndian.PutUint32(msg[:], uint32(m.Code))
 	binary.BigEndian.PutUint32(msg[4:], uint32(length))
-	copy(msg[8:], m.Message)
+	if version >= INIT_PROTO_V2 {
+		binary.BigEndian.PutUint32(msg[8:], m.Id)
+	}
+	copy(msg[header:], m.Message)
 	return msg
 }
 
-func ReadVmMessage(conn *net.UnixConn) (*DecodedMessage, error) {
-	needRead := 8
-	length := 0
-	read := 0
-	buf := make([]byte, 512)
-	res := []byte{}
-	for read < needRead {
-		want := needRead - read
-		if want > 512 {
-			want = 512
-		}
-		glog.V(1).Infof("trying to read %d bytes", want)
-		nr, err := conn.Read(buf[:want])
-		if err != nil {
-			glog.Error("read init data failed")
-			return nil, err
-		}
+func readVmMessage(conn io.Reader, version int) (*DecodedMessage, error) {
+	header := initHeaderSize(version)
+	res := make([]byte, header)
+	if _, err := io.ReadFull(conn, res); err != nil {
+		glog.Error("read init data failed")
+		return nil, err
+	}
 
+	length := int(binary.BigEndian.Uint32(res[4:8]))
+	glog.V(1).Infof("data length is %d", length)
+	if length > INIT_MAX_MESSAGE_SIZE {
+		glog.Errorf("init message of %d bytes is too long", length)
+		return nil, fmt.Errorf("init message of %d bytes exceeds the limit of %d", length, INIT_MAX_MESSAGE_SIZE)
+	}
+	if length < header {
+		length = header
+	}
 
-		res = append(res, buf[:nr]...)
-		read = read + nr
+	msg := &DecodedMessage{
+		Code:    binary.BigEndian.Uint32(res[:4]),
+		Message: make([]byte, length-header),
+	}
+	if version >= INIT_PROTO_V2 {
+		msg.Id = binary.BigEndian.Uint32(res[8:12])
+	}
+	if _, err := io.ReadFull(conn, msg.Message); err != nil {
+		glog.Error("read init data failed")
+		return nil, err
+	}
+	return msg, nil
+}
 
-		if nr<want && read<needRead {
-			return ReadVmMessage(conn)
-		}
+// negotiateInitVersion agrees on the protocol version with init. The init
+// supporting a newer protocol puts its version in the INIT_READY message
+// and waits for INIT_VERSION, the old one sends an empty INIT_READY.
+func negotiateInitVersion(conn *net.UnixConn, ready *DecodedMessage) (int, error) {
+	if len(ready.Message) < 4 {
+		return INIT_PROTO_V1, nil
+	}
+	version := int(binary.BigEndian.Uint32(ready.Message[:4]))
+	if version > INIT_PROTO_MAX {
+		version = INIT_PROTO_MAX
+	}
+	if version < INIT_PROTO_V2 {
+		return INIT_PROTO_V1, nil
+	}
 
-		glog.V(1).Infof("read %d/%d [length = %d]", read, needRead, length)
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(version))
+	if _, err := conn.Write(NewVmMessage(&DecodedMessage{Code: INIT_VERSION, Message: payload})); err != nil {
+		return 0, err
+	}
 
-		if length == 0 && read >= 8 {
-			length = int(binary.BigEndian.Uint32(res[4:8]))
-			glog.V(1).Infof("data length is %d", length)
-			if length > 8 {
-				needRead = length
-			}
+	conn.SetReadDeadline(time.Now().Add(INIT_VERSION_TIMEOUT))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := ReadVmMessage(conn)
+		if err != nil {
+			return 0, err
+		}
+		switch msg.Code {
+		case INIT_NEXT:
+		case INIT_ACK:
+			return version, nil
+		default:
+			glog.Warningf("init refused protocol version %d, fall back to version %d", version, INIT_PROTO_V1)
+			return INIT_PROTO_V1, nil
 		}
 	}
-
-	return &DecodedMessage{
-		Code:    binary.BigEndian.Uint32(res[:4]),
-		Message: res[8:],
-	}, nil
 }
 
 func waitInitReady(ctx *VmContext) {
@@ -126,6 +221,17 @@ func waitInitReady(ctx *VmContext) {
 		conn.Close()
 	} else if msg.Code == INIT_READY {
 		glog.Info("Get init ready message")
+		version, err := negotiateInitVersion(conn.(*net.UnixConn), msg)
+		if err != nil {
+			glog.Error("negotiate init protocol failed... ", err.Error())
+			ctx.Hub <- &InitFailedEvent{
+				Reason: "negotiate init protocol failed... " + err.Error(),
+			}
+			conn.Close()
+			return
+		}
+		glog.Infof("talk with init in protocol version %d", version)
+		ctx.initVersion = version
 		ctx.Hub <- &InitConnectedEvent{conn: conn.(*net.UnixConn)}
 		go waitCmdToInit(ctx, conn.(*net.UnixConn))
 	} else {
@@ -162,46 +268,157 @@ func connectToInit(ctx *VmContext) {
 	go waitCmdToInit(ctx, conn.(*net.UnixConn))
 }
 
+// stopTimer stops the timeout of the request. The timer which fired already
+// gives up handing the request over once done is closed.
+func (cmd *DecodedMessage) stopTimer() {
+	if cmd.timer == nil {
+		return
+	}
+	cmd.timer.Stop()
+	cmd.timer = nil
+	close(cmd.done)
+}
+
+// initCmdIndex returns the position of the request in the queue
+func initCmdIndex(cmds []*DecodedMessage, cmd *DecodedMessage) int {
+	for i, c := range cmds {
+		if c == cmd {
+			return i
+		}
+	}
+	return -1
+}
+
+// initReplyIndex returns the position of the request replied by msg. The
+// replies come in the order of the requests in protocol version 1.
+func initReplyIndex(cmds []*DecodedMessage, msg *DecodedMessage, version int) int {
+	if version < INIT_PROTO_V2 {
+		if len(cmds) > 0 {
+			return 0
+		}
+		return -1
+	}
+	for i, c := range cmds {
+		if c.Id == msg.Id {
+			return i
+		}
+	}
+	return -1
+}
+
 func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 	looping := true
+	version := ctx.initVersion
 	cmds := []*DecodedMessage{}
 
 	var data []byte
 	var timeout bool = false
 	var index int = 0
 	var got int = 0
+	var nextId uint32 = 0
 	var pingTimer *time.Timer = nil
 	var pongTimer *time.Timer = nil
 
-	go waitInitAck(ctx, init)
+	// the timers hand the expired requests and the pings over to the loop,
+	// until the request is done or the loop quits
+	expired := make(chan *DecodedMessage)
+	pings := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+
+	send := func(cmd *DecodedMessage) {
+		// id 0 is left for the messages not replying any request
+		if nextId++; nextId == 0 {
+			nextId++
+		}
+		cmd.Id = nextId
+		payload := string(cmd.Message)
+		if cmd.Code == INIT_STARTPOD || cmd.Code == INIT_NEWCONTAINER {
+			// the specs carry the content of the tmpfs files
+			payload = "<container specs>"
+		}
+		glog.V(1).Infof("send command %d [id %d] to init, payload: '%s'.", cmd.Code, cmd.Id, payload)
+		cmds = append(cmds, cmd)
+		data = append(data, newVmMessage(cmd, version)...)
+		timeout = true
+		if cmd.Timeout > 0 {
+			done := make(chan struct{})
+			cmd.done = done
+			cmd.timer = time.AfterFunc(cmd.Timeout, func() {
+				select {
+				case expired <- cmd:
+				case <-done:
+				}
+			})
+		}
+	}
+
+	// next takes the message from the vm, or the cancel of an expired
+	// request, which fails the request and is also sent to init if the
+	// protocol supports it, otherwise the reply to it is dropped when it
+	// comes.
+	next := func() (*DecodedMessage, bool) {
+		select {
+		case cmd, ok := <-ctx.vm:
+			return cmd, ok
+		case target := <-expired:
+			return &DecodedMessage{
+				Code:    INIT_CANCEL,
+				Message: []byte(fmt.Sprintf("init did not reply in %v", target.Timeout)),
+				target:  target,
+			}, true
+		case <-pings:
+			pingTimer = nil
+			glog.V(1).Info("Send ping message to init")
+			return &DecodedMessage{
+				Code:    INIT_PING,
+				Message: []byte{},
+			}, true
+		}
+	}
+
+	go waitInitAck(ctx, init, version)
 
 	for looping {
-		cmd, ok := <-ctx.vm
+		cmd, ok := next()
 		if !ok {
 			glog.Info("vm channel closed, quit")
 			break
 		}
 		glog.Infof("got cmd:%d", cmd.Code)
 		if cmd.Code == INIT_ACK || cmd.Code == INIT_ERROR {
-			if len(cmds) > 0 {
-				if cmds[0].Code == INIT_DESTROYPOD {
-					glog.Info("got response of shutdown command, last round of command to init")
-					looping = false
-				}
-				if cmd.Code == INIT_ACK {
-					if cmds[0].Code != INIT_PING {
-						ctx.Hub <- &CommandAck{
-							reply: cmds[0],
-							msg:   cmd.Message,
+			if idx := initReplyIndex(cmds, cmd, version); idx >= 0 {
+				reply := cmds[idx]
+				cmds = append(cmds[:idx], cmds[idx+1:]...)
+				reply.stopTimer()
+
+				if reply.canceled {
+					glog.V(1).Infof("drop the reply to canceled command %d [id %d]", reply.Code, reply.Id)
+					if reply.Code == INIT_CANCEL {
+						// init will not reply the canceled request any more
+						if i := initCmdIndex(cmds, reply.target); i >= 0 {
+							cmds = append(cmds[:i], cmds[i+1:]...)
 						}
 					}
 				} else {
-					ctx.Hub <- &CommandError{
-						reply: cmds[0],
-						msg:   cmd.Message,
+					if reply.Code == INIT_DESTROYPOD {
+						glog.Info("got response of shutdown command, last round of command to init")
+						looping = false
+					}
+					if cmd.Code == INIT_ACK {
+						if reply.Code != INIT_PING {
+							ctx.Hub <- &CommandAck{
+								reply: reply,
+								msg:   cmd.Message,
+							}
+						}
+					} else {
+						ctx.Hub <- &CommandError{
+							reply: reply,
+							msg:   cmd.Message,
+						}
 					}
 				}
-				cmds = cmds[1:]
 
 				if pongTimer != nil {
 					glog.V(1).Info("ack got, clear pong timer")
@@ -210,20 +427,19 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 				}
 				if pingTimer == nil {
 					pingTimer = time.AfterFunc(30*time.Second, func() {
-						defer func() { recover() }()
-						glog.V(1).Info("Send ping message to init")
-						ctx.vm <- &DecodedMessage{
-							Code:    INIT_PING,
-							Message: []byte{},
+						select {
+						case pings <- struct{}{}:
+						case <-quit:
 						}
-						pingTimer = nil
 					})
 				} else {
 					pingTimer.Reset(30 * time.Second)
 				}
 			} else {
-				glog.Error("got ack but no command in queue")
+				glog.Errorf("got ack [id %d] but no command in queue", cmd.Id)
 			}
+		} else if cmd.Code == INIT_HEARTBEAT {
+			ctx.Hub <- &GuestHeartbeat{msg: cmd.Message}
 		} else if cmd.Code == INIT_FINISHPOD {
 			num := len(cmd.Message) / 4
 			results := make([]uint32, num)
@@ -261,11 +477,31 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 					index = 0
 					got = 0
 				}
+			} else if cmd.Code == INIT_CANCEL && cmd.target != nil {
+				target := cmd.target
+				if initCmdIndex(cmds, target) < 0 || target.canceled {
+					// it has been replied
+					continue
+				}
+				glog.Infof("cancel command %d [id %d]: %s", target.Code, target.Id, string(cmd.Message))
+				target.canceled = true
+				target.stopTimer()
+				ctx.Hub <- &CommandError{
+					reply: target,
+					msg:   cmd.Message,
+				}
+				if version >= INIT_PROTO_V2 {
+					payload := make([]byte, 4)
+					binary.BigEndian.PutUint32(payload, target.Id)
+					send(&DecodedMessage{
+						Code:     INIT_CANCEL,
+						Message:  payload,
+						target:   target,
+						canceled: true,
+					})
+				}
 			} else {
-				glog.V(1).Infof("send command %d to init, payload: '%s'.", cmd.Code, string(cmd.Message))
-				cmds = append(cmds, cmd)
-				data = append(data, NewVmMessage(cmd)...)
-				timeout = true
+				send(cmd)
 			}
 
 			if index == 0 && len(data) != 0 {
@@ -288,6 +524,9 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 		}
 	}
 
+	for _, c := range cmds {
+		c.stopTimer()
+	}
 	if pingTimer != nil {
 		pingTimer.Stop()
 	}
@@ -296,14 +535,15 @@ func waitCmdToInit(ctx *VmContext, init *net.UnixConn) {
 	}
 }
 
-func waitInitAck(ctx *VmContext, init *net.UnixConn) {
+func waitInitAck(ctx *VmContext, init *net.UnixConn, version int) {
 	for {
-		res, err := ReadVmMessage(init)
+		res, err := readVmMessage(init, version)
 		if err != nil {
 			ctx.Hub <- &Interrupted{Reason: "init socket failed " + err.Error()}
 			return
 		} else if res.Code == INIT_ACK || res.Code == INIT_NEXT ||
-			res.Code == INIT_ERROR || res.Code == INIT_FINISHPOD {
+			res.Code == INIT_ERROR || res.Code == INIT_FINISHPOD ||
+			res.Code == INIT_HEARTBEAT {
 			ctx.vm <- res
 		} else {
 			fmt.Println("\n\n waitInitAck get unwanted response !!!\n\n")
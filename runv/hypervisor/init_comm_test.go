@@ -0,0 +1,302 @@
+package hypervisor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func initSocketPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "init")
+		c, err := net.FileConn(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+// fakeInit reports INIT_NEXT for every read like hyperstart, and hands the
+// requests to the test to reply
+type fakeInit struct {
+	sync.Mutex
+	conn     *net.UnixConn
+	version  int
+	requests chan *DecodedMessage
+}
+
+func (f *fakeInit) reply(m *DecodedMessage) {
+	f.Lock()
+	defer f.Unlock()
+	f.conn.Write(newVmMessage(m, f.version))
+}
+
+func (f *fakeInit) serve() {
+	header := initHeaderSize(f.version)
+	buf := make([]byte, 512)
+	var pending []byte
+	for {
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			close(f.requests)
+			return
+		}
+		next := make([]byte, 4)
+		binary.BigEndian.PutUint32(next, uint32(n))
+		f.reply(&DecodedMessage{Code: INIT_NEXT, Message: next})
+
+		pending = append(pending, buf[:n]...)
+		for len(pending) >= header {
+			length := int(binary.BigEndian.Uint32(pending[4:8]))
+			if len(pending) < length {
+				break
+			}
+			msg, _ := readVmMessage(bytes.NewReader(pending[:length]), f.version)
+			pending = pending[length:]
+			f.requests <- msg
+		}
+	}
+}
+
+func (f *fakeInit) request(t *testing.T, code uint32) *DecodedMessage {
+	select {
+	case req := <-f.requests:
+		if req == nil || req.Code != code {
+			t.Fatalf("expect request %d, got %v", code, req)
+		}
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatalf("request %d not received by init", code)
+	}
+	return nil
+}
+
+func startFakeInit(t *testing.T, version int) (*VmContext, *fakeInit, func()) {
+	host, guest := initSocketPair(t)
+	ctx := &VmContext{
+		Id:          "vm-test",
+		Hub:         make(chan VmEvent, 128),
+		vm:          make(chan *DecodedMessage, 128),
+		initVersion: version,
+	}
+	init := &fakeInit{
+		conn:     guest,
+		version:  version,
+		requests: make(chan *DecodedMessage, 16),
+	}
+	go init.serve()
+	go waitCmdToInit(ctx, host)
+
+	return ctx, init, func() {
+		host.Close()
+		guest.Close()
+		// the ack reader quits after reporting the interrupt
+		for ev := range ctx.Hub {
+			if ev.Event() == ERROR_INTERRUPTED {
+				break
+			}
+		}
+		close(ctx.vm)
+	}
+}
+
+func expectInitReply(t *testing.T, ctx *VmContext, event int, req *DecodedMessage) []byte {
+	select {
+	case ev := <-ctx.Hub:
+		if ev.Event() != event {
+			t.Fatalf("expect event %d to %d, got %d", event, req.Code, ev.Event())
+		}
+		var ack *CommandAck
+		if event == ERROR_CMD_FAIL {
+			ack = (*CommandAck)(ev.(*CommandError))
+		} else {
+			ack = ev.(*CommandAck)
+		}
+		if ack.reply != req {
+			t.Fatalf("expect reply to %d, got reply to %d", req.Code, ack.reply.Code)
+		}
+		return ack.msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reply to %d", req.Code)
+	}
+	return nil
+}
+
+func TestInitMessage(t *testing.T) {
+	for _, version := range []int{INIT_PROTO_V1, INIT_PROTO_V2} {
+		m := &DecodedMessage{Code: INIT_EXECCMD, Message: []byte("payload"), Id: 7}
+		res, err := readVmMessage(bytes.NewReader(newVmMessage(m, version)), version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Code != m.Code || string(res.Message) != "payload" {
+			t.Fatalf("version %d: got message %d '%s'", version, res.Code, res.Message)
+		}
+		if version == INIT_PROTO_V2 && res.Id != m.Id {
+			t.Fatalf("expect id %d, got %d", m.Id, res.Id)
+		}
+	}
+}
+
+func TestNegotiateInitVersion(t *testing.T) {
+	host, guest := initSocketPair(t)
+	defer host.Close()
+	defer guest.Close()
+
+	if version, err := negotiateInitVersion(host, &DecodedMessage{Code: INIT_READY}); err != nil || version != INIT_PROTO_V1 {
+		t.Fatalf("old init should talk version 1, got %d %v", version, err)
+	}
+
+	ready := func(version uint32) *DecodedMessage {
+		m := &DecodedMessage{Code: INIT_READY, Message: make([]byte, 4)}
+		binary.BigEndian.PutUint32(m.Message, version)
+		return m
+	}
+	for _, c := range []struct {
+		ready    uint32
+		reply    uint32
+		expected int
+	}{
+		{INIT_PROTO_V2, INIT_ACK, INIT_PROTO_V2},
+		{INIT_PROTO_V2 + 3, INIT_ACK, INIT_PROTO_V2},
+		{INIT_PROTO_V2, INIT_ERROR, INIT_PROTO_V1},
+	} {
+		go func(reply uint32) {
+			req, err := ReadVmMessage(guest)
+			if err != nil || req.Code != INIT_VERSION || binary.BigEndian.Uint32(req.Message) != INIT_PROTO_V2 {
+				guest.Write(NewVmMessage(&DecodedMessage{Code: INIT_ERROR}))
+				return
+			}
+			guest.Write(NewVmMessage(&DecodedMessage{Code: INIT_NEXT, Message: []byte{0, 0, 0, 12}}))
+			guest.Write(NewVmMessage(&DecodedMessage{Code: reply}))
+		}(c.reply)
+
+		version, err := negotiateInitVersion(host, ready(c.ready))
+		if err != nil || version != c.expected {
+			t.Fatalf("init ready with %d replied %d: expect version %d, got %d %v", c.ready, c.reply, c.expected, version, err)
+		}
+	}
+}
+
+func TestInitOutOfOrderReply(t *testing.T) {
+	ctx, init, stop := startFakeInit(t, INIT_PROTO_V2)
+	defer stop()
+
+	exec := &DecodedMessage{Code: INIT_EXECCMD, Message: []byte("sleep")}
+	read := &DecodedMessage{Code: INIT_READFILE, Message: []byte("file")}
+	winsize := &DecodedMessage{Code: INIT_WINSIZE, Message: []byte("size")}
+	ctx.vm <- exec
+	ctx.vm <- read
+	ctx.vm <- winsize
+
+	execReq := init.request(t, INIT_EXECCMD)
+	readReq := init.request(t, INIT_READFILE)
+	winReq := init.request(t, INIT_WINSIZE)
+
+	// the slow exec does not hold the replies of the later requests
+	init.reply(&DecodedMessage{Code: INIT_ACK, Id: winReq.Id})
+	init.reply(&DecodedMessage{Code: INIT_ACK, Id: readReq.Id, Message: []byte("data")})
+	expectInitReply(t, ctx, COMMAND_ACK, winsize)
+	if msg := expectInitReply(t, ctx, COMMAND_ACK, read); string(msg) != "data" {
+		t.Fatalf("expect read data, got '%s'", msg)
+	}
+	init.reply(&DecodedMessage{Code: INIT_ERROR, Id: execReq.Id, Message: []byte("failed")})
+	expectInitReply(t, ctx, ERROR_CMD_FAIL, exec)
+}
+
+func TestInitRequestTimeout(t *testing.T) {
+	ctx, init, stop := startFakeInit(t, INIT_PROTO_V2)
+	defer stop()
+
+	read := &DecodedMessage{Code: INIT_READFILE, Message: []byte("file"), Timeout: 50 * time.Millisecond}
+	ctx.vm <- read
+	readReq := init.request(t, INIT_READFILE)
+
+	expectInitReply(t, ctx, ERROR_CMD_FAIL, read)
+	cancel := init.request(t, INIT_CANCEL)
+	if id := binary.BigEndian.Uint32(cancel.Message); id != readReq.Id {
+		t.Fatalf("expect cancel of %d, got %d", readReq.Id, id)
+	}
+	init.reply(&DecodedMessage{Code: INIT_ACK, Id: cancel.Id})
+
+	// the ack of the cancel is not reported
+	winsize := &DecodedMessage{Code: INIT_WINSIZE, Message: []byte("size")}
+	ctx.vm <- winsize
+	winReq := init.request(t, INIT_WINSIZE)
+	init.reply(&DecodedMessage{Code: INIT_ACK, Id: winReq.Id})
+	expectInitReply(t, ctx, COMMAND_ACK, winsize)
+}
+
+func TestInitVersion1(t *testing.T) {
+	ctx, init, stop := startFakeInit(t, INIT_PROTO_V1)
+	defer stop()
+
+	read := &DecodedMessage{Code: INIT_READFILE, Message: []byte("file"), Timeout: 50 * time.Millisecond}
+	winsize := &DecodedMessage{Code: INIT_WINSIZE, Message: []byte("size")}
+	ctx.vm <- read
+	init.request(t, INIT_READFILE)
+	expectInitReply(t, ctx, ERROR_CMD_FAIL, read)
+
+	// old init can not cancel, the late reply of the canceled request is
+	// dropped and the replies are matched in order
+	ctx.vm <- winsize
+	init.request(t, INIT_WINSIZE)
+	init.reply(&DecodedMessage{Code: INIT_ACK, Message: []byte("data")})
+	init.reply(&DecodedMessage{Code: INIT_ACK})
+	if msg := expectInitReply(t, ctx, COMMAND_ACK, winsize); len(msg) != 0 {
+		t.Fatalf("expect the reply of winsize, got '%s'", msg)
+	}
+}
+
+func TestInitMessageTooLong(t *testing.T) {
+	ctx, init, stop := startFakeInit(t, INIT_PROTO_V2)
+	defer stop()
+
+	header := make([]byte, initHeaderSize(INIT_PROTO_V2))
+	binary.BigEndian.PutUint32(header, INIT_ACK)
+	binary.BigEndian.PutUint32(header[4:], INIT_MAX_MESSAGE_SIZE+1)
+	if _, err := readVmMessage(bytes.NewReader(header), INIT_PROTO_V2); err == nil {
+		t.Fatal("the message longer than the limit should fail")
+	}
+
+	// the connection fails without waiting for the body
+	init.Lock()
+	init.conn.Write(header)
+	init.Unlock()
+	select {
+	case ev := <-ctx.Hub:
+		if ev.Event() != ERROR_INTERRUPTED {
+			t.Fatalf("expect the interrupt, got %d", ev.Event())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the long message should fail the connection")
+	}
+	// stop waits for the interrupt taken above
+	ctx.Hub <- &Interrupted{}
+}
+
+func TestInitRequestTimeoutAfterQuit(t *testing.T) {
+	ctx, init, stop := startFakeInit(t, INIT_PROTO_V2)
+
+	read := &DecodedMessage{Code: INIT_READFILE, Message: []byte("file"), Timeout: 50 * time.Millisecond}
+	ctx.vm <- read
+	init.request(t, INIT_READFILE)
+
+	// the loop quits with the request pending, the timer does not send to
+	// the closed channel
+	stop()
+	time.Sleep(100 * time.Millisecond)
+}
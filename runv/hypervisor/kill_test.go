@@ -0,0 +1,61 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+func TestKillProcess(t *testing.T) {
+	ctx := &VmContext{
+		Id:          "vm-test",
+		vm:          make(chan *DecodedMessage, 1),
+		client:      make(chan *types.VmResponse, 1),
+		lock:        &sync.Mutex{},
+		ttySessions: map[string]uint64{"exec-a": 7},
+	}
+
+	ctx.killCmd(&KillCommand{Container: "c1", Tag: "exec-a", Signal: syscall.SIGKILL})
+	msg := <-ctx.vm
+	var cmd map[string]interface{}
+	if err := json.Unmarshal(msg.Message, &cmd); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Code != INIT_KILLCONTAINER || cmd["container"] != "c1" || cmd["process"] != float64(7) || cmd["tag"] != nil {
+		t.Fatalf("unexpected kill message %d %s", msg.Code, string(msg.Message))
+	}
+
+	ctx.killCmd(&KillCommand{Container: "c1", Tag: "exec-b", Signal: syscall.SIGKILL})
+	if r := <-ctx.client; r.Code != types.E_FAILED {
+		t.Fatalf("killing an unknown process should fail, got %d", r.Code)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	vm := NewVm("vm-test", 1, 128, false, types.VM_KEEP_NONE)
+
+	var wg sync.WaitGroup
+	for _, tag := range []string{"a", "b", "c"} {
+		callback := make(chan *types.VmResponse, 1)
+		callback <- &types.VmResponse{Code: types.E_EXEC_FINISH, Data: uint8(len(tag))}
+		wg.Add(1)
+		go func(tag string) {
+			defer wg.Done()
+			vm.GetExitCode(tag, callback)
+		}(tag)
+	}
+	wg.Wait()
+
+	if code, ok := vm.ExitCode("a"); !ok || code != 1 {
+		t.Fatalf("unexpected exit code %d %v", code, ok)
+	}
+	if _, ok := vm.TakeExitCode("a"); !ok {
+		t.Fatal("the exit code should be there")
+	}
+	if _, ok := vm.ExitCode("a"); ok {
+		t.Fatal("the exit code should be forgotten")
+	}
+}
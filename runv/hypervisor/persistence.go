@@ -35,6 +35,7 @@ type PersistInfo struct {
 	HwStat      *VmHwStatus
 	VolumeList  []*PersistVolumeInfo
 	NetworkList []*PersistNetworkInfo
+	InitVersion int
 }
 
 func (ctx *VmContext) dump() (*PersistInfo, error) {
@@ -47,10 +48,11 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 		Id:          ctx.Id,
 		DriverInfo:  dr,
 		UserSpec:    ctx.userSpec,
-		VmSpec:      ctx.vmSpec,
+		VmSpec:      ctx.vmSpec.persistent(),
 		HwStat:      ctx.dumpHwInfo(),
 		VolumeList:  make([]*PersistVolumeInfo, len(ctx.devices.imageMap)+len(ctx.devices.volumeMap)),
 		NetworkList: make([]*PersistNetworkInfo, len(ctx.devices.networkMap)),
+		InitVersion: ctx.initVersion,
 	}
 
 	vid := 0
@@ -89,6 +91,28 @@ func (ctx *VmContext) dump() (*PersistInfo, error) {
 	return info, nil
 }
 
+// persistent returns a copy of the spec without the content of the tmpfs
+// files, the files stay in the guest only
+func (spec *VmPod) persistent() *VmPod {
+	if spec == nil {
+		return nil
+	}
+	ps := *spec
+	ps.Containers = make([]VmContainer, len(spec.Containers))
+	for i, c := range spec.Containers {
+		if len(c.Tmpfs) > 0 {
+			tmpfs := make([]VmTmpfsDescriptor, len(c.Tmpfs))
+			for j, t := range c.Tmpfs {
+				tmpfs[j] = t
+				tmpfs[j].Files = nil
+			}
+			c.Tmpfs = tmpfs
+		}
+		ps.Containers[i] = c
+	}
+	return &ps
+}
+
 func (ctx *VmContext) dumpHwInfo() *VmHwStatus {
 	return &VmHwStatus{
 		PciAddr:  ctx.pciAddr,
@@ -182,6 +206,9 @@ func (pinfo *PersistInfo) vmContext(hub chan VmEvent, client chan *types.VmRespo
 	ctx.wg = wg
 
 	ctx.loadHwStatus(pinfo)
+	if pinfo.InitVersion > INIT_PROTO_V1 {
+		ctx.initVersion = pinfo.InitVersion
+	}
 
 	for idx, container := range ctx.vmSpec.Containers {
 		ctx.ptys.ttys[container.Tty] = newAttachments(idx, true)
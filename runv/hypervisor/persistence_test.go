@@ -0,0 +1,30 @@
+package hypervisor
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPersistentSpec(t *testing.T) {
+	spec := &VmPod{
+		Containers: []VmContainer{
+			{Id: "c1", Tmpfs: []VmTmpfsDescriptor{{Path: "/run/secrets", Files: []VmTmpfsFile{{Path: "foo", Content: []byte("password")}}}}},
+			{Id: "c2"},
+		},
+	}
+
+	pinfo := &PersistInfo{VmSpec: spec.persistent()}
+	data, err := pinfo.serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("cGFzc3dvcmQ=")) || bytes.Contains(data, []byte("password")) {
+		t.Fatalf("the tmpfs files should not be persisted: %s", string(data))
+	}
+	if !bytes.Contains(data, []byte("/run/secrets")) {
+		t.Fatal("the tmpfs should be persisted")
+	}
+	if len(spec.Containers[0].Tmpfs[0].Files) != 1 {
+		t.Fatal("the spec of the vm should be kept")
+	}
+}
@@ -19,6 +19,9 @@ type HandleEvent struct {
 }
 
 type PodStatus struct {
+	// guards the fields changed while the pod runs: the status, the vm and
+	// the times of the pod, and its containers
+	sync.RWMutex
 	Id            string
 	Name          string
 	Vm            string
@@ -29,6 +32,7 @@ type PodStatus struct {
 	RestartPolicy string
 	Autoremove    bool
 	Handler       HandleEvent
+	CreatedAt     string
 	StartedAt     string
 	FinishedAt    string
 	ResourcePath  string
@@ -60,6 +64,14 @@ type VmVolumeDescriptor struct {
 	ReadOnly bool   `json:"readOnly"`
 }
 
+// VmVolumeHotplug is the message of INIT_MOUNTVOLUME and INIT_UMOUNTVOLUME,
+// Volumes and Fsmap are keyed by the container id.
+type VmVolumeHotplug struct {
+	Name    string                          `json:"name"`
+	Volumes map[string][]VmVolumeDescriptor `json:"volumes,omitempty"`
+	Fsmap   map[string][]VmFsmapDescriptor  `json:"fsmap,omitempty"`
+}
+
 type VmFsmapDescriptor struct {
 	Source   string `json:"source"`
 	Path     string `json:"path"`
@@ -86,9 +98,37 @@ type VmContainer struct {
 	Entrypoint    []string             `json:"-"`
 	Cmd           []string             `json:"cmd"`
 	Envs          []VmEnvironmentVar   `json:"envs,omitempty"`
+	Sockets       []VmSocketDescriptor `json:"sockets,omitempty"`
+	Tmpfs         []VmTmpfsDescriptor  `json:"tmpfs,omitempty"`
 	RestartPolicy string               `json:"restartPolicy"`
 }
 
+// VmSocketDescriptor is a unix socket listened by init at Path in the
+// container, the connections to it are relayed to the host through the
+// INIT_FORWARDSOCK commands of the Session. The socket is removed when the
+// container exits.
+type VmSocketDescriptor struct {
+	Path    string `json:"path"`
+	Session uint64 `json:"seq"`
+}
+
+// VmTmpfsDescriptor is a tmpfs of Size bytes mounted by init at Path in the
+// container, with the Files written into it before the process is started.
+// The content only lives in the memory of the guest, it is not persisted.
+type VmTmpfsDescriptor struct {
+	Path     string        `json:"path"`
+	Size     int64         `json:"size"`
+	ReadOnly bool          `json:"readOnly"`
+	Files    []VmTmpfsFile `json:"files,omitempty"`
+}
+
+// VmTmpfsFile is a file in a tmpfs, Path is relative to the tmpfs
+type VmTmpfsFile struct {
+	Path    string `json:"path"`
+	Mode    uint32 `json:"mode"`
+	Content []byte `json:"content"`
+}
+
 type VmNetworkInf struct {
 	Device    string `json:"device"`
 	IpAddress string `json:"ipAddress"`
@@ -119,6 +159,9 @@ type PreparingItem interface {
 }
 
 func (mypod *PodStatus) SetPodContainerStatus(data []uint32) {
+	mypod.Lock()
+	defer mypod.Unlock()
+
 	failure := 0
 	for i, c := range mypod.Containers {
 		if data[i] != 0 {
@@ -138,6 +181,13 @@ func (mypod *PodStatus) SetPodContainerStatus(data []uint32) {
 }
 
 func (mypod *PodStatus) SetContainerStatus(status uint32) {
+	mypod.Lock()
+	defer mypod.Unlock()
+	mypod.setContainerStatus(status)
+}
+
+// setContainerStatus is SetContainerStatus for the caller holding the lock
+func (mypod *PodStatus) setContainerStatus(status uint32) {
 	for _, c := range mypod.Containers {
 		c.Status = status
 	}
@@ -153,11 +203,50 @@ func (mypod *PodStatus) AddContainer(containerId, name, image string, cmds []str
 		Status: status,
 	}
 
+	mypod.Lock()
 	mypod.Containers = append(mypod.Containers, container)
+	mypod.Unlock()
+}
+
+// Snapshot returns a copy of the status, for the readers which don't hold
+// the lock of the pod, e.g. list and info.
+func (mypod *PodStatus) Snapshot() *PodStatus {
+	mypod.RLock()
+	defer mypod.RUnlock()
+	return mypod.Copy()
+}
+
+// Copy returns a copy of the status and its containers, the caller holds
+// the lock.
+func (mypod *PodStatus) Copy() *PodStatus {
+	status := &PodStatus{
+		Id:            mypod.Id,
+		Name:          mypod.Name,
+		Vm:            mypod.Vm,
+		Wg:            mypod.Wg,
+		Containers:    make([]*Container, len(mypod.Containers)),
+		Status:        mypod.Status,
+		Type:          mypod.Type,
+		RestartPolicy: mypod.RestartPolicy,
+		Autoremove:    mypod.Autoremove,
+		Handler:       mypod.Handler,
+		CreatedAt:     mypod.CreatedAt,
+		StartedAt:     mypod.StartedAt,
+		FinishedAt:    mypod.FinishedAt,
+		ResourcePath:  mypod.ResourcePath,
+	}
+	for i, c := range mypod.Containers {
+		container := *c
+		status.Containers[i] = &container
+	}
+	return status
 }
 
 func (mypod *PodStatus) GetPodIP(vm *Vm) []string {
-	if mypod.Vm == "" {
+	mypod.RLock()
+	vmId := mypod.Vm
+	mypod.RUnlock()
+	if vmId == "" {
 		return nil
 	}
 	var response *types.VmResponse
@@ -174,7 +263,7 @@ func (mypod *PodStatus) GetPodIP(vm *Vm) []string {
 	defer vm.ReleaseResponseChan(Status)
 
 	getPodIPEvent := &GetPodIPCommand{
-		Id: mypod.Vm,
+		Id: vmId,
 	}
 	PodEvent <- getPodIPEvent
 	// wait for the VM response
@@ -204,6 +293,7 @@ func NewPod(podId string, userPod *pod.UserPod) *PodStatus {
 		Type:          userPod.Type,
 		RestartPolicy: userPod.RestartPolicy,
 		Autoremove:    false,
+		CreatedAt:     time.Now().Format("2006-01-02T15:04:05Z"),
 		Handler: HandleEvent{
 			Handle: defaultHandlePodEvent,
 			Data:   nil,
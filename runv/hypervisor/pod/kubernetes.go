@@ -13,6 +13,8 @@ type KSpec struct {
 	Volumes       []*KVolume    `json:"volumes"`
 	RestartPolicy string        `json:"restartPolicy"`
 	DNSPolicy     []string
+	// the grace period applies to all the containers
+	TerminationGracePeriod int `json:"terminationGracePeriodSeconds,omitempty"`
 }
 
 type KMeta struct {
@@ -32,6 +34,14 @@ type KContainer struct {
 	Volumes    []*KVolumeReference `json:"volumeMounts"`
 	Ports      []*KPort            `json:"ports"`
 	Env        []*KEnv             `json:"env"`
+	// the probes share the format of the kubernetes ones
+	LivenessProbe  *UserProbe  `json:"livenessProbe,omitempty"`
+	ReadinessProbe *UserProbe  `json:"readinessProbe,omitempty"`
+	Lifecycle      *KLifecycle `json:"lifecycle,omitempty"`
+}
+
+type KLifecycle struct {
+	PreStop *UserHandler `json:"preStop,omitempty"`
 }
 
 type KVolumeReference struct {
@@ -128,16 +138,23 @@ func (kp *KPod) Convert() (*UserPod, error) {
 		}
 
 		containers[i] = UserContainer{
-			Name:          kc.Name,
-			Image:         kc.Image,
-			Entrypoint:    kc.Command,
-			Command:       kc.Args,
-			Workdir:       kc.WorkingDir,
-			Ports:         ports,
-			Envs:          envs,
-			Volumes:       vols,
-			Files:         []UserFileReference{},
-			RestartPolicy: "never",
+			Name:           kc.Name,
+			Image:          kc.Image,
+			Entrypoint:     kc.Command,
+			Command:        kc.Args,
+			Workdir:        kc.WorkingDir,
+			Ports:          ports,
+			Envs:           envs,
+			Volumes:        vols,
+			Files:          []UserFileReference{},
+			RestartPolicy:  "never",
+			LivenessProbe:  kc.LivenessProbe,
+			ReadinessProbe: kc.ReadinessProbe,
+
+			TerminationGracePeriod: kp.Spec.TerminationGracePeriod,
+		}
+		if kc.Lifecycle != nil {
+			containers[i].PreStop = kc.Lifecycle.PreStop
 		}
 	}
 
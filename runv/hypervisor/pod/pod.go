@@ -9,8 +9,16 @@ import (
 	"os"
 	"reflect"
 	"regexp"
+	"strings"
 )
 
+// SECRET_URI_PREFIX is the scheme of the secret store, the secrets are only
+// delivered with the secrets of the containers, not as files
+const SECRET_URI_PREFIX = "secret:"
+
+// SecretNameReg restricts secret names, which are used as file names in the pod
+var SecretNameReg = regexp.MustCompile("^[a-zA-Z0-9][a-zA-Z0-9_.-]*$")
+
 // Pod Data Structure
 type UserContainerPort struct {
 	HostPort      int    `json:"hostPort"`
@@ -38,6 +46,11 @@ type UserFileReference struct {
 	Group    string `json:"group"`
 }
 
+type UserSecretReference struct {
+	Name string `json:"name"`
+	Path string `json:"path,omitempty"`
+}
+
 type UserContainer struct {
 	Name          string                `json:"name"`
 	Image         string                `json:"image"`
@@ -50,7 +63,84 @@ type UserContainer struct {
 	Envs          []UserEnvironmentVar  `json:"envs"`
 	Volumes       []UserVolumeReference `json:"volumes"`
 	Files         []UserFileReference   `json:"files"`
+	Secrets       []UserSecretReference `json:"secrets,omitempty"`
 	RestartPolicy string                `json:"restartPolicy"`
+	// the limit of the consecutive restarts by RestartPolicy, 0 is unlimited
+	MaxRestarts int `json:"maxRestarts,omitempty"`
+	// the container is restarted when the liveness probe fails, and
+	// reported as not ready while the readiness probe fails
+	LivenessProbe  *UserProbe `json:"livenessProbe,omitempty"`
+	ReadinessProbe *UserProbe `json:"readinessProbe,omitempty"`
+	// the container is stopped by StopSignal (SIGTERM by default), and
+	// killed if it is still running after TerminationGracePeriod seconds.
+	// The PreStop hook is run in the container before the signal is sent.
+	StopSignal             string       `json:"stopSignal,omitempty"`
+	TerminationGracePeriod int          `json:"terminationGracePeriod,omitempty"`
+	PreStop                *UserHandler `json:"preStop,omitempty"`
+}
+
+// UserHandler is the action of a lifecycle hook of the container
+type UserHandler struct {
+	Exec *UserExecAction `json:"exec,omitempty"`
+}
+
+// UserProbe follows the kubernetes probe, exactly one of Exec, HttpGet
+// and TcpSocket should be set
+type UserProbe struct {
+	Exec             *UserExecAction      `json:"exec,omitempty"`
+	HttpGet          *UserHttpGetAction   `json:"httpGet,omitempty"`
+	TcpSocket        *UserTcpSocketAction `json:"tcpSocket,omitempty"`
+	InitialDelay     int                  `json:"initialDelaySeconds,omitempty"`
+	Timeout          int                  `json:"timeoutSeconds,omitempty"`
+	Period           int                  `json:"periodSeconds,omitempty"`
+	SuccessThreshold int                  `json:"successThreshold,omitempty"`
+	FailureThreshold int                  `json:"failureThreshold,omitempty"`
+}
+
+type UserExecAction struct {
+	Command []string `json:"command"`
+}
+
+type UserHttpGetAction struct {
+	Path   string `json:"path"`
+	Port   int    `json:"port"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+type UserTcpSocketAction struct {
+	Port int `json:"port"`
+}
+
+func (p *UserProbe) Validate() error {
+	handlers := 0
+	if p.Exec != nil {
+		if len(p.Exec.Command) == 0 {
+			return errors.New("exec probe without command")
+		}
+		handlers++
+	}
+	if p.HttpGet != nil {
+		if p.HttpGet.Port <= 0 || p.HttpGet.Port > 65535 {
+			return fmt.Errorf("invalid port %d of http probe", p.HttpGet.Port)
+		}
+		if s := strings.ToLower(p.HttpGet.Scheme); s != "" && s != "http" && s != "https" {
+			return fmt.Errorf("invalid scheme %s of http probe", p.HttpGet.Scheme)
+		}
+		handlers++
+	}
+	if p.TcpSocket != nil {
+		if p.TcpSocket.Port <= 0 || p.TcpSocket.Port > 65535 {
+			return fmt.Errorf("invalid port %d of tcp probe", p.TcpSocket.Port)
+		}
+		handlers++
+	}
+	if handlers != 1 {
+		return errors.New("probe should have exactly one of exec, httpGet and tcpSocket")
+	}
+	if p.InitialDelay < 0 || p.Timeout < 0 || p.Period < 0 || p.SuccessThreshold < 0 || p.FailureThreshold < 0 {
+		return errors.New("the delay, timeout, period and thresholds of probe should not be negative")
+	}
+	return nil
 }
 
 type UserResource struct {
@@ -63,6 +153,10 @@ type UserFile struct {
 	Encoding string `json:"encoding"`
 	Uri      string `json:"uri"`
 	Contents string `json:"content"`
+	Sha256   string `json:"sha256,omitempty"`
+	MaxSize  int64  `json:"maxSize,omitempty"`
+	Timeout  int    `json:"timeout,omitempty"`
+	Retries  int    `json:"retries,omitempty"`
 }
 
 type UserVolumeOption struct {
@@ -101,6 +195,13 @@ type UserService struct {
 type PodLogConfig struct {
 	Type   string            `json:"type"`
 	Config map[string]string `json:"config"`
+	// additional drivers the logs are sent to besides Type
+	Extra []LogDriverConfig `json:"extra,omitempty"`
+}
+
+type LogDriverConfig struct {
+	Type   string            `json:"type"`
+	Config map[string]string `json:"config"`
 }
 
 type UserPod struct {
@@ -116,7 +217,7 @@ type UserPod struct {
 	Dns           []string          `json:"dns,omitempty"`
 	Tty           bool              `json:"tty"`
 	Type          string            `json:"type"`
-	RestartPolicy string
+	RestartPolicy string            `json:"restartPolicy,omitempty"`
 }
 
 func ProcessPodFile(jsonFile string) (*UserPod, error) {
@@ -194,6 +295,19 @@ func RandStr(strSize int, randType string) string {
 	return string(bytes)
 }
 
+// RestartsContainers tells whether some container is restarted inside the
+// VM by its restart policy. The VM is kept once all the containers exited
+// then, and shut down by its owner when none of them is restarted.
+func (pod *UserPod) RestartsContainers() bool {
+	for _, c := range pod.Containers {
+		switch strings.ToLower(c.RestartPolicy) {
+		case "always", "onfailure":
+			return true
+		}
+	}
+	return false
+}
+
 //validate
 // 1. volume name, file name is unique
 // 2. source mount to only one pos in one container
@@ -232,6 +346,19 @@ func (pod *UserPod) Validate() error {
 			return errors.New("Files name does not unique")
 		}
 	}
+	var shaReg = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+	for _, f := range pod.Files {
+		if f.Sha256 != "" && !shaReg.MatchString(f.Sha256) {
+			return fmt.Errorf("file %s: invalid sha256 checksum %s", f.Name, f.Sha256)
+		}
+		if f.MaxSize < 0 || f.Timeout < 0 || f.Retries < 0 {
+			return fmt.Errorf("file %s: maxSize, timeout and retries should not be negative", f.Name)
+		}
+		if strings.HasPrefix(f.Uri, SECRET_URI_PREFIX) {
+			return fmt.Errorf("file %s: secrets can not be injected as files, use the secrets of the container", f.Name)
+		}
+	}
+
 	var permReg = regexp.MustCompile("0[0-7]{3}")
 	for idx, container := range pod.Containers {
 
@@ -257,11 +384,47 @@ func (pod *UserPod) Validate() error {
 			}
 		}
 
+		secrets := make(map[string]bool)
+		for _, sr := range container.Secrets {
+			if !SecretNameReg.MatchString(sr.Name) {
+				return fmt.Errorf("in container %d, invalid secret name %s", idx, sr.Name)
+			}
+			p := sr.Path
+			if p == "" {
+				p = sr.Name
+			}
+			if !SecretNameReg.MatchString(p) || secrets[p] {
+				return fmt.Errorf("in container %d, invalid or duplicated secret path %s", idx, p)
+			}
+			secrets[p] = true
+		}
+
 		for _, v := range container.Volumes {
 			if _, ok := vset[v.Volume]; !ok {
 				return fmt.Errorf("in container %d, volume %s does not exist in volume list.", idx, v.Volume)
 			}
 		}
+
+		if container.MaxRestarts < 0 {
+			return fmt.Errorf("in container %d, maxRestarts should not be negative", idx)
+		}
+
+		if container.LivenessProbe != nil {
+			if err := container.LivenessProbe.Validate(); err != nil {
+				return fmt.Errorf("in container %d, invalid liveness probe: %v", idx, err)
+			}
+		}
+		if container.ReadinessProbe != nil {
+			if err := container.ReadinessProbe.Validate(); err != nil {
+				return fmt.Errorf("in container %d, invalid readiness probe: %v", idx, err)
+			}
+		}
+		if container.TerminationGracePeriod < 0 {
+			return fmt.Errorf("in container %d, terminationGracePeriod should not be negative", idx)
+		}
+		if container.PreStop != nil && (container.PreStop.Exec == nil || len(container.PreStop.Exec.Command) == 0) {
+			return fmt.Errorf("in container %d, preStop hook without command", idx)
+		}
 	}
 
 	for idx, v := range pod.Volumes {
@@ -58,6 +58,21 @@ func (ctx *VmContext) reportPodFinished(result *PodFinished) {
 	}
 }
 
+func (ctx *VmContext) reportContainerFinished(result *ContainerFinished) {
+	if result.Index < 0 || result.Index >= len(ctx.vmSpec.Containers) {
+		return
+	}
+	ctx.client <- &types.VmResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_CONTAINER_FINISHED,
+		Cause: "container finished",
+		Data: &types.ContainerExit{
+			Id:   ctx.vmSpec.Containers[result.Index].Id,
+			Code: result.Code,
+		},
+	}
+}
+
 func (ctx *VmContext) reportSuccess(msg string, data interface{}) {
 	ctx.client <- &types.VmResponse{
 		VmId:  ctx.Id,
@@ -159,6 +174,45 @@ func (ctx *VmContext) reportPodStats(ev VmEvent) {
 	ctx.client <- &response
 }
 
+// reportGuestHealth replies the latest health of the guest, the Data is
+// nil if init does not send heartbeats
+func (ctx *VmContext) reportGuestHealth(ev VmEvent) {
+	response := &types.VmResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_GUEST_HEALTH,
+		Reply: ev,
+	}
+	if ctx.guestHealth != nil {
+		health := *ctx.guestHealth
+		response.Data = &health
+	}
+	ctx.client <- response
+}
+
+func (ctx *VmContext) reportVolume(reply VmEvent, cause string) {
+	ctx.client <- &types.VmResponse{
+		VmId:  ctx.Id,
+		Code:  types.E_OK,
+		Cause: cause,
+		Reply: reply,
+	}
+}
+
+// reportContainerCmd send report to daemon, notify about that:
+//   1. the kill or restart of a container is done, or failed with the cause
+func (ctx *VmContext) reportContainerCmd(reply VmEvent, cause string) {
+	code := types.E_OK
+	if cause != "" {
+		code = types.E_FAILED
+	}
+	ctx.client <- &types.VmResponse{
+		VmId:  ctx.Id,
+		Code:  code,
+		Cause: cause,
+		Reply: reply,
+	}
+}
+
 func (ctx *VmContext) reportFile(reply VmEvent, code uint32, data []byte, err bool) {
 	response := &types.VmResponse{
 		VmId:  ctx.Id,
@@ -0,0 +1,152 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+// the interval to poll init when no data is relayed
+const SOCKET_FORWARD_INTERVAL = 20 * time.Millisecond
+
+// NewSocketSession returns the session of a forwarded socket, see
+// VmSocketDescriptor
+func NewSocketSession() uint64 {
+	return atomic.AddUint64(&archiveSession, 1)
+}
+
+type socketChunk struct {
+	conn uint64
+	data []byte
+	eof  bool
+}
+
+// ForwardSocket relays the connections to the unix socket listened by init
+// for the session to the connections made by dial, until stop is closed. The
+// socket listened in the container is closed when ForwardSocket returns.
+func (vm *Vm) ForwardSocket(session uint64, dial func() (net.Conn, error), stop <-chan struct{}) error {
+	var (
+		conns   = map[uint64]net.Conn{}
+		chunks  = make(chan *socketChunk, 16)
+		done    = make(chan struct{})
+		pending *socketChunk
+	)
+	defer func() {
+		close(done)
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+
+	read := func(id uint64, c net.Conn) {
+		for {
+			buf := make([]byte, ARCHIVE_CHUNK_SIZE)
+			n, err := c.Read(buf)
+			select {
+			case chunks <- &socketChunk{conn: id, data: buf[:n], eof: err != nil}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	for {
+		cmd := &ForwardSocketCommand{Session: session}
+		if pending != nil {
+			cmd.Conn, cmd.Data, cmd.Eof = pending.conn, pending.data, pending.eof
+			pending = nil
+		} else {
+			select {
+			case <-stop:
+				cmd.Done = true
+			case chunk := <-chunks:
+				cmd.Conn, cmd.Data, cmd.Eof = chunk.conn, chunk.data, chunk.eof
+			default:
+			}
+		}
+		if cmd.Conn != 0 {
+			if _, ok := conns[cmd.Conn]; !ok {
+				// already closed by init
+				continue
+			}
+			if _, failed := conns[cmd.Conn].(nopConn); cmd.Eof && !failed {
+				conns[cmd.Conn].Close()
+				delete(conns, cmd.Conn)
+			}
+		}
+
+		data, err := vm.archiveRequest(cmd)
+		if err != nil {
+			return fmt.Errorf("Forward socket of session %d failed: %v", session, err)
+		}
+		if cmd.Done {
+			return nil
+		}
+
+		reply := &types.SocketForwardReply{}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, reply); err != nil {
+				return fmt.Errorf("Forward socket of session %d failed: %v", session, err)
+			}
+		}
+
+		if reply.Conn == 0 {
+			if cmd.Conn != 0 {
+				continue
+			}
+			select {
+			case <-stop:
+			case chunk := <-chunks:
+				pending = chunk
+			case <-time.After(SOCKET_FORWARD_INTERVAL):
+			}
+			continue
+		}
+
+		c, ok := conns[reply.Conn]
+		if !ok {
+			if reply.Eof {
+				continue
+			}
+			if c, err = dial(); err != nil {
+				glog.Warningf("Forward socket of session %d: %v", session, err)
+				// let init close the connection, the data coming in the
+				// meantime is dropped
+				c = nopConn{}
+				pending = &socketChunk{conn: reply.Conn, eof: true}
+			} else {
+				go read(reply.Conn, c)
+			}
+			conns[reply.Conn] = c
+		}
+		if len(reply.Data) > 0 {
+			if _, err := c.Write(reply.Data); err != nil {
+				pending = &socketChunk{conn: reply.Conn, eof: true}
+				continue
+			}
+		}
+		if reply.Eof {
+			c.Close()
+			delete(conns, reply.Conn)
+		}
+	}
+}
+
+// nopConn stands for the connection failed to dial until init closes it,
+// the data written to it is discarded
+type nopConn struct {
+	net.Conn
+}
+
+func (nopConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (nopConn) Write(b []byte) (int, error) { return len(b), nil }
+func (nopConn) Close() error                { return nil }
@@ -0,0 +1,159 @@
+package hypervisor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hyperhq/runv/hypervisor/types"
+)
+
+// fakeSocketVm plays a client of the forwarded socket in the container, it
+// sends ping on a new connection, and closes it when pong is received
+func fakeSocketVm(received chan<- string) *Vm {
+	status := make(chan *types.VmResponse, 128)
+	vm := &Vm{
+		Id:      "vm-test",
+		VmChan:  make(chan VmEvent, 128),
+		clients: CreateFanout(status, 128, false),
+	}
+	go func() {
+		sent, closed := false, false
+		for ev := range vm.VmChan {
+			cmd := ev.(*ForwardSocketCommand)
+			r := &types.VmResponse{VmId: vm.Id, Code: types.E_ARCHIVE, Reply: ev}
+			reply := types.SocketForwardReply{}
+			switch {
+			case cmd.Done:
+				received <- "done"
+			case cmd.Conn == 7:
+				received <- string(cmd.Data)
+				if !closed {
+					reply = types.SocketForwardReply{Conn: 7, Eof: true}
+					closed = true
+				}
+			case !sent:
+				reply = types.SocketForwardReply{Conn: 7, Data: []byte("ping")}
+				sent = true
+			}
+			if reply.Conn != 0 {
+				r.Data, _ = json.Marshal(reply)
+			}
+			status <- r
+		}
+	}()
+	return vm
+}
+
+func TestForwardSocket(t *testing.T) {
+	received := make(chan string, 16)
+	vm := fakeSocketVm(received)
+	defer close(vm.VmChan)
+
+	agent := make(chan string, 1)
+	dial := func() (net.Conn, error) {
+		c, s := net.Pipe()
+		go func() {
+			buf := make([]byte, 4)
+			n, _ := s.Read(buf)
+			agent <- string(buf[:n])
+			s.Write([]byte("pong"))
+			ioutil.ReadAll(s)
+			s.Close()
+		}()
+		return c, nil
+	}
+
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- vm.ForwardSocket(NewSocketSession(), dial, stop)
+	}()
+
+	if got := <-agent; got != "ping" {
+		t.Fatalf("expected ping forwarded to the agent, got %q", got)
+	}
+	select {
+	case got := <-received:
+		if got != "pong" {
+			t.Fatalf("expected pong forwarded to the VM, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pong is not forwarded to the VM")
+	}
+
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	for got := range received {
+		if got == "done" {
+			break
+		}
+	}
+}
+
+func TestForwardSocketDialFailed(t *testing.T) {
+	status := make(chan *types.VmResponse, 128)
+	vm := &Vm{
+		Id:      "vm-test",
+		VmChan:  make(chan VmEvent, 128),
+		clients: CreateFanout(status, 128, false),
+	}
+	defer close(vm.VmChan)
+
+	// init keeps sending the data of the connection after the dial failed,
+	// and closes it after the eof from the host
+	eof := make(chan struct{})
+	go func() {
+		sent, closed := 0, false
+		for ev := range vm.VmChan {
+			cmd := ev.(*ForwardSocketCommand)
+			r := &types.VmResponse{VmId: vm.Id, Code: types.E_ARCHIVE, Reply: ev}
+			reply := types.SocketForwardReply{}
+			switch {
+			case cmd.Done:
+			case cmd.Conn == 9 && cmd.Eof:
+				close(eof)
+			case sent < 4:
+				reply = types.SocketForwardReply{Conn: 9, Data: []byte("ping")}
+				sent++
+			case !closed:
+				reply = types.SocketForwardReply{Conn: 9, Eof: true}
+				closed = true
+			}
+			if reply.Conn != 0 {
+				r.Data, _ = json.Marshal(reply)
+			}
+			status <- r
+		}
+	}()
+
+	dials := 0
+	dial := func() (net.Conn, error) {
+		dials++
+		return nil, errors.New("agent is gone")
+	}
+
+	stop := make(chan struct{})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- vm.ForwardSocket(NewSocketSession(), dial, stop)
+	}()
+
+	select {
+	case <-eof:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection failed to dial is not closed in the VM")
+	}
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if dials != 1 {
+		t.Fatalf("the data of the failed connection should be dropped, dialed %d times", dials)
+	}
+}
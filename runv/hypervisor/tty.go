@@ -154,8 +154,12 @@ func waitPts(ctx *VmContext) {
 				if len(res.message) == 1 {
 					code = uint8(res.message[0])
 				}
-				glog.V(1).Infof("session %d, exit code", res.session, code)
+				glog.V(1).Infof("session %d, exit code %d", res.session, code)
 				ctx.ptys.Close(ctx, res.session, code)
+				// the stdout session of the container is closed with its exit code
+				if ta.persistent && ctx.isContainerTty(ta.container, res.session) {
+					ctx.Hub <- &ContainerFinished{Index: ta.container, Code: code}
+				}
 			} else {
 				for _, tty := range ta.attachments {
 					if tty.Stdout != nil && tty.liner == nil {
@@ -176,6 +180,13 @@ func waitPts(ctx *VmContext) {
 	}
 }
 
+func (ctx *VmContext) isContainerTty(idx int, session uint64) bool {
+	ctx.lock.Lock()
+	defer ctx.lock.Unlock()
+	return ctx.vmSpec != nil && idx >= 0 && idx < len(ctx.vmSpec.Containers) &&
+		ctx.vmSpec.Containers[idx].Tty == session
+}
+
 func newAttachments(idx int, persist bool) *ttyAttachments {
 	return &ttyAttachments{
 		container:   idx,
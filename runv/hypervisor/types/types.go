@@ -1,5 +1,10 @@
 package types
 
+import (
+	"os"
+	"time"
+)
+
 const (
 	E_OK = iota
 	E_VM_RUNNING
@@ -18,6 +23,9 @@ const (
 	E_READFILE
 	E_POD_STATS
 	E_UNEXPECTED
+	E_CONTAINER_FINISHED
+	E_GUEST_HEALTH
+	E_ARCHIVE
 )
 
 // status for POD or container
@@ -38,6 +46,58 @@ const (
 	VM_KEEP_AFTER_SHUTDOWN
 )
 
+// ContainerExit is the Data of E_CONTAINER_FINISHED
+type ContainerExit struct {
+	Id   string
+	Code uint8
+}
+
+// status of the guest judged from its heartbeats
+const (
+	GUEST_HEALTHY         = "healthy"
+	GUEST_MEMORY_PRESSURE = "memory-pressure"
+	GUEST_DISK_PRESSURE   = "disk-pressure"
+	GUEST_UNRESPONSIVE    = "unresponsive"
+)
+
+type GuestFilesystem struct {
+	Path  string `json:"path"`
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+}
+
+// GuestHealth is the Data of E_GUEST_HEALTH, it is sent when the status of
+// the guest is changed, or to reply GetGuestHealthCommand
+type GuestHealth struct {
+	Status        string
+	Reason        string
+	LastHeartbeat time.Time
+	Uptime        uint64 // seconds
+	Load          [3]float64
+	MemTotal      uint64
+	MemAvailable  uint64
+	Filesystems   []GuestFilesystem
+}
+
+// PathStat is the stat of a path in the container, in the same format as
+// the one of docker
+type PathStat struct {
+	Name       string      `json:"name"`
+	Size       int64       `json:"size"`
+	Mode       os.FileMode `json:"mode"`
+	Mtime      time.Time   `json:"mtime"`
+	LinkTarget string      `json:"linkTarget"`
+}
+
+// SocketForwardReply is the data read by init from the connection Conn to
+// a forwarded socket, Conn is 0 if there is nothing to read. A connection
+// unknown to the host is a new one, and it is closed with Eof.
+type SocketForwardReply struct {
+	Conn uint64 `json:"conn"`
+	Eof  bool   `json:"eof"`
+	Data []byte `json:"data,omitempty"`
+}
+
 type VmResponse struct {
 	VmId  string
 	Code  int
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -22,9 +24,13 @@ type Vm struct {
 	Lazy   bool
 	Keep   int
 
-	VmChan    chan VmEvent
-	ExitCodes map[string]uint8
-	clients   *Fanout
+	VmChan  chan VmEvent
+	clients *Fanout
+
+	// the exit codes of the exec processes by tag, they are set by the
+	// goroutines waiting for the processes
+	exitLock  sync.Mutex
+	exitCodes map[string]uint8
 }
 
 func (vm *Vm) GetRequestChan() (chan VmEvent, error) {
@@ -128,9 +134,11 @@ func (vm *Vm) AssociateVm(mypod *PodStatus, data []byte) error {
 	vm.VmChan = PodEvent
 	vm.clients = CreateFanout(Status, 128, false)
 
+	mypod.Lock()
 	mypod.Status = types.S_POD_RUNNING
 	mypod.StartedAt = time.Now().Format("2006-01-02T15:04:05Z")
-	mypod.SetContainerStatus(types.S_POD_RUNNING)
+	mypod.setContainerStatus(types.S_POD_RUNNING)
+	mypod.Unlock()
 
 	vm.Status = types.S_VM_ASSOCIATED
 	vm.Pod = mypod
@@ -183,14 +191,18 @@ func defaultHandlePodEvent(Response *types.VmResponse, data interface{},
 	mypod *PodStatus, vm *Vm) bool {
 	if Response.Code == types.E_POD_FINISHED {
 		mypod.SetPodContainerStatus(Response.Data.([]uint32))
+		mypod.Lock()
 		mypod.Vm = ""
+		mypod.Unlock()
 		vm.Status = types.S_VM_IDLE
 	} else if Response.Code == types.E_VM_SHUTDOWN {
+		mypod.Lock()
 		if mypod.Status == types.S_POD_RUNNING {
 			mypod.Status = types.S_POD_SUCCEEDED
-			mypod.SetContainerStatus(types.S_POD_SUCCEEDED)
+			mypod.setContainerStatus(types.S_POD_SUCCEEDED)
 		}
 		mypod.Vm = ""
+		mypod.Unlock()
 		return true
 	}
 
@@ -222,7 +234,9 @@ func (vm *Vm) handlePodEvent(mypod *PodStatus) {
 }
 
 func (vm *Vm) ListenPod(ip, port string,mypod *PodStatus, userPod *pod.UserPod, cList []*ContainerInfo, vList []*VolumeInfo) *types.VmResponse {
+	mypod.Lock()
 	mypod.Vm = vm.Id
+	mypod.Unlock()
 	//var ok bool = false
 	vm.Pod = mypod
 	vm.Status = types.S_VM_ASSOCIATED
@@ -243,10 +257,12 @@ func (vm *Vm) ListenPod(ip, port string,mypod *PodStatus, userPod *pod.UserPod,
 
 	go vm.handlePodEvent(mypod)
 
+	mypod.Lock()
 	mypod.Status = types.S_POD_RUNNING
 	mypod.StartedAt = time.Now().Format("2006-01-02T15:04:05Z")
 	// Set the container status to online
-	mypod.SetContainerStatus(types.S_POD_RUNNING)
+	mypod.setContainerStatus(types.S_POD_RUNNING)
+	mypod.Unlock()
 
 	listenPodEvent := &ListenPodCommand{
 		Ip:			ip,
@@ -286,14 +302,17 @@ func (vm *Vm) ListenPod(ip, port string,mypod *PodStatus, userPod *pod.UserPod,
 }
 
 func (vm *Vm) StartPod(mypod *PodStatus, userPod *pod.UserPod,cList []*ContainerInfo, vList []*VolumeInfo) *types.VmResponse {
+	mypod.Lock()
 	mypod.Vm = vm.Id
+	status := mypod.Status
+	mypod.Unlock()
 	var ok bool = false
 	vm.Pod = mypod
 	vm.Status = types.S_VM_ASSOCIATED
 
 	var response *types.VmResponse
 
-	if mypod.Status == types.S_POD_RUNNING {
+	if status == types.S_POD_RUNNING {
 		err := fmt.Errorf("The pod(%s) is running, can not start it", mypod.Id)
 		response = &types.VmResponse{
 			Code:  -1,
@@ -303,7 +322,7 @@ func (vm *Vm) StartPod(mypod *PodStatus, userPod *pod.UserPod,cList []*Container
 		return response
 	}
 
-	if mypod.Type == "kubernetes" && mypod.Status != types.S_POD_CREATED {
+	if mypod.Type == "kubernetes" && status != types.S_POD_CREATED {
 		err := fmt.Errorf("The pod(%s) is finished with kubernetes type, can not start it again",
 			mypod.Id)
 		response = &types.VmResponse{
@@ -328,10 +347,12 @@ func (vm *Vm) StartPod(mypod *PodStatus, userPod *pod.UserPod,cList []*Container
 
 	go vm.handlePodEvent(mypod)
 
+	mypod.Lock()
 	mypod.Status = types.S_POD_RUNNING
 	mypod.StartedAt = time.Now().Format("2006-01-02T15:04:05Z")
 	// Set the container status to online
-	mypod.SetContainerStatus(types.S_POD_RUNNING)
+	mypod.setContainerStatus(types.S_POD_RUNNING)
+	mypod.Unlock()
 
 	runPodEvent := &RunPodCommand{
 		Spec:       userPod,
@@ -381,7 +402,10 @@ func (vm *Vm) StopPod(mypod *PodStatus, stopVm string) *types.VmResponse {
 	}
 	defer vm.ReleaseResponseChan(Status)
 
-	if mypod.Status != types.S_POD_RUNNING {
+	mypod.RLock()
+	running := mypod.Status == types.S_POD_RUNNING
+	mypod.RUnlock()
+	if !running {
 		return errorResponse("The POD has already stoppod")
 	}
 
@@ -394,7 +418,9 @@ func (vm *Vm) StopPod(mypod *PodStatus, stopVm string) *types.VmResponse {
 			Response = <-Status
 			glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
 			if Response.Code == types.E_VM_SHUTDOWN {
+				mypod.Lock()
 				mypod.Vm = ""
+				mypod.Unlock()
 				break
 			}
 		}
@@ -408,15 +434,19 @@ func (vm *Vm) StopPod(mypod *PodStatus, stopVm string) *types.VmResponse {
 			Response = <-Status
 			glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
 			if Response.Code == types.E_POD_STOPPED || Response.Code == types.E_BAD_REQUEST || Response.Code == types.E_FAILED {
+				mypod.Lock()
 				mypod.Vm = ""
+				mypod.Unlock()
 				vm.Status = types.S_VM_IDLE
 				break
 			}
 		}
 	}
 
+	mypod.Lock()
 	mypod.Status = types.S_POD_FAILED
-	mypod.SetContainerStatus(types.S_POD_FAILED)
+	mypod.setContainerStatus(types.S_POD_FAILED)
+	mypod.Unlock()
 
 	return Response
 }
@@ -437,7 +467,10 @@ func (vm *Vm) MigratePod(mypod *PodStatus, ip, port string ) *types.VmResponse {
 	}
 	defer vm.ReleaseResponseChan(Status)
 
-	if mypod.Status != types.S_POD_RUNNING {
+	mypod.RLock()
+	running := mypod.Status == types.S_POD_RUNNING
+	mypod.RUnlock()
+	if !running {
 		return errorResponse("The POD is not running")
 	}
 	migratePodEvent := &MigratePodCommand{Ip: ip , Port: port}
@@ -453,15 +486,19 @@ func (vm *Vm) MigratePod(mypod *PodStatus, ip, port string ) *types.VmResponse {
 		if response == nil {
 			glog.V(1).Infof("Migrate Pod timeout")
 		}else if response.Code == types.E_VM_SHUTDOWN {
+			mypod.Lock()
 			mypod.Vm = ""
+			mypod.Unlock()
 			break
 		}
 	}
 
 	//migrate succeeded,update pod status first
 	if response != nil {
+		mypod.Lock()
 		mypod.Status = types.S_POD_FAILED
-		mypod.SetContainerStatus(types.S_POD_FAILED)		
+		mypod.setContainerStatus(types.S_POD_FAILED)
+		mypod.Unlock()
 	}
 	return response
 
@@ -512,6 +549,64 @@ func (vm *Vm) WriteFile(container, target string, data []byte) error {
 	return fmt.Errorf("Write container %s file %s failed: %s", container, target, cause)
 }
 
+func (vm *Vm) AttachVolume(info *VolumeInfo, mounts []VolumeMount) error {
+	return vm.volumeHotplug(&AttachVolumeCommand{
+		Volume: info,
+		Mounts: mounts,
+	}, info.Name)
+}
+
+func (vm *Vm) DetachVolume(name string) error {
+	return vm.volumeHotplug(&DetachVolumeCommand{Name: name}, name)
+}
+
+// the time to wait for a volume hot added or removed, including the block
+// device inserted into or ejected from the VM
+const VOLUME_HOTPLUG_TIMEOUT = 2 * time.Minute
+
+func (vm *Vm) volumeHotplug(cmd VmEvent, name string) error {
+	PodEvent, err := vm.GetRequestChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseRequestChan(PodEvent)
+
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseResponseChan(Status)
+
+	PodEvent <- cmd
+
+	timeout := time.NewTimer(VOLUME_HOTPLUG_TIMEOUT)
+	defer timeout.Stop()
+
+	cause := "get response failed"
+loop:
+	for {
+		select {
+		case Response, ok := <-Status:
+			if !ok {
+				break loop
+			}
+			glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
+			if Response.Reply == cmd {
+				if Response.Cause == "" {
+					return nil
+				}
+				cause = Response.Cause
+				break loop
+			}
+		case <-timeout.C:
+			cause = fmt.Sprintf("no response in %v", VOLUME_HOTPLUG_TIMEOUT)
+			break loop
+		}
+	}
+
+	return fmt.Errorf("Hotplug volume %s failed: %s", name, cause)
+}
+
 func (vm *Vm) ReadFile(container, target string) ([]byte, error) {
 	if target == "" {
 		return nil, fmt.Errorf("'read' without file")
@@ -557,11 +652,24 @@ func (vm *Vm) ReadFile(container, target string) ([]byte, error) {
 }
 
 func (vm *Vm) KillContainer(container string, signal syscall.Signal) error {
-	killCmd := &KillCommand{
+	return vm.kill(&KillCommand{
 		Container: container,
 		Signal:    signal,
-	}
+	})
+}
 
+// KillProcess sends the signal to the exec process with the tag in the
+// container, e.g. to kill a process which does not finish in time
+func (vm *Vm) KillProcess(container, tag string, signal syscall.Signal) error {
+	return vm.kill(&KillCommand{
+		Container: container,
+		Tag:       tag,
+		Signal:    signal,
+	})
+}
+
+func (vm *Vm) kill(killCmd *KillCommand) error {
+	container := killCmd.Container
 	Event, err := vm.GetRequestChan()
 	if err != nil {
 		return err
@@ -596,6 +704,99 @@ func (vm *Vm) KillContainer(container string, signal syscall.Signal) error {
 	return nil
 }
 
+// Shutdown asks the VM to shut down without waiting for it, the handler of
+// the pod gets the shutdown.
+func (vm *Vm) Shutdown() error {
+	PodEvent, err := vm.GetRequestChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseRequestChan(PodEvent)
+
+	PodEvent <- &ShutdownCommand{Wait: false}
+	return nil
+}
+
+// RestartContainer restarts the container inside the running pod, the
+// other containers and the VM are left alone.
+func (vm *Vm) RestartContainer(container string) error {
+	restartCmd := &RestartContainerCommand{
+		Container: container,
+	}
+
+	Event, err := vm.GetRequestChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseRequestChan(Event)
+
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseResponseChan(Status)
+
+	Event <- restartCmd
+
+	for {
+		Response, ok := <-Status
+		if !ok {
+			return fmt.Errorf("restart container %v failed: get response failed", container)
+		}
+
+		glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
+		if Response.Reply == restartCmd {
+			if Response.Cause != "" {
+				return fmt.Errorf("restart container %v failed: %s", container, Response.Cause)
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
+
+// RemoveContainer removes an exited container from the pod, and releases its
+// rootfs, so that the rootfs is not used by the VM any more when it returns
+func (vm *Vm) RemoveContainer(container string) error {
+	removeCmd := &RemoveContainerCommand{
+		Container: container,
+	}
+
+	Event, err := vm.GetRequestChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseRequestChan(Event)
+
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return err
+	}
+	defer vm.ReleaseResponseChan(Status)
+
+	Event <- removeCmd
+
+	for {
+		Response, ok := <-Status
+		if !ok {
+			return fmt.Errorf("remove container %v failed: get response failed", container)
+		}
+
+		glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
+		if Response.Reply == removeCmd {
+			if Response.Cause != "" {
+				return fmt.Errorf("remove container %v failed: %s", container, Response.Cause)
+			}
+
+			break
+		}
+	}
+
+	return nil
+}
+
 func (vm *Vm) GetExitCode(tag string, callback chan *types.VmResponse) error {
 	Response, ok := <-callback
 	if !ok {
@@ -604,16 +805,35 @@ func (vm *Vm) GetExitCode(tag string, callback chan *types.VmResponse) error {
 
 	glog.V(1).Infof("Got response: %d: %s", Response.Code, Response.Cause)
 	if Response.Code == types.E_EXEC_FINISH {
-		vm.ExitCodes[tag] = Response.Data.(uint8)
+		vm.exitLock.Lock()
+		vm.exitCodes[tag] = Response.Data.(uint8)
+		vm.exitLock.Unlock()
 	}
 
 	close(callback)
 	return nil
 }
 
+// ExitCode returns the exit code of the process with the tag, if it exited
+func (vm *Vm) ExitCode(tag string) (uint8, bool) {
+	vm.exitLock.Lock()
+	defer vm.exitLock.Unlock()
+	code, ok := vm.exitCodes[tag]
+	return code, ok
+}
+
+// TakeExitCode returns the exit code of the process with the tag like
+// ExitCode, and forgets it
+func (vm *Vm) TakeExitCode(tag string) (uint8, bool) {
+	vm.exitLock.Lock()
+	defer vm.exitLock.Unlock()
+	code, ok := vm.exitCodes[tag]
+	delete(vm.exitCodes, tag)
+	return code, ok
+}
+
 func (vm *Vm) Exec(Stdin io.ReadCloser, Stdout io.WriteCloser, cmd, tag, container string) error {
 	var command []string
-	Callback := make(chan *types.VmResponse, 1)
 
 	if cmd == "" {
 		return fmt.Errorf("'exec' without command")
@@ -622,10 +842,36 @@ func (vm *Vm) Exec(Stdin io.ReadCloser, Stdout io.WriteCloser, cmd, tag, contain
 	if err := json.Unmarshal([]byte(cmd), &command); err != nil {
 		return err
 	}
+	return vm.AddProcess(Stdin, Stdout, container, tag, command, nil, "", "")
+}
+
+// AddProcess runs the command in the container with the environment
+// variables in KEY=VALUE format, as the user in the working directory, the
+// default ones of the container are used if they are empty. It returns after
+// the process exits, and the exit code is kept with the tag, see ExitCode.
+func (vm *Vm) AddProcess(Stdin io.ReadCloser, Stdout io.WriteCloser, container, tag string, command, env []string, user, workdir string) error {
+	Callback := make(chan *types.VmResponse, 1)
+
+	if len(command) == 0 {
+		return fmt.Errorf("'exec' without command")
+	}
+
+	envs := []VmEnvironmentVar{}
+	for _, v := range env {
+		if eqlIndex := strings.Index(v, "="); eqlIndex > 0 {
+			envs = append(envs, VmEnvironmentVar{
+				Env:   v[:eqlIndex],
+				Value: v[eqlIndex+1:],
+			})
+		}
+	}
 
 	execCmd := &ExecCommand{
 		Command:   command,
 		Container: container,
+		Envs:      envs,
+		User:      user,
+		Workdir:   workdir,
 		Streams: &TtyIO{
 			Stdin:     Stdin,
 			Stdout:    Stdout,
@@ -738,6 +984,43 @@ func (vm *Vm) Stats() *types.VmResponse {
 	return response
 }
 
+// GuestHealth returns the latest health reported by the heartbeats of the
+// guest, it is nil if the init of the guest does not send heartbeats
+func (vm *Vm) GuestHealth() (*types.GuestHealth, error) {
+	PodEvent, err := vm.GetRequestChan()
+	if err != nil {
+		return nil, err
+	}
+	defer vm.ReleaseRequestChan(PodEvent)
+
+	Status, err := vm.GetResponseChan()
+	if err != nil {
+		return nil, err
+	}
+	defer vm.ReleaseResponseChan(Status)
+
+	getGuestHealthEvent := &GetGuestHealthCommand{
+		Id: vm.Id,
+	}
+	PodEvent <- getGuestHealthEvent
+
+	// wait for the VM response
+	for {
+		response, ok := <-Status
+		if !ok {
+			return nil, fmt.Errorf("vm %s is gone", vm.Id)
+		}
+		if response.Reply != getGuestHealthEvent {
+			continue
+		}
+		if response.Code != types.E_GUEST_HEALTH {
+			return nil, errors.New(response.Cause)
+		}
+		health, _ := response.Data.(*types.GuestHealth)
+		return health, nil
+	}
+}
+
 func errorResponse(cause string) *types.VmResponse {
 	return &types.VmResponse{
 		Code:  -1,
@@ -755,6 +1038,6 @@ func NewVm(vmId string, cpu, memory int, lazy bool, keep int) *Vm {
 		Cpu:       cpu,
 		Mem:       memory,
 		Keep:      keep,
-		ExitCodes: make(map[string]uint8),
+		exitCodes: make(map[string]uint8),
 	}
 }
@@ -92,6 +92,18 @@ func (ctx *VmContext) prepareContainer(cmd *NewContainerCommand) *VmContainer {
 	ctx.initContainerInfo(idx, vmContainer, cmd.container)
 	ctx.setContainerInfo(idx, vmContainer, cmd.info)
 
+	// the directory volumes are already mounted in the VM, the hot added
+	// container can use them at once
+	for _, v := range cmd.container.Volumes {
+		if vol, ok := ctx.devices.volumeMap[v.Volume]; ok && vol.info.Fstype == "" && vol.info.Filename != "" {
+			vmContainer.Fsmap = append(vmContainer.Fsmap, VmFsmapDescriptor{
+				Source:   vol.info.Filename,
+				Path:     v.Path,
+				ReadOnly: v.ReadOnly,
+			})
+		}
+	}
+
 	vmContainer.Sysctl = cmd.container.Sysctl
 	vmContainer.Tty = ctx.attachId
 	ctx.attachId++
@@ -155,6 +167,7 @@ func (ctx *VmContext) setWindowSize(tag string, size *WindowSize) {
 		ctx.vm <- &DecodedMessage{
 			Code:    INIT_WINSIZE,
 			Message: msg,
+			Timeout: INIT_WINSIZE_TIMEOUT,
 		}
 	} else {
 		msg := fmt.Sprintf("cannot resolve client tag %s", tag)
@@ -191,6 +204,7 @@ func (ctx *VmContext) readFile(cmd *ReadFileCommand) {
 		Code:    INIT_READFILE,
 		Message: readCmd,
 		Event:   cmd,
+		Timeout: INIT_READFILE_TIMEOUT,
 	}
 }
 
@@ -214,6 +228,16 @@ func (ctx *VmContext) execCmd(cmd *ExecCommand) {
 }
 
 func (ctx *VmContext) killCmd(cmd *KillCommand) {
+	if cmd.Tag != "" {
+		ctx.lock.Lock()
+		session, ok := ctx.ttySessions[cmd.Tag]
+		ctx.lock.Unlock()
+		if !ok {
+			ctx.reportContainerCmd(cmd, fmt.Sprintf("process %s is not running", cmd.Tag))
+			return
+		}
+		cmd.Process = session
+	}
 	killCmd, err := json.Marshal(*cmd)
 	if err != nil {
 		ctx.Hub <- &InitFailedEvent{
@@ -228,6 +252,43 @@ func (ctx *VmContext) killCmd(cmd *KillCommand) {
 	}
 }
 
+func (ctx *VmContext) restartContainerCmd(cmd *RestartContainerCommand) {
+	idx := ctx.Lookup(cmd.Container)
+	if idx < 0 {
+		ctx.reportContainerCmd(cmd, fmt.Sprintf("container %s is not in the pod", cmd.Container))
+		return
+	}
+	// the sessions of the container are closed when it exited, the
+	// restarted container reuses them
+	c := ctx.vmSpec.Containers[idx]
+	sessions := []uint64{c.Tty}
+	if c.Stderr > 0 {
+		sessions = append(sessions, c.Stderr)
+	}
+	ctx.ptys.lock.Lock()
+	for _, s := range sessions {
+		if ta, ok := ctx.ptys.ttys[s]; ok {
+			ta.persistent = true
+			ta.closed = false
+		} else {
+			ctx.ptys.ttys[s] = newAttachments(idx, true)
+		}
+	}
+	ctx.ptys.lock.Unlock()
+	restartCmd, err := json.Marshal(*cmd)
+	if err != nil {
+		ctx.Hub <- &InitFailedEvent{
+			Reason: "Generated wrong restart profile " + err.Error(),
+		}
+		return
+	}
+	ctx.vm <- &DecodedMessage{
+		Code:    INIT_RESTARTCONTAINER,
+		Message: restartCmd,
+		Event:   cmd,
+	}
+}
+
 func (ctx *VmContext) attachCmd(cmd *AttachCommand) {
 	idx := ctx.Lookup(cmd.Container)
 	if cmd.Container != "" && idx < 0 {
@@ -359,6 +420,12 @@ func commonStateHandler(ctx *VmContext, ev VmEvent, hasPod bool) bool {
 	case COMMAND_SHUTDOWN:
 		glog.Info("got shutdown command, shutting down")
 		ctx.exitVM(false, "", hasPod, ev.(*ShutdownCommand).Wait)
+	case EVENT_GUEST_HEARTBEAT:
+		ctx.onGuestHeartbeat(ev.(*GuestHeartbeat))
+	case EVENT_GUEST_UNRESPONSIVE:
+		ctx.onGuestUnresponsive(ev.(*GuestUnresponsive))
+	case COMMAND_GET_GUEST_HEALTH:
+		ctx.reportGuestHealth(ev)
 	default:
 		processed = false
 	}
@@ -442,8 +509,16 @@ func unexpectedEventHandler(ctx *VmContext, ev VmEvent, state string) {
 		COMMAND_REPLACE_POD,
 		COMMAND_EXEC,
 		COMMAND_KILL,
+		COMMAND_RESTART_CONTAINER,
+		COMMAND_REMOVE_CONTAINER,
 		COMMAND_WRITEFILE,
 		COMMAND_READFILE,
+		COMMAND_ATTACH_VOLUME,
+		COMMAND_DETACH_VOLUME,
+		COMMAND_GET_GUEST_HEALTH,
+		COMMAND_STAT_PATH,
+		COMMAND_GET_ARCHIVE,
+		COMMAND_PUT_ARCHIVE,
 		COMMAND_SHUTDOWN,
 		COMMAND_RELEASE:
 		ctx.reportUnexpectedRequest(ev, state)
@@ -590,7 +665,9 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 		ctx.shutdownVM(true, "Fail during reconnect to a running pod")
 		ctx.Become(stateTerminating, "TERMINATING")
 	} else if processed := deviceInitHandler(ctx, ev); processed {
-		if ctx.deviceReady () {
+		if ev.Event() == EVENT_BLOCK_INSERTED && ctx.onHotplugBlockInserted(ev.(*BlockdevInsertedEvent)) {
+			glog.V(1).Info("hot added volume inserted")
+		} else if ctx.deviceReady () {
 			glog.V(1).Info("devices ready before migration")
 		}
 	} else {
@@ -608,6 +685,10 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 			ctx.execCmd(ev.(*ExecCommand))
 		case COMMAND_KILL:
 			ctx.killCmd(ev.(*KillCommand))
+		case COMMAND_RESTART_CONTAINER:
+			ctx.restartContainerCmd(ev.(*RestartContainerCommand))
+		case COMMAND_REMOVE_CONTAINER:
+			ctx.removeContainer(ev.(*RemoveContainerCommand))
 		case COMMAND_ATTACH:
 			ctx.attachCmd(ev.(*AttachCommand))
 		case COMMAND_NEWCONTAINER:
@@ -621,10 +702,30 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 			ctx.writeFile(ev.(*WriteFileCommand))
 		case COMMAND_READFILE:
 			ctx.readFile(ev.(*ReadFileCommand))
+		case COMMAND_STAT_PATH, COMMAND_GET_ARCHIVE, COMMAND_PUT_ARCHIVE, COMMAND_FORWARD_SOCKET:
+			ctx.archiveCmd(ev)
+		case COMMAND_ATTACH_VOLUME:
+			ctx.attachVolume(ev.(*AttachVolumeCommand))
+		case COMMAND_DETACH_VOLUME:
+			ctx.detachVolume(ev.(*DetachVolumeCommand))
+		case EVENT_BLOCK_EJECTED:
+			if !ctx.onHotplugVolumeRemoved(ev.(*VolumeUnmounted)) {
+				glog.V(1).Infof("volume %s ejected", ev.(*VolumeUnmounted).Name)
+			}
+		case EVENT_CONTAINER_DELETE:
+			if !ctx.onHotplugContainerRemoved(ev.(*ContainerUnmounted)) {
+				glog.V(1).Infof("container %d unmounted", ev.(*ContainerUnmounted).Index)
+			}
+		case EVENT_VOLUME_DELETE:
+			if !ctx.onHotplugContainerReleased(ev.(*BlockdevRemovedEvent)) {
+				glog.V(1).Infof("blockdev %s deleted", ev.(*BlockdevRemovedEvent).Name)
+			}
+		case EVENT_CONTAINER_FINISH:
+			ctx.reportContainerFinished(ev.(*ContainerFinished))
 		case EVENT_POD_FINISH:
 			result := ev.(*PodFinished)
 			ctx.reportPodFinished(result)
-			if ctx.Keep == types.VM_KEEP_NONE {
+			if ctx.Keep == types.VM_KEEP_NONE && (ctx.userSpec == nil || !ctx.userSpec.RestartsContainers()) {
 				ctx.exitVM(false, "", true, false)
 			}
 		case COMMAND_ACK:
@@ -640,6 +741,14 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 			} else if ack.reply.Code == INIT_WRITEFILE {
 				ctx.reportFile(ack.reply.Event, INIT_WRITEFILE, ack.msg, false)
 				glog.Infof("Get ack for write data: %s", string(ack.msg))
+			} else if ack.reply.Code == INIT_STATPATH || ack.reply.Code == INIT_GETARCHIVE || ack.reply.Code == INIT_PUTARCHIVE || ack.reply.Code == INIT_FORWARDSOCK {
+				ctx.reportArchive(ack.reply.Event, ack.msg, false)
+			} else if ack.reply.Code == INIT_MOUNTVOLUME || ack.reply.Code == INIT_UMOUNTVOLUME {
+				ctx.onVolumeHotplugAck(ack.reply.Event, false)
+			} else if ack.reply.Code == INIT_KILLCONTAINER || ack.reply.Code == INIT_RESTARTCONTAINER {
+				ctx.reportContainerCmd(ack.reply.Event, "")
+			} else if ack.reply.Code == INIT_REMOVECONTAINER {
+				ctx.onContainerRemoveAck(ack.reply.Event, false)
 			}
 		case ERROR_CMD_FAIL:
 			ack := ev.(*CommandError)
@@ -654,6 +763,16 @@ func stateRunning(ctx *VmContext, ev VmEvent) {
 			} else if ack.reply.Code == INIT_WRITEFILE {
 				ctx.reportFile(ack.reply.Event, INIT_WRITEFILE, ack.msg, true)
 				glog.Infof("Get error for write data: %s", string(ack.msg))
+			} else if ack.reply.Code == INIT_STATPATH || ack.reply.Code == INIT_GETARCHIVE || ack.reply.Code == INIT_PUTARCHIVE || ack.reply.Code == INIT_FORWARDSOCK {
+				ctx.reportArchive(ack.reply.Event, ack.msg, true)
+			} else if ack.reply.Code == INIT_MOUNTVOLUME || ack.reply.Code == INIT_UMOUNTVOLUME {
+				ctx.onVolumeHotplugAck(ack.reply.Event, true)
+				glog.Infof("Get error for volume hotplug: %s", string(ack.msg))
+			} else if ack.reply.Code == INIT_KILLCONTAINER || ack.reply.Code == INIT_RESTARTCONTAINER {
+				ctx.reportContainerCmd(ack.reply.Event, "container command failed: "+string(ack.msg))
+			} else if ack.reply.Code == INIT_REMOVECONTAINER {
+				ctx.onContainerRemoveAck(ack.reply.Event, true)
+				glog.Infof("Get error for container removal: %s", string(ack.msg))
 			}
 
 		case COMMAND_GET_POD_IP:
@@ -541,10 +541,8 @@ func HandleRunvRequest(context *nsContext, info *hypervisor.ContainerInfo, conn
 			}
 
 			fmt.Printf("client get exit status: tag %v\n", tagCmd)
-			_, ok := context.vm.ExitCodes[tagCmd.Tag]
-			if ok {
-				code = uint8(context.vm.ExitCodes[tagCmd.Tag])
-				delete(context.vm.ExitCodes, tagCmd.Tag)
+			if c, ok := context.vm.TakeExitCode(tagCmd.Tag); ok {
+				code = c
 			}
 
 			m := &hypervisor.DecodedMessage{